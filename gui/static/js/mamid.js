@@ -159,7 +159,16 @@ mamidApp.factory('SlaveService', function ($resource) {
     return $resource('/api/slaves/:slave', {slave: "@id"}, {
         create: {method: 'put'},
         queryByReplicaSet: {method: 'get', url: '/api/replicasets/:replicaset/slaves/', isArray: true},
-        getMongods: {method: 'get', url: '/api/slaves/:slave/mongods', isArray: true}
+        getMongods: {method: 'get', url: '/api/slaves/:slave/mongods', isArray: true},
+        getDrain: {method: 'get', url: '/api/slaves/:slave/drain'},
+        drain: {method: 'post', url: '/api/slaves/:slave/drain'},
+        getHostMetrics: {method: 'get', url: '/api/slaves/:slave/hostmetrics'}
+    });
+});
+
+mamidApp.factory('MongodService', function ($resource) {
+    return $resource('/api/mongods/:mongod', {mongod: "@id"}, {
+        getLog: {method: 'get', url: '/api/mongods/:mongod/log'}
     });
 });
 
@@ -178,7 +187,8 @@ mamidApp.factory('MgmtUserService', function ($resource) {
 mamidApp.factory('ReplicaSetService', function ($resource) {
     return $resource('/api/replicasets/:replicaset', {replicaset: "@id"}, {
         create: {method: 'put'},
-        getMongods: {method: 'get', url: '/api/replicasets/:replicaset/mongods', isArray: true}
+        getMongods: {method: 'get', url: '/api/replicasets/:replicaset/mongods', isArray: true},
+        getReplication: {method: 'get', url: '/api/replicasets/:replicaset/replication'}
     });
 });
 
@@ -369,7 +379,23 @@ mamidApp.controller('riskGroupIndexController', function ($scope, $http, RiskGro
         if (riskgroup.slaves === undefined) {
             $scope.getSlaves(riskgroup);
         }
-        return riskgroup.slaves.length == 0;
+        var hasChildren = $scope.riskgroups.some(function (other) {
+            return other.parent_id == riskgroup.id;
+        });
+        return riskgroup.slaves.length == 0 && !hasChildren;
+    };
+    $scope.riskGroupPath = function (riskgroup) {
+        var names = [];
+        var visited = {};
+        for (var current = riskgroup; current && !visited[current.id]; ) {
+            visited[current.id] = true;
+            names.unshift(current.name);
+            var parentId = current.parent_id;
+            current = $scope.riskgroups.find(function (other) {
+                return other.id == parentId;
+            });
+        }
+        return names.join(' > ');
     };
     $(function () {
         $('[data-toggle="tooltip"]').tooltip();
@@ -385,7 +411,7 @@ mamidApp.controller('riskGroupIndexController', function ($scope, $http, RiskGro
     }
 });
 var slaveMongoPoll = false;
-mamidApp.controller('slaveByIdController', function ($scope, $http, $routeParams, $location, $timeout, SlaveService, RiskGroupService, ReplicaSetService) {
+mamidApp.controller('slaveByIdController', function ($scope, $http, $routeParams, $location, $timeout, SlaveService, RiskGroupService, ReplicaSetService, MongodService) {
     var slaveId = $routeParams['slaveId'];
     slaveMongoPoll = !$scope.is_create_view;
     var pollMongo = function () {
@@ -398,6 +424,16 @@ mamidApp.controller('slaveByIdController', function ($scope, $http, $routeParams
                     $scope.slave.configured_state_transitioning = slave.configured_state_transitioning;
                 });
             });
+            SlaveService.getDrain({slave: $scope.slave.id}, function (drain) {
+                $scope.drain = drain;
+            }, function () {
+                $scope.drain = null; // never drained
+            });
+            SlaveService.getHostMetrics({slave: $scope.slave.id}, function (metrics) {
+                $scope.hostMetrics = metrics;
+            }, function () {
+                $scope.hostMetrics = null; // not reported yet
+            });
             var finished = 0;
             if (mongods.length == 0) {
                 $scope.mongods = mongods;
@@ -501,6 +537,37 @@ mamidApp.controller('slaveByIdController', function ($scope, $http, $routeParams
         });
     };
 
+    $scope.drainSlave = function () {
+        SlaveService.drain({slave: slaveId}, {}, function (drain) {
+            $scope.drain = drain;
+            SlaveService.get({slave: slaveId}, function (slave) {
+                $scope.slave = slave;
+                $scope.edit_slave = angular.copy($scope.slave);
+            });
+        });
+    };
+
+    $scope.mongodLogs = {};
+    $scope.toggleMongodLog = function (mongod) {
+        if ($scope.mongodLogs[mongod.id]) {
+            delete $scope.mongodLogs[mongod.id];
+            return;
+        }
+        $scope.refreshMongodLog(mongod);
+    };
+
+    $scope.refreshMongodLog = function (mongod) {
+        MongodService.getLog({mongod: mongod.id, tail: 100}, function (log) {
+            $scope.mongodLogs[mongod.id] = log;
+        }, function (response) {
+            $scope.mongodLogs[mongod.id] = {error: response.data};
+        });
+    };
+
+    $scope.isDraining = function () {
+        return $scope.drain && $scope.drain.state != 'finished' && $scope.drain.state != 'cancelled';
+    };
+
     $scope.calcMongodCount = function () {
         if (!$scope.edit_slave) {
             return '?';
@@ -550,6 +617,18 @@ mamidApp.controller('replicasetByIdController',
                     $scope.replicaset.mongods = mongods;
                     $timeout(mongoPoll, 2000);
                 });
+                ReplicaSetService.getReplication({replicaset: replicasetId}, function (replication) {
+                    for (var i = 0; i < replication.members.length; i++) {
+                        for (var j = 0; j < mongods.length; j++) {
+                            if (mongods[j].id == replication.members[i].mongod_id) {
+                                replication.members[i].mongod = mongods[j];
+                            }
+                        }
+                    }
+                    $scope.replication = replication;
+                }, function () {
+                    $scope.replication = null; // no PRIMARY observed yet
+                });
 
 
             });
@@ -561,6 +640,9 @@ mamidApp.controller('replicasetByIdController',
             //Copy replicaset for edit form so that changes are only applied to model when apply is clicked
             $scope.edit_replicaset = angular.copy($scope.replicaset);
             $scope.edit_replicaset.sharding_role = "none";
+            $scope.edit_replicaset.placement_strategy = "";
+            $scope.edit_replicaset.storage_engine = "";
+            $scope.edit_replicaset.bind_ip = "";
         } else {
             $scope.replicaset = ReplicaSetService.get({replicaset: replicasetId});
 
@@ -86,6 +86,7 @@ func (b *Bus) Run() {
 				case channel <- recv.Interface():
 				default:
 					busLog.Error("Bus channel full - dropping message")
+					busDroppedMessages.Inc()
 				}
 			}
 			b.readChannelsMutex.Unlock()
@@ -2,6 +2,7 @@ package master
 
 import (
 	"crypto/rand"
+	"database/sql"
 	"encoding/base64"
 	"fmt"
 	. "github.com/KIT-MAMID/mamid/model"
@@ -15,6 +16,10 @@ var caLog = logrus.WithField("module", "cluster_allocator")
 
 type ClusterAllocator struct {
 	BusWriteChannel *chan<- interface{}
+	// The PlacementStrategy for Replica Sets that do not specify one. Defaults to SpreadPlacementStrategy.
+	PlacementStrategy PlacementStrategy
+	// Whether the allocator runs on transactions that are rolled back, see dryRunCopy
+	dryRun bool
 }
 
 type persistence uint
@@ -22,6 +27,8 @@ type persistence uint
 const (
 	Persistent persistence = 0
 	Volatile   persistence = 1
+	// Arbiters hold no data and are placed on Slaves regardless of their persistent storage
+	Arbiter persistence = 2
 )
 
 type memberCountTuple map[persistence]uint
@@ -35,12 +42,14 @@ func (c *ClusterAllocator) Run(db *DB) {
 			case <-ticker.C:
 				caLog.Info("Periodic cluster allocator run")
 				tx := db.Begin()
-				compileErr := c.CompileMongodLayout(tx)
+				var decisions AllocatorDecisions
+				compileErr := c.CompileMongodLayout(tx, &decisions)
 				if compileErr != nil {
 					caLog.WithError(compileErr).Error("Periodic cluster allocator run failed")
+					tx.Rollback()
 					continue
 				}
-				if commitErr := tx.Commit().Error; commitErr != nil {
+				if commitErr := c.Commit(tx, decisions); commitErr != nil {
 					caLog.WithError(commitErr).Error("Periodic cluster allocator commit failed")
 					continue
 				}
@@ -53,6 +62,39 @@ func (c *ClusterAllocator) Run(db *DB) {
 	}()
 }
 
+// The AllocatorDecisions made in a transaction, sent on the bus by Commit once the transaction is committed,
+// i.e. consumers never learn about Mongods that are rolled back.
+// A nil *AllocatorDecisions does not collect decisions.
+type AllocatorDecisions []AllocatorDecision
+
+func (d *AllocatorDecisions) add(decision AllocatorDecision) {
+	if d != nil {
+		*d = append(*d, decision)
+	}
+}
+
+// Commit tx and send the decisions made in it on the bus
+func (c *ClusterAllocator) Commit(tx *gorm.DB, decisions AllocatorDecisions) error {
+	if err := tx.Commit().Error; err != nil {
+		return err
+	}
+	if c.BusWriteChannel != nil {
+		for _, decision := range decisions {
+			*c.BusWriteChannel <- decision
+		}
+	}
+	return nil
+}
+
+// A copy of the allocator for runs on transactions that are rolled back afterwards:
+// it neither sends messages on the bus nor records its runs in the metrics.
+func (c *ClusterAllocator) dryRunCopy() *ClusterAllocator {
+	dryRun := *c
+	dryRun.BusWriteChannel = nil
+	dryRun.dryRun = true
+	return &dryRun
+}
+
 const MamidManagementUsername = "mamid"
 
 func (c *ClusterAllocator) InitializeGlobalSecrets(tx *gorm.DB) (err error) {
@@ -122,7 +164,20 @@ func randomBase64(len int) (str string, err error) {
 	return base64.StdEncoding.EncodeToString(randBytes)[:len], nil
 }
 
-func (c *ClusterAllocator) CompileMongodLayout(tx *gorm.DB) (err error) {
+// Spawn and remove Mongods in tx to satisfy the Replica Sets' constraints.
+// The decisions made are appended to decisions, pass them to Commit.
+func (c *ClusterAllocator) CompileMongodLayout(tx *gorm.DB, decisions *AllocatorDecisions) (err error) {
+
+	defer func() {
+		if c.dryRun {
+			return
+		}
+		if err == nil {
+			clusterAllocatorRuns.WithLabelValues("success").Inc()
+		} else {
+			clusterAllocatorRuns.WithLabelValues("error").Inc()
+		}
+	}()
 
 	defer func() {
 		r := recover()
@@ -182,13 +237,22 @@ func (c *ClusterAllocator) CompileMongodLayout(tx *gorm.DB) (err error) {
 		caLog.Debugf("removed `%d` destroyed Mongods from the database", removeDestroyedMongodsRes.RowsAffected)
 	}
 
+	// move members off drained slaves and members moved by the Rebalancer
+	replacingReplicaSetIDs := c.advanceSlaveDrains(tx, decisions)
+	for replicaSetID := range c.advanceMongodMoves(tx) {
+		replacingReplicaSetIDs[replicaSetID] = true
+	}
+
 	// list of replica sets with number of excess mongods
 	replicaSets, err := tx.Raw(`SELECT
 			r.id,
-			(SELECT COUNT(*) FROM replica_set_effective_members WHERE replica_set_id = r.id AND persistent_storage = ?)
+			r.placement_strategy,
+			(SELECT COUNT(*) FROM replica_set_effective_members WHERE replica_set_id = r.id AND persistent_storage = ? AND NOT arbiter)
 				- r.persistent_member_count AS deletable_persistent,
-			(SELECT COUNT(*) FROM replica_set_effective_members WHERE replica_set_id = r.id AND persistent_storage = ?)
-				- r.volatile_member_count AS deletable_volatile
+			(SELECT COUNT(*) FROM replica_set_effective_members WHERE replica_set_id = r.id AND persistent_storage = ? AND NOT arbiter)
+				- r.volatile_member_count AS deletable_volatile,
+			(SELECT COUNT(*) FROM replica_set_effective_members WHERE replica_set_id = r.id AND arbiter)
+				- r.arbiter_member_count AS deletable_arbiter
 		    FROM replica_sets r`, true, false,
 	).Rows()
 
@@ -198,7 +262,9 @@ func (c *ClusterAllocator) CompileMongodLayout(tx *gorm.DB) (err error) {
 
 	type excessMongodsRow struct {
 		replicaSetID                             uint
+		placementStrategy                        string
 		deletable_persistent, deletable_volatile int
+		deletable_arbiter                        int
 	}
 
 	excessMongodsRows := make([]excessMongodsRow, 0)
@@ -206,7 +272,7 @@ func (c *ClusterAllocator) CompileMongodLayout(tx *gorm.DB) (err error) {
 	for replicaSets.Next() {
 
 		var row excessMongodsRow
-		err := replicaSets.Scan(&row.replicaSetID, &row.deletable_persistent, &row.deletable_volatile)
+		err := replicaSets.Scan(&row.replicaSetID, &row.placementStrategy, &row.deletable_persistent, &row.deletable_volatile, &row.deletable_arbiter)
 		if err != nil {
 			panic(err)
 		}
@@ -218,13 +284,22 @@ func (c *ClusterAllocator) CompileMongodLayout(tx *gorm.DB) (err error) {
 
 	for _, r := range excessMongodsRows {
 
-		for _, p := range []persistence{Persistent, Volatile} {
+		if replacingReplicaSetIDs[int64(r.replicaSetID)] {
+			// the drained or moved member is removed once the replacement member is running
+			caLog.Debugf("not removing excess mongods for replica set `%#v`: waiting for replacement member", r.replicaSetID)
+			continue
+		}
+
+		for _, p := range []persistence{Persistent, Volatile, Arbiter} {
 
 			var deletable_count int
-			if p.PersistentStorage() {
+			switch p {
+			case Persistent:
 				deletable_count = r.deletable_persistent
-			} else {
+			case Volatile:
 				deletable_count = r.deletable_volatile
+			case Arbiter:
+				deletable_count = r.deletable_arbiter
 			}
 
 			// Assert that deletable_count > 0
@@ -235,25 +310,64 @@ func (c *ClusterAllocator) CompileMongodLayout(tx *gorm.DB) (err error) {
 
 			caLog.Infof("removing excess mongods for replica set `%#v`: up to `%d` `%s` mongods", r.replicaSetID, deletable_count, p)
 
-			var deletableMongds []*Mongod
+			failureDomains, err := loadFailureDomainTree(tx)
+			if err != nil {
+				panic(err)
+			}
+			memberFailureDomains, err := replicaSetMemberFailureDomains(tx, int64(r.replicaSetID))
+			if err != nil {
+				panic(err)
+			}
 
-			err := tx.Raw(`SELECT m.*
+			victimCandidates := make([]PlacementCandidate, 0)
+			victimRows, err := tx.Raw(`SELECT m.id, m.desired_state_id, s.id, s.risk_group_id, s.configured_state,
+					(CASE WHEN s.observation_error_id IS NULL THEN 0 ELSE 1 END),
+					su.max_mongods, su.free_mongods, su.utilization
 				FROM replica_sets r
 				JOIN mongods m ON m.replica_set_id = r.id
 				JOIN slaves s ON s.id = m.parent_slave_id
 				JOIN slave_utilization su ON s.id = su.id
 				WHERE
 					r.id = ?
-					AND s.persistent_storage = ?
+					AND s.persistent_storage IN (?)
+					AND m.arbiter = ?
 					AND s.configured_state != ?
-				ORDER BY (CASE WHEN s.configured_state = ? THEN 1 ELSE 2 END) ASC,
-				(CASE WHEN s.observation_error_id IS NULL THEN 0 ELSE 1 END) DESC, -- prioritize slaves with observation error
-				su.utilization DESC
-				LIMIT ?`, r.replicaSetID, p.PersistentStorage(), SlaveStateMaintenance, SlaveStateDisabled, deletable_count,
-			).Find(&deletableMongds).Error
+				ORDER BY m.id`, r.replicaSetID, p.slaveStorage(), p == Arbiter, SlaveStateMaintenance,
+			).Rows()
 			if err != nil {
 				panic(err)
 			}
+			desiredStateIDs := make(map[int64]int64)
+			for victimRows.Next() {
+				var candidate PlacementCandidate
+				var desiredStateID int64
+				var riskGroupID sql.NullInt64
+				var observationError int
+				if err := victimRows.Scan(&candidate.MongodID, &desiredStateID, &candidate.SlaveID, &riskGroupID, &candidate.ConfiguredState,
+					&observationError, &candidate.MaxMongods, &candidate.FreeMongods, &candidate.Utilization); err != nil {
+					panic(err)
+				}
+				candidate.ObservationError = observationError != 0
+				otherMembers := make([]sql.NullInt64, 0, len(memberFailureDomains))
+				for mongodID, domain := range memberFailureDomains {
+					if mongodID != candidate.MongodID {
+						otherMembers = append(otherMembers, domain)
+					}
+				}
+				candidate.FailureDomainOverlap = failureDomains.overlap(riskGroupID, otherMembers)
+				desiredStateIDs[candidate.MongodID] = desiredStateID
+				victimCandidates = append(victimCandidates, candidate)
+			}
+			victimRows.Close()
+
+			rankedVictims := c.placementStrategy(r.placementStrategy).RankVictims(victimCandidates)
+			if len(rankedVictims) > deletable_count {
+				rankedVictims = rankedVictims[:deletable_count]
+			}
+			deletableMongds := make([]*Mongod, len(rankedVictims))
+			for i, v := range rankedVictims {
+				deletableMongds[i] = &Mongod{ID: v.MongodID, ParentSlaveID: v.SlaveID, DesiredStateID: desiredStateIDs[v.MongodID]}
+			}
 
 			caLog.Infof("setting %d mongods for replica set `%#v` to desired state `destroyed`", len(deletableMongds), r.replicaSetID)
 
@@ -272,6 +386,11 @@ func (c *ClusterAllocator) CompileMongodLayout(tx *gorm.DB) (err error) {
 					caLog.Errorf("internal inconsistency: setting desired mongod_state of mongod `%#v` to `destroyed` affected more than one row", m)
 				}
 
+				decisions.add(AllocatorDecision{
+					Removal: true,
+					Mongod:  Mongod{ID: m.ID, ParentSlaveID: m.ParentSlaveID, ReplicaSetID: NullIntValue(int64(r.replicaSetID))},
+				})
+
 			}
 
 		}
@@ -280,17 +399,14 @@ func (c *ClusterAllocator) CompileMongodLayout(tx *gorm.DB) (err error) {
 
 	//All unsatisfiable replica sets (independent of persistence)
 	unsatisfiable_replica_set_ids := []int64{}
+	// Why no slave could host the missing members of an unsatisfiable replica set
+	slave_rejections_by_replica_set_id := make(map[int64][]SlavePlacementEvaluation)
 
 	// Now add new members
 
-	for _, p := range []persistence{Persistent, Volatile} {
+	for _, p := range []persistence{Persistent, Volatile, Arbiter} {
 
-		var memberCountColumnName string
-		if p.PersistentStorage() {
-			memberCountColumnName = "persistent_member_count"
-		} else {
-			memberCountColumnName = "volatile_member_count"
-		}
+		memberCountColumnName := p.memberCountColumnName()
 
 		//Unsatisfiable replica sets for the current persistence
 		unsatisfiable_replica_set_ids_by_persistance := []int64{0} // we always start at 1, this is a workaround for the statement generator producing (NULL) in case of an empty set otherwise
@@ -307,7 +423,8 @@ func (c *ClusterAllocator) CompileMongodLayout(tx *gorm.DB) (err error) {
 					FROM replica_sets r
 					LEFT OUTER JOIN replica_set_configured_members members
 						ON r.id = members.replica_set_id
-						AND members.persistent_storage = ?
+						AND members.persistent_storage IN (?)
+						AND members.arbiter = ?
 					WHERE
 						r.`+memberCountColumnName+` != 0
 						AND
@@ -315,7 +432,7 @@ func (c *ClusterAllocator) CompileMongodLayout(tx *gorm.DB) (err error) {
 					GROUP BY r.id
 					HAVING COUNT(DISTINCT members.mongod_id) < r.`+memberCountColumnName+`
 					ORDER BY COUNT(DISTINCT members.mongod_id) / r.`+memberCountColumnName+`
-					LIMIT 1`, p.PersistentStorage(), unsatisfiable_replica_set_ids_by_persistance,
+					LIMIT 1`, p.slaveStorage(), p == Arbiter, unsatisfiable_replica_set_ids_by_persistance,
 			).Scan(&replicaSet)
 
 			if res.RecordNotFound() {
@@ -327,50 +444,23 @@ func (c *ClusterAllocator) CompileMongodLayout(tx *gorm.DB) (err error) {
 
 			caLog.Debugf("looking for least busy `%s` slave suitable as mongod host for replica set `%s`", p, replicaSet.Name)
 
-			var leastBusySuitableSlave Slave
-			res = tx.Raw(`SELECT s.*
-			      	      FROM slave_utilization s
-			      	      WHERE
-			      	        s.persistent_storage = ?
-			      	        AND
-			      	      	s.free_mongods > 0
-					AND
-					s.configured_state = ?
-			      	      	AND (
-			      	      		s.risk_group_id NOT IN (
-			      	      			SELECT DISTINCT s.risk_group_id
-			      	      			FROM mongods m
-			      	      			JOIN slaves s ON m.parent_slave_id = s.id
-			      	      			WHERE m.replica_set_id = ?
-			      	      		)
-			      	      		-- 0 is the default risk group that is not a risk group,
-			      	      		-- i.e from which multiple slaves can be allocated for the same replica set
-			      	      		OR s.risk_group_id IS NULL
-			      	      	)
-					AND
-					s.id NOT IN ( -- Slaves already hosting a Mongod of the Replica Set
-						SELECT DISTINCT m.parent_slave_id
-						FROM mongods m
-						WHERE m.replica_set_id = ?
-					)
-			      	      ORDER BY
-				      	(CASE WHEN s.observation_error_id IS NULL THEN 0 ELSE 1 END) ASC, -- prioritize slaves without observation error
-					s.utilization ASC
-			      	      LIMIT 1`, p.PersistentStorage(), SlaveStateActive, replicaSet.ID, replicaSet.ID,
-			).Scan(&leastBusySuitableSlave)
-
-			if res.RecordNotFound() {
-				caLog.Warn("unsatisfiable replica set `%s`: not enough suitable `%s` slaves", replicaSet.Name, p)
+			host := c.selectHost(tx, &replicaSet.ReplicaSet, p, 0)
+			if host == nil {
+				caLog.Warnf("unsatisfiable replica set `%s`: not enough suitable `%s` slaves", replicaSet.Name, p)
 				unsatisfiable_replica_set_ids_by_persistance = append(unsatisfiable_replica_set_ids_by_persistance, replicaSet.ID)
 				unsatisfiable_replica_set_ids = append(unsatisfiable_replica_set_ids, replicaSet.ID)
+				evaluations, err := evaluatePlacement(tx, &replicaSet.ReplicaSet, p)
+				if err != nil {
+					panic(err)
+				}
+				slave_rejections_by_replica_set_id[replicaSet.ID] = append(slave_rejections_by_replica_set_id[replicaSet.ID], rejectedPlacements(evaluations)...)
 				continue
-			} else if res.Error != nil {
-				panic(res.Error)
 			}
+			leastBusySuitableSlave := *host
 
 			caLog.Debugf("found slave `%s` as host for new mongod for replica set `%s`", leastBusySuitableSlave.Hostname, replicaSet.Name)
 
-			m, err := c.spawnMongodOnSlave(tx, &leastBusySuitableSlave, &replicaSet.ReplicaSet)
+			m, err := c.spawnMongodOnSlave(tx, decisions, &leastBusySuitableSlave, &replicaSet.ReplicaSet, p)
 			if err != nil {
 				caLog.Errorf("could not spawn mongod on slave `%s`: %s", leastBusySuitableSlave.Hostname, err.Error())
 				// the queries should have not returned a slave without free ports
@@ -382,16 +472,29 @@ func (c *ClusterAllocator) CompileMongodLayout(tx *gorm.DB) (err error) {
 		}
 	}
 
+	unsatisfiable := make(map[int64]bool)
+	for _, id := range unsatisfiable_replica_set_ids {
+		unsatisfiable[id] = true
+	}
+	if !c.dryRun {
+		unsatisfiableReplicaSets.Set(float64(len(unsatisfiable)))
+	}
+
+	// designate hidden and delayed members among the data-bearing members
+	c.assignMemberRoles(tx)
+
 	// Send replica set constraint status messages on bus for every replica set
 	if c.BusWriteChannel != nil {
 
 		// Get replica sets and the count of their actually configured members from the database
 		replicaSetsWithMemberCounts, err := tx.Raw(`SELECT
 			r.*,
-			(SELECT COUNT(*) FROM replica_set_configured_members WHERE replica_set_id = r.id AND persistent_storage = ?)
+			(SELECT COUNT(*) FROM replica_set_configured_members WHERE replica_set_id = r.id AND persistent_storage = ? AND NOT arbiter)
 				AS configured_persistent_members,
-			(SELECT COUNT(*) FROM replica_set_configured_members WHERE replica_set_id = r.id AND persistent_storage = ?)
-				AS configured_volatile_members
+			(SELECT COUNT(*) FROM replica_set_configured_members WHERE replica_set_id = r.id AND persistent_storage = ? AND NOT arbiter)
+				AS configured_volatile_members,
+			(SELECT COUNT(*) FROM replica_set_configured_members WHERE replica_set_id = r.id AND arbiter)
+				AS configured_arbiter_members
 		    	FROM replica_sets r
 		`, true, false).Rows()
 		if err != nil {
@@ -405,6 +508,7 @@ func (c *ClusterAllocator) CompileMongodLayout(tx *gorm.DB) (err error) {
 			configuredMemberCounts := struct {
 				ConfiguredPersistentMembers uint
 				ConfiguredVolatileMembers   uint
+				ConfiguredArbiterMembers    uint
 			}{}
 			tx.ScanRows(replicaSetsWithMemberCounts, &configuredMemberCounts)
 
@@ -419,6 +523,8 @@ func (c *ClusterAllocator) CompileMongodLayout(tx *gorm.DB) (err error) {
 				ReplicaSet:                replicaSet,
 				ConfiguredPersistentCount: configuredMemberCounts.ConfiguredPersistentMembers,
 				ConfiguredVolatileCount:   configuredMemberCounts.ConfiguredVolatileMembers,
+				ConfiguredArbiterCount:    configuredMemberCounts.ConfiguredArbiterMembers,
+				SlaveRejections:           slave_rejections_by_replica_set_id[replicaSet.ID],
 			}
 		}
 	}
@@ -431,6 +537,149 @@ func (c *ClusterAllocator) CompileMongodLayout(tx *gorm.DB) (err error) {
 	return err
 }
 
+// The Slave preferred by the Replica Set's PlacementStrategy as host for an additional `p` member,
+// nil if no Slave satisfies the Replica Set's constraints.
+// The member with leavingMongodID (0 for none) is about to be replaced and does not constrain the placement.
+func (c *ClusterAllocator) selectHost(tx *gorm.DB, replicaSet *ReplicaSet, p persistence, leavingMongodID int64) *Slave {
+	candidates, slaves := suitableHosts(tx, replicaSet, p, leavingMongodID)
+	if len(candidates) == 0 {
+		return nil
+	}
+	return slaves[c.placementStrategy(replicaSet.PlacementStrategy).RankHosts(candidates)[0].SlaveID]
+}
+
+// All Slaves satisfying the Replica Set's constraints for an additional `p` member, indexed by Slave ID.
+// Panics on database errors like CompileMongodLayout.
+func suitableHosts(tx *gorm.DB, replicaSet *ReplicaSet, p persistence, leavingMongodID int64) (candidates []PlacementCandidate, slaves map[int64]*Slave) {
+
+	var suitableSlaves []struct {
+		Slave
+		MaxMongods             int
+		FreeMongods            int
+		Utilization            float64
+		RequestedMemoryMB      int64
+		RequestedCPUMillicores int64
+		RequestedDiskGB        int64
+	}
+	res := tx.Raw(`SELECT s.*
+	      	      FROM slave_utilization s
+	      	      WHERE
+	      	        s.persistent_storage IN (?)
+	      	        AND
+	      	      	s.free_mongods > 0
+			AND
+			s.configured_state = ?
+	      	      	AND (
+	      	      		s.risk_group_id NOT IN (
+	      	      			SELECT DISTINCT s.risk_group_id
+	      	      			FROM mongods m
+	      	      			JOIN slaves s ON m.parent_slave_id = s.id
+	      	      			WHERE m.replica_set_id = ? AND m.id != ?
+	      	      			AND s.risk_group_id IS NOT NULL -- NOT IN (NULL, ...) would never be true
+	      	      		)
+	      	      		-- 0 is the default risk group that is not a risk group,
+	      	      		-- i.e from which multiple slaves can be allocated for the same replica set
+	      	      		OR s.risk_group_id IS NULL
+	      	      	)
+			AND
+			s.id NOT IN ( -- Slaves already hosting a Mongod of the Replica Set
+				SELECT DISTINCT m.parent_slave_id
+				FROM mongods m
+				WHERE m.replica_set_id = ?
+			)
+	      	      ORDER BY s.id`, p.slaveStorage(), SlaveStateActive, replicaSet.ID, leavingMongodID, replicaSet.ID,
+	).Scan(&suitableSlaves)
+
+	if res.RecordNotFound() || len(suitableSlaves) == 0 {
+		return nil, nil
+	} else if res.Error != nil {
+		panic(res.Error)
+	}
+
+	failureDomains, err := loadFailureDomainTree(tx)
+	if err != nil {
+		panic(err)
+	}
+	memberFailureDomains, err := replicaSetMemberFailureDomains(tx, replicaSet.ID)
+	if err != nil {
+		panic(err)
+	}
+	delete(memberFailureDomains, leavingMongodID)
+	members := make([]sql.NullInt64, 0, len(memberFailureDomains))
+	for _, domain := range memberFailureDomains {
+		members = append(members, domain)
+	}
+
+	hostCandidates := make([]PlacementCandidate, 0, len(suitableSlaves))
+	suitableSlavesByID := make(map[int64]*Slave, len(suitableSlaves))
+	for i := range suitableSlaves {
+		slave := &suitableSlaves[i]
+		// Slaves must not be overcommitted
+		hostable := hostableMembers(slave.FreeMongods, slaveResources{
+			MemoryCapacityMB:       int64(slave.MemoryCapacityMB),
+			CPUCapacityMillicores:  int64(slave.CPUCapacityMillicores),
+			DiskCapacityGB:         int64(slave.DiskCapacityGB),
+			RequestedMemoryMB:      slave.RequestedMemoryMB,
+			RequestedCPUMillicores: slave.RequestedCPUMillicores,
+			RequestedDiskGB:        slave.RequestedDiskGB,
+		}, replicaSet, p)
+		if hostable <= 0 {
+			continue
+		}
+		hostCandidates = append(hostCandidates, PlacementCandidate{
+			SlaveID:              slave.ID,
+			ConfiguredState:      slave.ConfiguredState,
+			ObservationError:     slave.ObservationErrorID.Valid,
+			MaxMongods:           slave.MaxMongods,
+			FreeMongods:          hostable,
+			Utilization:          slave.Utilization,
+			FailureDomainOverlap: failureDomains.overlap(slave.RiskGroupID, members),
+		})
+		suitableSlavesByID[slave.ID] = &slave.Slave
+	}
+	return hostCandidates, suitableSlavesByID
+}
+
+// The failure domain (risk group) of the Slave of every Mongod of a Replica Set, indexed by Mongod ID
+func replicaSetMemberFailureDomains(tx *gorm.DB, replicaSetID int64) (domains map[int64]sql.NullInt64, err error) {
+
+	rows, err := tx.Raw(`SELECT m.id, s.risk_group_id
+		FROM mongods m
+		JOIN slaves s ON m.parent_slave_id = s.id
+		WHERE m.replica_set_id = ?`, replicaSetID).Rows()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	domains = make(map[int64]sql.NullInt64)
+	for rows.Next() {
+		var mongodID int64
+		var domain sql.NullInt64
+		if err = rows.Scan(&mongodID, &domain); err != nil {
+			return nil, err
+		}
+		domains[mongodID] = domain
+	}
+
+	return domains, rows.Err()
+}
+
+// The PlacementStrategy with name `replicaSetStrategyName` or the default strategy if the name is empty
+func (c *ClusterAllocator) placementStrategy(replicaSetStrategyName string) PlacementStrategy {
+	if replicaSetStrategyName != "" {
+		strategy, err := PlacementStrategyByName(replicaSetStrategyName)
+		if err == nil {
+			return strategy
+		}
+		caLog.Warnf("falling back to default placement strategy: %s", err)
+	}
+	if c.PlacementStrategy != nil {
+		return c.PlacementStrategy
+	}
+	return SpreadPlacementStrategy{}
+}
+
 func (c *ClusterAllocator) replicaSets(tx *gorm.DB) (replicaSets []*ReplicaSet) {
 
 	if err := tx.Where(ReplicaSet{}).Find(&replicaSets).Error; err != nil {
@@ -478,6 +727,18 @@ func slavePersistence(s *Slave) persistence {
 	}
 }
 
+// The persistence of a member of a Replica Set hosted on a Slave with persistentStorage
+func memberPersistence(arbiter bool, persistentStorage bool) persistence {
+	switch {
+	case arbiter:
+		return Arbiter
+	case persistentStorage:
+		return Persistent
+	default:
+		return Volatile
+	}
+}
+
 func (p persistence) PersistentStorage() bool {
 	switch p {
 	case Persistent:
@@ -489,18 +750,44 @@ func (p persistence) PersistentStorage() bool {
 	}
 }
 
+// The values of Slave.PersistentStorage of Slaves suitable for a `p` member
+func (p persistence) slaveStorage() []bool {
+	switch p {
+	case Arbiter:
+		return []bool{true, false}
+	default:
+		return []bool{p.PersistentStorage()}
+	}
+}
+
+// The column of the `replica_sets` table holding the desired number of `p` members
+func (p persistence) memberCountColumnName() string {
+	switch p {
+	case Persistent:
+		return "persistent_member_count"
+	case Volatile:
+		return "volatile_member_count"
+	case Arbiter:
+		return "arbiter_member_count"
+	default:
+		panic("invalid value for persistence")
+	}
+}
+
 func (p persistence) String() string {
 	switch p {
 	case Persistent:
 		return "persistent"
 	case Volatile:
 		return "volatile"
+	case Arbiter:
+		return "arbiter"
 	default:
 		panic("invalid value for persistence")
 	}
 }
 
-func (c *ClusterAllocator) spawnMongodOnSlave(tx *gorm.DB, s *Slave, r *ReplicaSet) (*Mongod, error) {
+func (c *ClusterAllocator) spawnMongodOnSlave(tx *gorm.DB, decisions *AllocatorDecisions, s *Slave, r *ReplicaSet, p persistence) (*Mongod, error) {
 
 	var usedPorts []PortNumber
 	res := tx.Raw(`
@@ -527,6 +814,7 @@ func (c *ClusterAllocator) spawnMongodOnSlave(tx *gorm.DB, s *Slave, r *ReplicaS
 		ReplSetName:   r.Name,
 		ParentSlaveID: s.ID,
 		ReplicaSetID:  NullIntValue(r.ID),
+		Arbiter:       p == Arbiter,
 	}
 	if err := tx.Create(&m).Error; err != nil {
 		panic(err)
@@ -545,6 +833,8 @@ func (c *ClusterAllocator) spawnMongodOnSlave(tx *gorm.DB, s *Slave, r *ReplicaS
 		panic(err)
 	}
 
+	decisions.add(AllocatorDecision{Mongod: *m})
+
 	return m, nil
 
 }
@@ -601,6 +891,8 @@ const ( // between 0 and 1000
 
 // Return the list of msp.HostPort a model.ReplicaSet should have as members
 // Calculates priorities and selects voting members
+// Arbiters always vote so that they can break ties between the voting data-bearing members.
+// Hidden and delayed members are never elected PRIMARY.
 func DesiredMSPReplicaSetMembersForReplicaSetID(tx *gorm.DB, replicaSetID int64) (replicaSetMembers []msp.ReplicaSetMember, initiator Mongod, err error) {
 
 	rows, err := tx.Raw(`
@@ -608,6 +900,10 @@ func DesiredMSPReplicaSetMembersForReplicaSetID(tx *gorm.DB, replicaSetID int64)
 			m.id,
 			s.hostname,
 			m.port,
+			m.arbiter,
+			m.hidden,
+			m.delayed,
+			r.slave_delay_seconds,
 			CASE s.configured_state
 				WHEN ? THEN ? -- prioritize members to be removed lower
 				ELSE
@@ -634,34 +930,69 @@ func DesiredMSPReplicaSetMembersForReplicaSetID(tx *gorm.DB, replicaSetID int64)
 	}
 	defer rows.Close()
 
-	var initiatorId int64
-	for i := 0; rows.Next(); i++ {
+	var mongodIds []int64
+	arbiterCount := 0
+	for rows.Next() {
 
 		member := msp.ReplicaSetMember{}
 		var mongodId int64
+		var delayed bool
+		var slaveDelaySeconds int64
 
-		err = rows.Scan(&mongodId, &member.HostPort.Hostname, &member.HostPort.Port, &member.Priority)
+		err = rows.Scan(&mongodId, &member.HostPort.Hostname, &member.HostPort.Port, &member.ArbiterOnly,
+			&member.Hidden, &delayed, &slaveDelaySeconds, &member.Priority)
 		if err != nil {
 			return
 		}
 
-		if i == 0 {
-			//Use first mongod as initiator as it can vote.
-			initiatorId = mongodId
+		if delayed {
+			member.Hidden = true
+			member.SlaveDelay = slaveDelaySeconds
+		}
+
+		if member.ArbiterOnly {
+			arbiterCount++
 		}
 
-		// A replica set may have at most 7 voting members
-		// The query is ordered by slave configured_state so that mongods on running slaves become voting first
-		if i < 7 {
+		mongodIds = append(mongodIds, mongodId)
+		replicaSetMembers = append(replicaSetMembers, member)
+	}
+	rows.Close()
+
+	// A replica set may have at most 7 voting members
+	// The query is ordered by slave configured_state so that mongods on running slaves become voting first
+	const maxVotingMembers = 7
+	dataVotes := maxVotingMembers - arbiterCount
+	if dataVotes < 1 {
+		dataVotes = 1
+	}
+	votes := 0
+	var initiatorId int64
+	for i := range replicaSetMembers {
+		member := &replicaSetMembers[i]
+
+		if member.ArbiterOnly || member.Hidden {
+			member.Priority = ReplicaSetMemberPriorityNone // MongoDB says: arbiters and hidden members have priority 0
+		} else if initiatorId == 0 {
+			//Use first electable mongod as initiator as it can vote.
+			initiatorId = mongodIds[i]
+		}
+
+		if votes < maxVotingMembers && (member.ArbiterOnly || dataVotes > 0) {
 			member.Votes = 1
+			votes++
+			if !member.ArbiterOnly {
+				dataVotes--
+			}
 		} else {
 			member.Votes = 0
 			member.Priority = 0 //Mongodb says: priority must be 0 when non-voting
 		}
+	}
 
-		replicaSetMembers = append(replicaSetMembers, member)
+	if initiatorId == 0 && len(mongodIds) > 0 {
+		initiatorId = mongodIds[0]
 	}
-	rows.Close()
 
 	if res := tx.First(&initiator, initiatorId); res.Error != nil && !res.RecordNotFound() {
 		return []msp.ReplicaSetMember{}, Mongod{}, res.Error
@@ -0,0 +1,255 @@
+package master
+
+import (
+	"database/sql"
+	"fmt"
+	. "github.com/KIT-MAMID/mamid/model"
+	"github.com/jinzhu/gorm"
+	"time"
+)
+
+/*
+	Slave drains
+
+	A drain moves the members of all Replica Sets off a Slave in maintenance, one Replica Set at a time:
+
+	1. a replacement member is spawned on another Slave, ignoring the drained member's risk group
+	2. once the replacement is observed running (PRIMARY or SECONDARY), the drained member's desired state is set to `destroyed`
+	3. once the drained member is removed from the database, the drain continues with the next Replica Set
+
+	Excess members of a Replica Set waiting for its replacement member are not removed,
+	otherwise the ClusterAllocator would remove the drained member before the replacement caught up.
+*/
+
+// Advance all active SlaveDrains by as many steps as possible.
+// Returns the IDs of the Replica Sets waiting for a replacement member.
+// Panics on database errors like CompileMongodLayout.
+func (c *ClusterAllocator) advanceSlaveDrains(tx *gorm.DB, decisions *AllocatorDecisions) (replacingReplicaSetIDs map[int64]bool) {
+
+	replacingReplicaSetIDs = make(map[int64]bool)
+
+	var drains []SlaveDrain
+	if err := tx.Where("state NOT IN (?)", []SlaveDrainState{SlaveDrainStateFinished, SlaveDrainStateCancelled}).
+		Order("id").Find(&drains).Error; err != nil {
+		panic(err)
+	}
+
+	for i := range drains {
+		drain := &drains[i]
+
+		var slave Slave
+		if err := tx.First(&slave, drain.SlaveID).Error; err != nil {
+			panic(err)
+		}
+
+		if slave.ConfiguredState != SlaveStateMaintenance {
+			caLog.Infof("cancelling drain of slave `%s`: slave is no longer in maintenance", slave.Hostname)
+			finishSlaveDrain(drain, SlaveDrainStateCancelled, "Slave is no longer in maintenance")
+		} else {
+			c.advanceSlaveDrain(tx, decisions, drain, &slave)
+		}
+
+		if err := tx.Save(drain).Error; err != nil {
+			panic(err)
+		}
+
+		if drain.State == SlaveDrainStateAddingReplacement {
+			replacingReplicaSetIDs[drain.ReplicaSetID.Int64] = true
+		}
+
+		if c.BusWriteChannel != nil {
+			remaining, err := DrainableMongodCount(tx, slave.ID)
+			if err != nil {
+				panic(err)
+			}
+			*c.BusWriteChannel <- SlaveDrainStatus{
+				Drain:            *drain,
+				Slave:            slave,
+				RemainingMongods: remaining,
+			}
+		}
+	}
+
+	return replacingReplicaSetIDs
+}
+
+func (c *ClusterAllocator) advanceSlaveDrain(tx *gorm.DB, decisions *AllocatorDecisions, drain *SlaveDrain, slave *Slave) {
+	for {
+		switch drain.State {
+
+		case SlaveDrainStateAddingReplacement:
+			if !drain.DrainedMongodID.Valid || !drain.ReplacementMongodID.Valid {
+				// Replica Set or one of the members was removed in the meantime
+				c.drainNextReplicaSet(tx, decisions, drain, slave)
+				if drain.State != SlaveDrainStateAddingReplacement {
+					return
+				}
+				continue
+			}
+			running, err := mongodObservedRunning(tx, drain.ReplacementMongodID.Int64)
+			if err != nil {
+				panic(err)
+			}
+			if !running {
+				return
+			}
+			caLog.Infof("replacement mongod `%d` is running, destroying mongod `%d` on drained slave `%s`",
+				drain.ReplacementMongodID.Int64, drain.DrainedMongodID.Int64, slave.Hostname)
+			destroyMongod(tx, drain.DrainedMongodID.Int64)
+			drain.State = SlaveDrainStateRemovingMember
+
+		case SlaveDrainStateRemovingMember:
+			if drain.DrainedMongodID.Valid {
+				return // wait until the Mongod is destroyed and removed from the database
+			}
+			c.drainNextReplicaSet(tx, decisions, drain, slave)
+			if drain.State != SlaveDrainStateAddingReplacement {
+				return
+			}
+
+		default: // SlaveDrainStatePending, SlaveDrainStateBlocked
+			c.drainNextReplicaSet(tx, decisions, drain, slave)
+			if drain.State != SlaveDrainStateAddingReplacement {
+				return
+			}
+
+		}
+	}
+}
+
+// Spawn a replacement member for the next Replica Set with a member on the drained Slave
+func (c *ClusterAllocator) drainNextReplicaSet(tx *gorm.DB, decisions *AllocatorDecisions, drain *SlaveDrain, slave *Slave) {
+
+	drain.ReplicaSetID = NullInt()
+	drain.DrainedMongodID = NullInt()
+	drain.ReplacementMongodID = NullInt()
+	drain.StateDescription = ""
+
+	var next struct {
+		MongodID     int64
+		ReplicaSetID int64
+		Arbiter      bool
+	}
+	res := tx.Raw(`SELECT m.id AS mongod_id, m.replica_set_id, m.arbiter
+		FROM mongods m
+		JOIN mongod_states desired ON m.desired_state_id = desired.id
+		WHERE
+			m.parent_slave_id = ?
+			AND m.replica_set_id IS NOT NULL
+			AND desired.execution_state NOT IN (?, ?)
+		ORDER BY m.replica_set_id, m.id
+		LIMIT 1`, slave.ID, MongodExecutionStateDestroyed, MongodExecutionStateForceDestroyed,
+	).Scan(&next)
+	if res.RecordNotFound() {
+		caLog.Infof("drain of slave `%s` finished", slave.Hostname)
+		finishSlaveDrain(drain, SlaveDrainStateFinished, "")
+		return
+	} else if res.Error != nil {
+		panic(res.Error)
+	}
+
+	var replicaSet ReplicaSet
+	if err := tx.First(&replicaSet, next.ReplicaSetID).Error; err != nil {
+		panic(err)
+	}
+
+	drain.ReplicaSetID = NullIntValue(replicaSet.ID)
+	drain.DrainedMongodID = NullIntValue(next.MongodID)
+
+	p := memberPersistence(next.Arbiter, slave.PersistentStorage)
+	host := c.selectHost(tx, &replicaSet, p, next.MongodID)
+	if host == nil {
+		caLog.Warnf("drain of slave `%s` blocked: no slave can host a replacement member of replica set `%s`", slave.Hostname, replicaSet.Name)
+		drain.State = SlaveDrainStateBlocked
+		drain.StateDescription = fmt.Sprintf("No Slave can host a replacement member of Replica Set `%s`", replicaSet.Name)
+		return
+	}
+
+	replacement, err := c.spawnMongodOnSlave(tx, decisions, host, &replicaSet, p)
+	if err != nil {
+		// selectHost should not have returned a slave without free ports
+		panic(err)
+	}
+	caLog.Infof("spawned replacement mongod `%d` on slave `%s` for mongod `%d` of replica set `%s` on drained slave `%s`",
+		replacement.ID, host.Hostname, next.MongodID, replicaSet.Name, slave.Hostname)
+
+	drain.State = SlaveDrainStateAddingReplacement
+	drain.ReplacementMongodID = NullIntValue(replacement.ID)
+}
+
+func finishSlaveDrain(drain *SlaveDrain, state SlaveDrainState, description string) {
+	now := time.Now()
+	drain.State = state
+	drain.StateDescription = description
+	drain.Finished = &now
+	drain.ReplicaSetID = NullInt()
+	drain.DrainedMongodID = NullInt()
+	drain.ReplacementMongodID = NullInt()
+}
+
+// Set the desired state of the Mongod to `destroyed`
+func destroyMongod(tx *gorm.DB, mongodID int64) {
+	res := tx.Exec(`UPDATE mongod_states SET execution_state = ?
+		WHERE id = (SELECT desired_state_id FROM mongods WHERE id = ?)`,
+		MongodExecutionStateDestroyed, mongodID)
+	if res.Error != nil {
+		panic(res.Error)
+	}
+}
+
+// Whether the Mongod was observed as PRIMARY or SECONDARY of its Replica Set
+func mongodObservedRunning(tx *gorm.DB, mongodID int64) (running bool, err error) {
+	var observedState sql.NullInt64
+	err = tx.Raw(`SELECT observed.execution_state
+		FROM mongods m
+		JOIN slaves s ON m.parent_slave_id = s.id
+		LEFT OUTER JOIN mongod_states observed ON m.observed_state_id = observed.id
+		WHERE m.id = ? AND m.observation_error_id IS NULL AND s.observation_error_id IS NULL`, mongodID,
+	).Row().Scan(&observedState)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	return observedState.Valid && MongodExecutionState(observedState.Int64) == MongodExecutionStateRunning, err
+}
+
+// The number of Replica Set members on the Slave that are not being destroyed
+func DrainableMongodCount(tx *gorm.DB, slaveID int64) (count uint, err error) {
+	err = tx.Raw(`SELECT COUNT(*)
+		FROM mongods m
+		JOIN mongod_states desired ON m.desired_state_id = desired.id
+		WHERE
+			m.parent_slave_id = ?
+			AND m.replica_set_id IS NOT NULL
+			AND desired.execution_state NOT IN (?, ?)`, slaveID, MongodExecutionStateDestroyed, MongodExecutionStateForceDestroyed,
+	).Row().Scan(&count)
+	return count, err
+}
+
+// Start draining the Slave. The Slave must be in maintenance.
+// A finished or cancelled drain of the Slave is restarted.
+func StartSlaveDrain(tx *gorm.DB, slave *Slave) (drain *SlaveDrain, err error) {
+
+	if slave.ConfiguredState != SlaveStateMaintenance {
+		return nil, fmt.Errorf("slave `%s` must be in maintenance to be drained", slave.Hostname)
+	}
+
+	drain = &SlaveDrain{}
+	res := tx.Where(SlaveDrain{SlaveID: slave.ID}).First(drain)
+	if res.Error != nil && !res.RecordNotFound() {
+		return nil, res.Error
+	}
+	if !res.RecordNotFound() && drain.State.Active() {
+		return drain, nil // already draining
+	}
+
+	*drain = SlaveDrain{
+		ID:      drain.ID,
+		SlaveID: slave.ID,
+		State:   SlaveDrainStatePending,
+		Started: time.Now(),
+	}
+	if err = tx.Save(drain).Error; err != nil {
+		return nil, err
+	}
+	return drain, nil
+}
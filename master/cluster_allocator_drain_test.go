@@ -0,0 +1,138 @@
+package master
+
+import (
+	. "github.com/KIT-MAMID/mamid/model"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestClusterAllocator_SlaveDrain(t *testing.T) {
+	db, slaves, _ := createClusterAllocatorTestDB(t)
+	defer db.CloseAndDrop()
+
+	busChannel := make(chan interface{}, 100)
+	var busWriteChannel chan<- interface{} = busChannel
+	c := ClusterAllocator{BusWriteChannel: &busWriteChannel}
+
+	compileMongodLayout(t, db, &c)
+
+	tx := db.Begin()
+	drained := mongodsOnSlave(t, tx, slaves[0].ID)
+	if !assert.Len(t, drained, 1) {
+		return
+	}
+	for _, s := range slaves[:2] {
+		for _, m := range mongodsOnSlave(t, tx, s.ID) {
+			observeMongod(t, tx, m, MongodExecutionStateRunning)
+		}
+	}
+
+	// Start the drain
+	slaves[0].ConfiguredState = SlaveStateMaintenance
+	assert.NoError(t, tx.Save(&slaves[0]).Error)
+	_, err := StartSlaveDrain(tx, &slaves[0])
+	assert.NoError(t, err)
+	assert.NoError(t, tx.Commit().Error)
+
+	compileMongodLayout(t, db, &c)
+
+	tx = db.Begin()
+	var drain SlaveDrain
+	assert.NoError(t, tx.First(&drain).Error)
+	assert.Equal(t, SlaveDrainStateAddingReplacement, drain.State)
+	assert.Equal(t, NullIntValue(drained[0].ID), drain.DrainedMongodID)
+	replacements := mongodsOnSlave(t, tx, slaves[2].ID)
+	if !assert.Len(t, replacements, 1, "should spawn a replacement on the free slave") {
+		return
+	}
+	assert.Equal(t, NullIntValue(replacements[0].ID), drain.ReplacementMongodID)
+	tx.Rollback()
+
+	// Replacement is not yet running
+	compileMongodLayout(t, db, &c)
+
+	tx = db.Begin()
+	assert.Equal(t, MongodExecutionStateRunning, mongodsOnSlave(t, tx, slaves[0].ID)[0].DesiredState.ExecutionState,
+		"drained member must not be removed before the replacement is running")
+	observeMongod(t, tx, replacements[0], MongodExecutionStateRunning)
+	assert.NoError(t, tx.Commit().Error)
+
+	compileMongodLayout(t, db, &c)
+
+	tx = db.Begin()
+	assert.NoError(t, tx.First(&drain).Error)
+	assert.Equal(t, SlaveDrainStateRemovingMember, drain.State)
+	assert.Equal(t, MongodExecutionStateDestroyed, mongodsOnSlave(t, tx, slaves[0].ID)[0].DesiredState.ExecutionState)
+
+	// The slave destroyed the Mongod
+	observeMongod(t, tx, drained[0], MongodExecutionStateDestroyed)
+	assert.NoError(t, tx.Commit().Error)
+
+	compileMongodLayout(t, db, &c)
+
+	tx = db.Begin()
+	assert.NoError(t, tx.First(&drain).Error)
+	assert.Equal(t, SlaveDrainStateFinished, drain.State)
+	assert.NotNil(t, drain.Finished)
+	assert.Empty(t, mongodsOnSlave(t, tx, slaves[0].ID))
+	tx.Rollback()
+
+	var lastStatus *SlaveDrainStatus
+	for len(busChannel) > 0 {
+		if status, ok := (<-busChannel).(SlaveDrainStatus); ok {
+			lastStatus = &status
+		}
+	}
+	if assert.NotNil(t, lastStatus, "should report drain progress on the bus") {
+		assert.Equal(t, SlaveDrainStateFinished, lastStatus.Drain.State)
+		assert.EqualValues(t, 0, lastStatus.RemainingMongods)
+	}
+}
+
+func TestClusterAllocator_SlaveDrain_blocked(t *testing.T) {
+	db, slaves, _ := createClusterAllocatorTestDB(t)
+	defer db.CloseAndDrop()
+
+	var c ClusterAllocator
+	compileMongodLayout(t, db, &c)
+
+	tx := db.Begin()
+	for i := range slaves {
+		slaves[i].ConfiguredState = SlaveStateMaintenance
+		assert.NoError(t, tx.Save(&slaves[i]).Error)
+	}
+	_, err := StartSlaveDrain(tx, &slaves[1])
+	assert.NoError(t, err)
+	assert.NoError(t, tx.Commit().Error)
+
+	compileMongodLayout(t, db, &c)
+
+	tx = db.Begin()
+	var drain SlaveDrain
+	assert.NoError(t, tx.First(&drain).Error)
+	assert.Equal(t, SlaveDrainStateBlocked, drain.State)
+	assert.NotEmpty(t, drain.StateDescription)
+	assert.Len(t, mongodsOnSlave(t, tx, slaves[1].ID), 1)
+
+	// Cancel by activating the slave
+	slaves[1].ConfiguredState = SlaveStateActive
+	assert.NoError(t, tx.Save(&slaves[1]).Error)
+	assert.NoError(t, tx.Commit().Error)
+
+	compileMongodLayout(t, db, &c)
+
+	tx = db.Begin()
+	assert.NoError(t, tx.First(&drain).Error)
+	assert.Equal(t, SlaveDrainStateCancelled, drain.State)
+	tx.Rollback()
+}
+
+func TestStartSlaveDrain_notInMaintenance(t *testing.T) {
+	db, slaves, _ := createClusterAllocatorTestDB(t)
+	defer db.CloseAndDrop()
+
+	tx := db.Begin()
+	defer tx.Rollback()
+	_, err := StartSlaveDrain(tx, &slaves[0])
+	assert.Error(t, err)
+}
@@ -0,0 +1,166 @@
+package master
+
+import (
+	"database/sql"
+	. "github.com/KIT-MAMID/mamid/model"
+	"github.com/jinzhu/gorm"
+)
+
+// Evaluate every Slave as host for an additional member of Replica Set r.
+// Members are only evaluated for the persistence types r has a member count > 0 for.
+// The criteria are the same as in CompileMongodLayout.
+func EvaluatePlacement(tx *gorm.DB, r *ReplicaSet) (evaluations []SlavePlacementEvaluation, err error) {
+	evaluations = make([]SlavePlacementEvaluation, 0)
+	for _, p := range []persistence{Persistent, Volatile, Arbiter} {
+		if (p == Persistent && r.PersistentMemberCount == 0) || (p == Volatile && r.VolatileMemberCount == 0) ||
+			(p == Arbiter && r.ArbiterMemberCount == 0) {
+			continue
+		}
+		e, err := evaluatePlacement(tx, r, p)
+		if err != nil {
+			return nil, err
+		}
+		evaluations = append(evaluations, e...)
+	}
+	return evaluations, nil
+}
+
+// Evaluate every Slave as host for an additional `p` member of Replica Set r
+func evaluatePlacement(tx *gorm.DB, r *ReplicaSet, p persistence) (evaluations []SlavePlacementEvaluation, err error) {
+
+	replicaSetID := r.ID
+
+	var usedRiskGroupIDs []int64
+	err = tx.Raw(`SELECT DISTINCT s.risk_group_id
+		FROM mongods m
+		JOIN slaves s ON m.parent_slave_id = s.id
+		WHERE m.replica_set_id = ? AND s.risk_group_id IS NOT NULL`, replicaSetID,
+	).Pluck("risk_group_id", &usedRiskGroupIDs).Error
+	if err != nil {
+		return nil, err
+	}
+	usedRiskGroups := make(map[int64]bool)
+	for _, id := range usedRiskGroupIDs {
+		usedRiskGroups[id] = true
+	}
+
+	var hostingSlaveIDs []int64
+	err = tx.Raw(`SELECT DISTINCT m.parent_slave_id
+		FROM mongods m
+		WHERE m.replica_set_id = ?`, replicaSetID,
+	).Pluck("parent_slave_id", &hostingSlaveIDs).Error
+	if err != nil {
+		return nil, err
+	}
+	hostingSlaves := make(map[int64]bool)
+	for _, id := range hostingSlaveIDs {
+		hostingSlaves[id] = true
+	}
+
+	rows, err := tx.Raw(`SELECT s.id, s.hostname, s.persistent_storage, s.configured_state, s.risk_group_id, s.free_mongods,
+			s.memory_capacity_mb, s.cpu_capacity_millicores, s.disk_capacity_gb,
+			s.requested_memory_mb, s.requested_cpu_millicores, s.requested_disk_gb
+		FROM slave_utilization s
+		ORDER BY s.id`).Rows()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	evaluations = make([]SlavePlacementEvaluation, 0)
+
+	for rows.Next() {
+
+		var (
+			slaveID           int64
+			hostname          string
+			persistentStorage bool
+			configuredState   SlaveState
+			riskGroupID       sql.NullInt64
+			freeMongods       int64
+			resources         slaveResources
+		)
+		if err = rows.Scan(&slaveID, &hostname, &persistentStorage, &configuredState, &riskGroupID, &freeMongods,
+			&resources.MemoryCapacityMB, &resources.CPUCapacityMillicores, &resources.DiskCapacityGB,
+			&resources.RequestedMemoryMB, &resources.RequestedCPUMillicores, &resources.RequestedDiskGB); err != nil {
+			return nil, err
+		}
+
+		evaluation := SlavePlacementEvaluation{
+			SlaveID:          slaveID,
+			SlaveHostname:    hostname,
+			PersistentMember: p == Persistent,
+			ArbiterMember:    p == Arbiter,
+			RejectionReasons: make([]PlacementRejectionReason, 0),
+		}
+		reject := func(reason PlacementRejectionReason) {
+			evaluation.RejectionReasons = append(evaluation.RejectionReasons, reason)
+		}
+
+		if p != Arbiter && persistentStorage != p.PersistentStorage() {
+			reject(PlacementRejectionWrongPersistence)
+		}
+		if configuredState != SlaveStateActive {
+			reject(PlacementRejectionNotActive)
+		}
+		if freeMongods <= 0 {
+			reject(PlacementRejectionNoFreePort)
+		} else if hostableMembers(int(freeMongods), resources, r, p) <= 0 {
+			reject(PlacementRejectionInsufficientResources)
+		}
+		if hostingSlaves[slaveID] {
+			reject(PlacementRejectionAlreadyHostsMember)
+		} else if riskGroupID.Valid && usedRiskGroups[riskGroupID.Int64] {
+			reject(PlacementRejectionRiskGroupUsed)
+		}
+
+		evaluations = append(evaluations, evaluation)
+
+	}
+
+	return evaluations, rows.Err()
+}
+
+func rejectedPlacements(evaluations []SlavePlacementEvaluation) (rejected []SlavePlacementEvaluation) {
+	rejected = make([]SlavePlacementEvaluation, 0, len(evaluations))
+	for _, e := range evaluations {
+		if !e.Suitable() {
+			rejected = append(rejected, e)
+		}
+	}
+	return rejected
+}
+
+// Resources of a Slave as declared and as requested by the members it hosts, see view `slave_utilization`
+type slaveResources struct {
+	MemoryCapacityMB       int64
+	CPUCapacityMillicores  int64
+	DiskCapacityGB         int64
+	RequestedMemoryMB      int64
+	RequestedCPUMillicores int64
+	RequestedDiskGB        int64
+}
+
+// The number of additional `p` members of Replica Set r the Slave's free ports (freeMongods) and free resources suffice for.
+// Undeclared capacities do not limit placement, arbiters request no resources.
+func hostableMembers(freeMongods int, s slaveResources, r *ReplicaSet, p persistence) int {
+	hostable := freeMongods
+	if p == Arbiter {
+		return hostable
+	}
+	limit := func(capacity, requested int64, request uint) {
+		if capacity == 0 || request == 0 {
+			return
+		}
+		if n := int((capacity - requested) / int64(request)); n < hostable {
+			hostable = n
+		}
+	}
+	limit(s.MemoryCapacityMB, s.RequestedMemoryMB, r.MemoryRequestMB)
+	limit(s.CPUCapacityMillicores, s.RequestedCPUMillicores, r.CPURequestMillicores)
+	limit(s.DiskCapacityGB, s.RequestedDiskGB, r.DiskRequestGB)
+	if hostable < 0 {
+		return 0
+	}
+	return hostable
+}
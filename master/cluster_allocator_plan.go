@@ -0,0 +1,101 @@
+package master
+
+import (
+	"fmt"
+	. "github.com/KIT-MAMID/mamid/model"
+	"github.com/jinzhu/gorm"
+)
+
+// A Mongod affected by a run of the ClusterAllocator
+type PlannedMongod struct {
+	MongodID       int64
+	ReplicaSetID   int64
+	ReplicaSetName string
+	SlaveID        int64
+	SlaveHostname  string
+	Port           PortNumber
+}
+
+// The changes a run of the ClusterAllocator makes to the Mongod layout
+type MongodLayoutDiff struct {
+	// Mongods created by the ClusterAllocator
+	Spawned []PlannedMongod
+	// Mongods whose desired state was changed to `destroyed` or `force_destroyed`
+	Destroyed []PlannedMongod
+}
+
+type plannedMongodRow struct {
+	PlannedMongod
+	DesiredExecutionState MongodExecutionState
+}
+
+// Run CompileMongodLayout on tx and compute the changes it made to the Mongod layout.
+// No messages are sent on the bus and the run is not recorded in the metrics.
+// The caller is responsible for rolling back tx if the changes shall not be persisted.
+func (c *ClusterAllocator) PlanMongodLayout(tx *gorm.DB) (diff MongodLayoutDiff, err error) {
+
+	beforeRows, err := mongodLayout(tx)
+	if err != nil {
+		return diff, fmt.Errorf("cannot read Mongod layout before planning: %s", err)
+	}
+	before := make(map[int64]plannedMongodRow, len(beforeRows))
+	for _, m := range beforeRows {
+		before[m.MongodID] = m
+	}
+
+	if err = c.dryRunCopy().CompileMongodLayout(tx, nil); err != nil {
+		return diff, err
+	}
+
+	after, err := mongodLayout(tx)
+	if err != nil {
+		return diff, fmt.Errorf("cannot read Mongod layout after planning: %s", err)
+	}
+
+	diff.Spawned = make([]PlannedMongod, 0)
+	diff.Destroyed = make([]PlannedMongod, 0)
+
+	for _, m := range after {
+		previous, existed := before[m.MongodID]
+		switch {
+		case !existed:
+			diff.Spawned = append(diff.Spawned, m.PlannedMongod)
+		case isDestroyedExecutionState(m.DesiredExecutionState) && !isDestroyedExecutionState(previous.DesiredExecutionState):
+			diff.Destroyed = append(diff.Destroyed, m.PlannedMongod)
+		}
+	}
+
+	return diff, nil
+}
+
+func isDestroyedExecutionState(s MongodExecutionState) bool {
+	return s == MongodExecutionStateDestroyed || s == MongodExecutionStateForceDestroyed
+}
+
+// All Mongods ordered by their ID
+func mongodLayout(tx *gorm.DB) (layout []plannedMongodRow, err error) {
+
+	rows, err := tx.Raw(`SELECT
+			m.id, COALESCE(m.replica_set_id, 0), COALESCE(r.name, ''), s.id, s.hostname, m.port, desired_state.execution_state
+		FROM mongods m
+		JOIN slaves s ON m.parent_slave_id = s.id
+		JOIN mongod_states desired_state ON m.desired_state_id = desired_state.id
+		LEFT OUTER JOIN replica_sets r ON m.replica_set_id = r.id
+		ORDER BY m.id`).Rows()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	layout = make([]plannedMongodRow, 0)
+	for rows.Next() {
+		var row plannedMongodRow
+		err = rows.Scan(&row.MongodID, &row.ReplicaSetID, &row.ReplicaSetName, &row.SlaveID, &row.SlaveHostname, &row.Port, &row.DesiredExecutionState)
+		if err != nil {
+			return nil, err
+		}
+		layout = append(layout, row)
+	}
+
+	return layout, rows.Err()
+}
@@ -0,0 +1,99 @@
+package master
+
+import (
+	. "github.com/KIT-MAMID/mamid/model"
+	"github.com/jinzhu/gorm"
+)
+
+/*
+	Hidden and delayed members
+
+	Hidden and delayed members are not placed separately:
+	the ClusterAllocator designates running data-bearing members of a Replica Set,
+	preferring members that already have the role, then members on persistent Slaves, then the newest members.
+
+	At least one member of a Replica Set always remains electable.
+*/
+
+// Designate the hidden and delayed members of every Replica Set.
+// Panics on database errors like CompileMongodLayout.
+func (c *ClusterAllocator) assignMemberRoles(tx *gorm.DB) {
+
+	var replicaSets []ReplicaSet
+	if err := tx.Find(&replicaSets).Error; err != nil {
+		panic(err)
+	}
+
+	for i := range replicaSets {
+		assignReplicaSetMemberRoles(tx, &replicaSets[i])
+	}
+}
+
+type roleCandidate struct {
+	ID      int64
+	Hidden  bool
+	Delayed bool
+}
+
+func assignReplicaSetMemberRoles(tx *gorm.DB, r *ReplicaSet) {
+
+	var members []roleCandidate
+	err := tx.Raw(`SELECT m.id, m.hidden, m.delayed
+		FROM mongods m
+		JOIN mongod_states desired ON m.desired_state_id = desired.id
+		JOIN slaves s ON m.parent_slave_id = s.id
+		WHERE
+			m.replica_set_id = ?
+			AND NOT m.arbiter
+			AND desired.execution_state = ?
+			AND s.configured_state != ?
+		ORDER BY s.persistent_storage DESC, m.id DESC`, r.ID, MongodExecutionStateRunning, SlaveStateDisabled,
+	).Scan(&members).Error
+	if err != nil {
+		panic(err)
+	}
+
+	available := len(members) - 1 // one member remains electable
+	if available < 0 {
+		available = 0
+	}
+	delayedCount := minInt(int(r.DelayedMemberCount), available)
+	hiddenCount := minInt(int(r.HiddenMemberCount), available-delayedCount)
+
+	delayed := pickRoleCandidates(members, delayedCount, nil, func(m roleCandidate) bool { return m.Delayed })
+	hidden := pickRoleCandidates(members, hiddenCount, delayed, func(m roleCandidate) bool { return m.Hidden })
+
+	for _, m := range members {
+		if m.Hidden == hidden[m.ID] && m.Delayed == delayed[m.ID] {
+			continue
+		}
+		caLog.Infof("designating mongod `%d` of replica set `%s`: hidden=%t delayed=%t", m.ID, r.Name, hidden[m.ID], delayed[m.ID])
+		res := tx.Exec("UPDATE mongods SET hidden = ?, delayed = ? WHERE id = ?", hidden[m.ID], delayed[m.ID], m.ID)
+		if res.Error != nil {
+			panic(res.Error)
+		}
+	}
+}
+
+// Pick count members not in `exclude`, members with hasRole first
+func pickRoleCandidates(members []roleCandidate, count int, exclude map[int64]bool, hasRole func(roleCandidate) bool) (picked map[int64]bool) {
+	picked = make(map[int64]bool, count)
+	for _, preferRole := range []bool{true, false} {
+		for _, m := range members {
+			if len(picked) == count {
+				return picked
+			}
+			if !exclude[m.ID] && hasRole(m) == preferRole {
+				picked[m.ID] = true
+			}
+		}
+	}
+	return picked
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
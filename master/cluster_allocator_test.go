@@ -4,6 +4,8 @@ import (
 	"database/sql"
 	"fmt"
 	. "github.com/KIT-MAMID/mamid/model"
+	"github.com/KIT-MAMID/mamid/msp"
+	"github.com/jinzhu/gorm"
 	"github.com/stretchr/testify/assert"
 	"testing"
 )
@@ -64,20 +66,14 @@ func saveDB(dsn string, driver string) (dump map[string][]string, err error) {
 		return
 	}
 	defer db.Close()
-	tables, err := db.Query("select tablename from pg_tables WHERE schemaname='public' ORDER by tablename")
-	//tables, err := db.Query("select repl_set_name from mongods")
-
-	defer tables.Close()
-	if err != nil {
-		return
-	}
-	for tables.Next() {
-		var table string
-		err = tables.Scan(&table)
-		if err != nil {
-			return dump, err
-		}
-		res, err := db.Query(fmt.Sprintf(`SELECT
+	var tablesQuery, primaryKeyQuery string
+	switch driver {
+	case "sqlite3":
+		tablesQuery = "SELECT name FROM sqlite_master WHERE type='table' AND name NOT LIKE 'sqlite_%' ORDER BY name"
+		primaryKeyQuery = "SELECT name FROM pragma_table_info('%s') WHERE pk = 1"
+	default:
+		tablesQuery = "select tablename from pg_tables WHERE schemaname='public' ORDER by tablename"
+		primaryKeyQuery = `SELECT
 		pg_attribute.attname
 		FROM pg_index, pg_class, pg_attribute, pg_namespace
 		WHERE
@@ -87,7 +83,20 @@ func saveDB(dsn string, driver string) (dump map[string][]string, err error) {
 		pg_class.relnamespace = pg_namespace.oid AND
 		pg_attribute.attrelid = pg_class.oid AND
 		pg_attribute.attnum = any(pg_index.indkey)
-		AND indisprimary;`, table))
+		AND indisprimary;`
+	}
+	tables, err := db.Query(tablesQuery)
+	if err != nil {
+		return
+	}
+	defer tables.Close()
+	for tables.Next() {
+		var table string
+		err = tables.Scan(&table)
+		if err != nil {
+			return dump, err
+		}
+		res, err := db.Query(fmt.Sprintf(primaryKeyQuery, table))
 		if err != nil {
 			return dump, err
 		}
@@ -151,6 +160,57 @@ func TestTestSaveDB(t *testing.T) {
 	assert.NotEqual(t, dump, dump2)
 }
 
+// Three active Slaves with persistent storage and a Replica Set `repl1` of two persistent members without Mongods
+func createClusterAllocatorTestDB(t *testing.T) (db *DB, slaves []Slave, replicaSet ReplicaSet) {
+	db, _, err := InitializeTestDB()
+	assert.NoError(t, err)
+
+	tx := db.Begin()
+	for _, hostname := range []string{"host1", "host2", "host3"} {
+		slave := Slave{
+			Hostname:             hostname,
+			Port:                 8081,
+			MongodPortRangeBegin: 18080,
+			MongodPortRangeEnd:   18090,
+			PersistentStorage:    true,
+			ConfiguredState:      SlaveStateActive,
+		}
+		assert.NoError(t, tx.Create(&slave).Error)
+		slaves = append(slaves, slave)
+	}
+	replicaSet = ReplicaSet{
+		Name:                  "repl1",
+		PersistentMemberCount: 2,
+		ShardingRole:          ShardingRoleNone,
+	}
+	assert.NoError(t, tx.Create(&replicaSet).Error)
+	assert.NoError(t, tx.Commit().Error)
+
+	return db, slaves, replicaSet
+}
+
+func compileMongodLayout(t *testing.T, db *DB, c *ClusterAllocator) {
+	tx := db.Begin()
+	var decisions AllocatorDecisions
+	assert.NoError(t, c.CompileMongodLayout(tx, &decisions))
+	assert.NoError(t, c.Commit(tx, decisions))
+}
+
+// Simulate an observation of the Mongod by the Monitor
+func observeMongod(t *testing.T, tx *gorm.DB, m *Mongod, state MongodExecutionState) {
+	observed := MongodState{ParentMongodID: m.ID, ShardingRole: ShardingRoleNone, ExecutionState: state}
+	assert.NoError(t, tx.Create(&observed).Error)
+	assert.NoError(t, tx.Model(m).UpdateColumn("ObservedStateID", NullIntValue(observed.ID)).Error) // do not save associations
+}
+
+func mongodsOnSlave(t *testing.T, tx *gorm.DB, slaveID int64) (mongods []*Mongod) {
+	assert.NoError(t, tx.Where(Mongod{ParentSlaveID: slaveID}).Find(&mongods).Error)
+	for _, m := range mongods {
+		assert.NoError(t, tx.Model(m).Related(&m.DesiredState, "DesiredState").Error)
+	}
+	return mongods
+}
+
 func TestClusterAllocator_CompileMongodLayout_Idempotence_Simple(t *testing.T) {
 	db, dsn, err := InitializeTestDBFromFile("cluster_allocator_test_fixture_allocate_full.sql")
 	defer db.CloseAndDrop()
@@ -159,7 +219,7 @@ func TestClusterAllocator_CompileMongodLayout_Idempotence_Simple(t *testing.T) {
 	assert.NoError(t, err)
 	var alloc ClusterAllocator
 	tx := db.Begin()
-	alloc.CompileMongodLayout(tx)
+	alloc.CompileMongodLayout(tx, nil)
 	tx.Commit()
 	defer tx.Close()
 	dump2, err := saveDB(dsn, db.Driver)
@@ -167,10 +227,257 @@ func TestClusterAllocator_CompileMongodLayout_Idempotence_Simple(t *testing.T) {
 	assert.NotEqual(t, dump, dump2)
 
 	tx = db.Begin()
-	alloc.CompileMongodLayout(tx)
+	alloc.CompileMongodLayout(tx, nil)
 	tx.Commit()
 	defer tx.Close()
 	dump3, err := saveDB(dsn, db.Driver)
 	assert.NoError(t, err)
 	assert.Equal(t, dump2, dump3)
 }
+
+func TestClusterAllocator_CompileMongodLayout_DisabledSlave(t *testing.T) {
+	db, slaves, _ := createClusterAllocatorTestDB(t)
+	defer db.CloseAndDrop()
+
+	var c ClusterAllocator
+	compileMongodLayout(t, db, &c)
+
+	tx := db.Begin()
+	disabled, healthy := mongodsOnSlave(t, tx, slaves[0].ID), mongodsOnSlave(t, tx, slaves[1].ID)
+	if !assert.Len(t, disabled, 1) || !assert.Len(t, healthy, 1) {
+		tx.Rollback()
+		return
+	}
+	observeMongod(t, tx, disabled[0], MongodExecutionStateRunning)
+	observeMongod(t, tx, healthy[0], MongodExecutionStateRunning)
+	slaves[0].ConfiguredState = SlaveStateDisabled
+	assert.NoError(t, tx.Save(&slaves[0]).Error)
+	assert.NoError(t, tx.Commit().Error)
+
+	// The first run spawns a replacement, the next one removes the excess member once the replacement is running
+	compileMongodLayout(t, db, &c)
+	tx = db.Begin()
+	for _, m := range mongodsOnSlave(t, tx, slaves[2].ID) {
+		observeMongod(t, tx, m, MongodExecutionStateRunning)
+	}
+	assert.NoError(t, tx.Commit().Error)
+	compileMongodLayout(t, db, &c)
+
+	tx = db.Begin()
+	defer tx.Rollback()
+	disabled, healthy = mongodsOnSlave(t, tx, slaves[0].ID), mongodsOnSlave(t, tx, slaves[1].ID)
+	assert.Equal(t, MongodExecutionStateDestroyed, disabled[0].DesiredState.ExecutionState, "the member on the disabled slave should be removed")
+	assert.Equal(t, MongodExecutionStateRunning, healthy[0].DesiredState.ExecutionState, "the healthy member must be kept")
+	assert.Len(t, mongodsOnSlave(t, tx, slaves[2].ID), 1, "the member should be replaced on the remaining slave")
+}
+
+func TestClusterAllocator_CompileMongodLayout_Arbiter(t *testing.T) {
+	db, slaves, replicaSet := createClusterAllocatorTestDB(t)
+	defer db.CloseAndDrop()
+
+	tx := db.Begin()
+	riskGroup := RiskGroup{Name: "rack1"}
+	assert.NoError(t, tx.Create(&riskGroup).Error)
+	for _, i := range []int{0, 2} {
+		slaves[i].RiskGroupID = NullIntValue(riskGroup.ID)
+		assert.NoError(t, tx.Save(&slaves[i]).Error)
+	}
+	replicaSet.ArbiterMemberCount = 1
+	assert.NoError(t, tx.Save(&replicaSet).Error)
+	assert.NoError(t, tx.Commit().Error)
+
+	var c ClusterAllocator
+	compileMongodLayout(t, db, &c)
+
+	tx = db.Begin()
+	var arbiters int
+	assert.NoError(t, tx.Model(&Mongod{}).Where("arbiter = ?", true).Count(&arbiters).Error)
+	assert.Equal(t, 0, arbiters, "arbiter must not share a risk group with a data-bearing member")
+
+	slaves[2].RiskGroupID = NullInt()
+	assert.NoError(t, tx.Save(&slaves[2]).Error)
+	assert.NoError(t, tx.Commit().Error)
+
+	compileMongodLayout(t, db, &c)
+
+	tx = db.Begin()
+	defer tx.Rollback()
+	mongods := mongodsOnSlave(t, tx, slaves[2].ID)
+	if assert.Len(t, mongods, 1) {
+		assert.True(t, mongods[0].Arbiter, "arbiter should be placed on the remaining slave")
+	}
+	for _, s := range slaves[:2] {
+		for _, m := range mongodsOnSlave(t, tx, s.ID) {
+			assert.False(t, m.Arbiter)
+		}
+	}
+
+	members, initiator, err := DesiredMSPReplicaSetMembersForReplicaSetID(tx, replicaSet.ID)
+	assert.NoError(t, err)
+	assert.Len(t, members, 3)
+	assert.False(t, initiator.Arbiter, "arbiters cannot initiate a replica set")
+	for _, member := range members {
+		assert.Equal(t, 1, member.Votes)
+		if member.ArbiterOnly {
+			assert.Equal(t, ReplicaSetMemberPriorityNone, member.Priority)
+		} else {
+			assert.Equal(t, ReplicaSetMemberPriorityPersistent, member.Priority)
+		}
+	}
+}
+
+func TestClusterAllocator_CompileMongodLayout_HiddenAndDelayed(t *testing.T) {
+	db, slaves, replicaSet := createClusterAllocatorTestDB(t)
+	defer db.CloseAndDrop()
+
+	tx := db.Begin()
+	replicaSet.PersistentMemberCount = 3
+	replicaSet.HiddenMemberCount = 1
+	replicaSet.DelayedMemberCount = 1
+	replicaSet.SlaveDelaySeconds = 3600
+	assert.NoError(t, tx.Save(&replicaSet).Error)
+	assert.NoError(t, tx.Commit().Error)
+
+	var c ClusterAllocator
+	compileMongodLayout(t, db, &c)
+
+	tx = db.Begin()
+	var roles []struct {
+		ParentSlaveID   int64
+		Hidden, Delayed bool
+	}
+	assert.NoError(t, tx.Raw("SELECT parent_slave_id, hidden, delayed FROM mongods ORDER BY parent_slave_id").Scan(&roles).Error)
+	if assert.Len(t, roles, 3) {
+		assert.False(t, roles[0].Hidden || roles[0].Delayed, "one member must remain electable")
+		assert.True(t, roles[1].Hidden)
+		assert.False(t, roles[1].Delayed)
+		assert.True(t, roles[2].Delayed)
+		assert.False(t, roles[2].Hidden)
+	}
+
+	members, initiator, err := DesiredMSPReplicaSetMembersForReplicaSetID(tx, replicaSet.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, slaves[0].ID, initiator.ParentSlaveID, "hidden members cannot become PRIMARY")
+	if assert.Len(t, members, 3) {
+		assert.Equal(t, msp.ReplicaSetMember{HostPort: msp.HostPort{Hostname: "host1", Port: 18080}, Priority: ReplicaSetMemberPriorityPersistent, Votes: 1}, members[0])
+		assert.Equal(t, msp.ReplicaSetMember{HostPort: msp.HostPort{Hostname: "host2", Port: 18080}, Priority: ReplicaSetMemberPriorityNone, Votes: 1, Hidden: true}, members[1])
+		assert.Equal(t, msp.ReplicaSetMember{HostPort: msp.HostPort{Hostname: "host3", Port: 18080}, Priority: ReplicaSetMemberPriorityNone, Votes: 1, Hidden: true, SlaveDelay: 3600}, members[2])
+	}
+
+	// Roles are kept stable when the Replica Set shrinks
+	replicaSet.HiddenMemberCount = 0
+	assert.NoError(t, tx.Save(&replicaSet).Error)
+	assert.NoError(t, tx.Commit().Error)
+
+	compileMongodLayout(t, db, &c)
+
+	tx = db.Begin()
+	defer tx.Rollback()
+	assert.NoError(t, tx.Raw("SELECT parent_slave_id, hidden, delayed FROM mongods ORDER BY parent_slave_id").Scan(&roles).Error)
+	if assert.Len(t, roles, 3) {
+		assert.False(t, roles[1].Hidden)
+		assert.True(t, roles[2].Delayed)
+	}
+}
+
+func TestClusterAllocator_CompileMongodLayout_Capacity(t *testing.T) {
+	db, slaves, replicaSet := createClusterAllocatorTestDB(t)
+	defer db.CloseAndDrop()
+
+	tx := db.Begin()
+	slaves[0].MemoryCapacityMB = 4096
+	slaves[1].MemoryCapacityMB = 1024
+	for _, s := range slaves[:2] {
+		assert.NoError(t, tx.Save(&s).Error)
+	}
+	replicaSet.MemoryRequestMB = 2048
+	assert.NoError(t, tx.Save(&replicaSet).Error)
+	assert.NoError(t, tx.Commit().Error)
+
+	var c ClusterAllocator
+	compileMongodLayout(t, db, &c)
+
+	tx = db.Begin()
+	assert.Len(t, mongodsOnSlave(t, tx, slaves[0].ID), 1)
+	assert.Len(t, mongodsOnSlave(t, tx, slaves[1].ID), 0, "slave without sufficient memory must not host a member")
+	assert.Len(t, mongodsOnSlave(t, tx, slaves[2].ID), 1, "slave with undeclared memory can host a member")
+
+	var utilization float64
+	assert.NoError(t, tx.Raw("SELECT utilization FROM slave_utilization WHERE id = ?", slaves[0].ID).Row().Scan(&utilization))
+	assert.Equal(t, 0.5, utilization, "utilization should be dominated by memory")
+
+	// A second Replica Set fills up the remaining memory of the first slave
+	for _, name := range []string{"repl2", "repl3"} {
+		assert.NoError(t, tx.Create(&ReplicaSet{
+			Name:                  name,
+			PersistentMemberCount: 2,
+			ShardingRole:          ShardingRoleNone,
+			MemoryRequestMB:       2048,
+		}).Error)
+	}
+	assert.NoError(t, tx.Commit().Error)
+
+	compileMongodLayout(t, db, &c)
+
+	tx = db.Begin()
+	defer tx.Rollback()
+	assert.Len(t, mongodsOnSlave(t, tx, slaves[0].ID), 2, "slave must not be overcommitted")
+	assert.Len(t, mongodsOnSlave(t, tx, slaves[1].ID), 0)
+
+	var repl3 ReplicaSet
+	assert.NoError(t, tx.Where(&ReplicaSet{Name: "repl3"}).First(&repl3).Error)
+	evaluations, err := EvaluatePlacement(tx, &repl3)
+	assert.NoError(t, err)
+	for _, e := range evaluations {
+		if e.SlaveID != slaves[2].ID {
+			assert.Equal(t, []PlacementRejectionReason{PlacementRejectionInsufficientResources}, e.RejectionReasons, "slave `%s`", e.SlaveHostname)
+		}
+	}
+}
+
+func TestClusterAllocator_AllocatorDecisions(t *testing.T) {
+	db, _, _ := createClusterAllocatorTestDB(t)
+	defer db.CloseAndDrop()
+
+	busChannel := make(chan interface{}, 100)
+	var busWriteChannel chan<- interface{} = busChannel
+	c := ClusterAllocator{BusWriteChannel: &busWriteChannel}
+	received := func() (decisions []AllocatorDecision) {
+		for {
+			select {
+			case message := <-busChannel:
+				if decision, ok := message.(AllocatorDecision); ok {
+					decisions = append(decisions, decision)
+				}
+			default:
+				return decisions
+			}
+		}
+	}
+
+	tx := db.Begin()
+	var collected AllocatorDecisions
+	assert.NoError(t, c.CompileMongodLayout(tx, &collected))
+	assert.Len(t, collected, 2)
+	assert.Empty(t, received(), "decisions must not be sent before the commit")
+	assert.NoError(t, c.Commit(tx, collected))
+	sent := received()
+	if assert.Len(t, sent, 2) {
+		for _, decision := range sent {
+			assert.False(t, decision.Removal)
+			assert.NotZero(t, decision.Mongod.ID)
+		}
+	}
+}
+
+func TestHostableMembers(t *testing.T) {
+	r := &ReplicaSet{MemoryRequestMB: 1024, CPURequestMillicores: 500, DiskRequestGB: 10}
+	s := slaveResources{MemoryCapacityMB: 4096, RequestedMemoryMB: 1024, CPUCapacityMillicores: 2000, DiskCapacityGB: 0}
+	assert.Equal(t, 3, hostableMembers(5, s, r, Persistent), "limited by memory")
+	assert.Equal(t, 2, hostableMembers(2, s, r, Persistent), "limited by free ports")
+	s.RequestedCPUMillicores = 1500
+	assert.Equal(t, 1, hostableMembers(5, s, r, Persistent), "limited by CPU")
+	s.RequestedMemoryMB = 8192
+	assert.Equal(t, 0, hostableMembers(5, s, r, Persistent), "overcommitted slaves host no further members")
+	assert.Equal(t, 5, hostableMembers(5, s, r, Arbiter), "arbiters request no resources")
+}
@@ -18,7 +18,7 @@ SET search_path = public, pg_catalog;
 -- Data for Name: mamid_metadata; Type: TABLE DATA; Schema: public; Owner: -
 --
 
-INSERT INTO mamid_metadata VALUES ('schema_version', '0.0.1');
+INSERT INTO mamid_metadata VALUES ('schema_version', '0.0.12');
 
 
 
@@ -34,7 +34,7 @@ SELECT pg_catalog.setval('mongod_states_id_seq', 3, true);
 -- Data for Name: replica_sets; Type: TABLE DATA; Schema: public; Owner: -
 --
 
-INSERT INTO replica_sets VALUES (1, 'test', 1, 2, 'configsvr', false);
+INSERT INTO replica_sets VALUES (1, 'test', 1, 2, 'configsvr', false, '', 0, 0, 0, 0, '', 0, 0, '', false, 0, 0, 0);
 
 
 --
@@ -47,9 +47,9 @@ INSERT INTO replica_sets VALUES (1, 'test', 1, 2, 'configsvr', false);
 -- Data for Name: slaves; Type: TABLE DATA; Schema: public; Owner: -
 --
 
-INSERT INTO slaves VALUES (1, 'test1', 8081, 18080, 18081, true, 1, NULL, NULL);
-INSERT INTO slaves VALUES (2, 'test2', 8081, 18080, 18081, false, 1, NULL, NULL);
-INSERT INTO slaves VALUES (3, 'test3', 8081, 18080, 18081, false, 1, NULL, NULL);
+INSERT INTO slaves VALUES (1, 'test1', 8081, 18080, 18081, true, 1, NULL, NULL, 0, 0, 0);
+INSERT INTO slaves VALUES (2, 'test2', 8081, 18080, 18081, false, 1, NULL, NULL, 0, 0, 0);
+INSERT INTO slaves VALUES (3, 'test3', 8081, 18080, 18081, false, 1, NULL, NULL, 0, 0, 0);
 
 
 --
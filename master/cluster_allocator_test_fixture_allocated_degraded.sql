@@ -18,7 +18,7 @@ SET search_path = public, pg_catalog;
 -- Data for Name: mamid_metadata; Type: TABLE DATA; Schema: public; Owner: -
 --
 
-INSERT INTO mamid_metadata VALUES ('schema_version', '0.0.1');
+INSERT INTO mamid_metadata VALUES ('schema_version', '0.0.12');
 
 
 --
@@ -52,7 +52,7 @@ INSERT INTO msp_errors VALUES (5, 'COMM', 'Error communicating with slave.', 'Ge
 -- Data for Name: replica_sets; Type: TABLE DATA; Schema: public; Owner: -
 --
 
-INSERT INTO replica_sets VALUES (1, 'test', 1, 2, 'configsvr', false);
+INSERT INTO replica_sets VALUES (1, 'test', 1, 2, 'configsvr', false, '', 0, 0, 0, 0, '', 0, 0, '', false, 0, 0, 0);
 
 
 --
@@ -65,18 +65,18 @@ INSERT INTO replica_sets VALUES (1, 'test', 1, 2, 'configsvr', false);
 -- Data for Name: slaves; Type: TABLE DATA; Schema: public; Owner: -
 --
 
-INSERT INTO slaves VALUES (1, 'test1', 8081, 18080, 18081, true, 1, NULL, 4);
-INSERT INTO slaves VALUES (2, 'test2', 8081, 18080, 18081, false, 1, NULL, 5);
-INSERT INTO slaves VALUES (3, 'test3', 8081, 18080, 18081, false, 1, NULL, NULL);
+INSERT INTO slaves VALUES (1, 'test1', 8081, 18080, 18081, true, 1, NULL, 4, 0, 0, 0);
+INSERT INTO slaves VALUES (2, 'test2', 8081, 18080, 18081, false, 1, NULL, 5, 0, 0, 0);
+INSERT INTO slaves VALUES (3, 'test3', 8081, 18080, 18081, false, 1, NULL, NULL, 0, 0, 0);
 
 
 --
 -- Data for Name: mongods; Type: TABLE DATA; Schema: public; Owner: -
 --
 
-INSERT INTO mongods VALUES (1, 18080, 'test', NULL, NULL, 1, 1, 1, NULL);
-INSERT INTO mongods VALUES (2, 18080, 'test', NULL, NULL, 2, 1, 2, NULL);
-INSERT INTO mongods VALUES (3, 18080, 'test', NULL, NULL, 3, 1, 3, NULL);
+INSERT INTO mongods VALUES (1, 18080, 'test', NULL, NULL, 1, 1, 1, NULL, false, false, false);
+INSERT INTO mongods VALUES (2, 18080, 'test', NULL, NULL, 2, 1, 2, NULL, false, false, false);
+INSERT INTO mongods VALUES (3, 18080, 'test', NULL, NULL, 3, 1, 3, NULL, false, false, false);
 
 
 --
@@ -5,14 +5,18 @@ import (
 	"crypto/x509"
 	"encoding/pem"
 	"flag"
+	"fmt"
 	"github.com/KIT-MAMID/mamid/master"
 	"github.com/KIT-MAMID/mamid/master/masterapi"
 	"github.com/KIT-MAMID/mamid/model"
 	"github.com/KIT-MAMID/mamid/msp"
 	"github.com/Sirupsen/logrus"
 	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"io/ioutil"
 	"net/http"
+	"strings"
 	"time"
 )
 
@@ -39,17 +43,39 @@ func main() {
 	// Command Line Flags
 	var (
 		logLevel                                                                 LogLevelFlag = LogLevelFlag{logrus.DebugLevel}
-		listenString                                                             string
+		listenString, metricsListenString                                        string
 		slaveVerifyCA, slaveAuthCert, slaveAuthKey, apiCert, apiKey, apiVerifyCA string
 		dbDriver, dbDSN                                                          string
+		dbMigrateOnly, dbMigrateDryRun                                           bool
+		placementStrategyName                                                    string
+		rebalanceThreshold                                                       float64
+		rebalanceMaxConcurrentMoves                                              int
+		rebalanceInterval                                                        time.Duration
 		monitorInterval                                                          = 10 * time.Second
+		hostMetricsThresholds                                                    = master.DefaultHostMetricsThresholds
+		replicationThresholds                                                    = master.DefaultReplicationThresholds
 		slaveTimeout                                                             = 5 * time.Second
 	)
 
 	flag.Var(&logLevel, "log.level", "possible values: debug, info, warning, error, fatal, panic")
-	flag.StringVar(&dbDriver, "db.driver", "postgres", "the database driver to use. See https://golang.org/pkg/database/sql/#Open")
-	flag.StringVar(&dbDSN, "db.dsn", "", "the data source name to use. for PostgreSQL, checkout https://godoc.org/github.com/lib/pq")
+	flag.StringVar(&dbDriver, "db.driver", "postgres", "the database driver to use: 'postgres' or 'sqlite3'")
+	flag.StringVar(&dbDSN, "db.dsn", "", "the data source name to use. for PostgreSQL, checkout https://godoc.org/github.com/lib/pq, "+
+		"for SQLite, specify the path to the database file")
+	flag.BoolVar(&dbMigrateOnly, "db.migrate-only", false, "migrate the database schema to the version required by this binary and exit")
+	flag.BoolVar(&dbMigrateDryRun, "db.migrate-dryrun", false,
+		"list pending database schema migrations and test them in a transaction that is rolled back, then exit")
+	flag.StringVar(&placementStrategyName, "placement.strategy", master.DefaultPlacementStrategyName,
+		fmt.Sprintf("the default strategy for placing Mongods on Slaves, can be overridden per Replica Set. possible values: %s",
+			strings.Join(master.PlacementStrategyNames(), ", ")))
+	flag.Float64Var(&rebalanceThreshold, "rebalance.threshold", master.DefaultRebalanceThreshold,
+		"minimum difference in utilization (0 to 1) between two Slaves for the rebalancer to move a Mongod between them")
+	flag.IntVar(&rebalanceMaxConcurrentMoves, "rebalance.max-concurrent-moves", master.DefaultRebalanceMaxConcurrentMoves,
+		"maximum number of Mongods moved by the rebalancer at the same time")
+	flag.DurationVar(&rebalanceInterval, "rebalance.interval", 0,
+		"Interval in which the rebalancer is run automatically, 0 disables automatic rebalancing. Specify with suffix [ms,s,min,...]")
 	flag.StringVar(&listenString, "listen", ":8080", "net.Listen() string, e.g. addr:port")
+	flag.StringVar(&metricsListenString, "metrics.listen", "",
+		"net.Listen() string for serving Prometheus metrics at /metrics over plain HTTP, e.g. localhost:8082 (empty = no metrics)")
 	flag.StringVar(&slaveVerifyCA, "slave.verifyCA", "", "The CA certificate to verify slaves against")
 	flag.StringVar(&slaveAuthCert, "slave.auth.cert", "", "The client certificate for authentication against the slave")
 	flag.StringVar(&slaveAuthKey, "slave.auth.key", "", "The key for the client certificate for authentication against the slave")
@@ -62,14 +88,39 @@ func main() {
 	flag.DurationVar(&monitorInterval, "monitor.interval", monitorInterval,
 		"Interval in which the monitoring component should poll slaves for status updates."+
 			"Note that the monitor waits for the slowest slave before a new monitor run starts (-slave.timeout). Specify with suffix [ms,s,min,...]")
+	flag.Float64Var(&hostMetricsThresholds.DataVolumeUsage, "monitor.threshold.dataVolume", hostMetricsThresholds.DataVolumeUsage,
+		"used share (0 to 1) of a slave's data volume above which a problem is reported, 0 disables the check")
+	flag.Float64Var(&hostMetricsThresholds.MemoryUsage, "monitor.threshold.memory", hostMetricsThresholds.MemoryUsage,
+		"share (0 to 1) of a slave's memory not available without swapping above which a problem is reported, 0 disables the check")
+	flag.Float64Var(&hostMetricsThresholds.LoadPerCPU, "monitor.threshold.loadPerCPU", hostMetricsThresholds.LoadPerCPU,
+		"5-minute load average per CPU of a slave above which a problem is reported, 0 disables the check")
+	flag.DurationVar(&replicationThresholds.MaxLag, "monitor.threshold.replicationLag", replicationThresholds.MaxLag,
+		"lag of a replica set member behind the PRIMARY, not counting its slave delay, above which a problem is reported, 0 disables the check. Specify with suffix [ms,s,min,...]")
+	flag.DurationVar(&replicationThresholds.MinOplogWindow, "monitor.threshold.oplogWindow", replicationThresholds.MinOplogWindow,
+		"time span covered by a full oplog of a PRIMARY below which a problem is reported, 0 disables the check. Specify with suffix [ms,s,min,...]")
 	flag.Parse()
 
-	if dbDriver != "postgres" {
-		masterLog.Fatal("-db.driver: only 'postgres' is supported")
+	if dbDriver != "postgres" && dbDriver != "sqlite3" {
+		masterLog.Fatal("-db.driver: only 'postgres' and 'sqlite3' are supported")
 	}
 	if dbDSN == "" {
 		masterLog.Fatal("-db.dsn cannot be empty")
 	}
+	if dbMigrateOnly || dbMigrateDryRun {
+		logrus.SetLevel(logLevel.lvl)
+		migrateDatabase(dbDriver, dbDSN, dbMigrateDryRun)
+		return
+	}
+	if rebalanceThreshold < 0 || rebalanceThreshold > 1 {
+		masterLog.Fatal("-rebalance.threshold must be between 0 and 1")
+	}
+	if rebalanceMaxConcurrentMoves < 1 {
+		masterLog.Fatal("-rebalance.max-concurrent-moves must be at least 1")
+	}
+	placementStrategy, err := master.PlacementStrategyByName(placementStrategyName)
+	if err != nil {
+		masterLog.Fatalf("-placement.strategy: %s", err)
+	}
 	if slaveVerifyCA == "" {
 		masterLog.Fatal("No root certificate for the slave server communication passed. Specify with -slave.verifyCA")
 	}
@@ -94,9 +145,15 @@ func main() {
 	db, err := model.InitializeDB(dbDriver, dbDSN)
 	dieOnError(err)
 
+	prometheus.MustRegister(bus, master.ProblemCollector{DB: db})
+	if metricsListenString != "" {
+		go serveMetrics(metricsListenString)
+	}
+
 	clusterAllocatorBusWriteChannel := bus.GetNewWriteChannel()
 	clusterAllocator := &master.ClusterAllocator{
-		BusWriteChannel: &clusterAllocatorBusWriteChannel,
+		BusWriteChannel:   &clusterAllocatorBusWriteChannel,
+		PlacementStrategy: placementStrategy,
 	}
 
 	tx := db.Begin()
@@ -108,6 +165,13 @@ func main() {
 
 	go clusterAllocator.Run(db)
 
+	rebalancer := &master.Rebalancer{
+		ClusterAllocator:   clusterAllocator,
+		Threshold:          rebalanceThreshold,
+		MaxConcurrentMoves: rebalanceMaxConcurrentMoves,
+	}
+	go rebalancer.Run(db, rebalanceInterval)
+
 	mainRouter := mux.NewRouter().StrictSlash(true)
 
 	httpStatic := http.FileServer(http.Dir("./gui/"))
@@ -115,13 +179,6 @@ func main() {
 	mainRouter.PathPrefix("/static/").Handler(httpStatic)
 	mainRouter.PathPrefix("/pages/").Handler(httpStatic)
 
-	masterAPI := &masterapi.MasterAPI{
-		DB:               db,
-		ClusterAllocator: clusterAllocator,
-		Router:           mainRouter.PathPrefix("/api/").Subrouter(),
-	}
-	masterAPI.Setup()
-
 	certPool := x509.NewCertPool()
 	cert, err := loadCertificateFromFile(slaveVerifyCA)
 	dieOnError(err)
@@ -141,11 +198,24 @@ func main() {
 		},
 	}
 
+	masterAPI := &masterapi.MasterAPI{
+		DB:               db,
+		ClusterAllocator: clusterAllocator,
+		Rebalancer:       rebalancer,
+		MSPClient:        mspClient,
+		Router:           mainRouter.PathPrefix("/api/").Subrouter(),
+		Events:           masterapi.NewEventStream(bus.GetNewReadChannel(), masterapi.DefaultEventHistorySize),
+	}
+	go masterAPI.Events.Run()
+	masterAPI.Setup()
+
 	monitor := master.Monitor{
-		DB:              db,
-		BusWriteChannel: bus.GetNewWriteChannel(),
-		MSPClient:       mspClient,
-		Interval:        monitorInterval,
+		DB:                    db,
+		BusWriteChannel:       bus.GetNewWriteChannel(),
+		MSPClient:             mspClient,
+		Interval:              monitorInterval,
+		HostMetricsThresholds: hostMetricsThresholds,
+		ReplicationThresholds: replicationThresholds,
 	}
 	go monitor.Run()
 
@@ -157,14 +227,41 @@ func main() {
 	go deployer.Run()
 
 	problemManager := master.ProblemManager{
-		DB:             db,
-		BusReadChannel: bus.GetNewReadChannel(),
+		DB:              db,
+		BusReadChannel:  bus.GetNewReadChannel(),
+		BusWriteChannel: bus.GetNewWriteChannel(),
 	}
 	go problemManager.Run()
 
 	listenAndServe(listenString, mainRouter, apiCert, apiKey, apiVerifyCA)
 }
 
+func migrateDatabase(driver, dsn string, dryRun bool) {
+
+	db, err := model.OpenDB(driver, dsn)
+	if err != nil {
+		masterLog.Fatalf("Error opening database: %s", err)
+	}
+
+	applied, err := db.Migrate(dryRun)
+	for _, m := range applied {
+		masterLog.Infof("Migration `%s` succeeded", m)
+	}
+	if err != nil {
+		masterLog.Fatalf("Error migrating database: %s", err)
+	}
+
+	switch {
+	case len(applied) == 0:
+		masterLog.Infof("Database schema is up to date (version `%s`)", model.SCHEMA_VERSION)
+	case dryRun:
+		masterLog.Infof("Dry run: %d pending migrations to schema version `%s` succeeded and were rolled back", len(applied), model.SCHEMA_VERSION)
+	default:
+		masterLog.Infof("Database schema migrated to version `%s`", model.SCHEMA_VERSION)
+	}
+
+}
+
 func dieOnError(err error) {
 	if err != nil {
 		panic(err)
@@ -181,6 +278,13 @@ func loadCertificateFromFile(file string) (cert *x509.Certificate, err error) {
 	return
 }
 
+func serveMetrics(listenString string) {
+	metricsRouter := http.NewServeMux()
+	metricsRouter.Handle("/metrics", promhttp.Handler())
+	err := http.ListenAndServe(listenString, metricsRouter)
+	dieOnError(err)
+}
+
 func listenAndServe(listenString string, mainRouter *mux.Router, apiCert string, apiKey string, apiVerifyCA string) {
 	// Listen...
 	if apiCert != "" {
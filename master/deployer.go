@@ -37,6 +37,10 @@ func (d *Deployer) handleMatchStatus(m MongodMatchStatus) {
 	if !m.Mismatch {
 		return
 	}
+	if m.CrashLooping {
+		deployerLog.Warnf("not deploying Mongod `%d` as it is crash looping on its slave", m.Mongod.ID)
+		return
+	}
 	d.pushMongodState(m.Mongod)
 }
 
@@ -132,6 +136,7 @@ func (d *Deployer) pushMongodState(mongod Mongod) {
 	if mspError != nil {
 		deployerLog.Errorf("MSP error establishing mongod state on `%s` for Mongod `(%v(id=%d),%d,)` in Replica Set `%s`: %s",
 			hostPort, mongod.ParentSlave, mongod.ParentSlaveID, mongod.Port, mongod.ReplSetName, mspError)
+		deployerPushFailures.WithLabelValues(hostPort.Hostname).Inc()
 	} else {
 		deployerLog.Debugf("finished establishing Mongod state on %s", hostPort)
 	}
@@ -148,6 +153,8 @@ func (d *Deployer) mspMongodStateRepresentation(tx *gorm.DB, mongod Mongod) (hos
 	var shardingRole msp.ShardingRole
 	var mspMongodState msp.MongodState
 	var replicaSetMembers []msp.ReplicaSetMember
+	var options msp.MongodOptions
+	var resources msp.MongodResources
 
 	// Fetch master representation
 	if err = tx.Model(&mongod).Related(&slave, "ParentSlave").Error; err != nil {
@@ -167,6 +174,12 @@ func (d *Deployer) mspMongodStateRepresentation(tx *gorm.DB, mongod Mongod) (hos
 		if replicaSetMembers, _, err = DesiredMSPReplicaSetMembersForReplicaSetID(tx, mongod.ReplicaSetID.Int64); err != nil {
 			return
 		}
+		var replicaSet ReplicaSet
+		if err = tx.First(&replicaSet, mongod.ReplicaSetID.Int64).Error; err != nil {
+			return
+		}
+		options = ProjectModelReplicaSetToMSPMongodOptions(replicaSet)
+		resources = ProjectModelReplicaSetToMSPMongodResources(replicaSet)
 	}
 
 	shardingRole, err = ProjectModelShardingRoleToMSPShardingRole(desiredState.ShardingRole)
@@ -200,6 +213,8 @@ func (d *Deployer) mspMongodStateRepresentation(tx *gorm.DB, mongod Mongod) (hos
 		},
 		KeyfileContent: keyfileContents,
 		State:          mspMongodState,
+		Options:        options,
+		Resources:      resources,
 	}
 
 	return
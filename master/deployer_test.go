@@ -82,6 +82,17 @@ func TestDeployer_mspMongodStateRepresentation(t *testing.T) {
 		State: expectedMongodState,
 	}, mspMongod)
 
+	// Options and resources of the Replica Set
+	assert.Nil(t, tx.Model(&model.ReplicaSet{ID: dbMongod.ReplicaSetID.Int64}).Updates(map[string]interface{}{
+		"storage_engine":         msp.StorageEngineWiredTiger,
+		"memory_request_mb":      1024,
+		"cpu_request_millicores": 500,
+	}).Error)
+	_, mspMongod, err = d.mspMongodStateRepresentation(tx, dbMongod)
+	assert.Nil(t, err)
+	assert.Equal(t, msp.MongodOptions{StorageEngine: msp.StorageEngineWiredTiger}, mspMongod.Options)
+	assert.Equal(t, msp.MongodResources{MemoryLimitBytes: 1024 * 1024 * 1024, CPULimitMillicores: 500}, mspMongod.Resources)
+
 }
 
 func TestDeployer_mspDesiredReplicaSetMembersForMongod(t *testing.T) {
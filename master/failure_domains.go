@@ -0,0 +1,192 @@
+package master
+
+import (
+	"database/sql"
+	"fmt"
+	"github.com/jinzhu/gorm"
+	"sort"
+)
+
+/*
+	Failure domains
+
+	RiskGroups form a forest of failure domains, e.g. datacenter > room > rack > host.
+	A Slave belongs to the failure domain it is assigned to and all its ancestors.
+
+	The ClusterAllocator never places two members of a Replica Set in the same RiskGroup a Slave is directly assigned to.
+	Beyond that, it prefers Slaves that share as few failure domains with the existing members as possible,
+	starting at the top level: a Slave in another datacenter is preferred over a Slave in another rack of the same datacenter.
+	Flat RiskGroups are simply failure domains without parent.
+
+	Overlap is compared by the Level of the RiskGroups, not by their depth in the tree,
+	so that trees of different shape (datacenter > rack, datacenter > room > rack) can be compared.
+	RiskGroups without Level are compared by their depth.
+	Slaves without RiskGroup might share any failure domain with the members and are therefore ranked last.
+*/
+
+type failureDomain struct {
+	parent sql.NullInt64
+	level  string
+}
+
+type failureDomainTree struct {
+	// indexed by failure domain ID
+	domains map[int64]failureDomain
+	// the level names, top level first
+	levels []string
+}
+
+func loadFailureDomainTree(tx *gorm.DB) (tree failureDomainTree, err error) {
+
+	rows, err := tx.Raw("SELECT id, parent_id, level FROM risk_groups").Rows()
+	if err != nil {
+		return tree, err
+	}
+	defer rows.Close()
+
+	domains := make(map[int64]failureDomain)
+	for rows.Next() {
+		var id int64
+		var domain failureDomain
+		if err = rows.Scan(&id, &domain.parent, &domain.level); err != nil {
+			return tree, err
+		}
+		domains[id] = domain
+	}
+	if err = rows.Err(); err != nil {
+		return tree, err
+	}
+
+	return newFailureDomainTree(domains), nil
+}
+
+func newFailureDomainTree(domains map[int64]failureDomain) failureDomainTree {
+	tree := failureDomainTree{domains: domains}
+	tree.levels = tree.orderLevels()
+	return tree
+}
+
+// The level of the failure domain at position depth of path
+func (t failureDomainTree) levelAt(path []int64, depth int) string {
+	if level := t.domains[path[depth]].level; level != "" {
+		return level
+	}
+	return fmt.Sprintf("#%d", depth)
+}
+
+// Order the levels such that every level comes before the levels below it.
+// Levels that cannot be ordered (e.g. room > rack in one tree and rack > room in another) are ordered by name.
+func (t failureDomainTree) orderLevels() []string {
+	below := make(map[string]map[string]bool)
+	above := make(map[string]int)
+	for id := range t.domains {
+		path := t.path(sql.NullInt64{Int64: id, Valid: true})
+		for depth := range path {
+			level := t.levelAt(path, depth)
+			if _, known := above[level]; !known {
+				below[level] = make(map[string]bool)
+				above[level] = 0
+			}
+			if depth > 0 {
+				parent := t.levelAt(path, depth-1)
+				if !below[parent][level] && parent != level {
+					below[parent][level] = true
+					above[level]++
+				}
+			}
+		}
+	}
+
+	levels := make([]string, 0, len(above))
+	for len(above) > 0 {
+		next := make([]string, 0)
+		for level, count := range above {
+			if count == 0 {
+				next = append(next, level)
+			}
+		}
+		if len(next) == 0 { // conflicting orders, take all remaining levels
+			for level := range above {
+				next = append(next, level)
+			}
+		}
+		sort.Strings(next)
+		for _, level := range next {
+			delete(above, level)
+			for child := range below[level] {
+				if _, remaining := above[child]; remaining {
+					above[child]--
+				}
+			}
+		}
+		levels = append(levels, next...)
+	}
+	return levels
+}
+
+// The IDs of the failure domain and all its ancestors, top level first.
+// Empty if domain is NULL.
+func (t failureDomainTree) path(domain sql.NullInt64) []int64 {
+	reversed := make([]int64, 0)
+	visited := make(map[int64]bool)
+	for current := domain; current.Valid && !visited[current.Int64]; current = t.domains[current.Int64].parent {
+		visited[current.Int64] = true // the API prevents cycles, but do not loop forever if there are any
+		reversed = append(reversed, current.Int64)
+	}
+	path := make([]int64, len(reversed))
+	for i, id := range reversed {
+		path[len(reversed)-1-i] = id
+	}
+	return path
+}
+
+// For every level of the tree, top level first, the number of `others` sharing the failure domain at this level with domain.
+// If domain is NULL, all `others` count as sharing every level.
+func (t failureDomainTree) overlap(domain sql.NullInt64, others []sql.NullInt64) []int {
+	overlap := make([]int, len(t.levels))
+	if !domain.Valid {
+		for i := range overlap {
+			overlap[i] = len(others)
+		}
+		return overlap
+	}
+
+	index := make(map[string]int, len(t.levels))
+	for i, level := range t.levels {
+		index[level] = i
+	}
+	path := t.path(domain)
+	for _, other := range others {
+		shared := make(map[int64]bool)
+		for _, id := range t.path(other) {
+			shared[id] = true
+		}
+		for depth, id := range path {
+			if shared[id] {
+				overlap[index[t.levelAt(path, depth)]]++
+			}
+		}
+	}
+	return overlap
+}
+
+// Compare overlaps lexicographically, top level first. Missing levels count as 0.
+// Returns -1 if a has less overlap than b, 1 if a has more overlap, 0 otherwise.
+func compareFailureDomainOverlap(a, b []int) int {
+	for level := 0; level < len(a) || level < len(b); level++ {
+		var aLevel, bLevel int
+		if level < len(a) {
+			aLevel = a[level]
+		}
+		if level < len(b) {
+			bLevel = b[level]
+		}
+		switch {
+		case aLevel < bLevel:
+			return -1
+		case aLevel > bLevel:
+			return 1
+		}
+	}
+	return 0
+}
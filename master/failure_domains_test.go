@@ -0,0 +1,90 @@
+package master
+
+import (
+	"database/sql"
+	. "github.com/KIT-MAMID/mamid/model"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+// datacenter 1 > rack 2, rack 3, room 7 > rack 8; datacenter 4 > rack 5; flat risk group 6 without level
+func fixtureFailureDomainTree() failureDomainTree {
+	return newFailureDomainTree(map[int64]failureDomain{
+		1: {NullInt(), "datacenter"},
+		2: {NullIntValue(1), "rack"},
+		3: {NullIntValue(1), "rack"},
+		4: {NullInt(), "datacenter"},
+		5: {NullIntValue(4), "rack"},
+		6: {NullInt(), ""},
+		7: {NullIntValue(1), "room"},
+		8: {NullIntValue(7), "rack"},
+	})
+}
+
+func TestFailureDomainTree_path(t *testing.T) {
+	tree := fixtureFailureDomainTree()
+
+	assert.Equal(t, []int64{1, 2}, tree.path(NullIntValue(2)))
+	assert.Equal(t, []int64{6}, tree.path(NullIntValue(6)))
+	assert.Empty(t, tree.path(NullInt()))
+
+	tree.domains[1] = failureDomain{NullIntValue(2), "datacenter"}
+	assert.Len(t, tree.path(NullIntValue(2)), 2, "cycles must not loop forever")
+}
+
+func TestFailureDomainTree_levels(t *testing.T) {
+	tree := fixtureFailureDomainTree()
+
+	assert.Equal(t, []string{"#0", "datacenter", "room", "rack"}, tree.levels)
+}
+
+func TestFailureDomainTree_overlap(t *testing.T) {
+	tree := fixtureFailureDomainTree()
+	members := []sql.NullInt64{NullIntValue(2), NullIntValue(5), NullInt()}
+
+	assert.Equal(t, []int{0, 1, 0, 0}, tree.overlap(NullIntValue(3), members), "shares datacenter with one member")
+	assert.Equal(t, []int{0, 1, 0, 1}, tree.overlap(NullIntValue(2), members))
+	assert.Equal(t, []int{0, 0, 0, 0}, tree.overlap(NullIntValue(6), members))
+	assert.Equal(t, []int{1, 0, 0, 0}, tree.overlap(NullIntValue(6), []sql.NullInt64{NullIntValue(6)}))
+}
+
+func TestFailureDomainTree_overlap_unbalanced(t *testing.T) {
+	tree := fixtureFailureDomainTree()
+	members := []sql.NullInt64{NullIntValue(8)}
+
+	assert.Equal(t, []int{0, 1, 1, 1}, tree.overlap(NullIntValue(8), members))
+	assert.Equal(t, []int{0, 1, 0, 0}, tree.overlap(NullIntValue(2), members),
+		"the rack directly below the datacenter must not be compared to the room")
+	assert.Equal(t, 1, compareFailureDomainOverlap(tree.overlap(NullIntValue(2), members), tree.overlap(NullIntValue(5), members)))
+}
+
+func TestFailureDomainTree_overlap_unassigned(t *testing.T) {
+	tree := fixtureFailureDomainTree()
+	members := []sql.NullInt64{NullIntValue(2), NullIntValue(5)}
+
+	unassigned := tree.overlap(NullInt(), members)
+	assert.Equal(t, []int{2, 2, 2, 2}, unassigned, "a Slave without RiskGroup might share every failure domain")
+	for _, domain := range []int64{2, 3, 5, 6, 8} {
+		assert.Equal(t, 1, compareFailureDomainOverlap(unassigned, tree.overlap(NullIntValue(domain), members)),
+			"a Slave without RiskGroup must rank after Slave in RiskGroup %d", domain)
+	}
+	assert.Equal(t, 0, compareFailureDomainOverlap(unassigned, tree.overlap(NullInt(), members)))
+}
+
+func TestCompareFailureDomainOverlap(t *testing.T) {
+	assert.Equal(t, 0, compareFailureDomainOverlap([]int{}, []int{0, 0}))
+	assert.Equal(t, -1, compareFailureDomainOverlap([]int{0, 3}, []int{1, 0}), "top level takes precedence")
+	assert.Equal(t, 1, compareFailureDomainOverlap([]int{1, 1}, []int{1, 0}))
+}
+
+func TestPlacementStrategy_failureDomains(t *testing.T) {
+	candidates := fixturePlacementCandidates()
+	candidates[0].FailureDomainOverlap = []int{0, 0}
+	candidates[1].FailureDomainOverlap = []int{1, 0}
+	candidates[2].FailureDomainOverlap = []int{0}
+
+	assert.Equal(t, []int64{1, 3, 2}, rankedSlaveIDs(SpreadPlacementStrategy{}.RankHosts(candidates)),
+		"slaves sharing a failure domain with members should be ranked last")
+	assert.Equal(t, []int64{2, 3, 1}, rankedSlaveIDs(SpreadPlacementStrategy{}.RankVictims(candidates)),
+		"mongods sharing a failure domain with other members should be removed first")
+}
@@ -0,0 +1,380 @@
+package masterapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/KIT-MAMID/mamid/model"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+/*
+	Event stream
+
+	GET /api/events streams changes of the cluster as Server-Sent Events (https://www.w3.org/TR/eventsource/).
+	The EventStream subscribes to the master's bus once and forwards changes to all connected clients:
+	the Monitor and ClusterAllocator repeat their status messages on every run, only changes are forwarded.
+
+	Event IDs increase monotonically and start at the master's startup time in nanoseconds, i.e. IDs of a
+	previous master process are lower than all IDs of the current one.
+	A client reconnecting with `Last-Event-ID` receives the events it missed from the stream's history.
+	If they are no longer available, the client receives a `resync` event first and should re-fetch the
+	resources it is interested in.
+
+	Clients may filter by Slave (`?slave=ID`) and Replica Set (`?replicaset=ID`). Events not related to
+	the filtered Slave or Replica Set are not sent, except for `resync`.
+*/
+
+const (
+	EventTypeResync                     = "resync"
+	EventTypeConnectionStatus           = "connection_status"
+	EventTypeMongodMatchStatus          = "mongod_match_status"
+	EventTypeReplicaSetConstraintStatus = "replica_set_constraint_status"
+	EventTypeProblemCreated             = "problem_created"
+	EventTypeProblemResolved            = "problem_resolved"
+	EventTypeAllocatorDecision          = "allocator_decision"
+)
+
+const DefaultEventHistorySize = 1000
+
+// Subscribers that do not keep up are disconnected and resume using Last-Event-ID
+const eventSubscriberBufferSize = 100
+
+const eventStreamKeepaliveInterval = 30 * time.Second
+
+type Event struct {
+	ID           uint64      `json:"id"`
+	Type         string      `json:"type"`
+	Time         time.Time   `json:"time"`
+	SlaveID      *int64      `json:"slave_id"`
+	ReplicaSetID *int64      `json:"replica_set_id"`
+	Data         interface{} `json:"data"` // one of the *EventData types below, Problem for problem events
+}
+
+type ConnectionStatusEventData struct {
+	Hostname    string `json:"hostname"`
+	Unreachable bool   `json:"unreachable"`
+	Error       string `json:"error"`
+}
+
+type MongodMatchStatusEventData struct {
+	MongodID     int64 `json:"mongod_id"`
+	Port         uint  `json:"port"`
+	Mismatch     bool  `json:"mismatch"`
+	CrashLooping bool  `json:"crash_looping"`
+	OptionsDrift bool  `json:"options_drift"`
+}
+
+type ReplicaSetConstraintStatusEventData struct {
+	Name        string `json:"name"`
+	Constraint  string `json:"constraint"` // `desired`: the ClusterAllocator's placement, `observed`: running members
+	Unsatisfied bool   `json:"unsatisfied"`
+}
+
+type AllocatorDecisionEventData struct {
+	Decision string `json:"decision"` // `add` or `remove` a member
+	MongodID int64  `json:"mongod_id"`
+	Port     uint   `json:"port"` // 0 for removals
+}
+
+type mongodMatchFlags struct {
+	mismatch, crashLooping, optionsDrift bool
+}
+
+type constraintKey struct {
+	replicaSetID int64
+	constraint   string
+}
+
+type EventStream struct {
+	busReadChannel <-chan interface{}
+	historySize    int
+
+	mutex       sync.Mutex // protects the fields below
+	history     []Event    // oldest first
+	nextID      uint64
+	subscribers map[chan Event]bool
+
+	// last forwarded states, only accessed by Run
+	unreachable map[int64]bool
+	mongodFlags map[int64]mongodMatchFlags
+	unsatisfied map[constraintKey]bool
+}
+
+func NewEventStream(busReadChannel <-chan interface{}, historySize int) *EventStream {
+	return &EventStream{
+		busReadChannel: busReadChannel,
+		historySize:    historySize,
+		nextID:         uint64(time.Now().UnixNano()),
+		subscribers:    make(map[chan Event]bool),
+		unreachable:    make(map[int64]bool),
+		mongodFlags:    make(map[int64]mongodMatchFlags),
+		unsatisfied:    make(map[constraintKey]bool),
+	}
+}
+
+func (s *EventStream) Run() {
+	for message := range s.busReadChannel {
+		if event, changed := s.eventForMessage(message); changed {
+			s.publish(event)
+		}
+	}
+}
+
+// The event for a bus message, changed is false if the message does not change the cluster's state
+func (s *EventStream) eventForMessage(message interface{}) (event Event, changed bool) {
+	switch message.(type) {
+	case model.ConnectionStatus:
+		status := message.(model.ConnectionStatus)
+		if s.unreachable[status.Slave.ID] == status.Unreachable {
+			return event, false
+		}
+		s.unreachable[status.Slave.ID] = status.Unreachable
+		data := ConnectionStatusEventData{Hostname: status.Slave.Hostname, Unreachable: status.Unreachable}
+		if status.Unreachable {
+			data.Error = status.CommunicationError.Description
+		}
+		return Event{Type: EventTypeConnectionStatus, SlaveID: &status.Slave.ID, Data: data}, true
+	case model.MongodMatchStatus:
+		status := message.(model.MongodMatchStatus)
+		flags := mongodMatchFlags{status.Mismatch, status.CrashLooping, status.OptionsDrift}
+		if s.mongodFlags[status.Mongod.ID] == flags {
+			return event, false
+		}
+		s.mongodFlags[status.Mongod.ID] = flags
+		return Event{
+			Type:         EventTypeMongodMatchStatus,
+			SlaveID:      &status.Mongod.ParentSlaveID,
+			ReplicaSetID: model.NullIntToPtr(status.Mongod.ReplicaSetID),
+			Data: MongodMatchStatusEventData{
+				MongodID:     status.Mongod.ID,
+				Port:         uint(status.Mongod.Port),
+				Mismatch:     status.Mismatch,
+				CrashLooping: status.CrashLooping,
+				OptionsDrift: status.OptionsDrift,
+			},
+		}, true
+	case model.DesiredReplicaSetConstraintStatus:
+		status := message.(model.DesiredReplicaSetConstraintStatus)
+		return s.constraintEvent(status.ReplicaSet, "desired", status.Unsatisfied)
+	case model.ObservedReplicaSetConstraintStatus:
+		status := message.(model.ObservedReplicaSetConstraintStatus)
+		return s.constraintEvent(status.ReplicaSet, "observed", status.Unsatisfied)
+	case model.ProblemStatus:
+		status := message.(model.ProblemStatus)
+		eventType := EventTypeProblemCreated
+		if status.Resolved {
+			eventType = EventTypeProblemResolved
+		}
+		problem := ProjectModelProblemToProblem(&status.Problem)
+		return Event{Type: eventType, SlaveID: problem.SlaveId, ReplicaSetID: problem.ReplicaSetId, Data: problem}, true
+	case model.AllocatorDecision:
+		decision := message.(model.AllocatorDecision)
+		data := AllocatorDecisionEventData{Decision: "add", MongodID: decision.Mongod.ID, Port: uint(decision.Mongod.Port)}
+		if decision.Removal {
+			data.Decision = "remove"
+		}
+		return Event{
+			Type:         EventTypeAllocatorDecision,
+			SlaveID:      &decision.Mongod.ParentSlaveID,
+			ReplicaSetID: model.NullIntToPtr(decision.Mongod.ReplicaSetID),
+			Data:         data,
+		}, true
+	}
+	return event, false
+}
+
+func (s *EventStream) constraintEvent(replicaSet model.ReplicaSet, constraint string, unsatisfied bool) (event Event, changed bool) {
+	key := constraintKey{replicaSet.ID, constraint}
+	if s.unsatisfied[key] == unsatisfied {
+		return event, false
+	}
+	s.unsatisfied[key] = unsatisfied
+	return Event{
+		Type:         EventTypeReplicaSetConstraintStatus,
+		ReplicaSetID: &replicaSet.ID,
+		Data:         ReplicaSetConstraintStatusEventData{Name: replicaSet.Name, Constraint: constraint, Unsatisfied: unsatisfied},
+	}, true
+}
+
+// Assign an ID to the event, record it in the history and send it to all subscribers
+func (s *EventStream) publish(event Event) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	event.ID = s.nextID
+	s.nextID++
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+
+	s.history = append(s.history, event)
+	if len(s.history) > s.historySize {
+		s.history = s.history[len(s.history)-s.historySize:]
+	}
+
+	for subscriber := range s.subscribers {
+		select {
+		case subscriber <- event:
+		default:
+			masterapiLog.Warn("event stream subscriber does not keep up, disconnecting it")
+			delete(s.subscribers, subscriber)
+			close(subscriber)
+		}
+	}
+}
+
+// Subscribe to events published from now on.
+// If lastEventID is not nil, replay contains the events published after it that are still available.
+// If some are no longer available, resync is the ID of the resync event to be sent before replay.
+func (s *EventStream) subscribe(lastEventID *uint64) (events chan Event, replay []Event, resync *uint64) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	events = make(chan Event, eventSubscriberBufferSize)
+	s.subscribers[events] = true
+
+	if lastEventID == nil {
+		return events, nil, nil
+	}
+
+	if *lastEventID >= s.nextID {
+		// from the future, e.g. the master's clock went back
+		latest := s.nextID - 1
+		return events, nil, &latest
+	}
+
+	oldestAvailable := s.nextID
+	if len(s.history) > 0 {
+		oldestAvailable = s.history[0].ID
+	}
+	for _, event := range s.history {
+		if event.ID > *lastEventID {
+			replay = append(replay, event)
+		}
+	}
+	if *lastEventID+1 < oldestAvailable {
+		// e.g. published by a previous master process or dropped from the history
+		beforeReplay := oldestAvailable - 1
+		return events, replay, &beforeReplay
+	}
+	return events, replay, nil
+}
+
+func (s *EventStream) unsubscribe(events chan Event) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.subscribers[events] {
+		delete(s.subscribers, events)
+		close(events)
+	}
+}
+
+type eventFilter struct {
+	slaveID, replicaSetID *int64
+}
+
+func (f eventFilter) matches(e Event) bool {
+	if e.Type == EventTypeResync {
+		return true
+	}
+	if f.slaveID != nil && (e.SlaveID == nil || *e.SlaveID != *f.slaveID) {
+		return false
+	}
+	if f.replicaSetID != nil && (e.ReplicaSetID == nil || *e.ReplicaSetID != *f.replicaSetID) {
+		return false
+	}
+	return true
+}
+
+func writeEvent(w http.ResponseWriter, e Event) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", e.ID, e.Type, data)
+	return err
+}
+
+func (m *MasterAPI) EventsGet(w http.ResponseWriter, r *http.Request) {
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(w, "streaming is not supported")
+		return
+	}
+
+	var filter eventFilter
+	for param, target := range map[string]**int64{"slave": &filter.slaveID, "replicaset": &filter.replicaSetID} {
+		if value := r.URL.Query().Get(param); value != "" {
+			id, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				fmt.Fprintf(w, "invalid %s id `%s`", param, value)
+				return
+			}
+			*target = &id
+		}
+	}
+
+	var lastEventID *uint64
+	if value := r.Header.Get("Last-Event-ID"); value != "" {
+		id, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintf(w, "invalid Last-Event-ID `%s`", value)
+			return
+		}
+		lastEventID = &id
+	}
+
+	events, replay, resync := m.Events.subscribe(lastEventID)
+	defer m.Events.unsubscribe(events)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	if resync != nil {
+		// the resync event's ID lets the client resume after it
+		if err := writeEvent(w, Event{ID: *resync, Type: EventTypeResync, Time: time.Now()}); err != nil {
+			return
+		}
+	}
+	for _, event := range replay {
+		if filter.matches(event) {
+			if err := writeEvent(w, event); err != nil {
+				return
+			}
+		}
+	}
+	flusher.Flush()
+
+	keepalive := time.NewTicker(eventStreamKeepaliveInterval)
+	defer keepalive.Stop()
+	for {
+		select {
+		case event, ok := <-events:
+			if !ok {
+				return // disconnected by the EventStream
+			}
+			if !filter.matches(event) {
+				continue
+			}
+			if err := writeEvent(w, event); err != nil {
+				return
+			}
+		case <-keepalive.C:
+			if _, err := fmt.Fprint(w, ": keepalive\n\n"); err != nil {
+				return
+			}
+		case <-r.Context().Done():
+			return
+		}
+		flusher.Flush()
+	}
+
+}
@@ -0,0 +1,132 @@
+package masterapi
+
+import (
+	"bufio"
+	"github.com/KIT-MAMID/mamid/model"
+	"github.com/KIT-MAMID/mamid/msp"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+type sentEvent struct {
+	id        uint64
+	eventType string
+	data      string
+}
+
+func createEventStreamServer(historySize int) (bus chan interface{}, server *httptest.Server) {
+	bus = make(chan interface{}, 10)
+	stream := NewEventStream(bus, historySize)
+	go stream.Run()
+
+	mainRouter := mux.NewRouter().StrictSlash(true)
+	masterAPI := &MasterAPI{
+		Events: stream,
+		Router: mainRouter.PathPrefix("/api/").Subrouter(),
+	}
+	masterAPI.Setup()
+	return bus, httptest.NewServer(mainRouter)
+}
+
+func openEventStream(t *testing.T, url string, lastEventID string) (*http.Response, *bufio.Reader) {
+	req, err := http.NewRequest("GET", url, nil)
+	assert.NoError(t, err)
+	if lastEventID != "" {
+		req.Header.Set("Last-Event-ID", lastEventID)
+	}
+	client := http.Client{Timeout: 5 * time.Second}
+	resp, err := client.Do(req)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+	return resp, bufio.NewReader(resp.Body)
+}
+
+func readEvent(t *testing.T, reader *bufio.Reader) (e sentEvent) {
+	for {
+		line, err := reader.ReadString('\n')
+		if !assert.NoError(t, err) {
+			return
+		}
+		line = strings.TrimSuffix(line, "\n")
+		switch {
+		case line == "":
+			return
+		case strings.HasPrefix(line, "id: "):
+			e.id, err = strconv.ParseUint(strings.TrimPrefix(line, "id: "), 10, 64)
+			assert.NoError(t, err)
+		case strings.HasPrefix(line, "event: "):
+			e.eventType = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			e.data = strings.TrimPrefix(line, "data: ")
+		}
+	}
+}
+
+func TestMasterAPI_EventsGet(t *testing.T) {
+	bus, server := createEventStreamServer(2)
+	defer server.Close()
+
+	resp, reader := openEventStream(t, server.URL+"/api/events?slave=1", "")
+
+	unreachable := model.ConnectionStatus{
+		Slave:              model.Slave{ID: 1, Hostname: "host1"},
+		Unreachable:        true,
+		CommunicationError: msp.Error{Description: "connection refused"},
+	}
+	bus <- unreachable
+	// unchanged, not forwarded
+	bus <- unreachable
+	// other slave, filtered
+	bus <- model.AllocatorDecision{Mongod: model.Mongod{ID: 3, Port: 2000, ParentSlaveID: 2}}
+	bus <- model.ProblemStatus{Problem: model.Problem{ID: 5, Description: "foo", SlaveID: model.NullIntValue(1)}}
+
+	connection := readEvent(t, reader)
+	assert.Equal(t, EventTypeConnectionStatus, connection.eventType)
+	assert.Contains(t, connection.data, `"unreachable":true`)
+	assert.Contains(t, connection.data, `"error":"connection refused"`)
+
+	problem := readEvent(t, reader)
+	assert.Equal(t, EventTypeProblemCreated, problem.eventType)
+	assert.Equal(t, connection.id+2, problem.id, "the allocator decision was published in between")
+	assert.Contains(t, problem.data, `"id":5`)
+	resp.Body.Close()
+
+	// Resume, the missed events are still in the history
+	resp, reader = openEventStream(t, server.URL+"/api/events", strconv.FormatUint(connection.id, 10))
+	decision := readEvent(t, reader)
+	assert.Equal(t, EventTypeAllocatorDecision, decision.eventType)
+	assert.Equal(t, connection.id+1, decision.id)
+	assert.Contains(t, decision.data, `"decision":"add"`)
+	replayedProblem := readEvent(t, reader)
+	assert.Equal(t, problem, replayedProblem)
+	resp.Body.Close()
+
+	// Resume, the connection event is no longer in the history
+	resp, reader = openEventStream(t, server.URL+"/api/events?slave=1", strconv.FormatUint(connection.id-1, 10))
+	resync := readEvent(t, reader)
+	assert.Equal(t, EventTypeResync, resync.eventType)
+	assert.Equal(t, connection.id, resync.id)
+	replayedProblem = readEvent(t, reader)
+	assert.Equal(t, problem, replayedProblem)
+	resp.Body.Close()
+}
+
+func TestMasterAPI_EventsGet_invalidLastEventID(t *testing.T) {
+	_, server := createEventStreamServer(2)
+	defer server.Close()
+
+	req, err := http.NewRequest("GET", server.URL+"/api/events", nil)
+	assert.NoError(t, err)
+	req.Header.Set("Last-Event-ID", "foo")
+	resp, err := http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	resp.Body.Close()
+}
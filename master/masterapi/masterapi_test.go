@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"github.com/KIT-MAMID/mamid/master"
 	"github.com/KIT-MAMID/mamid/model"
+	"github.com/KIT-MAMID/mamid/msp"
 	"github.com/gorilla/mux"
 	"github.com/stretchr/testify/assert"
 	"net/http"
@@ -14,6 +15,21 @@ import (
 	"time"
 )
 
+type fakeMSPClient struct {
+	msp.MSPClient
+}
+
+func (c fakeMSPClient) MongodLog(target msp.HostPort, port msp.PortNumber, tail uint) (msp.MongodLog, *msp.Error) {
+	if target.Hostname != "host1" {
+		return msp.MongodLog{}, &msp.Error{Identifier: msp.CommunicationError, Description: "Error communicating with slave."}
+	}
+	lines := []string{"line1", "line2", "line3"}
+	if tail < uint(len(lines)) {
+		lines = lines[uint(len(lines))-tail:]
+	}
+	return msp.MongodLog{Port: port, Lines: lines}, nil
+}
+
 func createDBAndMasterAPI(t *testing.T) (db *model.DB, mainRouter *mux.Router, err error) {
 	// Setup database
 	db, path, err := model.InitializeTestDB()
@@ -121,7 +137,13 @@ func createDBAndMasterAPI(t *testing.T) (db *model.DB, mainRouter *mux.Router, e
 	masterAPI := &MasterAPI{
 		DB:               db,
 		ClusterAllocator: clusterAllocator,
-		Router:           mainRouter.PathPrefix("/api/").Subrouter(),
+		Rebalancer: &master.Rebalancer{
+			ClusterAllocator:   clusterAllocator,
+			Threshold:          master.DefaultRebalanceThreshold,
+			MaxConcurrentMoves: master.DefaultRebalanceMaxConcurrentMoves,
+		},
+		MSPClient: fakeMSPClient{},
+		Router:    mainRouter.PathPrefix("/api/").Subrouter(),
 	}
 	masterAPI.Setup()
 
@@ -409,6 +431,45 @@ func TestMasterAPI_SlaveUpdate_change_desired_state_disabled(t *testing.T) {
 	assert.Equal(t, model.SlaveStateDisabled, updatedSlave.ConfiguredState)
 }
 
+func TestMasterAPI_SlaveUpdate_capacities(t *testing.T) {
+	db, mainRouter, err := createDBAndMasterAPI(t)
+	defer db.CloseAndDrop()
+	assert.NoError(t, err)
+
+	{
+		tx := db.Begin()
+		assert.NoError(t, tx.Model(&model.ReplicaSet{ID: 1}).Updates(map[string]interface{}{"memory_request_mb": 512, "disk_request_gb": 10}).Error)
+		tx.Commit()
+	}
+
+	// Capacities may be changed while the slave is active
+	resp := httptest.NewRecorder()
+	req_body := "{\"id\":1,\"hostname\":\"host1\",\"slave_port\":1,\"mongod_port_range_begin\":2,\"mongod_port_range_end\":3," +
+		"\"persistent_storage\":true,\"configured_state\":\"active\",\"risk_group_id\":2," +
+		"\"memory_capacity_mb\":4096,\"cpu_capacity_millicores\":2000,\"disk_capacity_gb\":100}"
+	req, err := http.NewRequest("POST", "/api/slaves/1", strings.NewReader(req_body))
+	assert.NoError(t, err)
+	mainRouter.ServeHTTP(resp, req)
+
+	if !assert.Equal(t, 200, resp.Code) {
+		fmt.Println(resp.Body.String())
+	}
+
+	resp = httptest.NewRecorder()
+	req, err = http.NewRequest("GET", "/api/slaves/1", nil)
+	assert.NoError(t, err)
+	mainRouter.ServeHTTP(resp, req)
+
+	var getSlaveResult Slave
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&getSlaveResult))
+	assert.EqualValues(t, 4096, getSlaveResult.MemoryCapacityMB)
+	assert.EqualValues(t, 2000, getSlaveResult.CPUCapacityMillicores)
+	assert.EqualValues(t, 100, getSlaveResult.DiskCapacityGB)
+	assert.EqualValues(t, 512, getSlaveResult.RequestedMemoryMB)
+	assert.EqualValues(t, 0, getSlaveResult.RequestedCPUMillicores)
+	assert.EqualValues(t, 10, getSlaveResult.RequestedDiskGB)
+}
+
 func TestMasterAPI_SlaveUpdate_existingHostname(t *testing.T) {
 	db, mainRouter, err := createDBAndMasterAPI(t)
 	defer db.CloseAndDrop()
@@ -474,6 +535,219 @@ func TestMasterAPI_SlaveDelete_invalid(t *testing.T) {
 }
 
 // Test correct get of replica sets
+func TestMasterAPI_SlaveHostMetricsGet(t *testing.T) {
+	db, mainRouter, err := createDBAndMasterAPI(t)
+	defer db.CloseAndDrop()
+	assert.NoError(t, err)
+
+	// No sample reported yet
+	resp := httptest.NewRecorder()
+	req, err := http.NewRequest("GET", "/api/slaves/1/hostmetrics", nil)
+	assert.NoError(t, err)
+	mainRouter.ServeHTTP(resp, req)
+	assert.Equal(t, 404, resp.Code)
+
+	{
+		tx := db.Begin()
+		assert.NoError(t, tx.Create(&model.SlaveHostMetrics{
+			SlaveID:                  1,
+			Sampled:                  time.Now(),
+			CPUCount:                 4,
+			LoadAverage5:             1.5,
+			DataVolumeTotalBytes:     1000,
+			DataVolumeAvailableBytes: 100,
+		}).Error)
+		tx.Commit()
+	}
+
+	resp = httptest.NewRecorder()
+	req, err = http.NewRequest("GET", "/api/slaves/1/hostmetrics", nil)
+	assert.NoError(t, err)
+	mainRouter.ServeHTTP(resp, req)
+	assert.Equal(t, 200, resp.Code)
+
+	var metrics SlaveHostMetrics
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&metrics))
+	assert.EqualValues(t, 1, metrics.SlaveID)
+	assert.EqualValues(t, 4, metrics.CPUCount)
+	assert.Equal(t, 1.5, metrics.LoadAverage5)
+	assert.EqualValues(t, 100, metrics.DataVolumeAvailableBytes)
+}
+
+func TestMasterAPI_ReplicaSetReplicationGet(t *testing.T) {
+	db, mainRouter, err := createDBAndMasterAPI(t)
+	defer db.CloseAndDrop()
+	assert.NoError(t, err)
+
+	// No PRIMARY observed yet
+	resp := httptest.NewRecorder()
+	req, err := http.NewRequest("GET", "/api/replicasets/1/replication", nil)
+	assert.NoError(t, err)
+	mainRouter.ServeHTTP(resp, req)
+	assert.Equal(t, 404, resp.Code)
+
+	{
+		tx := db.Begin()
+		first, last := time.Now().Add(-2*time.Hour), time.Now()
+		assert.NoError(t, tx.Create(&model.ReplicaSetReplication{
+			Observed:           time.Now(),
+			OplogFirst:         &first,
+			OplogLast:          &last,
+			OplogWindowSeconds: last.Sub(first).Seconds(),
+			OplogSizeBytes:     1000,
+			OplogMaxSizeBytes:  1000,
+			ReplicaSetID:       1,
+			PrimaryMongodID:    model.NullIntValue(1),
+		}).Error)
+		assert.NoError(t, tx.Create(&model.MongodReplication{
+			Observed:     time.Now(),
+			MemberState:  "PRIMARY",
+			Optime:       &last,
+			MongodID:     1,
+			ReplicaSetID: 1,
+		}).Error)
+		tx.Commit()
+	}
+
+	resp = httptest.NewRecorder()
+	req, err = http.NewRequest("GET", "/api/replicasets/1/replication", nil)
+	assert.NoError(t, err)
+	mainRouter.ServeHTTP(resp, req)
+	assert.Equal(t, 200, resp.Code)
+
+	var replication ReplicaSetReplication
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&replication))
+	assert.EqualValues(t, 1, replication.ReplicaSetID)
+	if assert.NotNil(t, replication.PrimaryMongodID) {
+		assert.EqualValues(t, 1, *replication.PrimaryMongodID)
+	}
+	assert.InDelta(t, 7200, replication.OplogWindowSeconds, 1)
+	assert.True(t, replication.OplogFull)
+	if assert.Len(t, replication.Members, 1) {
+		assert.EqualValues(t, 1, replication.Members[0].MongodID)
+		assert.EqualValues(t, 1, replication.Members[0].SlaveID)
+		assert.EqualValues(t, 5001, replication.Members[0].Port)
+		assert.Equal(t, "PRIMARY", replication.Members[0].MemberState)
+	}
+}
+
+func TestMasterAPI_SlaveDrainPost(t *testing.T) {
+	db, mainRouter, err := createDBAndMasterAPI(t)
+	defer db.CloseAndDrop()
+	assert.NoError(t, err)
+
+	resp := httptest.NewRecorder()
+	req, err := http.NewRequest("POST", "/api/slaves/1/drain", nil)
+	assert.NoError(t, err)
+	mainRouter.ServeHTTP(resp, req)
+
+	if !assert.Equal(t, 200, resp.Code) {
+		fmt.Println(resp.Body.String())
+	}
+
+	var drain SlaveDrain
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&drain))
+	assert.EqualValues(t, 1, drain.SlaveID)
+	assert.Equal(t, "blocked", drain.State, "there is no other persistent slave for the replacement member")
+	assert.EqualValues(t, 1, drain.RemainingMongods)
+
+	var slave model.Slave
+	{
+		tx := db.Begin()
+		tx.First(&slave, 1)
+		tx.Rollback()
+	}
+	assert.Equal(t, model.SlaveStateMaintenance, slave.ConfiguredState, "drained slave should be put in maintenance")
+
+	resp = httptest.NewRecorder()
+	req, err = http.NewRequest("GET", "/api/slaves/1/drain", nil)
+	assert.NoError(t, err)
+	mainRouter.ServeHTTP(resp, req)
+
+	assert.Equal(t, 200, resp.Code)
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&drain))
+	assert.Equal(t, "blocked", drain.State)
+}
+
+func TestMasterAPI_SlaveDrainPost_disabled(t *testing.T) {
+	db, mainRouter, err := createDBAndMasterAPI(t)
+	defer db.CloseAndDrop()
+	assert.NoError(t, err)
+
+	resp := httptest.NewRecorder()
+	req, err := http.NewRequest("POST", "/api/slaves/2/drain", nil)
+	assert.NoError(t, err)
+	mainRouter.ServeHTTP(resp, req)
+
+	assert.Equal(t, 403, resp.Code)
+
+	resp = httptest.NewRecorder()
+	req, err = http.NewRequest("GET", "/api/slaves/2/drain", nil)
+	assert.NoError(t, err)
+	mainRouter.ServeHTTP(resp, req)
+
+	assert.Equal(t, 404, resp.Code)
+}
+
+func TestMasterAPI_RebalanceProposalGet(t *testing.T) {
+	db, mainRouter, err := createDBAndMasterAPI(t)
+	defer db.CloseAndDrop()
+	assert.NoError(t, err)
+
+	resp := httptest.NewRecorder()
+	req, err := http.NewRequest("GET", "/api/rebalance/proposal", nil)
+	assert.NoError(t, err)
+	mainRouter.ServeHTTP(resp, req)
+
+	if !assert.Equal(t, 200, resp.Code) {
+		fmt.Println(resp.Body.String())
+	}
+	var moves []ProposedMove
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&moves))
+	assert.Empty(t, moves, "there is only one active slave")
+
+	resp = httptest.NewRecorder()
+	req, err = http.NewRequest("GET", "/api/rebalance/moves", nil)
+	assert.NoError(t, err)
+	mainRouter.ServeHTTP(resp, req)
+
+	assert.Equal(t, 200, resp.Code)
+	var mongodMoves []MongodMove
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&mongodMoves))
+	assert.Empty(t, mongodMoves)
+}
+
+func TestMasterAPI_MongodLogGet(t *testing.T) {
+	db, mainRouter, err := createDBAndMasterAPI(t)
+	defer db.CloseAndDrop()
+	assert.NoError(t, err)
+
+	resp := httptest.NewRecorder()
+	req, err := http.NewRequest("GET", "/api/mongods/1/log?tail=2", nil)
+	assert.NoError(t, err)
+	mainRouter.ServeHTTP(resp, req)
+
+	assert.Equal(t, http.StatusOK, resp.Code)
+	var mongodLog MongodLog
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&mongodLog))
+	assert.EqualValues(t, 1, mongodLog.MongodID)
+	assert.Equal(t, []string{"line2", "line3"}, mongodLog.Lines)
+
+	// Invalid tail
+	resp = httptest.NewRecorder()
+	req, err = http.NewRequest("GET", "/api/mongods/1/log?tail=-1", nil)
+	assert.NoError(t, err)
+	mainRouter.ServeHTTP(resp, req)
+	assert.Equal(t, http.StatusBadRequest, resp.Code)
+
+	// Not existing
+	resp = httptest.NewRecorder()
+	req, err = http.NewRequest("GET", "/api/mongods/100/log", nil)
+	assert.NoError(t, err)
+	mainRouter.ServeHTTP(resp, req)
+	assert.Equal(t, http.StatusNotFound, resp.Code)
+}
+
 func TestMasterAPI_ReplicaSetIndex(t *testing.T) {
 	db, mainRouter, err := createDBAndMasterAPI(t)
 	defer db.CloseAndDrop()
@@ -580,6 +854,124 @@ func TestMasterAPI_ReplicaSetPut(t *testing.T) {
 	assert.Equal(t, "repl2", getReplicaSetResult.Name)
 }
 
+func TestMasterAPI_ReplicaSetPut_arbiter(t *testing.T) {
+	db, mainRouter, err := createDBAndMasterAPI(t)
+	defer db.CloseAndDrop()
+	assert.NoError(t, err)
+
+	resp := httptest.NewRecorder()
+	req_body := "{\"id\":0,\"name\":\"repl2\",\"persistent_node_count\":2," +
+		"\"volatile_node_count\":0,\"arbiter_node_count\":1,\"sharding_role\":\"none\"}"
+	req, err := http.NewRequest("PUT", "/api/replicasets", strings.NewReader(req_body))
+	assert.NoError(t, err)
+	mainRouter.ServeHTTP(resp, req)
+
+	if !assert.Equal(t, 200, resp.Code) {
+		fmt.Println(resp.Body.String())
+	}
+	var replicaSet ReplicaSet
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&replicaSet))
+	assert.EqualValues(t, 1, replicaSet.ArbiterNodeCount)
+
+	// More arbiters than data-bearing members
+	resp = httptest.NewRecorder()
+	req_body = "{\"id\":0,\"name\":\"repl3\",\"persistent_node_count\":1," +
+		"\"volatile_node_count\":0,\"arbiter_node_count\":2,\"sharding_role\":\"none\"}"
+	req, err = http.NewRequest("PUT", "/api/replicasets", strings.NewReader(req_body))
+	assert.NoError(t, err)
+	mainRouter.ServeHTTP(resp, req)
+
+	assert.Equal(t, 400, resp.Code)
+}
+
+func TestMasterAPI_ReplicaSetPut_mongodOptions(t *testing.T) {
+	db, mainRouter, err := createDBAndMasterAPI(t)
+	defer db.CloseAndDrop()
+	assert.NoError(t, err)
+
+	resp := httptest.NewRecorder()
+	req_body := "{\"id\":0,\"name\":\"repl2\",\"persistent_node_count\":1,\"sharding_role\":\"none\"," +
+		"\"storage_engine\":\"wiredTiger\",\"wiredtiger_cache_size_gb\":1.5,\"oplog_size_mb\":1024," +
+		"\"bind_ip\":\"127.0.0.1,10.0.0.1\",\"journal_disabled\":true}"
+	req, err := http.NewRequest("PUT", "/api/replicasets", strings.NewReader(req_body))
+	assert.NoError(t, err)
+	mainRouter.ServeHTTP(resp, req)
+
+	if !assert.Equal(t, 200, resp.Code) {
+		fmt.Println(resp.Body.String())
+	}
+
+	var createdReplSet model.ReplicaSet
+	{
+		tx := db.Begin()
+		tx.First(&createdReplSet, "name = ?", "repl2")
+		tx.Rollback()
+	}
+	assert.Equal(t, "wiredTiger", createdReplSet.StorageEngine)
+	assert.Equal(t, 1.5, createdReplSet.WiredTigerCacheSizeGB)
+	assert.EqualValues(t, 1024, createdReplSet.OplogSizeMB)
+	assert.Equal(t, "127.0.0.1,10.0.0.1", createdReplSet.BindIP)
+	assert.True(t, createdReplSet.JournalDisabled)
+
+	invalidOptions := []string{
+		"\"storage_engine\":\"inMemory\"",
+		"\"storage_engine\":\"mmapv1\",\"wiredtiger_cache_size_gb\":1",
+		"\"wiredtiger_cache_size_gb\":-1",
+		"\"bind_ip\":\"127.0.0.1,localhost\"",
+		"\"bind_ip\":\"10.0.0.1\"",
+	}
+	for _, options := range invalidOptions {
+		resp = httptest.NewRecorder()
+		req_body = "{\"id\":0,\"name\":\"repl3\",\"persistent_node_count\":1,\"sharding_role\":\"none\"," + options + "}"
+		req, err = http.NewRequest("PUT", "/api/replicasets", strings.NewReader(req_body))
+		assert.NoError(t, err)
+		mainRouter.ServeHTTP(resp, req)
+
+		assert.Equal(t, 400, resp.Code, "options %s should be rejected", options)
+	}
+
+	// The storage engine of existing Mongods cannot be changed
+	resp = httptest.NewRecorder()
+	req_body = fmt.Sprintf("{\"id\":%d,\"name\":\"repl2\",\"persistent_node_count\":1,\"sharding_role\":\"none\","+
+		"\"storage_engine\":\"mmapv1\"}", createdReplSet.ID)
+	req, err = http.NewRequest("POST", fmt.Sprintf("/api/replicasets/%d", createdReplSet.ID), strings.NewReader(req_body))
+	assert.NoError(t, err)
+	mainRouter.ServeHTTP(resp, req)
+
+	assert.Equal(t, 400, resp.Code)
+}
+
+func TestMasterAPI_ReplicaSetPut_resourceRequests(t *testing.T) {
+	db, mainRouter, err := createDBAndMasterAPI(t)
+	defer db.CloseAndDrop()
+	assert.NoError(t, err)
+
+	resp := httptest.NewRecorder()
+	req_body := "{\"id\":0,\"name\":\"repl2\",\"persistent_node_count\":1,\"sharding_role\":\"none\"," +
+		"\"memory_request_mb\":2048,\"cpu_request_millicores\":500,\"wiredtiger_cache_size_gb\":1}"
+	req, err := http.NewRequest("PUT", "/api/replicasets", strings.NewReader(req_body))
+	assert.NoError(t, err)
+	mainRouter.ServeHTTP(resp, req)
+
+	if !assert.Equal(t, 200, resp.Code) {
+		fmt.Println(resp.Body.String())
+	}
+	var replicaSet ReplicaSet
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&replicaSet))
+	assert.EqualValues(t, 2048, replicaSet.MemoryRequestMB)
+	assert.EqualValues(t, 500, replicaSet.CPURequestMillicores)
+
+	// WiredTiger cache exceeding the memory request
+	resp = httptest.NewRecorder()
+	req_body = "{\"id\":0,\"name\":\"repl3\",\"persistent_node_count\":1,\"sharding_role\":\"none\"," +
+		"\"memory_request_mb\":1024,\"wiredtiger_cache_size_gb\":1}"
+	req, err = http.NewRequest("PUT", "/api/replicasets", strings.NewReader(req_body))
+	assert.NoError(t, err)
+	mainRouter.ServeHTTP(resp, req)
+
+	assert.Equal(t, 400, resp.Code)
+}
+
 func TestMasterAPI_ReplicaSetUpdate(t *testing.T) {
 	db, mainRouter, err := createDBAndMasterAPI(t)
 	defer db.CloseAndDrop()
@@ -900,6 +1292,83 @@ func TestMasterAPI_RiskGroupPut_existing_name(t *testing.T) {
 	assert.Equal(t, 400, resp.Code)
 }
 
+func TestMasterAPI_RiskGroupPut_nested(t *testing.T) {
+	db, mainRouter, err := createDBAndMasterAPI(t)
+	defer db.CloseAndDrop()
+	assert.NoError(t, err)
+
+	resp := httptest.NewRecorder()
+
+	req_body := "{\"id\":0,\"name\":\"rack1\",\"level\":\"rack\",\"parent_id\":3}"
+	req, err := http.NewRequest("PUT", "/api/riskgroups", strings.NewReader(req_body))
+	assert.NoError(t, err)
+	mainRouter.ServeHTTP(resp, req)
+
+	if !assert.Equal(t, 200, resp.Code) {
+		fmt.Println(resp.Body.String())
+	}
+
+	var createdRiskGroup model.RiskGroup
+	{
+		tx := db.Begin()
+		tx.First(&createdRiskGroup, "name = ?", "rack1")
+		tx.Rollback()
+	}
+
+	assert.Equal(t, "rack", createdRiskGroup.Level)
+	assert.Equal(t, model.NullIntValue(3), createdRiskGroup.ParentID)
+
+	// Parent must not be deletable while it has nested risk groups
+	resp = httptest.NewRecorder()
+	req, err = http.NewRequest("DELETE", "/api/riskgroups/3", nil)
+	assert.NoError(t, err)
+	mainRouter.ServeHTTP(resp, req)
+
+	assert.Equal(t, 403, resp.Code)
+}
+
+func TestMasterAPI_RiskGroupPut_parent_not_existing(t *testing.T) {
+	db, mainRouter, err := createDBAndMasterAPI(t)
+	defer db.CloseAndDrop()
+	assert.NoError(t, err)
+
+	resp := httptest.NewRecorder()
+
+	req_body := "{\"id\":0,\"name\":\"rack1\",\"parent_id\":9000}"
+	req, err := http.NewRequest("PUT", "/api/riskgroups", strings.NewReader(req_body))
+	assert.NoError(t, err)
+	mainRouter.ServeHTTP(resp, req)
+
+	assert.Equal(t, 400, resp.Code)
+}
+
+func TestMasterAPI_RiskGroupUpdate_cycle(t *testing.T) {
+	db, mainRouter, err := createDBAndMasterAPI(t)
+	defer db.CloseAndDrop()
+	assert.NoError(t, err)
+
+	resp := httptest.NewRecorder()
+	req, err := http.NewRequest("POST", "/api/riskgroups/2", strings.NewReader("{\"id\":2,\"name\":\"risk2\",\"parent_id\":1}"))
+	assert.NoError(t, err)
+	mainRouter.ServeHTTP(resp, req)
+
+	assert.Equal(t, 200, resp.Code)
+
+	resp = httptest.NewRecorder()
+	req, err = http.NewRequest("POST", "/api/riskgroups/1", strings.NewReader("{\"id\":1,\"name\":\"risk1\",\"parent_id\":2}"))
+	assert.NoError(t, err)
+	mainRouter.ServeHTTP(resp, req)
+
+	assert.Equal(t, 400, resp.Code, "risk groups must not be nested in themselves")
+
+	resp = httptest.NewRecorder()
+	req, err = http.NewRequest("POST", "/api/riskgroups/1", strings.NewReader("{\"id\":1,\"name\":\"risk1\",\"parent_id\":1}"))
+	assert.NoError(t, err)
+	mainRouter.ServeHTTP(resp, req)
+
+	assert.Equal(t, 400, resp.Code)
+}
+
 func TestMasterAPI_RiskGroupUpdate(t *testing.T) {
 	db, mainRouter, err := createDBAndMasterAPI(t)
 	defer db.CloseAndDrop()
@@ -1162,3 +1631,106 @@ func TestMasterAPI_MongodsByReplicaSet(t *testing.T) {
 	assert.EqualValues(t, 1, len(getMongodsResult))
 	assert.EqualValues(t, 5001, getMongodsResult[0].Port)
 }
+
+func TestMasterAPI_PlanPost(t *testing.T) {
+	db, mainRouter, err := createDBAndMasterAPI(t)
+	defer db.CloseAndDrop()
+	assert.NoError(t, err)
+
+	resp := httptest.NewRecorder()
+
+	// Activating the volatile slave host2 should allow spawning a volatile member of repl1
+	req_body := "{\"slave\":{\"id\":2,\"hostname\":\"host2\",\"slave_port\":1,\"mongod_port_range_begin\":100,\"mongod_port_range_end\":200,\"persistent_storage\":false,\"configured_state\":\"active\",\"risk_group_id\":1}}"
+	req, err := http.NewRequest("POST", "/api/plan", strings.NewReader(req_body))
+	assert.NoError(t, err)
+	mainRouter.ServeHTTP(resp, req)
+
+	if !assert.EqualValues(t, 200, resp.Code) {
+		fmt.Println(resp.Body.String())
+	}
+
+	var plan Plan
+	err = json.NewDecoder(resp.Body).Decode(&plan)
+	assert.NoError(t, err)
+
+	assert.Len(t, plan.Spawned, 1)
+	assert.Len(t, plan.Destroyed, 0)
+	if len(plan.Spawned) == 1 {
+		assert.EqualValues(t, 2, plan.Spawned[0].SlaveID)
+		assert.Equal(t, "host2", plan.Spawned[0].SlaveHostname)
+		assert.Equal(t, "repl1", plan.Spawned[0].ReplicaSetName)
+		assert.EqualValues(t, 100, plan.Spawned[0].Port)
+	}
+
+	// Nothing may be persisted
+	tx := db.Begin()
+	defer tx.Rollback()
+	var slave model.Slave
+	assert.NoError(t, tx.First(&slave, 2).Error)
+	assert.Equal(t, model.SlaveStateDisabled, slave.ConfiguredState)
+	var mongodCount int
+	assert.NoError(t, tx.Model(&model.Mongod{}).Where("parent_slave_id = ?", 2).Count(&mongodCount).Error)
+	assert.EqualValues(t, 0, mongodCount)
+}
+
+func TestMasterAPI_PlanPost_invalid(t *testing.T) {
+	db, mainRouter, err := createDBAndMasterAPI(t)
+	defer db.CloseAndDrop()
+	assert.NoError(t, err)
+
+	resp := httptest.NewRecorder()
+
+	// Changing the name of a replica set is not allowed
+	req_body := "{\"replica_set\":{\"id\":1,\"name\":\"renamed\",\"persistent_node_count\":1,\"volatile_node_count\":2,\"sharding_role\":\"none\"}}"
+	req, err := http.NewRequest("POST", "/api/plan", strings.NewReader(req_body))
+	assert.NoError(t, err)
+	mainRouter.ServeHTTP(resp, req)
+
+	assert.EqualValues(t, 400, resp.Code)
+}
+
+func TestMasterAPI_ReplicaSetGetPlacement(t *testing.T) {
+	db, mainRouter, err := createDBAndMasterAPI(t)
+	defer db.CloseAndDrop()
+	assert.NoError(t, err)
+
+	resp := httptest.NewRecorder()
+
+	req, err := http.NewRequest("GET", "/api/replicasets/1/placement", nil)
+	assert.NoError(t, err)
+	mainRouter.ServeHTTP(resp, req)
+
+	assert.EqualValues(t, 200, resp.Code)
+
+	var placement []SlavePlacement
+	err = json.NewDecoder(resp.Body).Decode(&placement)
+	assert.NoError(t, err)
+
+	// 3 slaves evaluated for persistent and volatile members each
+	if !assert.Len(t, placement, 6) {
+		return
+	}
+
+	assert.Equal(t, "host1", placement[0].SlaveHostname)
+	assert.Equal(t, "persistent", placement[0].MemberType)
+	assert.False(t, placement[0].Suitable)
+	assert.Equal(t, []string{"no_free_port", "already_hosting_member"}, placement[0].RejectionReasons)
+
+	assert.Equal(t, "host2", placement[4].SlaveHostname)
+	assert.Equal(t, "volatile", placement[4].MemberType)
+	assert.Equal(t, []string{"not_active"}, placement[4].RejectionReasons)
+}
+
+func TestMasterAPI_ReplicaSetGetPlacement_not_existing(t *testing.T) {
+	db, mainRouter, err := createDBAndMasterAPI(t)
+	defer db.CloseAndDrop()
+	assert.NoError(t, err)
+
+	resp := httptest.NewRecorder()
+
+	req, err := http.NewRequest("GET", "/api/replicasets/23/placement", nil)
+	assert.NoError(t, err)
+	mainRouter.ServeHTTP(resp, req)
+
+	assert.EqualValues(t, 404, resp.Code)
+}
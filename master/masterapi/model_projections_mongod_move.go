@@ -0,0 +1,34 @@
+package masterapi
+
+import (
+	"github.com/KIT-MAMID/mamid/model"
+)
+
+func ProjectModelMongodMoveToMongodMove(m *model.MongodMove) *MongodMove {
+	return &MongodMove{
+		ID:                  m.ID,
+		State:               MongodMoveStateToJSONRepresentation(m.State),
+		Started:             m.Started,
+		Finished:            m.Finished,
+		ReplicaSetID:        model.NullIntToPtr(m.ReplicaSetID),
+		SourceSlaveID:       model.NullIntToPtr(m.SourceSlaveID),
+		TargetSlaveID:       model.NullIntToPtr(m.TargetSlaveID),
+		SourceMongodID:      model.NullIntToPtr(m.SourceMongodID),
+		ReplacementMongodID: model.NullIntToPtr(m.ReplacementMongodID),
+	}
+}
+
+func MongodMoveStateToJSONRepresentation(s model.MongodMoveState) string {
+	switch s {
+	case model.MongodMoveStateAddingReplacement:
+		return "adding_replacement"
+	case model.MongodMoveStateRemovingMember:
+		return "removing_member"
+	case model.MongodMoveStateFinished:
+		return "finished"
+	case model.MongodMoveStateAborted:
+		return "aborted"
+	default:
+		return "undefined"
+	}
+}
@@ -7,6 +7,7 @@ func ProjectModelProblemToProblem(m *model.Problem) *Problem {
 		ID:              m.ID,
 		Description:     m.Description,
 		LongDescription: m.LongDescription,
+		ProblemType:     m.ProblemType.String(),
 		FirstOccurred:   m.FirstOccurred,
 		LastUpdated:     m.LastUpdated,
 		SlaveId:         model.NullIntToPtr(m.SlaveID),
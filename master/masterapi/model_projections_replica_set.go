@@ -2,6 +2,7 @@ package masterapi
 
 import (
 	"fmt"
+	"github.com/KIT-MAMID/mamid/master"
 	"github.com/KIT-MAMID/mamid/model"
 )
 
@@ -15,7 +16,22 @@ func ProjectModelReplicaSetToReplicaSet(m *model.ReplicaSet) *ReplicaSet {
 		Name:                m.Name,
 		PersistentNodeCount: m.PersistentMemberCount,
 		VolatileNodeCount:   m.VolatileMemberCount,
+		ArbiterNodeCount:    m.ArbiterMemberCount,
+		HiddenNodeCount:     m.HiddenMemberCount,
+		DelayedNodeCount:    m.DelayedMemberCount,
+		SlaveDelaySeconds:   m.SlaveDelaySeconds,
 		ShardingRole:        shardingRole,
+		PlacementStrategy:   m.PlacementStrategy,
+
+		StorageEngine:         m.StorageEngine,
+		WiredTigerCacheSizeGB: m.WiredTigerCacheSizeGB,
+		OplogSizeMB:           m.OplogSizeMB,
+		BindIP:                m.BindIP,
+		JournalDisabled:       m.JournalDisabled,
+
+		MemoryRequestMB:      m.MemoryRequestMB,
+		CPURequestMillicores: m.CPURequestMillicores,
+		DiskRequestGB:        m.DiskRequestGB,
 	}
 }
 
@@ -27,12 +43,32 @@ func ProjectReplicaSetToModelReplicaSet(r *ReplicaSet) (*model.ReplicaSet, error
 	if err != nil {
 		return nil, err
 	}
+	if r.PlacementStrategy != "" {
+		if _, err := master.PlacementStrategyByName(r.PlacementStrategy); err != nil {
+			return nil, err
+		}
+	}
 	return &model.ReplicaSet{
 		ID:   r.ID,
 		Name: r.Name,
 		PersistentMemberCount: r.PersistentNodeCount,
 		VolatileMemberCount:   r.VolatileNodeCount,
+		ArbiterMemberCount:    r.ArbiterNodeCount,
+		HiddenMemberCount:     r.HiddenNodeCount,
+		DelayedMemberCount:    r.DelayedNodeCount,
+		SlaveDelaySeconds:     r.SlaveDelaySeconds,
 		ShardingRole:          shardingRole,
+		PlacementStrategy:     r.PlacementStrategy,
+
+		StorageEngine:         r.StorageEngine,
+		WiredTigerCacheSizeGB: r.WiredTigerCacheSizeGB,
+		OplogSizeMB:           r.OplogSizeMB,
+		BindIP:                r.BindIP,
+		JournalDisabled:       r.JournalDisabled,
+
+		MemoryRequestMB:      r.MemoryRequestMB,
+		CPURequestMillicores: r.CPURequestMillicores,
+		DiskRequestGB:        r.DiskRequestGB,
 	}, nil
 }
 
@@ -65,3 +101,42 @@ func ProjectModelShardingRoleToShardingRole(modelRole model.ShardingRole) (out S
 	}
 	return
 }
+
+func ProjectSlavePlacementEvaluationToSlavePlacement(e model.SlavePlacementEvaluation) *SlavePlacement {
+	memberType := "volatile"
+	if e.ArbiterMember {
+		memberType = "arbiter"
+	} else if e.PersistentMember {
+		memberType = "persistent"
+	}
+	reasons := make([]string, len(e.RejectionReasons))
+	for i, r := range e.RejectionReasons {
+		reasons[i] = ProjectPlacementRejectionReasonToJSONRepresentation(r)
+	}
+	return &SlavePlacement{
+		SlaveID:          e.SlaveID,
+		SlaveHostname:    e.SlaveHostname,
+		MemberType:       memberType,
+		Suitable:         e.Suitable(),
+		RejectionReasons: reasons,
+	}
+}
+
+func ProjectPlacementRejectionReasonToJSONRepresentation(r model.PlacementRejectionReason) string {
+	switch r {
+	case model.PlacementRejectionWrongPersistence:
+		return "wrong_persistence"
+	case model.PlacementRejectionNoFreePort:
+		return "no_free_port"
+	case model.PlacementRejectionRiskGroupUsed:
+		return "risk_group_used"
+	case model.PlacementRejectionNotActive:
+		return "not_active"
+	case model.PlacementRejectionAlreadyHostsMember:
+		return "already_hosting_member"
+	case model.PlacementRejectionInsufficientResources:
+		return "insufficient_resources"
+	default:
+		return "undefined"
+	}
+}
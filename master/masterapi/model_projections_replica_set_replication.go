@@ -0,0 +1,34 @@
+package masterapi
+
+import (
+	"github.com/KIT-MAMID/mamid/model"
+)
+
+func ProjectModelReplicaSetReplicationToReplicaSetReplication(r *model.ReplicaSetReplication, members []model.MongodReplication) *ReplicaSetReplication {
+	replication := &ReplicaSetReplication{
+		ReplicaSetID:       r.ReplicaSetID,
+		Observed:           r.Observed,
+		PrimaryMongodID:    model.NullIntToPtr(r.PrimaryMongodID),
+		OplogFirst:         r.OplogFirst,
+		OplogLast:          r.OplogLast,
+		OplogWindowSeconds: r.OplogWindowSeconds,
+		OplogSizeBytes:     r.OplogSizeBytes,
+		OplogMaxSizeBytes:  r.OplogMaxSizeBytes,
+		OplogFull:          r.OplogFull(),
+		Members:            make([]ReplicaSetMemberReplication, len(members)),
+	}
+	for i, member := range members {
+		replication.Members[i] = ReplicaSetMemberReplication{
+			MongodID:    member.MongodID,
+			MemberState: member.MemberState,
+			Optime:      member.Optime,
+			LagSeconds:  member.LagSeconds,
+			Observed:    member.Observed,
+		}
+		if member.Mongod != nil {
+			replication.Members[i].SlaveID = member.Mongod.ParentSlaveID
+			replication.Members[i].Port = uint(member.Mongod.Port)
+		}
+	}
+	return replication
+}
@@ -7,8 +7,10 @@ import (
 
 func ProjectModelRiskGroupToRiskGroup(m *model.RiskGroup) *RiskGroup {
 	return &RiskGroup{
-		ID:   m.ID,
-		Name: m.Name,
+		ID:       m.ID,
+		Name:     m.Name,
+		Level:    m.Level,
+		ParentID: model.NullIntToPtr(m.ParentID),
 	}
 }
 
@@ -16,8 +18,13 @@ func ProjectRiskGroupToModelRiskGroup(r *RiskGroup) (*model.RiskGroup, error) {
 	if r.Name == "" {
 		return nil, fmt.Errorf("Risk group name may not be empty")
 	}
+	if r.ParentID != nil && *r.ParentID == r.ID {
+		return nil, fmt.Errorf("Risk group may not be its own parent")
+	}
 	return &model.RiskGroup{
-		ID:   r.ID,
-		Name: r.Name,
+		ID:       r.ID,
+		Name:     r.Name,
+		Level:    r.Level,
+		ParentID: model.PtrToNullInt(r.ParentID),
 	}, nil
 }
@@ -13,6 +13,11 @@ func ProjectModelSlaveToSlave(tx *gorm.DB, m *model.Slave) (*Slave, error) {
 		return nil, err
 	}
 
+	requested, err := slaveRequestedResources(tx, m)
+	if err != nil {
+		return nil, err
+	}
+
 	return &Slave{
 		ID:                           m.ID,
 		Hostname:                     m.Hostname,
@@ -23,6 +28,12 @@ func ProjectModelSlaveToSlave(tx *gorm.DB, m *model.Slave) (*Slave, error) {
 		ConfiguredState:              SlaveStateToJSONRepresentation(m.ConfiguredState),
 		ConfiguredStateTransitioning: configuredStateTransitioning,
 		RiskGroupID:                  model.NullIntToPtr(m.RiskGroupID),
+		MemoryCapacityMB:             m.MemoryCapacityMB,
+		CPUCapacityMillicores:        m.CPUCapacityMillicores,
+		DiskCapacityGB:               m.DiskCapacityGB,
+		RequestedMemoryMB:            requested.RequestedMemoryMB,
+		RequestedCPUMillicores:       requested.RequestedCPUMillicores,
+		RequestedDiskGB:              requested.RequestedDiskGB,
 	}, nil
 }
 
@@ -48,6 +59,22 @@ func SlaveConfiguredStateTransitioning(tx *gorm.DB, s *model.Slave) (bool, error
 
 }
 
+type requestedResources struct {
+	RequestedMemoryMB      uint
+	RequestedCPUMillicores uint
+	RequestedDiskGB        uint
+}
+
+// Resources requested by the data-bearing members hosted by the slave
+func slaveRequestedResources(tx *gorm.DB, s *model.Slave) (requested requestedResources, err error) {
+	if s.ID == 0 {
+		return // not persisted yet, hence hosting no Mongods
+	}
+	err = tx.Raw(`SELECT requested_memory_mb, requested_cpu_millicores, requested_disk_gb
+		FROM slave_utilization WHERE id = ?`, s.ID).Scan(&requested).Error
+	return
+}
+
 func ProjectSlaveToModelSlave(s *Slave) (*model.Slave, error) {
 
 	genericErr := fmt.Errorf("Could not map slave representation to internal representation")
@@ -72,14 +99,17 @@ func ProjectSlaveToModelSlave(s *Slave) (*model.Slave, error) {
 	}
 
 	return &model.Slave{
-		ID:                   s.ID,
-		Hostname:             s.Hostname,
-		Port:                 model.PortNumber(s.Port),
-		MongodPortRangeBegin: model.PortNumber(s.MongodPortRangeBegin),
-		MongodPortRangeEnd:   model.PortNumber(s.MongodPortRangeEnd),
-		PersistentStorage:    s.PersistentStorage,
-		ConfiguredState:      state,
-		RiskGroupID:          model.PtrToNullInt(s.RiskGroupID),
+		ID:                    s.ID,
+		Hostname:              s.Hostname,
+		Port:                  model.PortNumber(s.Port),
+		MongodPortRangeBegin:  model.PortNumber(s.MongodPortRangeBegin),
+		MongodPortRangeEnd:    model.PortNumber(s.MongodPortRangeEnd),
+		PersistentStorage:     s.PersistentStorage,
+		ConfiguredState:       state,
+		RiskGroupID:           model.PtrToNullInt(s.RiskGroupID),
+		MemoryCapacityMB:      s.MemoryCapacityMB,
+		CPUCapacityMillicores: s.CPUCapacityMillicores,
+		DiskCapacityGB:        s.DiskCapacityGB,
 	}, nil
 }
 
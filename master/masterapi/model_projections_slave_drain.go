@@ -0,0 +1,46 @@
+package masterapi
+
+import (
+	"github.com/KIT-MAMID/mamid/master"
+	"github.com/KIT-MAMID/mamid/model"
+	"github.com/jinzhu/gorm"
+)
+
+func ProjectModelSlaveDrainToSlaveDrain(tx *gorm.DB, m *model.SlaveDrain) (*SlaveDrain, error) {
+
+	remainingMongods, err := master.DrainableMongodCount(tx, m.SlaveID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &SlaveDrain{
+		SlaveID:             m.SlaveID,
+		State:               SlaveDrainStateToJSONRepresentation(m.State),
+		StateDescription:    m.StateDescription,
+		Started:             m.Started,
+		Finished:            m.Finished,
+		ReplicaSetID:        model.NullIntToPtr(m.ReplicaSetID),
+		DrainedMongodID:     model.NullIntToPtr(m.DrainedMongodID),
+		ReplacementMongodID: model.NullIntToPtr(m.ReplacementMongodID),
+		RemainingMongods:    remainingMongods,
+	}, nil
+}
+
+func SlaveDrainStateToJSONRepresentation(s model.SlaveDrainState) string {
+	switch s {
+	case model.SlaveDrainStatePending:
+		return "pending"
+	case model.SlaveDrainStateAddingReplacement:
+		return "adding_replacement"
+	case model.SlaveDrainStateRemovingMember:
+		return "removing_member"
+	case model.SlaveDrainStateBlocked:
+		return "blocked"
+	case model.SlaveDrainStateFinished:
+		return "finished"
+	case model.SlaveDrainStateCancelled:
+		return "cancelled"
+	default:
+		return "undefined"
+	}
+}
@@ -0,0 +1,22 @@
+package masterapi
+
+import (
+	"github.com/KIT-MAMID/mamid/model"
+)
+
+func ProjectModelSlaveHostMetricsToSlaveHostMetrics(m *model.SlaveHostMetrics) *SlaveHostMetrics {
+	return &SlaveHostMetrics{
+		SlaveID:                  m.SlaveID,
+		Sampled:                  m.Sampled,
+		CPUCount:                 m.CPUCount,
+		LoadAverage1:             m.LoadAverage1,
+		LoadAverage5:             m.LoadAverage5,
+		LoadAverage15:            m.LoadAverage15,
+		MemoryTotalBytes:         m.MemoryTotalBytes,
+		MemoryAvailableBytes:     m.MemoryAvailableBytes,
+		SwapTotalBytes:           m.SwapTotalBytes,
+		SwapFreeBytes:            m.SwapFreeBytes,
+		DataVolumeTotalBytes:     m.DataVolumeTotalBytes,
+		DataVolumeAvailableBytes: m.DataVolumeAvailableBytes,
+	}
+}
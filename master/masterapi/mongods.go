@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"github.com/KIT-MAMID/mamid/model"
+	"github.com/KIT-MAMID/mamid/msp"
 	"github.com/gorilla/mux"
 	"github.com/jinzhu/gorm"
 	"net/http"
@@ -18,6 +19,14 @@ type Mongod struct {
 	ObservedExecutionState string `json:"observed_execution_state"`
 }
 
+// Tail of the log of a Mongod, fetched from its Slave
+type MongodLog struct {
+	MongodID int64    `json:"mongod_id"`
+	Lines    []string `json:"lines"`
+}
+
+const maxMongodLogTail = 10000
+
 func mongodToApiMongod(tx *gorm.DB, m *model.Mongod) (*Mongod, error) {
 	if res := tx.Model(&m).Related(&m.ObservedState, "ObservedState"); res.Error != nil && !res.RecordNotFound() {
 		return &Mongod{}, res.Error
@@ -108,3 +117,60 @@ func (m *MasterAPI) MongodsByReplicaSet(w http.ResponseWriter, r *http.Request)
 	}
 	json.NewEncoder(w).Encode(out)
 }
+
+func (m *MasterAPI) MongodLogGet(w http.ResponseWriter, r *http.Request) {
+	idStr := mux.Vars(r)["mongodId"]
+	mongodId, err := strconv.ParseInt(idStr, 10, 0)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	tail := msp.DefaultMongodLogTail
+	if tailStr := r.URL.Query().Get("tail"); tailStr != "" {
+		tail64, err := strconv.ParseUint(tailStr, 10, 0)
+		if err != nil || tail64 > maxMongodLogTail {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintf(w, "tail must be a number of lines between 0 and %d", maxMongodLogTail)
+			return
+		}
+		tail = uint(tail64)
+	}
+
+	tx := m.DB.Begin()
+	defer tx.Rollback()
+
+	var mongod model.Mongod
+	getMongodRes := tx.First(&mongod, mongodId)
+	if getMongodRes.RecordNotFound() {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	} else if err := getMongodRes.Error; err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprintf(w, err.Error())
+		return
+	}
+
+	var slave model.Slave
+	if err := tx.First(&slave, mongod.ParentSlaveID).Error; err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprintf(w, err.Error())
+		return
+	}
+
+	mongodLog, mspErr := m.MSPClient.MongodLog(msp.HostPort{Hostname: slave.Hostname, Port: msp.PortNumber(slave.Port)}, msp.PortNumber(mongod.Port), tail)
+	if mspErr != nil {
+		if mspErr.Identifier == msp.CommunicationError {
+			w.WriteHeader(http.StatusBadGateway)
+		} else {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		fmt.Fprintf(w, "%s: %s", mspErr.Description, mspErr.LongDescription)
+		return
+	}
+
+	json.NewEncoder(w).Encode(MongodLog{
+		MongodID: mongod.ID,
+		Lines:    mongodLog.Lines,
+	})
+}
@@ -0,0 +1,197 @@
+package masterapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/KIT-MAMID/mamid/master"
+	"github.com/KIT-MAMID/mamid/model"
+	"github.com/jinzhu/gorm"
+	"net/http"
+)
+
+// A proposed change to the cluster configuration.
+// Every field is optional. Objects with ID 0 are created, all others are updated.
+type PlanRequest struct {
+	RiskGroup  *RiskGroup  `json:"risk_group"`
+	Slave      *Slave      `json:"slave"`
+	ReplicaSet *ReplicaSet `json:"replica_set"`
+}
+
+type PlannedMongod struct {
+	MongodID       int64  `json:"mongod_id"`
+	ReplicaSetID   int64  `json:"replica_set_id"`
+	ReplicaSetName string `json:"replica_set_name"`
+	SlaveID        int64  `json:"slave_id"`
+	SlaveHostname  string `json:"slave_hostname"`
+	Port           uint   `json:"port"`
+}
+
+type Plan struct {
+	Spawned   []*PlannedMongod `json:"spawned"`
+	Destroyed []*PlannedMongod `json:"destroyed"`
+}
+
+func (m *MasterAPI) PlanPost(w http.ResponseWriter, r *http.Request) {
+	var planRequest PlanRequest
+	err := json.NewDecoder(r.Body).Decode(&planRequest)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, "cannot parse object (%s)", err.Error())
+		return
+	}
+
+	// Nothing is ever committed
+	tx := m.DB.Begin()
+	defer tx.Rollback()
+
+	if planRequest.RiskGroup != nil {
+		if status, err := planRiskGroupChange(tx, planRequest.RiskGroup); err != nil {
+			w.WriteHeader(status)
+			fmt.Fprintf(w, "invalid risk group change: %s", err)
+			return
+		}
+	}
+
+	if planRequest.Slave != nil {
+		if status, err := planSlaveChange(tx, planRequest.Slave); err != nil {
+			w.WriteHeader(status)
+			fmt.Fprintf(w, "invalid slave change: %s", err)
+			return
+		}
+	}
+
+	if planRequest.ReplicaSet != nil {
+		if status, err := planReplicaSetChange(tx, planRequest.ReplicaSet); err != nil {
+			w.WriteHeader(status)
+			fmt.Fprintf(w, "invalid replica set change: %s", err)
+			return
+		}
+	}
+
+	diff, err := m.ClusterAllocator.PlanMongodLayout(tx)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprintf(w, "cluster allocator failure: %s", err)
+		return
+	}
+
+	json.NewEncoder(w).Encode(ProjectMongodLayoutDiffToPlan(diff))
+}
+
+func ProjectMongodLayoutDiffToPlan(diff master.MongodLayoutDiff) *Plan {
+	project := func(mongods []master.PlannedMongod) []*PlannedMongod {
+		out := make([]*PlannedMongod, len(mongods))
+		for i, m := range mongods {
+			out[i] = &PlannedMongod{
+				MongodID:       m.MongodID,
+				ReplicaSetID:   m.ReplicaSetID,
+				ReplicaSetName: m.ReplicaSetName,
+				SlaveID:        m.SlaveID,
+				SlaveHostname:  m.SlaveHostname,
+				Port:           uint(m.Port),
+			}
+		}
+		return out
+	}
+	return &Plan{
+		Spawned:   project(diff.Spawned),
+		Destroyed: project(diff.Destroyed),
+	}
+}
+
+// The plan* functions apply a change to tx using the same validation as the corresponding PUT / POST handlers.
+// They return the HTTP status code to respond with if the change is not valid.
+
+func planRiskGroupChange(tx *gorm.DB, riskGroup *RiskGroup) (status int, err error) {
+
+	modelRiskGroup, err := ProjectRiskGroupToModelRiskGroup(riskGroup)
+	if err != nil {
+		return http.StatusBadRequest, err
+	}
+
+	if modelRiskGroup.ID != 0 {
+		if status, err = assertExists(tx, &model.RiskGroup{}, modelRiskGroup.ID); err != nil {
+			return status, err
+		}
+	}
+
+	if status, err = changeToRiskGroupAllowed(tx, modelRiskGroup); err != nil {
+		return status, err
+	}
+
+	return saveProposedChange(tx, modelRiskGroup)
+}
+
+func planSlaveChange(tx *gorm.DB, slave *Slave) (status int, err error) {
+
+	modelSlave, err := ProjectSlaveToModelSlave(slave)
+	if err != nil {
+		return http.StatusBadRequest, err
+	}
+
+	if modelSlave.ID != 0 {
+
+		var currentSlave model.Slave
+		if status, err = assertExists(tx, &currentSlave, modelSlave.ID); err != nil {
+			return status, err
+		}
+
+		permissionError, dbError := changeToSlaveAllowed(tx, &currentSlave, modelSlave)
+		if dbError != nil {
+			return http.StatusInternalServerError, dbError
+		}
+		if permissionError != nil {
+			return http.StatusForbidden, permissionError
+		}
+
+	}
+
+	return saveProposedChange(tx, modelSlave)
+}
+
+func planReplicaSetChange(tx *gorm.DB, replicaSet *ReplicaSet) (status int, err error) {
+
+	modelReplSet, err := ProjectReplicaSetToModelReplicaSet(replicaSet)
+	if err != nil {
+		return http.StatusBadRequest, err
+	}
+
+	var currentReplSet *model.ReplicaSet
+	if modelReplSet.ID != 0 {
+		currentReplSet = &model.ReplicaSet{}
+		if status, err = assertExists(tx, currentReplSet, modelReplSet.ID); err != nil {
+			return status, err
+		}
+		modelReplSet.Initiated = currentReplSet.Initiated
+	}
+
+	allowed, msg, err := changeToReplicaSetAllowed(tx, currentReplSet, modelReplSet)
+	if err != nil {
+		return http.StatusInternalServerError, fmt.Errorf("error validating update permission: %s", err)
+	}
+	if !allowed {
+		return http.StatusBadRequest, fmt.Errorf("%s", msg)
+	}
+
+	return saveProposedChange(tx, modelReplSet)
+}
+
+func assertExists(tx *gorm.DB, out interface{}, id int64) (status int, err error) {
+	res := tx.First(out, id)
+	if res.RecordNotFound() {
+		return http.StatusNotFound, fmt.Errorf("object with id `%d` does not exist", id)
+	} else if res.Error != nil {
+		return http.StatusInternalServerError, res.Error
+	}
+	return http.StatusOK, nil
+}
+
+func saveProposedChange(tx *gorm.DB, value interface{}) (status int, err error) {
+	err = tx.Save(value).Error
+	if model.IsIntegrityConstraintViolation(err) {
+		return http.StatusBadRequest, err
+	} else if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	return http.StatusOK, nil
+}
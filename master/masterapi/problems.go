@@ -14,6 +14,7 @@ type Problem struct {
 	ID              int64     `json:"id"`
 	Description     string    `json:"description"`
 	LongDescription string    `json:"long_description"`
+	ProblemType     string    `json:"problem_type"`
 	FirstOccurred   time.Time `json:"first_occurred"`
 	LastUpdated     time.Time `json:"last_updated"`
 	SlaveId         *int64    `json:"slave_id"`
@@ -0,0 +1,112 @@
+package masterapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/KIT-MAMID/mamid/master"
+	"github.com/KIT-MAMID/mamid/model"
+	"net/http"
+	"time"
+)
+
+// A Replica Set member moved from one Slave to another by the Rebalancer
+type ProposedMove struct {
+	ReplicaSetID      int64   `json:"replica_set_id"`
+	ReplicaSetName    string  `json:"replica_set_name"`
+	SourceMongodID    int64   `json:"source_mongod_id"`
+	SourceSlaveID     int64   `json:"source_slave_id"`
+	SourceHostname    string  `json:"source_hostname"`
+	SourceUtilization float64 `json:"source_utilization"`
+	TargetSlaveID     int64   `json:"target_slave_id"`
+	TargetHostname    string  `json:"target_hostname"`
+	TargetUtilization float64 `json:"target_utilization"`
+}
+
+type MongodMove struct {
+	ID                  int64      `json:"id"`
+	State               string     `json:"state"`
+	Started             time.Time  `json:"started"`
+	Finished            *time.Time `json:"finished"`
+	ReplicaSetID        *int64     `json:"replica_set_id"`
+	SourceSlaveID       *int64     `json:"source_slave_id"`
+	TargetSlaveID       *int64     `json:"target_slave_id"`
+	SourceMongodID      *int64     `json:"source_mongod_id"`
+	ReplacementMongodID *int64     `json:"replacement_mongod_id"`
+}
+
+// The moves the Rebalancer would start now. Nothing is committed or sent on the bus.
+func (m *MasterAPI) RebalanceProposalGet(w http.ResponseWriter, r *http.Request) {
+	tx := m.DB.Begin()
+	defer tx.Rollback()
+
+	moves, err := m.Rebalancer.Rebalance(tx, nil)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprintf(w, "rebalancer failure: %s", err)
+		return
+	}
+
+	json.NewEncoder(w).Encode(ProjectProposedMovesToProposedMoves(moves))
+}
+
+// Start the proposed moves
+func (m *MasterAPI) RebalancePost(w http.ResponseWriter, r *http.Request) {
+	tx := m.DB.Begin()
+
+	var decisions master.AllocatorDecisions
+	moves, err := m.Rebalancer.Rebalance(tx, &decisions)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprintf(w, "rebalancer failure: %s", err)
+		tx.Rollback()
+		return
+	}
+
+	// Trigger cluster allocator
+	allocated, err := m.attemptClusterAllocator(tx, w)
+	if err != nil {
+		return
+	}
+
+	if err = m.attemptAllocatorCommit(tx, w, append(decisions, allocated...)); err != nil {
+		return
+	}
+
+	json.NewEncoder(w).Encode(ProjectProposedMovesToProposedMoves(moves))
+}
+
+func (m *MasterAPI) RebalanceMovesGet(w http.ResponseWriter, r *http.Request) {
+	tx := m.DB.Begin()
+	defer tx.Rollback()
+
+	var moves []model.MongodMove
+	if err := tx.Order("id DESC").Find(&moves).Error; err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(w, err.Error())
+		return
+	}
+
+	out := make([]*MongodMove, len(moves))
+	for i := range moves {
+		out[i] = ProjectModelMongodMoveToMongodMove(&moves[i])
+	}
+	json.NewEncoder(w).Encode(out)
+}
+
+func ProjectProposedMovesToProposedMoves(moves []master.ProposedMove) []*ProposedMove {
+	out := make([]*ProposedMove, len(moves))
+	for i, move := range moves {
+		out[i] = &ProposedMove{
+			ReplicaSetID:      move.ReplicaSetID,
+			ReplicaSetName:    move.ReplicaSetName,
+			SourceMongodID:    move.SourceMongodID,
+			SourceSlaveID:     move.SourceSlaveID,
+			SourceHostname:    move.SourceHostname,
+			SourceUtilization: move.SourceUtilization,
+			TargetSlaveID:     move.TargetSlaveID,
+			TargetHostname:    move.TargetHostname,
+			TargetUtilization: move.TargetUtilization,
+		}
+	}
+	return out
+}
@@ -0,0 +1,68 @@
+package masterapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/KIT-MAMID/mamid/model"
+	"github.com/gorilla/mux"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Replication figures of a Replica Set, computed by the Monitor from the latest observation of its PRIMARY
+type ReplicaSetReplication struct {
+	ReplicaSetID       int64                         `json:"replica_set_id"`
+	Observed           time.Time                     `json:"observed"`
+	PrimaryMongodID    *int64                        `json:"primary_mongod_id"`
+	OplogFirst         *time.Time                    `json:"oplog_first"`
+	OplogLast          *time.Time                    `json:"oplog_last"`
+	OplogWindowSeconds float64                       `json:"oplog_window_seconds"`
+	OplogSizeBytes     uint64                        `json:"oplog_size_bytes"`
+	OplogMaxSizeBytes  uint64                        `json:"oplog_max_size_bytes"`
+	OplogFull          bool                          `json:"oplog_full"`
+	Members            []ReplicaSetMemberReplication `json:"members"`
+}
+
+type ReplicaSetMemberReplication struct {
+	MongodID    int64      `json:"mongod_id"`
+	SlaveID     int64      `json:"slave_id"`
+	Port        uint       `json:"port"`
+	MemberState string     `json:"member_state"`
+	Optime      *time.Time `json:"optime"`
+	LagSeconds  float64    `json:"lag_seconds"`
+	Observed    time.Time  `json:"observed"`
+}
+
+func (m *MasterAPI) ReplicaSetReplicationGet(w http.ResponseWriter, r *http.Request) {
+	idStr := mux.Vars(r)["replicasetId"]
+	id, err := strconv.ParseInt(idStr, 10, 0)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	tx := m.DB.Begin()
+	defer tx.Rollback()
+
+	var replication model.ReplicaSetReplication
+	res := tx.Where(model.ReplicaSetReplication{ReplicaSetID: id}).First(&replication)
+	if res.RecordNotFound() {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintf(w, "no PRIMARY of replica set with id %d has been observed yet", id)
+		return
+	} else if res.Error != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(w, res.Error.Error())
+		return
+	}
+
+	var members []model.MongodReplication
+	if err = tx.Where(model.MongodReplication{ReplicaSetID: id}).Preload("Mongod").Order("mongod_id").Find(&members).Error; err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(w, err.Error())
+		return
+	}
+
+	json.NewEncoder(w).Encode(ProjectModelReplicaSetReplicationToReplicaSetReplication(&replication, members))
+}
@@ -3,11 +3,15 @@ package masterapi
 import (
 	"encoding/json"
 	"fmt"
+	"github.com/KIT-MAMID/mamid/master"
 	"github.com/KIT-MAMID/mamid/model"
+	"github.com/KIT-MAMID/mamid/msp"
 	"github.com/gorilla/mux"
 	"github.com/jinzhu/gorm"
+	"net"
 	"net/http"
 	"strconv"
+	"strings"
 )
 
 type ShardingRole string
@@ -23,7 +27,33 @@ type ReplicaSet struct {
 	Name                string       `json:"name"`
 	PersistentNodeCount uint         `json:"persistent_node_count"`
 	VolatileNodeCount   uint         `json:"volatile_node_count"`
+	ArbiterNodeCount    uint         `json:"arbiter_node_count"`
+	HiddenNodeCount     uint         `json:"hidden_node_count"`  // persistent or volatile members designated as hidden
+	DelayedNodeCount    uint         `json:"delayed_node_count"` // persistent or volatile members designated as hidden and delayed
+	SlaveDelaySeconds   uint         `json:"slave_delay_seconds"`
 	ShardingRole        ShardingRole `json:"sharding_role"`
+	PlacementStrategy   string       `json:"placement_strategy"` // empty for the master's default
+
+	// Mongod tuning options, zero values leave the Mongod's defaults in effect
+	StorageEngine         string  `json:"storage_engine"` // `wiredTiger` or `mmapv1`
+	WiredTigerCacheSizeGB float64 `json:"wiredtiger_cache_size_gb"`
+	OplogSizeMB           uint    `json:"oplog_size_mb"`
+	BindIP                string  `json:"bind_ip"` // comma separated list of IP addresses, must include 127.0.0.1
+	JournalDisabled       bool    `json:"journal_disabled"`
+
+	// Resources requested per data-bearing member, 0 is unlimited
+	MemoryRequestMB      uint `json:"memory_request_mb"`
+	CPURequestMillicores uint `json:"cpu_request_millicores"` // 1000 millicores correspond to one CPU
+	DiskRequestGB        uint `json:"disk_request_gb"`
+}
+
+// Whether a slave can host an additional member of a replica set
+type SlavePlacement struct {
+	SlaveID          int64    `json:"slave_id"`
+	SlaveHostname    string   `json:"slave_hostname"`
+	MemberType       string   `json:"member_type"` // persistent | volatile | arbiter
+	Suitable         bool     `json:"suitable"`
+	RejectionReasons []string `json:"rejection_reasons"`
 }
 
 func (m *MasterAPI) ReplicaSetIndex(w http.ResponseWriter, r *http.Request) {
@@ -136,11 +166,12 @@ func (m *MasterAPI) ReplicaSetPut(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Trigger cluster allocator
-	if err = m.attemptClusterAllocator(tx, w); err != nil {
+	decisions, err := m.attemptClusterAllocator(tx, w)
+	if err != nil {
 		return
 	}
 
-	m.attemptCommit(tx, w)
+	m.attemptAllocatorCommit(tx, w, decisions)
 
 	// Return created slave
 
@@ -225,11 +256,12 @@ func (m *MasterAPI) ReplicaSetUpdate(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Trigger cluster allocator
-	if err = m.attemptClusterAllocator(tx, w); err != nil {
+	decisions, err := m.attemptClusterAllocator(tx, w)
+	if err != nil {
 		return
 	}
 
-	m.attemptCommit(tx, w)
+	m.attemptAllocatorCommit(tx, w, decisions)
 }
 
 func (m *MasterAPI) ReplicaSetDelete(w http.ResponseWriter, r *http.Request) {
@@ -267,11 +299,12 @@ func (m *MasterAPI) ReplicaSetDelete(w http.ResponseWriter, r *http.Request) {
 	// TODO having removed the replica set, the cluster allocator should mark the
 	// affected mongod's desired state as deleted
 	// check issue #9
-	if err = m.attemptClusterAllocator(tx, w); err != nil {
+	decisions, err := m.attemptClusterAllocator(tx, w)
+	if err != nil {
 		return
 	}
 
-	m.attemptCommit(tx, w)
+	m.attemptAllocatorCommit(tx, w, decisions)
 
 }
 
@@ -327,6 +360,52 @@ func (m *MasterAPI) ReplicaSetGetSlaves(w http.ResponseWriter, r *http.Request)
 	return
 }
 
+func (m *MasterAPI) ReplicaSetGetPlacement(w http.ResponseWriter, r *http.Request) {
+	idStr := mux.Vars(r)["replicasetId"]
+	id64, err := strconv.ParseUint(idStr, 10, 0)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	id := uint(id64)
+
+	if id == 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, "id may not be 0")
+		return
+	}
+
+	tx := m.DB.Begin()
+	defer tx.Rollback()
+
+	var replSet model.ReplicaSet
+	res := tx.First(&replSet, id)
+
+	if res.RecordNotFound() {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if err = res.Error; err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(w, err.Error())
+		return
+	}
+
+	evaluations, err := master.EvaluatePlacement(tx, &replSet)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprintf(w, "cannot evaluate placement: %s", err)
+		return
+	}
+
+	out := make([]*SlavePlacement, len(evaluations))
+	for i, e := range evaluations {
+		out[i] = ProjectSlavePlacementEvaluationToSlavePlacement(e)
+	}
+	json.NewEncoder(w).Encode(out)
+	return
+}
+
 // Validate attributes of a replica set.
 // `current` may be nil if there is no current replica set
 func changeToReplicaSetAllowed(tx *gorm.DB, current *model.ReplicaSet, new *model.ReplicaSet) (allowed bool, msg string, err error) {
@@ -344,6 +423,10 @@ func changeToReplicaSetAllowed(tx *gorm.DB, current *model.ReplicaSet, new *mode
 		if current.Initiated != new.Initiated {
 			return false, "Replica Set initiation status may not be changed - Internal error", nil
 		}
+
+		if current.StorageEngine != new.StorageEngine {
+			return false, "cannot change storage engine of a Replica Set after creation", nil
+		}
 	} else {
 		if new.Initiated != false {
 			return false, "Replica Set initiation status of new Replica Set must be false - Internal error", nil
@@ -356,6 +439,59 @@ func changeToReplicaSetAllowed(tx *gorm.DB, current *model.ReplicaSet, new *mode
 		return false, "Replica Set must have at least one member", nil
 	}
 
+	if new.ArbiterMemberCount > newMemberCount {
+		return false, "Replica Set must not have more arbiters than persistent and volatile members", nil
+	}
+
+	if new.HiddenMemberCount+new.DelayedMemberCount >= newMemberCount {
+		return false, "Replica Set must have at least one persistent or volatile member that is neither hidden nor delayed", nil
+	}
+
+	if new.DelayedMemberCount > 0 && new.SlaveDelaySeconds == 0 {
+		return false, "Replica Set with delayed members must have a slave delay", nil
+	}
+
+	if allowed, msg := mongodOptionsAllowed(new); !allowed {
+		return false, msg, nil
+	}
+
 	return true, "", nil
 
 }
+
+func mongodOptionsAllowed(r *model.ReplicaSet) (allowed bool, msg string) {
+
+	switch r.StorageEngine {
+	case "", msp.StorageEngineWiredTiger, msp.StorageEngineMMAPv1:
+	default:
+		return false, fmt.Sprintf("unknown storage engine `%s`, must be one of `%s`, `%s`", r.StorageEngine, msp.StorageEngineWiredTiger, msp.StorageEngineMMAPv1)
+	}
+
+	if r.WiredTigerCacheSizeGB < 0 {
+		return false, "WiredTiger cache size must not be negative"
+	}
+	if r.WiredTigerCacheSizeGB > 0 && r.StorageEngine == msp.StorageEngineMMAPv1 {
+		return false, "WiredTiger cache size must not be set with storage engine `mmapv1`"
+	}
+	if r.WiredTigerCacheSizeGB > 0 && r.MemoryRequestMB > 0 && r.WiredTigerCacheSizeGB*1024 >= float64(r.MemoryRequestMB) {
+		return false, "WiredTiger cache size must be less than the memory request"
+	}
+
+	if r.BindIP != "" {
+		// the slave connects to its Mongods via the loopback interface
+		loopbackIncluded := false
+		for _, ipStr := range strings.Split(r.BindIP, ",") {
+			ip := net.ParseIP(strings.TrimSpace(ipStr))
+			if ip == nil {
+				return false, fmt.Sprintf("invalid IP address `%s` in bind IPs", strings.TrimSpace(ipStr))
+			}
+			loopbackIncluded = loopbackIncluded || ip.Equal(net.IPv4(127, 0, 0, 1))
+		}
+		if !loopbackIncluded {
+			return false, "bind IPs must include `127.0.0.1`"
+		}
+	}
+
+	return true, ""
+
+}
@@ -6,13 +6,16 @@ import (
 	"fmt"
 	"github.com/KIT-MAMID/mamid/model"
 	"github.com/gorilla/mux"
+	"github.com/jinzhu/gorm"
 	"net/http"
 	"strconv"
 )
 
 type RiskGroup struct {
-	ID   int64  `json:"id"`
-	Name string `json:"name"`
+	ID       int64  `json:"id"`
+	Name     string `json:"name"`
+	Level    string `json:"level"`     // e.g. datacenter, room, rack, host
+	ParentID *int64 `json:"parent_id"` // null for top level risk groups
 }
 
 func (m *MasterAPI) RiskGroupIndex(w http.ResponseWriter, r *http.Request) {
@@ -93,10 +96,17 @@ func (m *MasterAPI) RiskGroupPut(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Persist to database
-
 	tx := m.DB.Begin()
 
+	if status, err := changeToRiskGroupAllowed(tx, modelRiskGroup); err != nil {
+		tx.Rollback()
+		w.WriteHeader(status)
+		fmt.Fprint(w, err.Error())
+		return
+	}
+
+	// Persist to database
+
 	err = tx.Create(&modelRiskGroup).Error
 
 	//Check db specific errors
@@ -113,11 +123,12 @@ func (m *MasterAPI) RiskGroupPut(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Trigger cluster allocator
-	if err = m.attemptClusterAllocator(tx, w); err != nil {
+	decisions, err := m.attemptClusterAllocator(tx, w)
+	if err != nil {
 		return
 	}
 
-	m.attemptCommit(tx, w)
+	m.attemptAllocatorCommit(tx, w, decisions)
 
 	// Return created risk group
 
@@ -171,11 +182,19 @@ func (m *MasterAPI) RiskGroupUpdate(w http.ResponseWriter, r *http.Request) {
 
 	save, err := ProjectRiskGroupToModelRiskGroup(&postRiskGroup)
 	if err != nil {
+		tx.Rollback()
 		w.WriteHeader(http.StatusBadRequest)
 		fmt.Fprintf(w, err.Error())
 		return
 	}
 
+	if status, err := changeToRiskGroupAllowed(tx, save); err != nil {
+		tx.Rollback()
+		w.WriteHeader(status)
+		fmt.Fprint(w, err.Error())
+		return
+	}
+
 	// Persist to database
 
 	err = tx.Save(&save).Error
@@ -189,11 +208,12 @@ func (m *MasterAPI) RiskGroupUpdate(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Trigger cluster allocator
-	if err = m.attemptClusterAllocator(tx, w); err != nil {
+	decisions, err := m.attemptClusterAllocator(tx, w)
+	if err != nil {
 		return
 	}
 
-	m.attemptCommit(tx, w)
+	m.attemptAllocatorCommit(tx, w, decisions)
 
 }
 
@@ -235,6 +255,20 @@ func (m *MasterAPI) RiskGroupDelete(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	var childCount int
+	if err = tx.Model(&model.RiskGroup{}).Where("parent_id = ?", id).Count(&childCount).Error; err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		tx.Rollback()
+		fmt.Fprint(w, err.Error())
+		return
+	}
+	if childCount != 0 {
+		w.WriteHeader(http.StatusForbidden)
+		tx.Rollback()
+		fmt.Fprintf(w, "riskgroup with id %d has nested risk groups", currentRiskGroup.ID)
+		return
+	}
+
 	// Allow delete
 
 	s := tx.Delete(&model.RiskGroup{ID: id})
@@ -251,11 +285,12 @@ func (m *MasterAPI) RiskGroupDelete(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Trigger cluster allocator
-	if err = m.attemptClusterAllocator(tx, w); err != nil {
+	decisions, err := m.attemptClusterAllocator(tx, w)
+	if err != nil {
 		return
 	}
 
-	m.attemptCommit(tx, w)
+	m.attemptAllocatorCommit(tx, w, decisions)
 
 }
 
@@ -483,9 +518,37 @@ func (m *MasterAPI) RiskGroupRemoveSlave(w http.ResponseWriter, r *http.Request)
 	}
 
 	// Trigger cluster allocator
-	if err = m.attemptClusterAllocator(tx, w); err != nil {
+	decisions, err := m.attemptClusterAllocator(tx, w)
+	if err != nil {
 		return
 	}
 
-	m.attemptCommit(tx, w)
+	m.attemptAllocatorCommit(tx, w, decisions)
+}
+
+// Validate the position of a risk group in the failure domain hierarchy.
+// Returns the HTTP status code to respond with if the change is not allowed.
+func changeToRiskGroupAllowed(tx *gorm.DB, riskGroup *model.RiskGroup) (status int, err error) {
+
+	visited := map[int64]bool{riskGroup.ID: riskGroup.ID != 0}
+
+	for parentID := riskGroup.ParentID; parentID.Valid; {
+
+		if visited[parentID.Int64] {
+			return http.StatusBadRequest, fmt.Errorf("risk group cannot be nested in itself")
+		}
+		visited[parentID.Int64] = true
+
+		var parent model.RiskGroup
+		res := tx.First(&parent, parentID.Int64)
+		if res.RecordNotFound() {
+			return http.StatusBadRequest, fmt.Errorf("parent risk group with id %d does not exist", parentID.Int64)
+		} else if res.Error != nil {
+			return http.StatusInternalServerError, res.Error
+		}
+
+		parentID = parent.ParentID
+	}
+
+	return http.StatusOK, nil
 }
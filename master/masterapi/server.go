@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"github.com/KIT-MAMID/mamid/master"
 	"github.com/KIT-MAMID/mamid/model"
+	"github.com/KIT-MAMID/mamid/msp"
 	"github.com/Sirupsen/logrus"
 	"github.com/gorilla/mux"
 	"github.com/jinzhu/gorm"
@@ -15,7 +16,10 @@ var masterapiLog = logrus.WithField("module", "masterapi")
 type MasterAPI struct {
 	DB               *model.DB
 	ClusterAllocator *master.ClusterAllocator
+	Rebalancer       *master.Rebalancer
+	MSPClient        msp.MSPClient
 	Router           *mux.Router
+	Events           *EventStream // optional, serves GET /events
 }
 
 func (m *MasterAPI) Setup() {
@@ -25,6 +29,9 @@ func (m *MasterAPI) Setup() {
 	m.Router.Methods("PUT").Path("/slaves").Name("SlavePut").HandlerFunc(m.SlavePut)
 	m.Router.Methods("POST").Path("/slaves/{slaveId}").Name("SlaveUpdate").HandlerFunc(m.SlaveUpdate)
 	m.Router.Methods("DELETE").Path("/slaves/{slaveId}").Name("SlaveDelete").HandlerFunc(m.SlaveDelete)
+	m.Router.Methods("GET").Path("/slaves/{slaveId}/drain").Name("SlaveDrainGet").HandlerFunc(m.SlaveDrainGet)
+	m.Router.Methods("POST").Path("/slaves/{slaveId}/drain").Name("SlaveDrainPost").HandlerFunc(m.SlaveDrainPost)
+	m.Router.Methods("GET").Path("/slaves/{slaveId}/hostmetrics").Name("SlaveHostMetricsGet").HandlerFunc(m.SlaveHostMetricsGet)
 
 	m.Router.Methods("GET").Path("/replicasets").Name("ReplicaSetIndex").HandlerFunc(m.ReplicaSetIndex)
 	m.Router.Methods("GET").Path("/replicasets/{replicasetId}").Name("ReplicaSetById").HandlerFunc(m.ReplicaSetById)
@@ -32,6 +39,8 @@ func (m *MasterAPI) Setup() {
 	m.Router.Methods("POST").Path("/replicasets/{replicasetId}").Name("ReplicaSetUpdate").HandlerFunc(m.ReplicaSetUpdate)
 	m.Router.Methods("DELETE").Path("/replicasets/{replicasetId}").Name("ReplicaSetDelete").HandlerFunc(m.ReplicaSetDelete)
 	m.Router.Methods("GET").Path("/replicasets/{replicasetId}/slaves").Name("ReplicaSetGetSlaves").HandlerFunc(m.ReplicaSetGetSlaves)
+	m.Router.Methods("GET").Path("/replicasets/{replicasetId}/placement").Name("ReplicaSetGetPlacement").HandlerFunc(m.ReplicaSetGetPlacement)
+	m.Router.Methods("GET").Path("/replicasets/{replicasetId}/replication").Name("ReplicaSetReplicationGet").HandlerFunc(m.ReplicaSetReplicationGet)
 
 	m.Router.Methods("GET").Path("/riskgroups").Name("RiskGroupIndex").HandlerFunc(m.RiskGroupIndex)
 	m.Router.Methods("GET").Path("/riskgroups/{riskgroupId}").Name("RiskGroupById").HandlerFunc(m.RiskGroupById)
@@ -47,16 +56,28 @@ func (m *MasterAPI) Setup() {
 	m.Router.Methods("GET").Path("/slaves/{slaveId}/problems").Name("ProblemBySlave").HandlerFunc(m.ProblemBySlave)
 	m.Router.Methods("GET").Path("/replicasets/{replicasetId}/problems").Name("ProblemByReplicaSet").HandlerFunc(m.ProblemByReplicaSet)
 
+	if m.Events != nil {
+		m.Router.Methods("GET").Path("/events").Name("EventsGet").HandlerFunc(m.EventsGet)
+	}
+
 	m.Router.Methods("GET").Path("/slaves/{slaveId}/mongods").Name("MongodsBySlave").HandlerFunc(m.MongodsBySlave)
 	m.Router.Methods("GET").Path("/replicasets/{replicasetId}/mongods").Name("MongodsByReplicaSet").HandlerFunc(m.MongodsByReplicaSet)
+	m.Router.Methods("GET").Path("/mongods/{mongodId}/log").Name("MongodLogGet").HandlerFunc(m.MongodLogGet)
+
+	m.Router.Methods("POST").Path("/plan").Name("PlanPost").HandlerFunc(m.PlanPost)
+
+	m.Router.Methods("GET").Path("/rebalance/proposal").Name("RebalanceProposalGet").HandlerFunc(m.RebalanceProposalGet)
+	m.Router.Methods("POST").Path("/rebalance").Name("RebalancePost").HandlerFunc(m.RebalancePost)
+	m.Router.Methods("GET").Path("/rebalance/moves").Name("RebalanceMovesGet").HandlerFunc(m.RebalanceMovesGet)
 
 	m.Router.Methods("GET").Path("/system/keyfile").Name("KeyfileGet").HandlerFunc(m.KeyfileGet)
 	m.Router.Methods("GET").Path("/system/managementuser").Name("ManagementUserGet").HandlerFunc(m.ManagementUserGet)
 
 }
 
-func (m *MasterAPI) attemptClusterAllocator(tx *gorm.DB, w http.ResponseWriter) (err error) {
-	err = m.ClusterAllocator.CompileMongodLayout(tx)
+// Run the ClusterAllocator in tx, pass the decisions to attemptAllocatorCommit
+func (m *MasterAPI) attemptClusterAllocator(tx *gorm.DB, w http.ResponseWriter) (decisions master.AllocatorDecisions, err error) {
+	err = m.ClusterAllocator.CompileMongodLayout(tx, &decisions)
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		fmt.Fprintf(w, "cluster allocator failure: %s\n", err)
@@ -64,6 +85,17 @@ func (m *MasterAPI) attemptClusterAllocator(tx *gorm.DB, w http.ResponseWriter)
 			fmt.Fprintf(w, "cluster allocator rollback failure: %s\n", err)
 		}
 	}
+	return decisions, err
+}
+
+// Commit a transaction the ClusterAllocator ran in, sending its decisions on the bus
+func (m *MasterAPI) attemptAllocatorCommit(tx *gorm.DB, w http.ResponseWriter, decisions master.AllocatorDecisions) (err error) {
+	err = m.ClusterAllocator.Commit(tx, decisions)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprintf(w, "commit failed with error %s\n", err)
+		masterapiLog.WithError(err).Errorf("commit failed")
+	}
 	return err
 }
 
@@ -0,0 +1,137 @@
+package masterapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/KIT-MAMID/mamid/master"
+	"github.com/KIT-MAMID/mamid/model"
+	"github.com/gorilla/mux"
+	"github.com/jinzhu/gorm"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// The progress of moving all Mongods off a Slave
+type SlaveDrain struct {
+	SlaveID             int64      `json:"slave_id"`
+	State               string     `json:"state"`
+	StateDescription    string     `json:"state_description"`
+	Started             time.Time  `json:"started"`
+	Finished            *time.Time `json:"finished"`
+	ReplicaSetID        *int64     `json:"replica_set_id"`        // the Replica Set currently being moved
+	DrainedMongodID     *int64     `json:"drained_mongod_id"`     // the Replica Set's member on the drained Slave
+	ReplacementMongodID *int64     `json:"replacement_mongod_id"` // the member replacing it on another Slave
+	RemainingMongods    uint       `json:"remaining_mongods"`
+}
+
+func (m *MasterAPI) SlaveDrainGet(w http.ResponseWriter, r *http.Request) {
+	idStr := mux.Vars(r)["slaveId"]
+	id, err := strconv.ParseInt(idStr, 10, 0)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	tx := m.DB.Begin()
+	defer tx.Rollback()
+
+	var drain model.SlaveDrain
+	res := tx.Where(model.SlaveDrain{SlaveID: id}).First(&drain)
+	if res.RecordNotFound() {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintf(w, "slave with id %d has never been drained", id)
+		return
+	} else if res.Error != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(w, res.Error.Error())
+		return
+	}
+
+	out, err := ProjectModelSlaveDrainToSlaveDrain(tx, &drain)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprintf(w, "cannot project model slave drain to slave drain: %s", err)
+		return
+	}
+
+	json.NewEncoder(w).Encode(out)
+}
+
+// Move all Mongods off the Slave. Active Slaves are put in maintenance.
+// Draining stops if the Slave's configured state is changed from maintenance.
+func (m *MasterAPI) SlaveDrainPost(w http.ResponseWriter, r *http.Request) {
+	idStr := mux.Vars(r)["slaveId"]
+	id, err := strconv.ParseInt(idStr, 10, 0)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	tx := m.DB.Begin()
+
+	var slave model.Slave
+	res := tx.First(&slave, id)
+	if res.RecordNotFound() {
+		w.WriteHeader(http.StatusNotFound)
+		tx.Rollback()
+		return
+	} else if res.Error != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(w, res.Error.Error())
+		tx.Rollback()
+		return
+	}
+
+	if slave.ConfiguredState == model.SlaveStateDisabled {
+		w.WriteHeader(http.StatusForbidden)
+		fmt.Fprintf(w, "slave `%s` is disabled, its Mongods are already being replaced", slave.Hostname)
+		tx.Rollback()
+		return
+	}
+
+	if slave.ConfiguredState == model.SlaveStateActive {
+		slave.ConfiguredState = model.SlaveStateMaintenance
+		if err = tx.Save(&slave).Error; err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprint(w, err.Error())
+			tx.Rollback()
+			return
+		}
+	}
+
+	if _, err = master.StartSlaveDrain(tx, &slave); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(w, err.Error())
+		tx.Rollback()
+		return
+	}
+
+	// Trigger cluster allocator
+	decisions, err := m.attemptClusterAllocator(tx, w)
+	if err != nil {
+		return
+	}
+
+	out, err := projectSlaveDrainBySlaveID(tx, slave.ID)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprintf(w, "cannot project model slave drain to slave drain: %s", err)
+		tx.Rollback()
+		return
+	}
+
+	if err = m.attemptAllocatorCommit(tx, w, decisions); err != nil {
+		return
+	}
+
+	json.NewEncoder(w).Encode(out)
+}
+
+func projectSlaveDrainBySlaveID(tx *gorm.DB, slaveID int64) (*SlaveDrain, error) {
+	var drain model.SlaveDrain
+	if err := tx.Where(model.SlaveDrain{SlaveID: slaveID}).First(&drain).Error; err != nil {
+		return nil, err
+	}
+	return ProjectModelSlaveDrainToSlaveDrain(tx, &drain)
+}
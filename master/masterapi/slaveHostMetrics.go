@@ -0,0 +1,53 @@
+package masterapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/KIT-MAMID/mamid/model"
+	"github.com/gorilla/mux"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// The latest host metrics sample reported by a Slave
+type SlaveHostMetrics struct {
+	SlaveID                  int64     `json:"slave_id"`
+	Sampled                  time.Time `json:"sampled"`
+	CPUCount                 uint      `json:"cpu_count"`
+	LoadAverage1             float64   `json:"load_average_1"`
+	LoadAverage5             float64   `json:"load_average_5"`
+	LoadAverage15            float64   `json:"load_average_15"`
+	MemoryTotalBytes         uint64    `json:"memory_total_bytes"`
+	MemoryAvailableBytes     uint64    `json:"memory_available_bytes"`
+	SwapTotalBytes           uint64    `json:"swap_total_bytes"`
+	SwapFreeBytes            uint64    `json:"swap_free_bytes"`
+	DataVolumeTotalBytes     uint64    `json:"data_volume_total_bytes"`
+	DataVolumeAvailableBytes uint64    `json:"data_volume_available_bytes"`
+}
+
+func (m *MasterAPI) SlaveHostMetricsGet(w http.ResponseWriter, r *http.Request) {
+	idStr := mux.Vars(r)["slaveId"]
+	id, err := strconv.ParseInt(idStr, 10, 0)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	tx := m.DB.Begin()
+	defer tx.Rollback()
+
+	var metrics model.SlaveHostMetrics
+	res := tx.Where(model.SlaveHostMetrics{SlaveID: id}).First(&metrics)
+	if res.RecordNotFound() {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintf(w, "slave with id %d has not reported host metrics yet", id)
+		return
+	} else if res.Error != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(w, res.Error.Error())
+		return
+	}
+
+	json.NewEncoder(w).Encode(ProjectModelSlaveHostMetricsToSlaveHostMetrics(&metrics))
+}
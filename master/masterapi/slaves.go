@@ -20,6 +20,16 @@ type Slave struct {
 	ConfiguredState              string `json:"configured_state"`
 	ConfiguredStateTransitioning bool   `json:"configured_state_transitioning"`
 	RiskGroupID                  *int64 `json:"risk_group_id"`
+
+	// Declared resource capacities, 0 if undeclared
+	MemoryCapacityMB      uint `json:"memory_capacity_mb"`
+	CPUCapacityMillicores uint `json:"cpu_capacity_millicores"`
+	DiskCapacityGB        uint `json:"disk_capacity_gb"`
+
+	// Resources requested by the data-bearing members hosted by the slave, ignored on PUT and POST
+	RequestedMemoryMB      uint `json:"requested_memory_mb"`
+	RequestedCPUMillicores uint `json:"requested_cpu_millicores"`
+	RequestedDiskGB        uint `json:"requested_disk_gb"`
 }
 
 func (m *MasterAPI) SlaveIndex(w http.ResponseWriter, r *http.Request) {
@@ -134,7 +144,8 @@ func (m *MasterAPI) SlavePut(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Trigger cluster allocator
-	if err = m.attemptClusterAllocator(tx, w); err != nil {
+	decisions, err := m.attemptClusterAllocator(tx, w)
+	if err != nil {
 		return
 	}
 
@@ -149,7 +160,7 @@ func (m *MasterAPI) SlavePut(w http.ResponseWriter, r *http.Request) {
 
 	json.NewEncoder(w).Encode(apiSlave)
 
-	m.attemptCommit(tx, w)
+	m.attemptAllocatorCommit(tx, w, decisions)
 
 	return
 }
@@ -242,11 +253,12 @@ func (m *MasterAPI) SlaveUpdate(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Trigger cluster allocator
-	if err = m.attemptClusterAllocator(tx, w); err != nil {
+	decisions, err := m.attemptClusterAllocator(tx, w)
+	if err != nil {
 		return
 	}
 
-	m.attemptCommit(tx, w)
+	m.attemptAllocatorCommit(tx, w, decisions)
 
 }
 
@@ -298,17 +310,19 @@ func (m *MasterAPI) SlaveDelete(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Trigger cluster allocator
-	if err = m.attemptClusterAllocator(tx, w); err != nil {
+	decisions, err := m.attemptClusterAllocator(tx, w)
+	if err != nil {
 		return
 	}
 
-	m.attemptCommit(tx, w)
+	m.attemptAllocatorCommit(tx, w, decisions)
 }
 
 func changeToSlaveAllowed(tx *gorm.DB, currentSlave *model.Slave, updatedSlave *model.Slave) (permissionError, dbError error) {
 
 	// Allow change of state if nothing else is changed
 	// NOTE: changing the slave state is an indication to the ClusterAllocator but has no direct consequences in deployment
+	// NOTE: the same holds for the declared resource capacities, which only limit future placement
 	if currentSlave.ID == updatedSlave.ID &&
 		currentSlave.Hostname == updatedSlave.Hostname &&
 		currentSlave.Port == updatedSlave.Port &&
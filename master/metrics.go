@@ -0,0 +1,122 @@
+package master
+
+import (
+	"github.com/KIT-MAMID/mamid/model"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+/*
+	Prometheus metrics
+
+	The master's components update the metrics below, they are served by the master's metrics listener (-metrics.listen).
+	Figures that are stored in the database, e.g. open Problems, are collected when scraped, see ProblemCollector.
+*/
+
+var (
+	monitorCycleDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: "mamid",
+		Subsystem: "monitor",
+		Name:      "cycle_duration_seconds",
+		Help:      "Duration of a monitor run observing all slaves.",
+		Buckets:   prometheus.ExponentialBuckets(0.05, 2, 10),
+	})
+	slaveObservationErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "mamid",
+		Subsystem: "monitor",
+		Name:      "slave_observation_errors_total",
+		Help:      "Number of failed status requests by slave and MSP error identifier.",
+	}, []string{"slave", "identifier"})
+	clusterAllocatorRuns = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "mamid",
+		Subsystem: "cluster_allocator",
+		Name:      "runs_total",
+		Help:      "Number of cluster allocator runs by result (success, error).",
+	}, []string{"result"})
+	unsatisfiableReplicaSets = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "mamid",
+		Subsystem: "cluster_allocator",
+		Name:      "unsatisfiable_replica_sets",
+		Help:      "Number of Replica Sets whose member counts could not be satisfied by the latest cluster allocator run.",
+	})
+	deployerPushFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "mamid",
+		Subsystem: "deployer",
+		Name:      "push_failures_total",
+		Help:      "Number of failed attempts to establish the desired state of a Mongod by slave.",
+	}, []string{"slave"})
+	busQueueDepthDesc = prometheus.NewDesc("mamid_bus_queue_depth",
+		"Number of messages queued in the bus's channels by direction (write: towards the bus, read: towards consumers).",
+		[]string{"direction"}, nil)
+	busDroppedMessages = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "mamid",
+		Subsystem: "bus",
+		Name:      "dropped_messages_total",
+		Help:      "Number of messages dropped because a consumer's channel was full.",
+	})
+	openProblemsDesc = prometheus.NewDesc("mamid_problems",
+		"Number of open Problems by type.",
+		[]string{"type"}, nil)
+)
+
+func init() {
+	prometheus.MustRegister(
+		monitorCycleDuration,
+		slaveObservationErrors,
+		clusterAllocatorRuns,
+		unsatisfiableReplicaSets,
+		deployerPushFailures,
+		busDroppedMessages,
+	)
+}
+
+// The Bus reports the depth of its queues
+func (b *Bus) Describe(ch chan<- *prometheus.Desc) {
+	ch <- busQueueDepthDesc
+}
+
+func (b *Bus) Collect(ch chan<- prometheus.Metric) {
+	var writeDepth, readDepth int
+	b.writeChannelsMutex.Lock()
+	for _, channel := range b.writeChannels {
+		writeDepth += len(channel)
+	}
+	b.writeChannelsMutex.Unlock()
+	b.readChannelsMutex.Lock()
+	for _, channel := range b.readChannels {
+		readDepth += len(channel)
+	}
+	b.readChannelsMutex.Unlock()
+	ch <- prometheus.MustNewConstMetric(busQueueDepthDesc, prometheus.GaugeValue, float64(writeDepth), "write")
+	ch <- prometheus.MustNewConstMetric(busQueueDepthDesc, prometheus.GaugeValue, float64(readDepth), "read")
+}
+
+// Collects the number of open Problems by type from the database when scraped
+type ProblemCollector struct {
+	DB *model.DB
+}
+
+func (c ProblemCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- openProblemsDesc
+}
+
+func (c ProblemCollector) Collect(ch chan<- prometheus.Metric) {
+	tx := c.DB.Begin()
+	defer tx.Rollback()
+
+	var counts []struct {
+		ProblemType model.ProblemType
+		Count       uint
+	}
+	if err := tx.Raw("SELECT problem_type, COUNT(*) AS count FROM problems GROUP BY problem_type").Scan(&counts).Error; err != nil {
+		ch <- prometheus.NewInvalidMetric(openProblemsDesc, err)
+		return
+	}
+	byType := make(map[model.ProblemType]uint)
+	for _, count := range counts {
+		byType[count.ProblemType] = count.Count
+	}
+	// Report every type so that resolved Problems are visible as 0
+	for _, problemType := range model.ProblemTypes() {
+		ch <- prometheus.MustNewConstMetric(openProblemsDesc, prometheus.GaugeValue, float64(byType[problemType]), problemType.String())
+	}
+}
@@ -0,0 +1,91 @@
+package master
+
+import (
+	"github.com/KIT-MAMID/mamid/model"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestProblemCollector_Collect(t *testing.T) {
+	db, err := createDB(t)
+	defer db.CloseAndDrop()
+	assert.NoError(t, err)
+
+	{
+		tx := db.Begin()
+		for _, problemType := range []model.ProblemType{model.ProblemTypeConnection, model.ProblemTypeConnection, model.ProblemTypeReplicationLag} {
+			assert.NoError(t, tx.Create(&model.Problem{ProblemType: problemType, FirstOccurred: time.Now(), LastUpdated: time.Now()}).Error)
+		}
+		tx.Commit()
+	}
+
+	expected := `
+# HELP mamid_problems Number of open Problems by type.
+# TYPE mamid_problems gauge
+mamid_problems{type="connection"} 2
+mamid_problems{type="desired_replica_set_constraint"} 0
+mamid_problems{type="mismatch"} 0
+mamid_problems{type="mongod_crash_loop"} 0
+mamid_problems{type="mongod_options_drift"} 0
+mamid_problems{type="observed_replica_set_constraint"} 0
+mamid_problems{type="oplog_window"} 0
+mamid_problems{type="replication_lag"} 1
+mamid_problems{type="slave_data_volume_full"} 0
+mamid_problems{type="slave_high_load"} 0
+mamid_problems{type="slave_memory_pressure"} 0
+`
+	assert.NoError(t, testutil.CollectAndCompare(ProblemCollector{DB: db}, strings.NewReader(expected)))
+}
+
+func TestBus_Collect(t *testing.T) {
+	bus := NewBus()
+	write := bus.GetNewWriteChannel()
+	bus.GetNewReadChannel()
+	write <- 1
+	write <- 2
+
+	expected := `
+# HELP mamid_bus_queue_depth Number of messages queued in the bus's channels by direction (write: towards the bus, read: towards consumers).
+# TYPE mamid_bus_queue_depth gauge
+mamid_bus_queue_depth{direction="read"} 0
+mamid_bus_queue_depth{direction="write"} 3
+`
+	// the third message is the interrupt sent by GetNewWriteChannel, the bus is not running
+	assert.NoError(t, testutil.CollectAndCompare(bus, strings.NewReader(expected)))
+}
+
+func TestClusterAllocator_PlanMongodLayout_metrics(t *testing.T) {
+	db, _, err := model.InitializeTestDB()
+	defer db.CloseAndDrop()
+	assert.NoError(t, err)
+
+	tx := db.Begin()
+	assert.NoError(t, tx.Create(&model.ReplicaSet{Name: "repl1", PersistentMemberCount: 2, ShardingRole: model.ShardingRoleNone}).Error)
+	assert.NoError(t, tx.Commit().Error)
+
+	var c ClusterAllocator
+	compileMongodLayout(t, db, &c)
+	assert.Equal(t, float64(1), testutil.ToFloat64(unsatisfiableReplicaSets), "no slaves to host the members")
+	runs := testutil.ToFloat64(clusterAllocatorRuns.WithLabelValues("success"))
+
+	tx = db.Begin()
+	defer tx.Rollback()
+	for _, hostname := range []string{"host1", "host2"} {
+		assert.NoError(t, tx.Create(&model.Slave{
+			Hostname:             hostname,
+			Port:                 8081,
+			MongodPortRangeBegin: 18080,
+			MongodPortRangeEnd:   18090,
+			PersistentStorage:    true,
+			ConfiguredState:      model.SlaveStateActive,
+		}).Error)
+	}
+	diff, err := c.PlanMongodLayout(tx)
+	assert.NoError(t, err)
+	assert.Len(t, diff.Spawned, 2)
+	assert.Equal(t, float64(1), testutil.ToFloat64(unsatisfiableReplicaSets), "a plan must not change the gauge")
+	assert.Equal(t, runs, testutil.ToFloat64(clusterAllocatorRuns.WithLabelValues("success")), "a plan is not a cluster allocator run")
+}
@@ -7,6 +7,8 @@ import (
 	"github.com/KIT-MAMID/mamid/msp"
 	"github.com/Sirupsen/logrus"
 	"github.com/jinzhu/gorm"
+	"reflect"
+	"strings"
 	"sync"
 	"time"
 )
@@ -14,10 +16,36 @@ import (
 var monitorLog = logrus.WithField("module", "monitor")
 
 type Monitor struct {
-	DB              *model.DB
-	BusWriteChannel chan<- interface{}
-	MSPClient       msp.MSPClient
-	Interval        time.Duration
+	DB                    *model.DB
+	BusWriteChannel       chan<- interface{}
+	MSPClient             msp.MSPClient
+	Interval              time.Duration
+	HostMetricsThresholds HostMetricsThresholds
+	ReplicationThresholds ReplicationThresholds
+}
+
+// Thresholds for the host metrics reported by Slaves above which Problems are raised, 0 disables a check
+type HostMetricsThresholds struct {
+	DataVolumeUsage float64 // used share of the data volume (0 to 1)
+	MemoryUsage     float64 // share of memory not available without swapping (0 to 1)
+	LoadPerCPU      float64 // 5-minute load average divided by the number of CPUs
+}
+
+var DefaultHostMetricsThresholds = HostMetricsThresholds{
+	DataVolumeUsage: 0.9,
+	MemoryUsage:     0.95,
+	LoadPerCPU:      2,
+}
+
+// Thresholds for the replication of Replica Sets beyond which Problems are raised, 0 disables a check
+type ReplicationThresholds struct {
+	MaxLag         time.Duration // lag of a member behind the PRIMARY, not counting its slave delay
+	MinOplogWindow time.Duration // time span covered by the PRIMARY's oplog once it is full
+}
+
+var DefaultReplicationThresholds = ReplicationThresholds{
+	MaxLag:         5 * time.Minute,
+	MinOplogWindow: time.Hour,
 }
 
 func (m *Monitor) Run() {
@@ -28,6 +56,7 @@ func (m *Monitor) Run() {
 			select {
 			case <-ticker.C:
 				monitorLog.Info("Monitor running")
+				cycleStart := time.Now()
 
 				//Get all slaves from database
 				tx := m.DB.Begin()
@@ -41,7 +70,7 @@ func (m *Monitor) Run() {
 				wg := sync.WaitGroup{}
 
 				type observation struct {
-					result   []msp.Mongod
+					result   msp.SlaveStatus
 					err      *msp.Error
 					theSlave model.Slave
 				}
@@ -52,9 +81,9 @@ func (m *Monitor) Run() {
 					wg.Add(1)
 					go func(s model.Slave) {
 						//Request mongod states from slave
-						observedMongods, mspError := m.MSPClient.RequestStatus(msp.HostPort{s.Hostname, msp.PortNumber(s.Port)})
+						status, mspError := m.MSPClient.RequestStatus(msp.HostPort{s.Hostname, msp.PortNumber(s.Port)})
 						observationChan <- observation{
-							result:   observedMongods,
+							result:   status,
 							err:      mspError,
 							theSlave: s, //Do not call this slave or vet will fail
 						}
@@ -71,12 +100,17 @@ func (m *Monitor) Run() {
 				//Consumer loop that saves result to database
 				//We do this so that all transactions happen after eachother == prevent concurrent database access
 				for observationRes := range observationChan {
-					m.handleObservation(observationRes.result, observationRes.err, observationRes.theSlave)
+					m.handleObservation(observationRes.result.Mongods, observationRes.err, observationRes.theSlave)
+					if observationRes.err == nil && observationRes.result.HostMetrics != nil {
+						m.handleHostMetrics(*observationRes.result.HostMetrics, observationRes.theSlave)
+					}
 				}
 
 				//Check degradation of replica sets
 				m.observeReplicaSets()
 
+				monitorCycleDuration.Observe(time.Since(cycleStart).Seconds())
+
 			case <-quit:
 				ticker.Stop()
 				return
@@ -95,6 +129,7 @@ func (m *Monitor) handleObservation(observedMongods []msp.Mongod, mspError *msp.
 	if mspError != nil {
 		//TODO Handle other slave errors => check identifiers != CommunicationError
 		monitorLog.Errorf("monitor: error observing slave: %#v", mspError)
+		slaveObservationErrors.WithLabelValues(slave.Hostname, mspError.Identifier).Inc()
 		comErr = *mspError
 	}
 	m.BusWriteChannel <- model.ConnectionStatus{
@@ -134,6 +169,13 @@ func (m *Monitor) handleObservation(observedMongods []msp.Mongod, mspError *msp.
 		return
 	}
 
+	replicationStatuses, err := m.updateReplication(tx, modelToObservedMap)
+	if err != nil {
+		monitorLog.Errorf("error updating replication figures of Replica Sets with PRIMARY on slave `%s`: %s", slave.Hostname, err)
+		tx.Rollback()
+		return
+	}
+
 	if err := tx.Commit().Error; err != nil {
 		monitorLog.WithError(err).Error("Could not commit monitor run")
 	}
@@ -144,9 +186,197 @@ func (m *Monitor) handleObservation(observedMongods []msp.Mongod, mspError *msp.
 	if err := m.sendMongodMismatchStatusToBus(tx, slave, modelToObservedMap); err != nil {
 		monitorLog.WithError(err).Error()
 	}
+	for _, status := range replicationStatuses {
+		m.BusWriteChannel <- status
+	}
 
 }
 
+// Replace the latest host metrics sample of the Slave and send a HostMetricsStatus to the Bus
+func (m *Monitor) handleHostMetrics(metrics msp.HostMetrics, slave model.Slave) {
+
+	tx := m.DB.Begin()
+
+	sample := ProjectMSPHostMetricsToModelSlaveHostMetrics(metrics)
+	sample.SlaveID = slave.ID
+	sample.Sampled = time.Now()
+
+	var previous model.SlaveHostMetrics
+	if res := tx.Where(&model.SlaveHostMetrics{SlaveID: slave.ID}).First(&previous); res.Error != nil && !res.RecordNotFound() {
+		monitorLog.Errorf("error fetching host metrics of slave `%s`: %s", slave.Hostname, res.Error)
+		tx.Rollback()
+		return
+	}
+	sample.ID = previous.ID // created if the Slave has no sample yet
+	if err := tx.Save(&sample).Error; err != nil {
+		monitorLog.Errorf("error persisting host metrics of slave `%s`: %s", slave.Hostname, err)
+		tx.Rollback()
+		return
+	}
+	if err := tx.Commit().Error; err != nil {
+		monitorLog.Errorf("could not commit host metrics of slave `%s`: %s", slave.Hostname, err)
+		return
+	}
+
+	m.BusWriteChannel <- model.HostMetricsStatus{
+		Slave:          slave,
+		Metrics:        sample,
+		DataVolumeFull: m.HostMetricsThresholds.dataVolumeFull(sample),
+		MemoryPressure: m.HostMetricsThresholds.memoryPressure(sample),
+		HighLoad:       m.HostMetricsThresholds.highLoad(sample),
+	}
+
+}
+
+func (t HostMetricsThresholds) dataVolumeFull(s model.SlaveHostMetrics) bool {
+	return t.DataVolumeUsage > 0 && usedShare(s.DataVolumeAvailableBytes, s.DataVolumeTotalBytes) > t.DataVolumeUsage
+}
+
+func (t HostMetricsThresholds) memoryPressure(s model.SlaveHostMetrics) bool {
+	return t.MemoryUsage > 0 && usedShare(s.MemoryAvailableBytes, s.MemoryTotalBytes) > t.MemoryUsage
+}
+
+func (t HostMetricsThresholds) highLoad(s model.SlaveHostMetrics) bool {
+	return t.LoadPerCPU > 0 && s.CPUCount > 0 && s.LoadAverage5/float64(s.CPUCount) > t.LoadPerCPU
+}
+
+// Share of total not available, 0 if total is unknown
+func usedShare(available, total uint64) float64 {
+	if total == 0 || available > total {
+		return 0
+	}
+	return float64(total-available) / float64(total)
+}
+
+// Compute and persist the replication figures of every Replica Set whose PRIMARY is among the observed Mongods
+// Returns the ReplicaSetReplicationStatus messages to be sent to the Bus once the transaction is committed
+func (m *Monitor) updateReplication(tx *gorm.DB, modelToObservedMap map[int64]msp.Mongod) (statuses []model.ReplicaSetReplicationStatus, err error) {
+
+	for modelMongodID, observedMongod := range modelToObservedMap {
+
+		if observedMongod.StatusError != nil || observedMongod.Replication == nil {
+			continue
+		}
+		isPrimary := false
+		for _, member := range observedMongod.Replication.Members {
+			isPrimary = isPrimary || (member.Self && member.State == msp.ReplicationMemberStatePrimary)
+		}
+		if !isPrimary {
+			continue
+		}
+
+		var primary model.Mongod
+		if err = tx.First(&primary, modelMongodID).Error; err != nil {
+			return nil, err
+		}
+		if !primary.ReplicaSetID.Valid {
+			continue // a Mongod unknown to MAMID
+		}
+
+		status, err := m.updatePrimaryReplication(tx, primary, *observedMongod.Replication, observedMongod.ReplicaSetConfig.ReplicaSetMembers)
+		if err != nil {
+			return nil, fmt.Errorf("Replica Set `%s`: %s", primary.ReplSetName, err)
+		}
+		statuses = append(statuses, status)
+
+	}
+
+	return statuses, nil
+}
+
+// The lag of every member is computed from the optimes seen by the PRIMARY, i.e. at the same point in time
+func (m *Monitor) updatePrimaryReplication(tx *gorm.DB, primary model.Mongod, replication msp.ReplicationStatus, configMembers []msp.ReplicaSetMember) (status model.ReplicaSetReplicationStatus, err error) {
+
+	now := time.Now()
+
+	if err = tx.First(&status.ReplicaSet, primary.ReplicaSetID.Int64).Error; err != nil {
+		return status, fmt.Errorf("could not fetch Replica Set: %s", err)
+	}
+
+	var primaryOptime time.Time
+	for _, member := range replication.Members {
+		if member.Self {
+			primaryOptime = member.Optime
+		}
+	}
+	slaveDelays := make(map[msp.HostPort]time.Duration)
+	for _, member := range configMembers {
+		slaveDelays[member.HostPort] = time.Duration(member.SlaveDelay) * time.Second
+	}
+
+	for _, member := range replication.Members {
+
+		var mongod model.Mongod
+		res := tx.Raw(`SELECT m.* FROM mongods m JOIN slaves s ON s.id = m.parent_slave_id
+			WHERE m.replica_set_id = ? AND s.hostname = ? AND m.port = ?`,
+			status.ReplicaSet.ID, member.HostPort.Hostname, member.HostPort.Port).Scan(&mongod)
+		if res.RecordNotFound() {
+			continue // not managed by MAMID
+		} else if res.Error != nil {
+			return status, fmt.Errorf("could not fetch member `%s:%d`: %s", member.HostPort.Hostname, member.HostPort.Port, res.Error)
+		}
+
+		memberReplication := model.MongodReplication{
+			Observed:     now,
+			MemberState:  member.State,
+			MongodID:     mongod.ID,
+			ReplicaSetID: status.ReplicaSet.ID,
+		}
+		if !member.Optime.IsZero() && !primaryOptime.IsZero() {
+			optime := member.Optime
+			memberReplication.Optime = &optime
+			lag := primaryOptime.Sub(optime)
+			if lag < 0 {
+				lag = 0 // the member applied operations after the PRIMARY reported its own optime
+			}
+			memberReplication.LagSeconds = lag.Seconds()
+			if lag -= slaveDelays[member.HostPort]; m.ReplicationThresholds.MaxLag > 0 && lag > m.ReplicationThresholds.MaxLag {
+				status.LaggingMembers = append(status.LaggingMembers, model.LaggingMember{
+					Mongod:   mongod,
+					Hostname: member.HostPort.Hostname,
+					Lag:      lag,
+				})
+			}
+		}
+
+		var previous model.MongodReplication
+		if res := tx.Where(&model.MongodReplication{MongodID: mongod.ID}).First(&previous); res.Error != nil && !res.RecordNotFound() {
+			return status, res.Error
+		}
+		memberReplication.ID = previous.ID // created on the first observation
+		if err = tx.Save(&memberReplication).Error; err != nil {
+			return status, fmt.Errorf("could not persist replication lag of member `%s:%d`: %s", member.HostPort.Hostname, member.HostPort.Port, err)
+		}
+
+	}
+
+	status.Replication = model.ReplicaSetReplication{
+		Observed:          now,
+		OplogSizeBytes:    replication.OplogSizeBytes,
+		OplogMaxSizeBytes: replication.OplogMaxSizeBytes,
+		ReplicaSetID:      status.ReplicaSet.ID,
+		PrimaryMongodID:   model.NullIntValue(primary.ID),
+	}
+	if !replication.OplogFirst.IsZero() && !replication.OplogLast.IsZero() {
+		first, last := replication.OplogFirst, replication.OplogLast
+		status.Replication.OplogFirst, status.Replication.OplogLast = &first, &last
+		status.Replication.OplogWindowSeconds = last.Sub(first).Seconds()
+		status.OplogWindowTooShort = m.ReplicationThresholds.MinOplogWindow > 0 && status.Replication.OplogFull() &&
+			last.Sub(first) < m.ReplicationThresholds.MinOplogWindow
+	}
+
+	var previous model.ReplicaSetReplication
+	if res := tx.Where(&model.ReplicaSetReplication{ReplicaSetID: status.ReplicaSet.ID}).First(&previous); res.Error != nil && !res.RecordNotFound() {
+		return status, res.Error
+	}
+	status.Replication.ID = previous.ID // created on the first observation
+	if err = tx.Save(&status.Replication).Error; err != nil {
+		return status, fmt.Errorf("could not persist oplog window: %s", err)
+	}
+
+	return status, nil
+}
+
 func (m *Monitor) updateSlaveObservationError(tx *gorm.DB, slave model.Slave, slaveObservationError *msp.Error) (criticalError error) {
 
 	if slaveObservationError != nil { // update observation error field
@@ -383,14 +613,30 @@ func (m *Monitor) sendMongodMismatchStatusToBus(tx *gorm.DB, slave model.Slave,
 
 	for _, modelMongod := range modelMongods {
 
-		var busMessage interface{}
+		var busMessage model.MongodMatchStatus
 
-		busMessage, err = m.compareStates(tx, modelMongod, modelToObservedMap[modelMongod.ID])
+		observedMongod := modelToObservedMap[modelMongod.ID]
+		busMessage, err = m.compareStates(tx, modelMongod, observedMongod)
 		if err != nil {
 			monitorLog.Errorf("error comparing Mongod `%d on %s` Desired and Observed state: %s", modelMongod.ID, slave.Hostname, err)
 			continue
 		}
 
+		if observedMongod.StatusError != nil && observedMongod.StatusError.Identifier == msp.SlaveMongodCrashLoopError {
+			busMessage.CrashLooping = true
+			busMessage.CrashLoopError = *observedMongod.StatusError
+		}
+
+		if observedMongod.StatusError == nil && observedMongod.State == msp.MongodStateRunning && modelMongod.ReplicaSetID.Valid {
+			var replicaSet model.ReplicaSet
+			if err := tx.First(&replicaSet, modelMongod.ReplicaSetID.Int64).Error; err != nil {
+				monitorLog.Errorf("error fetching Replica Set of Mongod `%d on %s`: %s", modelMongod.ID, slave.Hostname, err)
+			} else {
+				busMessage.DriftedOptions = MongodOptionsDrift(ProjectModelReplicaSetToMSPMongodOptions(replicaSet), observedMongod.Options)
+				busMessage.OptionsDrift = len(busMessage.DriftedOptions) > 0
+			}
+		}
+
 		monitorLog.Debugf("monitor: sending bus message for slave `%s`", slave.Hostname, busMessage)
 		m.BusWriteChannel <- busMessage
 		monitorLog.Debugf("monitor: sent bus message for slave `%s`", slave.Hostname, busMessage)
@@ -469,7 +715,8 @@ func MSPReplicaSetMembersDeepEqualsIgnoringOrder(a, b []msp.ReplicaSetMember) (e
 }
 
 func ReplicaSetMembersEquivalent(a, b msp.ReplicaSetMember) bool {
-	return a.HostPort == b.HostPort && a.Priority == b.Priority && a.Votes == b.Votes
+	return a.HostPort == b.HostPort && a.Priority == b.Priority && a.Votes == b.Votes && a.ArbiterOnly == b.ArbiterOnly &&
+		a.Hidden == b.Hidden && a.SlaveDelay == b.SlaveDelay
 }
 
 func MspMongodStateToModelExecutionState(e msp.MongodState) model.MongodExecutionState {
@@ -507,14 +754,18 @@ func (m *Monitor) observeReplicaSets() {
 	// Get replica sets and the count of their actually configured members from the database
 	replicaSetsWithMemberCounts, err := tx.Raw(`SELECT
 				r.*,
-				(SELECT COUNT(*) FROM replica_set_configured_members WHERE replica_set_id = r.id AND persistent_storage = ?)
+				(SELECT COUNT(*) FROM replica_set_configured_members WHERE replica_set_id = r.id AND persistent_storage = ? AND NOT arbiter)
 					AS configured_persistent_members,
-				(SELECT COUNT(*) FROM replica_set_configured_members WHERE replica_set_id = r.id AND persistent_storage = ?)
+				(SELECT COUNT(*) FROM replica_set_configured_members WHERE replica_set_id = r.id AND persistent_storage = ? AND NOT arbiter)
 					AS configured_volatile_members,
-				(SELECT COUNT(*) FROM replica_set_effective_members_for_monitoring WHERE replica_set_id = r.id AND persistent_storage = ?)
+				(SELECT COUNT(*) FROM replica_set_configured_members WHERE replica_set_id = r.id AND arbiter)
+					AS configured_arbiter_members,
+				(SELECT COUNT(*) FROM replica_set_effective_members_for_monitoring WHERE replica_set_id = r.id AND persistent_storage = ? AND NOT arbiter)
 					AS actual_persistent_members,
-				(SELECT COUNT(*) FROM replica_set_effective_members_for_monitoring WHERE replica_set_id = r.id AND persistent_storage = ?)
-					AS actual_volatile_members
+				(SELECT COUNT(*) FROM replica_set_effective_members_for_monitoring WHERE replica_set_id = r.id AND persistent_storage = ? AND NOT arbiter)
+					AS actual_volatile_members,
+				(SELECT COUNT(*) FROM replica_set_effective_members_for_monitoring WHERE replica_set_id = r.id AND arbiter)
+					AS actual_arbiter_members
 				FROM replica_sets r
 				`, true, false, true, false).Rows()
 	if err != nil {
@@ -530,13 +781,16 @@ func (m *Monitor) observeReplicaSets() {
 		memberCounts := struct {
 			ConfiguredPersistentMembers uint
 			ConfiguredVolatileMembers   uint
+			ConfiguredArbiterMembers    uint
 			ActualPersistentMembers     uint
 			ActualVolatileMembers       uint
+			ActualArbiterMembers        uint
 		}{}
 		tx.ScanRows(replicaSetsWithMemberCounts, &memberCounts)
 
 		unsatisfied := memberCounts.ConfiguredVolatileMembers > memberCounts.ActualVolatileMembers ||
-			memberCounts.ConfiguredPersistentMembers > memberCounts.ActualPersistentMembers
+			memberCounts.ConfiguredPersistentMembers > memberCounts.ActualPersistentMembers ||
+			memberCounts.ConfiguredArbiterMembers > memberCounts.ActualArbiterMembers
 
 		m.BusWriteChannel <- model.ObservedReplicaSetConstraintStatus{
 			Unsatisfied:               unsatisfied,
@@ -544,7 +798,9 @@ func (m *Monitor) observeReplicaSets() {
 			ConfiguredPersistentCount: memberCounts.ConfiguredPersistentMembers,
 			ConfiguredVolatileCount:   memberCounts.ConfiguredVolatileMembers,
 			ActualPersistentCount:     memberCounts.ActualPersistentMembers,
+			ConfiguredArbiterCount:    memberCounts.ConfiguredArbiterMembers,
 			ActualVolatileCount:       memberCounts.ActualVolatileMembers,
+			ActualArbiterCount:        memberCounts.ActualArbiterMembers,
 		}
 
 		m.BusWriteChannel <- model.ReplicaSetInitiationStatus{
@@ -557,3 +813,47 @@ func (m *Monitor) observeReplicaSets() {
 	replicaSetsWithMemberCounts.Close()
 
 }
+
+// Describe the options of a running Mongod that differ from the desired ones
+// Changed options only take effect when the Mongod is restarted, drift is therefore not a Mismatch.
+func MongodOptionsDrift(desired, observed msp.MongodOptions) (drifted []string) {
+	describe := func(name string, desired, observed interface{}, zero interface{}) {
+		format := func(v interface{}) string {
+			if v == zero {
+				return "default"
+			}
+			return fmt.Sprintf("`%v`", v)
+		}
+		drifted = append(drifted, fmt.Sprintf("%s: desired %s, running with %s", name, format(desired), format(observed)))
+	}
+	if desired.StorageEngine != observed.StorageEngine {
+		describe("storage engine", desired.StorageEngine, observed.StorageEngine, "")
+	}
+	if desired.WiredTigerCacheSizeGB != observed.WiredTigerCacheSizeGB {
+		describe("WiredTiger cache size (GB)", desired.WiredTigerCacheSizeGB, observed.WiredTigerCacheSizeGB, float64(0))
+	}
+	if desired.OplogSizeMB != observed.OplogSizeMB {
+		describe("oplog size (MB)", desired.OplogSizeMB, observed.OplogSizeMB, uint(0))
+	}
+	if !bindIPsEquivalent(desired.BindIP, observed.BindIP) {
+		describe("bind IPs", desired.BindIP, observed.BindIP, "")
+	}
+	if desired.JournalDisabled != observed.JournalDisabled {
+		describe("journal disabled", desired.JournalDisabled, observed.JournalDisabled, false)
+	}
+	return drifted
+}
+
+// Whether two comma separated lists of IP addresses contain the same addresses
+func bindIPsEquivalent(a, b string) bool {
+	set := func(list string) map[string]bool {
+		s := make(map[string]bool)
+		for _, ip := range strings.Split(list, ",") {
+			if ip = strings.TrimSpace(ip); ip != "" {
+				s[ip] = true
+			}
+		}
+		return s
+	}
+	return reflect.DeepEqual(set(a), set(b))
+}
@@ -6,6 +6,7 @@ import (
 	"github.com/stretchr/testify/assert"
 	"sync"
 	"testing"
+	"time"
 )
 
 func createDB(t *testing.T) (db *model.DB, err error) {
@@ -62,12 +63,13 @@ func createDB(t *testing.T) (db *model.DB, err error) {
 
 type FakeMSPClient struct {
 	msp.MSPClient
-	Status []msp.Mongod
-	Error  *msp.Error
+	Status      []msp.Mongod
+	HostMetrics *msp.HostMetrics
+	Error       *msp.Error
 }
 
-func (m FakeMSPClient) RequestStatus(Target msp.HostPort) ([]msp.Mongod, *msp.Error) {
-	return m.Status, m.Error
+func (m FakeMSPClient) RequestStatus(Target msp.HostPort) (msp.SlaveStatus, *msp.Error) {
+	return msp.SlaveStatus{Mongods: m.Status, HostMetrics: m.HostMetrics}, m.Error
 }
 
 func TestMonitor_observeSlave(t *testing.T) {
@@ -236,6 +238,276 @@ func TestMonitor_observeSlave(t *testing.T) {
 	wg.Wait()
 }
 
+func TestMonitor_observeSlave_crashLoop(t *testing.T) {
+	db, err := createDB(t)
+	defer db.CloseAndDrop()
+	assert.NoError(t, err)
+
+	busChannel := make(chan interface{}, 10)
+	monitor := Monitor{
+		DB:              db,
+		BusWriteChannel: busChannel,
+	}
+
+	var slave model.Slave
+	{
+		tx := db.Begin()
+		assert.NoError(t, tx.First(&slave).Error)
+		tx.Rollback()
+	}
+
+	monitor.handleObservation([]msp.Mongod{
+		msp.Mongod{
+			Port: 2000,
+			ReplicaSetConfig: msp.ReplicaSetConfig{
+				ReplicaSetName: "repl1",
+			},
+			StatusError: &msp.Error{
+				Identifier:      msp.SlaveMongodCrashLoopError,
+				Description:     "Mongod on port `2000` is crash looping",
+				LongDescription: "The Mongod crashed 5 times in a row",
+			},
+			State: msp.MongodStateNotRunning,
+		},
+	}, nil, slave)
+
+	_, ok := (<-busChannel).(model.ConnectionStatus)
+	assert.True(t, ok)
+
+	matchStatus, ok := (<-busChannel).(model.MongodMatchStatus)
+	if assert.True(t, ok) {
+		assert.True(t, matchStatus.CrashLooping)
+		assert.Equal(t, msp.SlaveMongodCrashLoopError, matchStatus.CrashLoopError.Identifier)
+		assert.EqualValues(t, 2000, matchStatus.Mongod.Port)
+	}
+}
+
+func TestMonitor_handleHostMetrics(t *testing.T) {
+	db, err := createDB(t)
+	defer db.CloseAndDrop()
+	assert.NoError(t, err)
+
+	busChannel := make(chan interface{}, 10)
+	monitor := Monitor{
+		DB:                    db,
+		BusWriteChannel:       busChannel,
+		HostMetricsThresholds: DefaultHostMetricsThresholds,
+	}
+
+	var slave model.Slave
+	{
+		tx := db.Begin()
+		assert.NoError(t, tx.First(&slave).Error)
+		tx.Rollback()
+	}
+
+	metrics := msp.HostMetrics{
+		CPUCount:                 2,
+		LoadAverage1:             1,
+		LoadAverage5:             5,
+		LoadAverage15:            1,
+		MemoryTotalBytes:         1000,
+		MemoryAvailableBytes:     500,
+		DataVolumeTotalBytes:     1000,
+		DataVolumeAvailableBytes: 50,
+	}
+	monitor.handleHostMetrics(metrics, slave)
+
+	status, ok := (<-busChannel).(model.HostMetricsStatus)
+	if assert.True(t, ok) {
+		assert.True(t, status.DataVolumeFull, "95% used")
+		assert.False(t, status.MemoryPressure, "50% used")
+		assert.True(t, status.HighLoad, "load 2.5 per CPU")
+		assert.EqualValues(t, 50, status.Metrics.DataVolumeAvailableBytes)
+	}
+
+	// Later samples replace the stored one
+	metrics.DataVolumeAvailableBytes = 500
+	metrics.LoadAverage5 = 1
+	monitor.handleHostMetrics(metrics, slave)
+
+	status, ok = (<-busChannel).(model.HostMetricsStatus)
+	if assert.True(t, ok) {
+		assert.False(t, status.DataVolumeFull)
+		assert.False(t, status.HighLoad)
+	}
+
+	var samples []model.SlaveHostMetrics
+	{
+		tx := db.Begin()
+		assert.NoError(t, tx.Find(&samples).Error)
+		tx.Rollback()
+	}
+	if assert.Len(t, samples, 1) {
+		assert.Equal(t, slave.ID, samples[0].SlaveID)
+		assert.EqualValues(t, 500, samples[0].DataVolumeAvailableBytes)
+		assert.EqualValues(t, 2, samples[0].CPUCount)
+	}
+
+	// Checks with threshold 0 are disabled
+	assert.False(t, HostMetricsThresholds{}.dataVolumeFull(model.SlaveHostMetrics{DataVolumeTotalBytes: 1000}))
+}
+
+func TestMonitor_updateReplication(t *testing.T) {
+	db, err := createDB(t)
+	defer db.CloseAndDrop()
+	assert.NoError(t, err)
+
+	monitor := Monitor{
+		DB:                    db,
+		ReplicationThresholds: DefaultReplicationThresholds,
+	}
+
+	tx := db.Begin()
+	defer tx.Rollback()
+
+	var primary model.Mongod
+	assert.NoError(t, tx.First(&primary).Error)
+	slave2 := model.Slave{
+		Hostname:             "host2",
+		Port:                 1,
+		MongodPortRangeBegin: 2000,
+		MongodPortRangeEnd:   2001,
+		ConfiguredState:      model.SlaveStateActive,
+	}
+	assert.NoError(t, tx.Create(&slave2).Error)
+	secondary := model.Mongod{
+		Port:          2000,
+		ReplSetName:   "repl1",
+		ParentSlaveID: slave2.ID,
+		ReplicaSetID:  primary.ReplicaSetID,
+	}
+	assert.NoError(t, tx.Create(&secondary).Error)
+
+	now := time.Now()
+	observed := msp.Mongod{
+		Port: 2000,
+		ReplicaSetConfig: msp.ReplicaSetConfig{
+			ReplicaSetName: "repl1",
+			ReplicaSetMembers: []msp.ReplicaSetMember{
+				{HostPort: msp.HostPort{Hostname: "host1", Port: 2000}},
+				{HostPort: msp.HostPort{Hostname: "host2", Port: 2000}, Hidden: true, SlaveDelay: 3600},
+			},
+		},
+		Replication: &msp.ReplicationStatus{
+			Members: []msp.ReplicationMemberStatus{
+				{HostPort: msp.HostPort{Hostname: "host1", Port: 2000}, State: msp.ReplicationMemberStatePrimary, Optime: now, Self: true},
+				{HostPort: msp.HostPort{Hostname: "host2", Port: 2000}, State: "SECONDARY", Optime: now.Add(-2 * time.Hour)},
+				{HostPort: msp.HostPort{Hostname: "host3", Port: 2000}, State: "SECONDARY", Optime: now}, // not managed by MAMID
+			},
+			OplogFirst:        now.Add(-30 * time.Minute),
+			OplogLast:         now,
+			OplogSizeBytes:    96,
+			OplogMaxSizeBytes: 100,
+		},
+	}
+
+	statuses, err := monitor.updateReplication(tx, map[int64]msp.Mongod{primary.ID: observed})
+	assert.NoError(t, err)
+	if assert.Len(t, statuses, 1) {
+		assert.EqualValues(t, primary.ReplicaSetID.Int64, statuses[0].ReplicaSet.ID)
+		assert.True(t, statuses[0].OplogWindowTooShort, "oplog is full and covers 30 minutes")
+		if assert.Len(t, statuses[0].LaggingMembers, 1) {
+			assert.Equal(t, secondary.ID, statuses[0].LaggingMembers[0].Mongod.ID)
+			assert.Equal(t, time.Hour, statuses[0].LaggingMembers[0].Lag, "the slave delay is not counted")
+		}
+	}
+
+	var memberReplications []model.MongodReplication
+	assert.NoError(t, tx.Order("mongod_id").Find(&memberReplications).Error)
+	if assert.Len(t, memberReplications, 2) {
+		assert.Equal(t, msp.ReplicationMemberStatePrimary, memberReplications[0].MemberState)
+		assert.Equal(t, 0.0, memberReplications[0].LagSeconds)
+		assert.Equal(t, 7200.0, memberReplications[1].LagSeconds)
+	}
+
+	// Later observations replace the stored figures, a SECONDARY does not report them
+	observed.Replication.OplogSizeBytes = 10
+	observed.Replication.Members[1].Optime = now
+	statuses, err = monitor.updateReplication(tx, map[int64]msp.Mongod{primary.ID: observed})
+	assert.NoError(t, err)
+	if assert.Len(t, statuses, 1) {
+		assert.False(t, statuses[0].OplogWindowTooShort)
+		assert.Empty(t, statuses[0].LaggingMembers)
+	}
+	observed.Replication.Members[0].State = "SECONDARY"
+	statuses, err = monitor.updateReplication(tx, map[int64]msp.Mongod{primary.ID: observed})
+	assert.NoError(t, err)
+	assert.Empty(t, statuses)
+
+	var replications []model.ReplicaSetReplication
+	assert.NoError(t, tx.Find(&replications).Error)
+	if assert.Len(t, replications, 1) {
+		assert.EqualValues(t, 10, replications[0].OplogSizeBytes)
+		assert.Equal(t, model.NullIntValue(primary.ID), replications[0].PrimaryMongodID)
+		assert.Equal(t, 1800.0, replications[0].OplogWindowSeconds)
+	}
+	assert.NoError(t, tx.Find(&memberReplications).Error)
+	assert.Len(t, memberReplications, 2)
+}
+
+func TestMonitor_observeSlave_optionsDrift(t *testing.T) {
+	db, err := createDB(t)
+	defer db.CloseAndDrop()
+	assert.NoError(t, err)
+
+	busChannel := make(chan interface{}, 10)
+	monitor := Monitor{
+		DB:              db,
+		BusWriteChannel: busChannel,
+	}
+
+	var slave model.Slave
+	{
+		tx := db.Begin()
+		assert.NoError(t, tx.First(&slave).Error)
+		assert.NoError(t, tx.Model(&model.ReplicaSet{}).Updates(map[string]interface{}{"storage_engine": msp.StorageEngineWiredTiger, "oplog_size_mb": 1024}).Error)
+		tx.Commit()
+	}
+
+	monitor.handleObservation([]msp.Mongod{
+		msp.Mongod{
+			Port: 2000,
+			ReplicaSetConfig: msp.ReplicaSetConfig{
+				ReplicaSetName: "repl1",
+			},
+			State: msp.MongodStateRunning,
+			Options: msp.MongodOptions{
+				StorageEngine: msp.StorageEngineWiredTiger,
+			},
+		},
+	}, nil, slave)
+
+	_, ok := (<-busChannel).(model.ConnectionStatus)
+	assert.True(t, ok)
+
+	matchStatus, ok := (<-busChannel).(model.MongodMatchStatus)
+	if assert.True(t, ok) {
+		assert.True(t, matchStatus.OptionsDrift)
+		assert.Equal(t, []string{"oplog size (MB): desired `1024`, running with default"}, matchStatus.DriftedOptions)
+	}
+}
+
+func TestMongodOptionsDrift(t *testing.T) {
+	desired := msp.MongodOptions{
+		StorageEngine:         msp.StorageEngineWiredTiger,
+		WiredTigerCacheSizeGB: 2,
+		BindIP:                "127.0.0.1,10.0.0.1",
+	}
+	observed := desired
+	observed.BindIP = "10.0.0.1, 127.0.0.1"
+	assert.Empty(t, MongodOptionsDrift(desired, observed), "order of bind IPs should not matter")
+
+	observed.StorageEngine = msp.StorageEngineMMAPv1
+	observed.WiredTigerCacheSizeGB = 0
+	observed.JournalDisabled = true
+	assert.Equal(t, []string{
+		"storage engine: desired `wiredTiger`, running with `mmapv1`",
+		"WiredTiger cache size (GB): desired `2`, running with default",
+		"journal disabled: desired default, running with `true`",
+	}, MongodOptionsDrift(desired, observed))
+}
+
 func TestMonitor_compareStates(t *testing.T) {
 
 	db, err := createDB(t)
@@ -341,4 +613,6 @@ func TestMonitor_ReplicaSetMembersEquivalent(t *testing.T) {
 	assert.False(t, ReplicaSetMembersEquivalent(msp.ReplicaSetMember{HostPort: msp.HostPort{Hostname: "host1", Port: 100}, Priority: 1}, msp.ReplicaSetMember{HostPort: msp.HostPort{Hostname: "host1", Port: 200}, Priority: 1}))
 	assert.False(t, ReplicaSetMembersEquivalent(msp.ReplicaSetMember{HostPort: msp.HostPort{Hostname: "host1", Port: 100}, Priority: 1}, msp.ReplicaSetMember{HostPort: msp.HostPort{Hostname: "host2", Port: 100}, Priority: 1}))
 	assert.False(t, ReplicaSetMembersEquivalent(msp.ReplicaSetMember{HostPort: msp.HostPort{Hostname: "host1", Port: 100}, Priority: 1}, msp.ReplicaSetMember{HostPort: msp.HostPort{Hostname: "host2", Port: 200}, Priority: 1}))
+	assert.False(t, ReplicaSetMembersEquivalent(msp.ReplicaSetMember{HostPort: msp.HostPort{Hostname: "host1", Port: 100}, Hidden: true}, msp.ReplicaSetMember{HostPort: msp.HostPort{Hostname: "host1", Port: 100}}))
+	assert.False(t, ReplicaSetMembersEquivalent(msp.ReplicaSetMember{HostPort: msp.HostPort{Hostname: "host1", Port: 100}, Hidden: true, SlaveDelay: 3600}, msp.ReplicaSetMember{HostPort: msp.HostPort{Hostname: "host1", Port: 100}, Hidden: true}))
 }
@@ -0,0 +1,150 @@
+package master
+
+import (
+	"fmt"
+	. "github.com/KIT-MAMID/mamid/model"
+	"sort"
+)
+
+// A Slave considered by a PlacementStrategy, either
+// as host for a new Mongod or as host of a Mongod (MongodID) that could be removed.
+type PlacementCandidate struct {
+	SlaveID          int64
+	MongodID         int64 // only set for victim candidates
+	ConfiguredState  SlaveState
+	ObservationError bool
+	MaxMongods       int     // the declared capacity of the Slave (size of its Mongod port range)
+	FreeMongods      int     // for host candidates, the number of additional members of the Replica Set the Slave's free ports and resources suffice for
+	Utilization      float64 // the highest utilization of the Slave's port range and declared resources
+	// The number of other members of the Replica Set sharing a failure domain with the Slave, per level (top level first)
+	FailureDomainOverlap []int
+}
+
+// A PlacementStrategy decides on which Slaves the ClusterAllocator places Mongods.
+// All candidates passed to a PlacementStrategy already satisfy the Replica Set's constraints
+// (persistence, free ports, risk groups, ...).
+// The built-in strategies spread members over failure domains before applying their own criteria.
+type PlacementStrategy interface {
+	// Rank Slaves as hosts for a new Mongod, most preferred first
+	RankHosts(candidates []PlacementCandidate) []PlacementCandidate
+	// Rank Mongods of a Replica Set with excess members for removal, first to be removed first
+	RankVictims(candidates []PlacementCandidate) []PlacementCandidate
+}
+
+const DefaultPlacementStrategyName = "spread"
+
+var placementStrategies = map[string]PlacementStrategy{
+	"spread":   SpreadPlacementStrategy{},
+	"pack":     PackPlacementStrategy{},
+	"weighted": WeightedPlacementStrategy{},
+}
+
+func PlacementStrategyByName(name string) (PlacementStrategy, error) {
+	if strategy, exists := placementStrategies[name]; exists {
+		return strategy, nil
+	}
+	return nil, fmt.Errorf("unknown placement strategy `%s`", name)
+}
+
+func PlacementStrategyNames() (names []string) {
+	for name := range placementStrategies {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Spread Mongods evenly over Slaves by utilization of their Mongod port range and declared resources.
+type SpreadPlacementStrategy struct{}
+
+func (SpreadPlacementStrategy) RankHosts(candidates []PlacementCandidate) []PlacementCandidate {
+	return rankHosts(candidates, func(a, b *PlacementCandidate) bool {
+		return a.Utilization < b.Utilization
+	})
+}
+
+func (SpreadPlacementStrategy) RankVictims(candidates []PlacementCandidate) []PlacementCandidate {
+	return rankVictims(candidates, func(a, b *PlacementCandidate) bool {
+		return a.Utilization > b.Utilization
+	})
+}
+
+// Fill up the most utilized Slaves first, keeping other Slaves free.
+type PackPlacementStrategy struct{}
+
+func (PackPlacementStrategy) RankHosts(candidates []PlacementCandidate) []PlacementCandidate {
+	return rankHosts(candidates, func(a, b *PlacementCandidate) bool {
+		return a.Utilization > b.Utilization
+	})
+}
+
+func (PackPlacementStrategy) RankVictims(candidates []PlacementCandidate) []PlacementCandidate {
+	return rankVictims(candidates, func(a, b *PlacementCandidate) bool {
+		return a.Utilization < b.Utilization
+	})
+}
+
+// Prefer Slaves with the most remaining capacity for the Replica Set, i.e. Slaves with a larger capacity receive more Mongods.
+type WeightedPlacementStrategy struct{}
+
+func (WeightedPlacementStrategy) RankHosts(candidates []PlacementCandidate) []PlacementCandidate {
+	return rankHosts(candidates, func(a, b *PlacementCandidate) bool {
+		return a.FreeMongods > b.FreeMongods
+	})
+}
+
+func (WeightedPlacementStrategy) RankVictims(candidates []PlacementCandidate) []PlacementCandidate {
+	return rankVictims(candidates, func(a, b *PlacementCandidate) bool {
+		return a.FreeMongods < b.FreeMongods
+	})
+}
+
+// Sort a copy of candidates by `less` after preferring Slaves without observation error
+// and Slaves sharing fewer failure domains with the Replica Set
+func rankHosts(candidates []PlacementCandidate, less func(a, b *PlacementCandidate) bool) []PlacementCandidate {
+	return rankCandidates(candidates, func(a, b *PlacementCandidate) bool {
+		if a.ObservationError != b.ObservationError {
+			return !a.ObservationError
+		}
+		if overlap := compareFailureDomainOverlap(a.FailureDomainOverlap, b.FailureDomainOverlap); overlap != 0 {
+			return overlap < 0
+		}
+		return less(a, b)
+	})
+}
+
+// Sort a copy of candidates by `less` after preferring Mongods on disabled Slaves, Slaves with observation error
+// and Slaves sharing more failure domains with the Replica Set
+func rankVictims(candidates []PlacementCandidate, less func(a, b *PlacementCandidate) bool) []PlacementCandidate {
+	return rankCandidates(candidates, func(a, b *PlacementCandidate) bool {
+		aDisabled, bDisabled := a.ConfiguredState == SlaveStateDisabled, b.ConfiguredState == SlaveStateDisabled
+		if aDisabled != bDisabled {
+			return aDisabled
+		}
+		if a.ObservationError != b.ObservationError {
+			return a.ObservationError
+		}
+		if overlap := compareFailureDomainOverlap(a.FailureDomainOverlap, b.FailureDomainOverlap); overlap != 0 {
+			return overlap > 0
+		}
+		return less(a, b)
+	})
+}
+
+type candidateSorter struct {
+	candidates []PlacementCandidate
+	less       func(a, b *PlacementCandidate) bool
+}
+
+func (s candidateSorter) Len() int { return len(s.candidates) }
+func (s candidateSorter) Swap(i, j int) {
+	s.candidates[i], s.candidates[j] = s.candidates[j], s.candidates[i]
+}
+func (s candidateSorter) Less(i, j int) bool { return s.less(&s.candidates[i], &s.candidates[j]) }
+
+func rankCandidates(candidates []PlacementCandidate, less func(a, b *PlacementCandidate) bool) []PlacementCandidate {
+	ranked := make([]PlacementCandidate, len(candidates))
+	copy(ranked, candidates)
+	sort.Stable(candidateSorter{ranked, less})
+	return ranked
+}
@@ -0,0 +1,64 @@
+package master
+
+import (
+	. "github.com/KIT-MAMID/mamid/model"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func fixturePlacementCandidates() []PlacementCandidate {
+	return []PlacementCandidate{
+		{SlaveID: 1, ConfiguredState: SlaveStateActive, MaxMongods: 10, FreeMongods: 5, Utilization: 0.5},
+		{SlaveID: 2, ConfiguredState: SlaveStateActive, MaxMongods: 100, FreeMongods: 70, Utilization: 0.3},
+		{SlaveID: 3, ConfiguredState: SlaveStateActive, MaxMongods: 10, FreeMongods: 1, Utilization: 0.9},
+	}
+}
+
+func rankedSlaveIDs(candidates []PlacementCandidate) (ids []int64) {
+	for _, c := range candidates {
+		ids = append(ids, c.SlaveID)
+	}
+	return ids
+}
+
+func TestPlacementStrategy_RankHosts(t *testing.T) {
+	candidates := fixturePlacementCandidates()
+
+	assert.Equal(t, []int64{2, 1, 3}, rankedSlaveIDs(SpreadPlacementStrategy{}.RankHosts(candidates)))
+	assert.Equal(t, []int64{3, 1, 2}, rankedSlaveIDs(PackPlacementStrategy{}.RankHosts(candidates)))
+	assert.Equal(t, []int64{2, 1, 3}, rankedSlaveIDs(WeightedPlacementStrategy{}.RankHosts(candidates)))
+
+	assert.Equal(t, []int64{1, 2, 3}, rankedSlaveIDs(candidates), "ranking must not modify the candidates")
+
+	candidates[1].ObservationError = true
+	assert.Equal(t, []int64{1, 3, 2}, rankedSlaveIDs(SpreadPlacementStrategy{}.RankHosts(candidates)),
+		"slaves with observation error should be ranked last")
+}
+
+func TestPlacementStrategy_RankVictims(t *testing.T) {
+	candidates := fixturePlacementCandidates()
+
+	assert.Equal(t, []int64{3, 1, 2}, rankedSlaveIDs(SpreadPlacementStrategy{}.RankVictims(candidates)))
+	assert.Equal(t, []int64{2, 1, 3}, rankedSlaveIDs(PackPlacementStrategy{}.RankVictims(candidates)))
+	assert.Equal(t, []int64{3, 1, 2}, rankedSlaveIDs(WeightedPlacementStrategy{}.RankVictims(candidates)))
+
+	candidates[0].ObservationError = true
+	candidates[1].ConfiguredState = SlaveStateDisabled
+	assert.Equal(t, []int64{2, 1, 3}, rankedSlaveIDs(SpreadPlacementStrategy{}.RankVictims(candidates)),
+		"mongods on disabled slaves and slaves with observation error should be removed first")
+}
+
+func TestPlacementStrategyByName(t *testing.T) {
+	strategy, err := PlacementStrategyByName(DefaultPlacementStrategyName)
+	assert.NoError(t, err)
+	assert.Equal(t, SpreadPlacementStrategy{}, strategy)
+
+	_, err = PlacementStrategyByName("foo")
+	assert.Error(t, err)
+
+	c := ClusterAllocator{PlacementStrategy: PackPlacementStrategy{}}
+	assert.Equal(t, PackPlacementStrategy{}, c.placementStrategy(""), "should use the allocator's default")
+	assert.Equal(t, WeightedPlacementStrategy{}, c.placementStrategy("weighted"), "should use the replica set's strategy")
+	var defaultAllocator ClusterAllocator
+	assert.Equal(t, SpreadPlacementStrategy{}, defaultAllocator.placementStrategy(""))
+}
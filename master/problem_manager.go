@@ -4,12 +4,16 @@ import (
 	"fmt"
 	"github.com/KIT-MAMID/mamid/model"
 	"github.com/Sirupsen/logrus"
+	"github.com/jinzhu/gorm"
+	"strings"
 	"time"
 )
 
 type ProblemManager struct {
-	DB             *model.DB
-	BusReadChannel <-chan interface{}
+	DB              *model.DB
+	BusReadChannel  <-chan interface{}
+	BusWriteChannel chan<- interface{} // optional, receives a ProblemStatus for every created or resolved Problem
+	statuses        []model.ProblemStatus
 }
 
 var pmLog = logrus.WithField("module", "problem_manager")
@@ -22,74 +26,249 @@ func (p *ProblemManager) Run() {
 		case model.ConnectionStatus:
 			connStatus := message.(model.ConnectionStatus)
 			if connStatus.Unreachable && connStatus.Slave.ConfiguredState == model.SlaveStateActive {
-				var problem model.Problem
-				tx.Where(&model.Problem{
+				p.raiseProblem(tx, model.Problem{
 					ProblemType: model.ProblemTypeConnection,
 					SlaveID:     model.NullIntValue(connStatus.Slave.ID),
-				}).Assign(&model.Problem{
+				}, model.Problem{
 					Description:     fmt.Sprintf("Slave `%s` is unreachable - %s", connStatus.Slave.Hostname, connStatus.CommunicationError.Description),
 					LongDescription: connStatus.CommunicationError.LongDescription,
-					LastUpdated:     time.Now(),
-				}).Attrs(&model.Problem{
-					FirstOccurred: time.Now(),
-				}).FirstOrCreate(&problem)
+				})
 			} else {
-				tx.Where(&model.Problem{
+				p.resolveProblems(tx, model.Problem{
 					ProblemType: model.ProblemTypeConnection,
 					SlaveID:     model.NullIntValue(connStatus.Slave.ID),
-				}).Delete(&model.Problem{})
+				})
 			}
+		case model.HostMetricsStatus:
+			metricsStatus := message.(model.HostMetricsStatus)
+			slave, metrics := metricsStatus.Slave, metricsStatus.Metrics
+			reportable := slave.ConfiguredState != model.SlaveStateMaintenance
+			p.updateSlaveProblem(tx, model.ProblemTypeSlaveDataVolumeFull, slave.ID, reportable && metricsStatus.DataVolumeFull,
+				fmt.Sprintf("Data volume of Slave `%s` is %.0f%% full", slave.Hostname, 100*usedShare(metrics.DataVolumeAvailableBytes, metrics.DataVolumeTotalBytes)),
+				fmt.Sprintf("%s of %s available to Mongods.", formatBytes(metrics.DataVolumeAvailableBytes), formatBytes(metrics.DataVolumeTotalBytes)))
+			p.updateSlaveProblem(tx, model.ProblemTypeSlaveMemoryPressure, slave.ID, reportable && metricsStatus.MemoryPressure,
+				fmt.Sprintf("Slave `%s` is running out of memory", slave.Hostname),
+				fmt.Sprintf("%s of %s memory available, %s of %s swap free.",
+					formatBytes(metrics.MemoryAvailableBytes), formatBytes(metrics.MemoryTotalBytes),
+					formatBytes(metrics.SwapFreeBytes), formatBytes(metrics.SwapTotalBytes)))
+			p.updateSlaveProblem(tx, model.ProblemTypeSlaveHighLoad, slave.ID, reportable && metricsStatus.HighLoad,
+				fmt.Sprintf("Slave `%s` is under high load", slave.Hostname),
+				fmt.Sprintf("Load average %.2f, %.2f, %.2f (1, 5, 15 minutes) on %d CPUs.",
+					metrics.LoadAverage1, metrics.LoadAverage5, metrics.LoadAverage15, metrics.CPUCount))
 		case model.DesiredReplicaSetConstraintStatus:
 			constrStatus := message.(model.DesiredReplicaSetConstraintStatus)
 			if constrStatus.Unsatisfied {
-				var problem model.Problem
-				tx.Where(&model.Problem{
+				p.raiseProblem(tx, model.Problem{
 					ProblemType:  model.ProblemTypeDesiredReplicaSetConstraint,
 					ReplicaSetID: model.NullIntValue(constrStatus.ReplicaSet.ID),
-				}).Assign(&model.Problem{
+				}, model.Problem{
 					Description: fmt.Sprintf("Replica Set `%s` with unsatisfiable constraints", constrStatus.ReplicaSet.Name),
 					LongDescription: fmt.Sprintf(
-						"Not enough free ports on suitable Slaves are available.\n"+
-							"This Replica Set's member counts are less than desired (%d/%d persistent, %d/%d volatile).",
+						"This Replica Set's member counts are less than desired (%d/%d persistent, %d/%d volatile, %d/%d arbiter).%s",
 						constrStatus.ConfiguredPersistentCount, constrStatus.ReplicaSet.PersistentMemberCount,
-						constrStatus.ConfiguredVolatileCount, constrStatus.ReplicaSet.VolatileMemberCount),
-					LastUpdated: time.Now(),
-				}).Attrs(&model.Problem{
-					FirstOccurred: time.Now(),
-				}).FirstOrCreate(&problem)
+						constrStatus.ConfiguredVolatileCount, constrStatus.ReplicaSet.VolatileMemberCount,
+						constrStatus.ConfiguredArbiterCount, constrStatus.ReplicaSet.ArbiterMemberCount,
+						describeSlaveRejections(constrStatus.SlaveRejections)),
+				})
 			} else {
-				tx.Where(&model.Problem{
+				p.resolveProblems(tx, model.Problem{
 					ProblemType:  model.ProblemTypeDesiredReplicaSetConstraint,
 					ReplicaSetID: model.NullIntValue(constrStatus.ReplicaSet.ID),
-				}).Delete(&model.Problem{})
+				})
 			}
 		case model.ObservedReplicaSetConstraintStatus:
 			constrStatus := message.(model.ObservedReplicaSetConstraintStatus)
 			if constrStatus.Unsatisfied {
-				var problem model.Problem
-				tx.Where(&model.Problem{
+				p.raiseProblem(tx, model.Problem{
 					ProblemType:  model.ProblemTypeObservedReplicaSetConstraint,
 					ReplicaSetID: model.NullIntValue(constrStatus.ReplicaSet.ID),
-				}).Assign(&model.Problem{
+				}, model.Problem{
 					Description: fmt.Sprintf("Replica Set `%s` is degraded", constrStatus.ReplicaSet.Name),
 					LongDescription: fmt.Sprintf(
-						"One or more Mongods in this Replica Set are not running (%d/%d persistent, %d/%d volatile).",
+						"One or more Mongods in this Replica Set are not running (%d/%d persistent, %d/%d volatile, %d/%d arbiter).",
 						constrStatus.ActualPersistentCount, constrStatus.ConfiguredPersistentCount,
-						constrStatus.ActualVolatileCount, constrStatus.ConfiguredVolatileCount),
-					LastUpdated: time.Now(),
-				}).Attrs(&model.Problem{
-					FirstOccurred: time.Now(),
-				}).FirstOrCreate(&problem)
+						constrStatus.ActualVolatileCount, constrStatus.ConfiguredVolatileCount,
+						constrStatus.ActualArbiterCount, constrStatus.ConfiguredArbiterCount),
+				})
 			} else {
-				tx.Where(&model.Problem{
+				p.resolveProblems(tx, model.Problem{
 					ProblemType:  model.ProblemTypeObservedReplicaSetConstraint,
 					ReplicaSetID: model.NullIntValue(constrStatus.ReplicaSet.ID),
-				}).Delete(&model.Problem{})
+				})
 			}
+		case model.ReplicaSetReplicationStatus:
+			replStatus := message.(model.ReplicaSetReplicationStatus)
+			if len(replStatus.LaggingMembers) > 0 {
+				var members []string
+				for _, member := range replStatus.LaggingMembers {
+					members = append(members, fmt.Sprintf("Mongod on port `%d` of Slave `%s` lags %s behind the PRIMARY", member.Mongod.Port, member.Hostname, member.Lag))
+				}
+				p.raiseProblem(tx, model.Problem{
+					ProblemType:  model.ProblemTypeReplicationLag,
+					ReplicaSetID: model.NullIntValue(replStatus.ReplicaSet.ID),
+				}, model.Problem{
+					Description:     fmt.Sprintf("Members of Replica Set `%s` lag behind the PRIMARY", replStatus.ReplicaSet.Name),
+					LongDescription: fmt.Sprintf("Configured slave delays are not counted.\n%s", strings.Join(members, "\n")),
+				})
+			} else {
+				p.resolveProblems(tx, model.Problem{
+					ProblemType:  model.ProblemTypeReplicationLag,
+					ReplicaSetID: model.NullIntValue(replStatus.ReplicaSet.ID),
+				})
+			}
+			if replStatus.OplogWindowTooShort {
+				p.raiseProblem(tx, model.Problem{
+					ProblemType:  model.ProblemTypeOplogWindow,
+					ReplicaSetID: model.NullIntValue(replStatus.ReplicaSet.ID),
+				}, model.Problem{
+					Description: fmt.Sprintf("Oplog of Replica Set `%s` covers too short a time span", replStatus.ReplicaSet.Name),
+					LongDescription: fmt.Sprintf("The oplog of the PRIMARY covers %s (%s of %s used).\n"+
+						"Members falling further behind cannot catch up without a full resync.",
+						time.Duration(replStatus.Replication.OplogWindowSeconds)*time.Second,
+						formatBytes(replStatus.Replication.OplogSizeBytes), formatBytes(replStatus.Replication.OplogMaxSizeBytes)),
+				})
+			} else {
+				p.resolveProblems(tx, model.Problem{
+					ProblemType:  model.ProblemTypeOplogWindow,
+					ReplicaSetID: model.NullIntValue(replStatus.ReplicaSet.ID),
+				})
+			}
+		case model.MongodMatchStatus:
+			matchStatus := message.(model.MongodMatchStatus)
+			if matchStatus.CrashLooping {
+				p.raiseProblem(tx, model.Problem{
+					ProblemType: model.ProblemTypeMongodCrashLoop,
+					MongodID:    model.NullIntValue(matchStatus.Mongod.ID),
+				}, model.Problem{
+					Description:     fmt.Sprintf("Mongod on port `%d` of Replica Set `%s` is crash looping", matchStatus.Mongod.Port, matchStatus.Mongod.ReplSetName),
+					LongDescription: matchStatus.CrashLoopError.LongDescription,
+					SlaveID:         model.NullIntValue(matchStatus.Mongod.ParentSlaveID),
+					ReplicaSetID:    matchStatus.Mongod.ReplicaSetID,
+				})
+			} else {
+				p.resolveProblems(tx, model.Problem{
+					ProblemType: model.ProblemTypeMongodCrashLoop,
+					MongodID:    model.NullIntValue(matchStatus.Mongod.ID),
+				})
+			}
+			if matchStatus.OptionsDrift {
+				p.raiseProblem(tx, model.Problem{
+					ProblemType: model.ProblemTypeMongodOptionsDrift,
+					MongodID:    model.NullIntValue(matchStatus.Mongod.ID),
+				}, model.Problem{
+					Description:     fmt.Sprintf("Mongod on port `%d` of Replica Set `%s` is not running with the Replica Set's options", matchStatus.Mongod.Port, matchStatus.Mongod.ReplSetName),
+					LongDescription: fmt.Sprintf("The options take effect when the Mongod is restarted.\n%s", strings.Join(matchStatus.DriftedOptions, "\n")),
+					SlaveID:         model.NullIntValue(matchStatus.Mongod.ParentSlaveID),
+					ReplicaSetID:    matchStatus.Mongod.ReplicaSetID,
+				})
+			} else {
+				p.resolveProblems(tx, model.Problem{
+					ProblemType: model.ProblemTypeMongodOptionsDrift,
+					MongodID:    model.NullIntValue(matchStatus.Mongod.ID),
+				})
+			}
+		}
+		if err := tx.Commit().Error; err != nil {
+			pmLog.WithError(err).Error("could not commit problem changes")
+			p.statuses = nil
+			continue
 		}
-		tx.Commit()
+		if p.BusWriteChannel != nil {
+			for _, status := range p.statuses {
+				p.BusWriteChannel <- status
+			}
+		}
+		p.statuses = nil
+	}
+
+}
+
+// Create the Problem matching where or update it with assign
+func (p *ProblemManager) raiseProblem(tx *gorm.DB, where model.Problem, assign model.Problem) {
+	now := time.Now()
+	assign.LastUpdated = now
+	var problem model.Problem
+	if err := tx.Where(&where).Assign(&assign).Attrs(&model.Problem{FirstOccurred: now}).FirstOrCreate(&problem).Error; err != nil {
+		pmLog.WithError(err).Errorf("could not create or update problem `%s`", where.ProblemType)
+		return
+	}
+	if problem.FirstOccurred.Equal(now) {
+		p.statuses = append(p.statuses, model.ProblemStatus{Problem: problem})
+	}
+}
+
+// Delete the Problems matching where
+func (p *ProblemManager) resolveProblems(tx *gorm.DB, where model.Problem) {
+	var problems []model.Problem
+	if err := tx.Where(&where).Find(&problems).Error; err != nil {
+		pmLog.WithError(err).Errorf("could not fetch problems `%s` to resolve", where.ProblemType)
+		return
+	}
+	if len(problems) == 0 {
+		return
+	}
+	if err := tx.Where(&where).Delete(&model.Problem{}).Error; err != nil {
+		pmLog.WithError(err).Errorf("could not resolve problems `%s`", where.ProblemType)
+		return
+	}
+	for _, problem := range problems {
+		p.statuses = append(p.statuses, model.ProblemStatus{Problem: problem, Resolved: true})
 	}
+}
+
+// Create or update the Problem of the Slave if present, delete it otherwise
+func (p *ProblemManager) updateSlaveProblem(tx *gorm.DB, problemType model.ProblemType, slaveID int64, present bool, description, longDescription string) {
+	if present {
+		p.raiseProblem(tx, model.Problem{
+			ProblemType: problemType,
+			SlaveID:     model.NullIntValue(slaveID),
+		}, model.Problem{
+			Description:     description,
+			LongDescription: longDescription,
+		})
+	} else {
+		p.resolveProblems(tx, model.Problem{
+			ProblemType: problemType,
+			SlaveID:     model.NullIntValue(slaveID),
+		})
+	}
+}
 
+// Human readable size, e.g. `1.5 GiB`
+func formatBytes(bytes uint64) string {
+	units := []string{"B", "KiB", "MiB", "GiB", "TiB"}
+	value := float64(bytes)
+	unit := 0
+	for value >= 1024 && unit < len(units)-1 {
+		value /= 1024
+		unit++
+	}
+	if unit == 0 {
+		return fmt.Sprintf("%d B", bytes)
+	}
+	return fmt.Sprintf("%.1f %s", value, units[unit])
+}
+
+// One line per Slave rejected as host for a missing member of a Replica Set
+func describeSlaveRejections(rejections []model.SlavePlacementEvaluation) string {
+	if len(rejections) == 0 {
+		return "\nNo Slaves are available as hosts for missing members."
+	}
+	description := "\nSlaves rejected as hosts for missing members:"
+	for _, r := range rejections {
+		memberType := "volatile"
+		if r.ArbiterMember {
+			memberType = "arbiter"
+		} else if r.PersistentMember {
+			memberType = "persistent"
+		}
+		reasons := make([]string, len(r.RejectionReasons))
+		for i, reason := range r.RejectionReasons {
+			reasons[i] = reason.String()
+		}
+		description += fmt.Sprintf("\n- `%s` (%s member): %s", r.SlaveHostname, memberType, strings.Join(reasons, ", "))
+	}
+	return description
 }
 
 func (p *ProblemManager) generateProblem(e model.StatusMessage) model.Problem {
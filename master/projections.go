@@ -59,3 +59,35 @@ func ProjectModelMongodbCredentialToMSPMongodCredential(m model.MongodbCredentia
 		Password: m.Password,
 	}
 }
+
+func ProjectModelReplicaSetToMSPMongodOptions(r model.ReplicaSet) msp.MongodOptions {
+	return msp.MongodOptions{
+		StorageEngine:         r.StorageEngine,
+		WiredTigerCacheSizeGB: r.WiredTigerCacheSizeGB,
+		OplogSizeMB:           r.OplogSizeMB,
+		BindIP:                r.BindIP,
+		JournalDisabled:       r.JournalDisabled,
+	}
+}
+
+func ProjectModelReplicaSetToMSPMongodResources(r model.ReplicaSet) msp.MongodResources {
+	return msp.MongodResources{
+		MemoryLimitBytes:   uint64(r.MemoryRequestMB) * 1024 * 1024,
+		CPULimitMillicores: r.CPURequestMillicores,
+	}
+}
+
+func ProjectMSPHostMetricsToModelSlaveHostMetrics(m msp.HostMetrics) model.SlaveHostMetrics {
+	return model.SlaveHostMetrics{
+		CPUCount:                 m.CPUCount,
+		LoadAverage1:             m.LoadAverage1,
+		LoadAverage5:             m.LoadAverage5,
+		LoadAverage15:            m.LoadAverage15,
+		MemoryTotalBytes:         m.MemoryTotalBytes,
+		MemoryAvailableBytes:     m.MemoryAvailableBytes,
+		SwapTotalBytes:           m.SwapTotalBytes,
+		SwapFreeBytes:            m.SwapFreeBytes,
+		DataVolumeTotalBytes:     m.DataVolumeTotalBytes,
+		DataVolumeAvailableBytes: m.DataVolumeAvailableBytes,
+	}
+}
@@ -0,0 +1,318 @@
+package master
+
+import (
+	"fmt"
+	. "github.com/KIT-MAMID/mamid/model"
+	"github.com/Sirupsen/logrus"
+	"github.com/jinzhu/gorm"
+	"sort"
+	"time"
+)
+
+var rebalancerLog = logrus.WithField("module", "rebalancer")
+
+/*
+	Rebalancing
+
+	The ClusterAllocator only places members of under-provisioned Replica Sets,
+	i.e. load stays on the existing Slaves when new Slaves are added.
+
+	The Rebalancer moves members from the most utilized active Slaves (see view `slave_utilization`)
+	to less utilized Slaves satisfying the same constraints as in CompileMongodLayout.
+	A member is only moved if the utilization of source and target differs by more than Threshold
+	and the target's utilization does not exceed the source's after the move, i.e. moves never oscillate.
+
+	Like a SlaveDrain, a MongodMove adds the replacement member first and removes the source member
+	once the replacement is running. Moves are advanced by the ClusterAllocator.
+	At most one member per Replica Set is moved at a time.
+*/
+
+const (
+	DefaultRebalanceThreshold          = 0.2
+	DefaultRebalanceMaxConcurrentMoves = 1
+)
+
+type Rebalancer struct {
+	ClusterAllocator *ClusterAllocator
+	// Minimum difference in utilization between source and target Slave of a move, in [0, 1]
+	Threshold float64
+	// Maximum number of active MongodMoves
+	MaxConcurrentMoves int
+}
+
+// A move of a Replica Set member proposed by the Rebalancer
+type ProposedMove struct {
+	ReplicaSetID      int64
+	ReplicaSetName    string
+	SourceMongodID    int64
+	Arbiter           bool // whether the moved member is an arbiter
+	SourceSlaveID     int64
+	SourceHostname    string
+	SourceUtilization float64 // before the move
+	TargetSlaveID     int64
+	TargetHostname    string
+	TargetUtilization float64 // before the move
+}
+
+// Periodically rebalance the cluster. Does nothing if interval is 0.
+func (r *Rebalancer) Run(db *DB, interval time.Duration) {
+	if interval == 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	for range ticker.C {
+		rebalancerLog.Info("Periodic rebalancer run")
+		tx := db.Begin()
+		var decisions AllocatorDecisions
+		if _, err := r.Rebalance(tx, &decisions); err != nil {
+			rebalancerLog.WithError(err).Error("Periodic rebalancer run failed")
+			tx.Rollback()
+			continue
+		}
+		if err := r.ClusterAllocator.CompileMongodLayout(tx, &decisions); err != nil {
+			rebalancerLog.WithError(err).Error("Cluster allocator run after rebalancing failed")
+			tx.Rollback()
+			continue
+		}
+		if err := r.ClusterAllocator.Commit(tx, decisions); err != nil {
+			rebalancerLog.WithError(err).Error("Periodic rebalancer commit failed")
+		}
+	}
+}
+
+// Start MongodMoves until the cluster is balanced or MaxConcurrentMoves moves are active.
+// The replacement members are spawned in tx and appended to decisions,
+// the caller should run the ClusterAllocator before committing.
+// Use Rebalance without decisions on a transaction that is rolled back to only propose moves.
+func (r *Rebalancer) Rebalance(tx *gorm.DB, decisions *AllocatorDecisions) (moves []ProposedMove, err error) {
+
+	defer func() {
+		// suitableHosts & spawnMongodOnSlave panic on database errors
+		if recovered := recover(); recovered != nil {
+			if recoveredErr, ok := recovered.(error); ok {
+				err = recoveredErr
+				return
+			}
+			panic(recovered)
+		}
+	}()
+
+	moves = make([]ProposedMove, 0)
+
+	var activeMoves int
+	if err = tx.Model(&MongodMove{}).
+		Where("state IN (?)", []MongodMoveState{MongodMoveStateAddingReplacement, MongodMoveStateRemovingMember}).
+		Count(&activeMoves).Error; err != nil {
+		return nil, err
+	}
+
+	for activeMoves < r.MaxConcurrentMoves {
+		move, found, err := r.proposeMove(tx)
+		if err != nil {
+			return nil, err
+		}
+		if !found {
+			rebalancerLog.Debug("no further moves improve the balance")
+			break
+		}
+		if err = r.startMove(tx, decisions, move); err != nil {
+			return nil, err
+		}
+		moves = append(moves, move)
+		activeMoves++
+	}
+
+	return moves, nil
+}
+
+type rebalancedSlave struct {
+	Slave
+	MaxMongods     int
+	CurrentMongods int // not counting members being moved away
+}
+
+func (s rebalancedSlave) utilization(change int) float64 {
+	if s.MaxMongods == 0 {
+		return 1
+	}
+	return float64(s.CurrentMongods+change) / float64(s.MaxMongods)
+}
+
+type byUtilizationDescending []rebalancedSlave
+
+func (s byUtilizationDescending) Len() int      { return len(s) }
+func (s byUtilizationDescending) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s byUtilizationDescending) Less(i, j int) bool {
+	return s[i].utilization(0) > s[j].utilization(0)
+}
+
+// Propose the move of a member from the most utilized Slave with a movable member
+func (r *Rebalancer) proposeMove(tx *gorm.DB) (move ProposedMove, found bool, err error) {
+
+	var slaves []rebalancedSlave
+	err = tx.Raw(`SELECT su.id, su.hostname, su.persistent_storage, su.max_mongods,
+			su.current_mongods - (
+				SELECT COUNT(*)
+				FROM mongod_moves mv
+				JOIN mongods m ON mv.source_mongod_id = m.id
+				WHERE m.parent_slave_id = su.id AND mv.state IN (?, ?)
+			) AS current_mongods
+		FROM slave_utilization su
+		WHERE su.configured_state = ? AND su.observation_error_id IS NULL
+		ORDER BY su.id`, MongodMoveStateAddingReplacement, MongodMoveStateRemovingMember, SlaveStateActive,
+	).Scan(&slaves).Error
+	if err != nil {
+		return move, false, err
+	}
+	slavesByID := make(map[int64]rebalancedSlave, len(slaves))
+	for _, s := range slaves {
+		slavesByID[s.ID] = s
+	}
+	sort.Stable(byUtilizationDescending(slaves))
+
+	for _, source := range slaves {
+
+		var members []struct {
+			MongodID     int64
+			ReplicaSetID int64
+			Arbiter      bool
+		}
+		err = tx.Raw(`SELECT m.id AS mongod_id, m.replica_set_id, m.arbiter
+			FROM mongods m
+			JOIN mongod_states desired ON m.desired_state_id = desired.id
+			WHERE
+				m.parent_slave_id = ?
+				AND m.replica_set_id IS NOT NULL
+				AND desired.execution_state = ?
+				AND m.replica_set_id NOT IN ( -- one move per Replica Set
+					SELECT replica_set_id FROM mongod_moves WHERE state IN (?, ?) AND replica_set_id IS NOT NULL
+					UNION
+					SELECT replica_set_id FROM slave_drains WHERE state NOT IN (?, ?) AND replica_set_id IS NOT NULL
+				)
+			ORDER BY m.replica_set_id`, source.ID, MongodExecutionStateRunning,
+			MongodMoveStateAddingReplacement, MongodMoveStateRemovingMember,
+			SlaveDrainStateFinished, SlaveDrainStateCancelled,
+		).Scan(&members).Error
+		if err != nil {
+			return move, false, err
+		}
+
+		for _, member := range members {
+
+			var replicaSet ReplicaSet
+			if err = tx.First(&replicaSet, member.ReplicaSetID).Error; err != nil {
+				return move, false, err
+			}
+
+			hosts, _ := suitableHosts(tx, &replicaSet, memberPersistence(member.Arbiter, source.PersistentStorage), member.MongodID)
+			targets := make([]PlacementCandidate, 0, len(hosts))
+			for _, host := range hosts {
+				target, isActive := slavesByID[host.SlaveID]
+				if isActive &&
+					source.utilization(0)-target.utilization(0) > r.Threshold &&
+					source.utilization(-1) >= target.utilization(+1) {
+					targets = append(targets, host)
+				}
+			}
+			if len(targets) == 0 {
+				continue
+			}
+
+			target := slavesByID[r.ClusterAllocator.placementStrategy(replicaSet.PlacementStrategy).RankHosts(targets)[0].SlaveID]
+			return ProposedMove{
+				ReplicaSetID:      replicaSet.ID,
+				ReplicaSetName:    replicaSet.Name,
+				SourceMongodID:    member.MongodID,
+				Arbiter:           member.Arbiter,
+				SourceSlaveID:     source.ID,
+				SourceHostname:    source.Hostname,
+				SourceUtilization: source.utilization(0),
+				TargetSlaveID:     target.ID,
+				TargetHostname:    target.Hostname,
+				TargetUtilization: target.utilization(0),
+			}, true, nil
+		}
+	}
+
+	return move, false, nil
+}
+
+func (r *Rebalancer) startMove(tx *gorm.DB, decisions *AllocatorDecisions, proposed ProposedMove) (err error) {
+
+	var target Slave
+	if err = tx.First(&target, proposed.TargetSlaveID).Error; err != nil {
+		return err
+	}
+	var replicaSet ReplicaSet
+	if err = tx.First(&replicaSet, proposed.ReplicaSetID).Error; err != nil {
+		return err
+	}
+
+	p := memberPersistence(proposed.Arbiter, target.PersistentStorage)
+	replacement, err := r.ClusterAllocator.spawnMongodOnSlave(tx, decisions, &target, &replicaSet, p)
+	if err != nil {
+		return fmt.Errorf("cannot spawn replacement member: %s", err)
+	}
+	rebalancerLog.Infof("moving mongod `%d` of replica set `%s` from slave `%s` to mongod `%d` on slave `%s`",
+		proposed.SourceMongodID, replicaSet.Name, proposed.SourceHostname, replacement.ID, target.Hostname)
+
+	return tx.Create(&MongodMove{
+		State:               MongodMoveStateAddingReplacement,
+		Started:             time.Now(),
+		ReplicaSetID:        NullIntValue(replicaSet.ID),
+		SourceSlaveID:       NullIntValue(proposed.SourceSlaveID),
+		TargetSlaveID:       NullIntValue(target.ID),
+		SourceMongodID:      NullIntValue(proposed.SourceMongodID),
+		ReplacementMongodID: NullIntValue(replacement.ID),
+	}).Error
+}
+
+// Advance all active MongodMoves, see advanceSlaveDrains.
+// Returns the IDs of the Replica Sets waiting for a replacement member.
+func (c *ClusterAllocator) advanceMongodMoves(tx *gorm.DB) (replacingReplicaSetIDs map[int64]bool) {
+
+	replacingReplicaSetIDs = make(map[int64]bool)
+
+	var moves []MongodMove
+	if err := tx.Where("state IN (?)", []MongodMoveState{MongodMoveStateAddingReplacement, MongodMoveStateRemovingMember}).
+		Order("id").Find(&moves).Error; err != nil {
+		panic(err)
+	}
+
+	for i := range moves {
+		move := &moves[i]
+
+		if move.State == MongodMoveStateAddingReplacement {
+			if !move.SourceMongodID.Valid || !move.ReplacementMongodID.Valid {
+				caLog.Warnf("aborting move `%d`: a member was removed while moving", move.ID)
+				finishMongodMove(move, MongodMoveStateAborted)
+			} else if running, err := mongodObservedRunning(tx, move.ReplacementMongodID.Int64); err != nil {
+				panic(err)
+			} else if running {
+				caLog.Infof("replacement mongod `%d` is running, destroying moved mongod `%d`", move.ReplacementMongodID.Int64, move.SourceMongodID.Int64)
+				destroyMongod(tx, move.SourceMongodID.Int64)
+				move.State = MongodMoveStateRemovingMember
+			}
+		}
+
+		if move.State == MongodMoveStateRemovingMember && !move.SourceMongodID.Valid {
+			finishMongodMove(move, MongodMoveStateFinished)
+		}
+
+		if err := tx.Save(move).Error; err != nil {
+			panic(err)
+		}
+
+		if move.State == MongodMoveStateAddingReplacement {
+			replacingReplicaSetIDs[move.ReplicaSetID.Int64] = true
+		}
+	}
+
+	return replacingReplicaSetIDs
+}
+
+func finishMongodMove(move *MongodMove, state MongodMoveState) {
+	now := time.Now()
+	move.State = state
+	move.Finished = &now
+}
@@ -0,0 +1,155 @@
+package master
+
+import (
+	. "github.com/KIT-MAMID/mamid/model"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+// Based on createClusterAllocatorTestDB: two Replica Sets placed on host1 and host2, host3 activated afterwards
+func createRebalancerTestDB(t *testing.T, c *ClusterAllocator) (db *DB, slaves []Slave) {
+	db, slaves, _ = createClusterAllocatorTestDB(t)
+
+	tx := db.Begin()
+	slaves[2].ConfiguredState = SlaveStateMaintenance
+	assert.NoError(t, tx.Save(&slaves[2]).Error)
+	replicaSet := ReplicaSet{
+		Name:                  "repl2",
+		PersistentMemberCount: 2,
+		ShardingRole:          ShardingRoleNone,
+	}
+	assert.NoError(t, tx.Create(&replicaSet).Error)
+	assert.NoError(t, tx.Commit().Error)
+
+	compileMongodLayout(t, db, c)
+
+	tx = db.Begin()
+	slaves[2].ConfiguredState = SlaveStateActive
+	assert.NoError(t, tx.Save(&slaves[2]).Error)
+	for _, s := range slaves[:2] {
+		for _, m := range mongodsOnSlave(t, tx, s.ID) {
+			observeMongod(t, tx, m, MongodExecutionStateRunning)
+		}
+	}
+	assert.NoError(t, tx.Commit().Error)
+
+	return db, slaves
+}
+
+func TestRebalancer_Rebalance(t *testing.T) {
+	var c ClusterAllocator
+	db, slaves := createRebalancerTestDB(t, &c)
+	defer db.CloseAndDrop()
+
+	r := Rebalancer{ClusterAllocator: &c, Threshold: 0.1, MaxConcurrentMoves: 1}
+
+	tx := db.Begin()
+	moves, err := r.Rebalance(tx, nil)
+	assert.NoError(t, err)
+	if !assert.Len(t, moves, 1, "should respect MaxConcurrentMoves") {
+		return
+	}
+	assert.Equal(t, slaves[0].ID, moves[0].SourceSlaveID)
+	assert.Equal(t, slaves[2].ID, moves[0].TargetSlaveID)
+	assert.InDelta(t, 0.2, moves[0].SourceUtilization, 0.001)
+	assert.InDelta(t, 0.0, moves[0].TargetUtilization, 0.001)
+	assert.NoError(t, c.CompileMongodLayout(tx, nil))
+	assert.NoError(t, tx.Commit().Error)
+
+	tx = db.Begin()
+	var move MongodMove
+	assert.NoError(t, tx.First(&move).Error)
+	assert.Equal(t, MongodMoveStateAddingReplacement, move.State)
+	replacements := mongodsOnSlave(t, tx, slaves[2].ID)
+	if !assert.Len(t, replacements, 1, "should spawn the replacement on the empty slave") {
+		return
+	}
+	assert.Equal(t, NullIntValue(replacements[0].ID), move.ReplacementMongodID)
+	assert.Len(t, mongodsOnSlave(t, tx, slaves[0].ID), 2, "moved member must not be removed before the replacement is running")
+
+	// no further moves while MaxConcurrentMoves are active
+	moves, err = r.Rebalance(tx, nil)
+	assert.NoError(t, err)
+	assert.Empty(t, moves)
+
+	observeMongod(t, tx, replacements[0], MongodExecutionStateRunning)
+	assert.NoError(t, tx.Commit().Error)
+
+	compileMongodLayout(t, db, &c)
+
+	tx = db.Begin()
+	assert.NoError(t, tx.First(&move).Error)
+	assert.Equal(t, MongodMoveStateRemovingMember, move.State)
+	var source *Mongod
+	for _, m := range mongodsOnSlave(t, tx, slaves[0].ID) {
+		if m.ID == move.SourceMongodID.Int64 {
+			source = m
+		}
+	}
+	if !assert.NotNil(t, source) {
+		return
+	}
+	assert.Equal(t, MongodExecutionStateDestroyed, source.DesiredState.ExecutionState)
+
+	// The slave destroyed the Mongod
+	observeMongod(t, tx, source, MongodExecutionStateDestroyed)
+	assert.NoError(t, tx.Commit().Error)
+
+	compileMongodLayout(t, db, &c)
+
+	tx = db.Begin()
+	assert.NoError(t, tx.First(&move).Error)
+	assert.Equal(t, MongodMoveStateFinished, move.State)
+	assert.NotNil(t, move.Finished)
+	assert.Len(t, mongodsOnSlave(t, tx, slaves[0].ID), 1)
+
+	// 1/10 vs 1/10 or 2/10 vs 1/10 does not exceed the threshold
+	moves, err = r.Rebalance(tx, nil)
+	assert.NoError(t, err)
+	assert.Empty(t, moves, "cluster should be balanced")
+	tx.Rollback()
+}
+
+func TestRebalancer_Rebalance_threshold(t *testing.T) {
+	var c ClusterAllocator
+	db, _ := createRebalancerTestDB(t, &c)
+	defer db.CloseAndDrop()
+
+	r := Rebalancer{ClusterAllocator: &c, Threshold: 0.2, MaxConcurrentMoves: 10}
+
+	tx := db.Begin()
+	defer tx.Rollback()
+	moves, err := r.Rebalance(tx, nil)
+	assert.NoError(t, err)
+	assert.Empty(t, moves, "utilization differs by only 0.2")
+}
+
+// Ranks like SpreadPlacementStrategy but records the hosts it was asked to rank
+type recordingPlacementStrategy struct {
+	SpreadPlacementStrategy
+	rankedHosts *[]PlacementCandidate
+}
+
+func (s recordingPlacementStrategy) RankHosts(candidates []PlacementCandidate) []PlacementCandidate {
+	*s.rankedHosts = append(*s.rankedHosts, candidates...)
+	return s.SpreadPlacementStrategy.RankHosts(candidates)
+}
+
+func TestRebalancer_Rebalance_placementStrategy(t *testing.T) {
+	var c ClusterAllocator
+	db, slaves := createRebalancerTestDB(t, &c)
+	defer db.CloseAndDrop()
+
+	var rankedHosts []PlacementCandidate
+	c.PlacementStrategy = recordingPlacementStrategy{rankedHosts: &rankedHosts}
+	r := Rebalancer{ClusterAllocator: &c, Threshold: 0.1, MaxConcurrentMoves: 1}
+
+	tx := db.Begin()
+	defer tx.Rollback()
+	moves, err := r.Rebalance(tx, nil)
+	assert.NoError(t, err)
+	assert.Len(t, moves, 1)
+	if assert.NotEmpty(t, rankedHosts, "targets should be ranked by the configured PlacementStrategy") {
+		assert.Equal(t, slaves[2].ID, rankedHosts[0].SlaveID)
+	}
+}
@@ -8,15 +8,19 @@ import (
 	"github.com/Sirupsen/logrus"
 	"github.com/jinzhu/gorm"
 	_ "github.com/jinzhu/gorm/dialects/postgres"
+	_ "github.com/jinzhu/gorm/dialects/sqlite"
 	"github.com/lib/pq"
+	"github.com/mattn/go-sqlite3"
+	"io/ioutil"
 	"math/rand"
 	"os"
+	"strings"
 	"time"
 )
 
 var modelLog = logrus.WithField("module", "model")
 
-const SCHEMA_VERSION string = "0.0.1"
+const SCHEMA_VERSION string = "0.0.12"
 
 /*
 	The structs defined in this file are stored in a database using the `gorm` package.
@@ -60,6 +64,11 @@ type Slave struct {
 	Mongods              []*Mongod `gorm:"ForeignKey:ParentSlaveID"`
 	ConfiguredState      SlaveState
 
+	// Declared resource capacities, 0 if undeclared, i.e. the resource does not limit placement
+	MemoryCapacityMB      uint
+	CPUCapacityMillicores uint
+	DiskCapacityGB        uint
+
 	Problems []*Problem
 
 	// Foreign keys
@@ -102,17 +111,44 @@ type ReplicaSet struct {
 	Name                  string `gorm:"unique_index"`
 	PersistentMemberCount uint
 	VolatileMemberCount   uint
+	ArbiterMemberCount    uint // members voting in elections without holding data
+	HiddenMemberCount     uint // data-bearing members designated as hidden by the ClusterAllocator, e.g. for analytics
+	DelayedMemberCount    uint // data-bearing members designated as hidden and delayed, e.g. to recover from operator errors
+	SlaveDelaySeconds     uint // replication delay of the delayed members
 	ShardingRole          ShardingRole
 	Initiated             bool
-	Mongods               []*Mongod
+	PlacementStrategy     string // name of the master.PlacementStrategy, empty for the ClusterAllocator's default
+
+	// Mongod tuning options, zero values leave the Mongod's defaults in effect (see msp.MongodOptions)
+	StorageEngine         string
+	WiredTigerCacheSizeGB float64
+	OplogSizeMB           uint
+	BindIP                string // comma separated list of IP addresses
+	JournalDisabled       bool
+
+	// Resources requested per data-bearing member, 0 is unlimited.
+	// Memory and CPU requests are enforced as limits on the slaves (see msp.MongodResources).
+	// Arbiters request no resources.
+	MemoryRequestMB      uint
+	CPURequestMillicores uint
+	DiskRequestGB        uint
+
+	Mongods []*Mongod
 
 	Problems []*Problem
 }
 
+// A RiskGroup is a failure domain, e.g. a datacenter, room, rack or host.
+// Failure domains can be nested, the Level describes the kind of failure domain.
+// RiskGroups without Parent are top level failure domains.
 type RiskGroup struct {
 	ID     int64  `gorm:"primary_key"` //TODO needs to start incrementing at 1, 0 is special value for slaves "out of risk" => define a constant?
 	Name   string `gorm:"unique_index"`
+	Level  string
 	Slaves []*Slave
+
+	Parent   *RiskGroup
+	ParentID sql.NullInt64 `sql:"type:integer NULL REFERENCES risk_groups(id) DEFERRABLE INITIALLY DEFERRED"`
 }
 
 type Mongod struct {
@@ -120,6 +156,9 @@ type Mongod struct {
 	ID          int64 `gorm:"primary_key"`
 	Port        PortNumber
 	ReplSetName string
+	Arbiter     bool // the Mongod holds no data and only votes in elections
+	Hidden      bool // the Mongod is invisible to clients and never becomes PRIMARY
+	Delayed     bool // the Mongod is hidden and replicates with the Replica Set's SlaveDelaySeconds
 
 	ObservationError   MSPError
 	ObservationErrorID sql.NullInt64 `sql:"type:integer NULL REFERENCES msp_errors(id) ON DELETE SET NULL DEFERRABLE INITIALLY DEFERRED"` // TODO not cleaned up on Mongod deletion right now
@@ -177,8 +216,44 @@ const (
 	ProblemTypeMismatch
 	ProblemTypeDesiredReplicaSetConstraint
 	ProblemTypeObservedReplicaSetConstraint
+	ProblemTypeMongodCrashLoop
+	ProblemTypeMongodOptionsDrift
+	ProblemTypeSlaveDataVolumeFull
+	ProblemTypeSlaveMemoryPressure
+	ProblemTypeSlaveHighLoad
+	ProblemTypeReplicationLag
+	ProblemTypeOplogWindow
 )
 
+var problemTypeNames = map[ProblemType]string{
+	ProblemTypeConnection:                   "connection",
+	ProblemTypeMismatch:                     "mismatch",
+	ProblemTypeDesiredReplicaSetConstraint:  "desired_replica_set_constraint",
+	ProblemTypeObservedReplicaSetConstraint: "observed_replica_set_constraint",
+	ProblemTypeMongodCrashLoop:              "mongod_crash_loop",
+	ProblemTypeMongodOptionsDrift:           "mongod_options_drift",
+	ProblemTypeSlaveDataVolumeFull:          "slave_data_volume_full",
+	ProblemTypeSlaveMemoryPressure:          "slave_memory_pressure",
+	ProblemTypeSlaveHighLoad:                "slave_high_load",
+	ProblemTypeReplicationLag:               "replication_lag",
+	ProblemTypeOplogWindow:                  "oplog_window",
+}
+
+// All ProblemTypes in ascending order
+func ProblemTypes() (types []ProblemType) {
+	for t := ProblemTypeConnection; t <= ProblemTypeOplogWindow; t++ {
+		types = append(types, t)
+	}
+	return types
+}
+
+func (t ProblemType) String() string {
+	if name, ok := problemTypeNames[t]; ok {
+		return name
+	}
+	return fmt.Sprintf("unknown(%d)", uint(t))
+}
+
 type Problem struct {
 	ID              int64 `gorm:"primary_key"`
 	Description     string
@@ -197,6 +272,144 @@ type Problem struct {
 	MongodID sql.NullInt64 `sql:"type:integer NULL REFERENCES mongods(id) ON DELETE CASCADE DEFERRABLE INITIALLY DEFERRED"`
 }
 
+// The latest host metrics sample reported by a Slave (see msp.HostMetrics)
+type SlaveHostMetrics struct {
+	ID                       int64 `gorm:"primary_key"`
+	Sampled                  time.Time
+	CPUCount                 uint
+	LoadAverage1             float64
+	LoadAverage5             float64
+	LoadAverage15            float64
+	MemoryTotalBytes         uint64
+	MemoryAvailableBytes     uint64
+	SwapTotalBytes           uint64
+	SwapFreeBytes            uint64
+	DataVolumeTotalBytes     uint64
+	DataVolumeAvailableBytes uint64
+
+	Slave   *Slave
+	SlaveID int64 `sql:"type:integer NOT NULL UNIQUE REFERENCES slaves(id) ON DELETE CASCADE DEFERRABLE INITIALLY DEFERRED"`
+}
+
+// Replication figures of a Replica Set computed by the Monitor from the status reported by its PRIMARY
+type ReplicaSetReplication struct {
+	ID                 int64 `gorm:"primary_key"`
+	Observed           time.Time
+	OplogFirst         *time.Time // oldest entry in the PRIMARY's oplog, nil if unknown
+	OplogLast          *time.Time // newest entry in the PRIMARY's oplog, nil if unknown
+	OplogWindowSeconds float64    // time span covered by the PRIMARY's oplog
+	OplogSizeBytes     uint64
+	OplogMaxSizeBytes  uint64
+
+	ReplicaSet   *ReplicaSet
+	ReplicaSetID int64 `sql:"type:integer NOT NULL UNIQUE REFERENCES replica_sets(id) ON DELETE CASCADE DEFERRABLE INITIALLY DEFERRED"`
+
+	PrimaryMongod   *Mongod
+	PrimaryMongodID sql.NullInt64 `sql:"type:integer NULL REFERENCES mongods(id) ON DELETE SET NULL DEFERRABLE INITIALLY DEFERRED"`
+}
+
+// Whether the oldest entries of the PRIMARY's oplog are being overwritten.
+// Only then the oplog window limits how long a member may be offline without requiring a full resync.
+func (r ReplicaSetReplication) OplogFull() bool {
+	return r.OplogMaxSizeBytes > 0 && r.OplogSizeBytes >= r.OplogMaxSizeBytes/100*95
+}
+
+// Replication lag of a member of a Replica Set computed by the Monitor
+type MongodReplication struct {
+	ID          int64 `gorm:"primary_key"`
+	Observed    time.Time
+	MemberState string     // as seen by the PRIMARY, e.g. `SECONDARY`
+	Optime      *time.Time // the last operation applied by the member, nil if unknown, e.g. for arbiters
+	LagSeconds  float64    // behind the PRIMARY's optime, including a configured slave delay, 0 if Optime is unknown
+
+	Mongod   *Mongod
+	MongodID int64 `sql:"type:integer NOT NULL UNIQUE REFERENCES mongods(id) ON DELETE CASCADE DEFERRABLE INITIALLY DEFERRED"`
+
+	ReplicaSet   *ReplicaSet
+	ReplicaSetID int64 `sql:"type:integer NOT NULL REFERENCES replica_sets(id) ON DELETE CASCADE DEFERRABLE INITIALLY DEFERRED"`
+}
+
+// A SlaveDrain moves all Mongods off a Slave, one Replica Set at a time.
+// A replacement member is added on another Slave first, the Slave's member
+// is only removed once the replacement is running (PRIMARY or SECONDARY).
+type SlaveDrain struct {
+	ID               int64 `gorm:"primary_key"`
+	State            SlaveDrainState
+	StateDescription string // why the drain is blocked or was cancelled
+	Started          time.Time
+	Finished         *time.Time
+
+	Slave   *Slave
+	SlaveID int64 `sql:"type:integer NOT NULL UNIQUE REFERENCES slaves(id) ON DELETE CASCADE DEFERRABLE INITIALLY DEFERRED"`
+
+	// The Replica Set currently being moved off the Slave
+	ReplicaSet   *ReplicaSet
+	ReplicaSetID sql.NullInt64 `sql:"type:integer NULL REFERENCES replica_sets(id) ON DELETE SET NULL DEFERRABLE INITIALLY DEFERRED"`
+
+	// The Replica Set's member on the drained Slave
+	DrainedMongod   *Mongod
+	DrainedMongodID sql.NullInt64 `sql:"type:integer NULL REFERENCES mongods(id) ON DELETE SET NULL DEFERRABLE INITIALLY DEFERRED"`
+
+	// The member replacing DrainedMongod
+	ReplacementMongod   *Mongod
+	ReplacementMongodID sql.NullInt64 `sql:"type:integer NULL REFERENCES mongods(id) ON DELETE SET NULL DEFERRABLE INITIALLY DEFERRED"`
+}
+
+type SlaveDrainState uint
+
+const (
+	_                                                = 0
+	SlaveDrainStatePending           SlaveDrainState = iota // waiting for the next ClusterAllocator run
+	SlaveDrainStateAddingReplacement                        // waiting for the replacement member to become PRIMARY or SECONDARY
+	SlaveDrainStateRemovingMember                           // waiting for the member on the drained Slave to be destroyed
+	SlaveDrainStateBlocked                                  // no Slave can host a replacement member
+	SlaveDrainStateFinished
+	SlaveDrainStateCancelled
+)
+
+// Whether the ClusterAllocator still works on the drain
+func (s SlaveDrainState) Active() bool {
+	return s != SlaveDrainStateFinished && s != SlaveDrainStateCancelled
+}
+
+// A MongodMove moves a member of a Replica Set from one Slave to another to rebalance the cluster.
+// Like a SlaveDrain, the replacement member is added first and the source member is only
+// removed once the replacement is running (PRIMARY or SECONDARY).
+type MongodMove struct {
+	ID       int64 `gorm:"primary_key"`
+	State    MongodMoveState
+	Started  time.Time
+	Finished *time.Time
+
+	ReplicaSet   *ReplicaSet
+	ReplicaSetID sql.NullInt64 `sql:"type:integer NULL REFERENCES replica_sets(id) ON DELETE SET NULL DEFERRABLE INITIALLY DEFERRED"`
+
+	SourceSlave   *Slave
+	SourceSlaveID sql.NullInt64 `sql:"type:integer NULL REFERENCES slaves(id) ON DELETE SET NULL DEFERRABLE INITIALLY DEFERRED"`
+	TargetSlave   *Slave
+	TargetSlaveID sql.NullInt64 `sql:"type:integer NULL REFERENCES slaves(id) ON DELETE SET NULL DEFERRABLE INITIALLY DEFERRED"`
+
+	SourceMongod        *Mongod
+	SourceMongodID      sql.NullInt64 `sql:"type:integer NULL REFERENCES mongods(id) ON DELETE SET NULL DEFERRABLE INITIALLY DEFERRED"`
+	ReplacementMongod   *Mongod
+	ReplacementMongodID sql.NullInt64 `sql:"type:integer NULL REFERENCES mongods(id) ON DELETE SET NULL DEFERRABLE INITIALLY DEFERRED"`
+}
+
+type MongodMoveState uint
+
+const (
+	_                                                = 0
+	MongodMoveStateAddingReplacement MongodMoveState = iota // waiting for the replacement member to become PRIMARY or SECONDARY
+	MongodMoveStateRemovingMember                           // waiting for the source member to be destroyed
+	MongodMoveStateFinished
+	MongodMoveStateAborted // a member was removed by someone else while moving
+)
+
+// Whether the ClusterAllocator still works on the move
+func (s MongodMoveState) Active() bool {
+	return s == MongodMoveStateAddingReplacement || s == MongodMoveStateRemovingMember
+}
+
 type MamidMetadata struct {
 	Key, Value string
 }
@@ -234,6 +447,16 @@ func (db *DB) CloseAndDrop() {
 		modelLog.Fatalf("could not close connection with database open: %s", err)
 	}
 
+	if db.Driver == "sqlite3" {
+		// dbName is the path to the database file
+		if err := os.Remove(db.dbName.String); err != nil {
+			modelLog.Fatalf("could not remove database file `%s`: %s", db.dbName.String, err)
+		} else {
+			modelLog.Infof("removed database file `%s`", db.dbName.String)
+		}
+		return
+	}
+
 	const driver = "postgres"
 	c, err := sql.Open(driver, db.connDSN.String)
 	if err != nil {
@@ -250,49 +473,25 @@ func (db *DB) CloseAndDrop() {
 
 }
 
-// Idempotently migrate the database schema.
-// Currenlty, only creation of the schema is supported.
+// Idempotently migrate the database schema to SCHEMA_VERSION.
+// See SchemaMigration for how schema changes are shipped.
 func (dbWrapper *DB) migrate() (err error) {
+	_, err = dbWrapper.Migrate(false)
+	return err
+}
 
-	db := dbWrapper.gormDB
-
-	if !db.HasTable(&MamidMetadata{}) {
-
-		// run the populating query
-
-		ddlStatements, err := Asset("model/sql/mamid_postgresql.sql")
-		if err != nil {
-			return fmt.Errorf("sql DDL data not found: %s", err)
-		}
-
-		err = db.Exec(string(ddlStatements), []interface{}{}).Error
-		if err != nil {
-			return fmt.Errorf("error running DDL statements: %s", err)
-		}
-
-		// persist schema version
-		if err = dbWrapper.setMetadata("schema_version", SCHEMA_VERSION); err != nil {
-			return fmt.Errorf("error setting schema version: %s", err)
-		}
-
-	} else {
-
-		version, err := dbWrapper.schemaVersion()
-		if err != nil {
-			return fmt.Errorf("error determining schema version: %s", err)
-		}
-
-		if version != SCHEMA_VERSION {
-			return fmt.Errorf("the database has already been populated, migrations are not supported")
-		}
-
+// The suffix of the SQL assets for the database driver in use
+func (dbWrapper *DB) sqlDialect() string {
+	switch dbWrapper.Driver {
+	case "sqlite3":
+		return "sqlite3"
+	default:
+		return "postgresql"
 	}
-
-	return nil
 }
 
 func (dbWrapper *DB) schemaVersion() (version string, err error) {
-	return dbWrapper.metadata("schema_version")
+	return dbWrapper.metadata(schemaVersionMetadataKey)
 }
 
 // Return metadata or an empty `value` if the entry does not exist
@@ -302,24 +501,52 @@ func (dbWrapper *DB) metadata(key string) (value string, err error) {
 		if res.RecordNotFound() {
 			return "", nil
 		} else {
-			return "", err
+			return "", res.Error
 		}
 	} else {
 		return metadata.Value, nil
 	}
 }
 
-func (dbWrapper *DB) setMetadata(key, value string) (err error) {
-	metadata := MamidMetadata{
-		Key:   key,
-		Value: value,
+// Create or overwrite a metadata entry
+func setMetadata(tx *gorm.DB, key, value string) (err error) {
+	res := tx.Model(&MamidMetadata{}).Where("key = ?", key).Update("value", value)
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		metadata := MamidMetadata{
+			Key:   key,
+			Value: value,
+		}
+		err = tx.Create(&metadata).Error
 	}
-	err = dbWrapper.gormDB.Save(&metadata).Error
 	return
 }
 
+// Open the database and migrate its schema to SCHEMA_VERSION
 func InitializeDB(driver, dsn string) (*DB, error) {
 
+	db, err := OpenDB(driver, dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := db.migrate(); err != nil {
+		return nil, fmt.Errorf("could not migrate database: %s", err)
+	}
+
+	return db, err
+
+}
+
+// Open the database without touching its schema
+func OpenDB(driver, dsn string) (*DB, error) {
+
+	if driver == "sqlite3" {
+		dsn = sqlite3DSN(dsn)
+	}
+
 	gormDB, err := gorm.Open(driver, dsn)
 	if err != nil {
 		return nil, err
@@ -331,12 +558,27 @@ func InitializeDB(driver, dsn string) (*DB, error) {
 		Driver: driver,
 		gormDB: gormDB}
 
-	if err := db.migrate(); err != nil {
-		return nil, fmt.Errorf("could not migrate database: %s", err)
-	}
+	return db, nil
 
-	return db, err
+}
 
+// Add the connection parameters the schema relies on to a SQLite DSN (a file path or `file:` URI)
+// unless they are specified explicitly.
+// Foreign keys are not enforced by SQLite unless enabled on every connection.
+// Concurrent transactions (e.g. of the Monitor) need to wait for the database lock instead of failing immediately.
+func sqlite3DSN(dsn string) string {
+	params := []string{"_foreign_keys=1", "_busy_timeout=5000", "_txlock=immediate"}
+	for _, param := range params {
+		if strings.Contains(dsn, strings.SplitN(param, "=", 2)[0]+"=") {
+			continue
+		}
+		if strings.Contains(dsn, "?") {
+			dsn += "&" + param
+		} else {
+			dsn += "?" + param
+		}
+	}
+	return dsn
 }
 
 func InitializeTestDBFromFile(file string) (db *DB, dsn string, err error) {
@@ -350,22 +592,27 @@ func InitializeTestDBFromFile(file string) (db *DB, dsn string, err error) {
 	}
 	defer fd.Close()
 	scann := bufio.NewScanner(fd)
-	nativeDb, err := sql.Open("postgres", dsn)
-	defer nativeDb.Close()
+	nativeDb, err := sql.Open(db.Driver, dsn)
 	if err != nil {
 		return
 	}
+	defer nativeDb.Close()
 	tx, err := nativeDb.Begin()
 	if err != nil {
 		return
 	}
-	_, err = nativeDb.Exec("DELETE FROM mamid_metadata")
+	_, err = tx.Exec("DELETE FROM mamid_metadata")
 	if err != nil {
 		return nil, "", err
 	}
 	line := 1
 	for scann.Scan() {
-		_, err := tx.Exec(scann.Text())
+		statement := scann.Text()
+		if db.Driver == "sqlite3" && isPostgreSQLDumpStatement(statement) {
+			// SQLite tracks AUTOINCREMENT counters on insert and has no session settings
+			continue
+		}
+		_, err := tx.Exec(statement)
 		if err != nil {
 			return nil, "", err
 		}
@@ -375,12 +622,36 @@ func InitializeTestDBFromFile(file string) (db *DB, dsn string, err error) {
 	return
 }
 
+// Statements in fixtures created by pg_dump that are not data but PostgreSQL-specific
+func isPostgreSQLDumpStatement(statement string) bool {
+	return strings.HasPrefix(statement, "SET ") || strings.HasPrefix(statement, "SELECT pg_catalog.")
+}
+
+// Create an empty test database on the PostgreSQL server specified by MAMID_TESTDB_DSN
+// or, if the environment variable is not set, in a temporary SQLite database file.
+// Use CloseAndDrop() to remove the database after the test.
 func InitializeTestDB() (db *DB, dsn string, err error) {
 
+	db, dsn, err = openEmptyTestDB()
+	if err != nil {
+		return nil, dsn, err
+	}
+
+	if err := db.migrate(); err != nil {
+		return nil, dsn, fmt.Errorf("could not migrate database: %s", err)
+	}
+
+	return db, dsn, nil
+
+}
+
+// Like InitializeTestDB but without creating the schema
+func openEmptyTestDB() (db *DB, dsn string, err error) {
+
 	const driver = "postgres"
 	connDSN := os.Getenv("MAMID_TESTDB_DSN")
 	if connDSN == "" {
-		modelLog.Panic("MAMID_TESTDB_DSN environment variable is not set")
+		return openEmptySQLiteTestDB()
 	}
 
 	c, err := sql.Open(driver, connDSN)
@@ -412,10 +683,29 @@ func InitializeTestDB() (db *DB, dsn string, err error) {
 		connDSN: sql.NullString{String: connDSN, Valid: true},
 	}
 
-	if err := db.migrate(); err != nil {
-		return nil, dsn, fmt.Errorf("could not migrate database: %s", err)
+	return db, dsn, nil
+
+}
+
+func openEmptySQLiteTestDB() (db *DB, dsn string, err error) {
+
+	const driver = "sqlite3"
+
+	f, err := ioutil.TempFile("", "mamid_testing_")
+	if err != nil {
+		modelLog.Fatalf("cannot create test database file: %s", err)
+	}
+	f.Close()
+
+	dsn = sqlite3DSN(f.Name())
+	db, err = OpenDB(driver, dsn)
+	if err != nil {
+		modelLog.Fatalf("cannot open just created test database `%s`: %s", dsn, err)
 	}
 
+	db.dbName = sql.NullString{String: f.Name(), Valid: true}
+	db.connDSN = sql.NullString{String: dsn, Valid: true}
+
 	return db, dsn, nil
 
 }
@@ -456,10 +746,15 @@ func PtrToNullInt(value *int64) sql.NullInt64 {
 }
 
 func IsIntegrityConstraintViolation(err error) bool {
-	if driverErr, ok := err.(*pq.Error); ok && driverErr.Code.Class() == "23" {
+	switch driverErr := err.(type) {
+	case *pq.Error:
 		// Integrity Constraint Violation
-		return true
-	} else {
+		return driverErr.Code.Class() == "23"
+	case sqlite3.Error:
+		return driverErr.Code == sqlite3.ErrConstraint
+	case *sqlite3.Error:
+		return driverErr.Code == sqlite3.ErrConstraint
+	default:
 		return false
 	}
 }
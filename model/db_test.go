@@ -250,6 +250,20 @@ func TestDeleteBehavior(t *testing.T) {
 
 }
 
+func TestIsIntegrityConstraintViolation(t *testing.T) {
+	db, _, _ := InitializeTestDB()
+	defer db.CloseAndDrop()
+	tx := db.Begin()
+	defer tx.Rollback()
+
+	assert.NoError(t, tx.Create(fixtureEmptySlave()).Error)
+	err := tx.Create(fixtureEmptySlave()).Error
+	assert.Error(t, err)
+	assert.True(t, IsIntegrityConstraintViolation(err), "duplicate hostname should violate unique constraint")
+
+	assert.False(t, IsIntegrityConstraintViolation(nil))
+}
+
 func TestGormFirstBehavior(t *testing.T) {
 	db, _, _ := InitializeTestDB()
 	defer db.CloseAndDrop()
@@ -1,6 +1,9 @@
 package model
 
-import "github.com/KIT-MAMID/mamid/msp"
+import (
+	"github.com/KIT-MAMID/mamid/msp"
+	"time"
+)
 
 type StatusMessage interface {
 }
@@ -12,8 +15,48 @@ type ConnectionStatus struct {
 }
 
 type MongodMatchStatus struct {
-	Mismatch bool
+	Mismatch       bool
+	Mongod         Mongod
+	CrashLooping   bool      // the Slave no longer restarts the Mongod, see msp.SlaveMongodCrashLoopError
+	CrashLoopError msp.Error // Only valid if CrashLooping=true
+	OptionsDrift   bool      // the running Mongod's options differ from its Replica Set's, see msp.MongodOptions
+	DriftedOptions []string  // One description per differing option. Only valid if OptionsDrift=true
+}
+
+// Sent by the Monitor for every host metrics sample reported by a Slave
+type HostMetricsStatus struct {
+	Slave          Slave
+	Metrics        SlaveHostMetrics
+	DataVolumeFull bool // the used share of the data volume exceeds the Monitor's threshold
+	MemoryPressure bool // the used share of memory exceeds the Monitor's threshold
+	HighLoad       bool // the 5-minute load average per CPU exceeds the Monitor's threshold
+}
+
+// Sent by the Monitor whenever the PRIMARY of a Replica Set reports its replication status
+type ReplicaSetReplicationStatus struct {
+	ReplicaSet          ReplicaSet
+	Replication         ReplicaSetReplication
+	LaggingMembers      []LaggingMember // members lagging more than the Monitor's threshold, not counting their slave delay
+	OplogWindowTooShort bool            // the PRIMARY's oplog is full and covers less than the Monitor's threshold
+}
+
+type LaggingMember struct {
 	Mongod   Mongod
+	Hostname string
+	Lag      time.Duration // not counting the member's slave delay
+}
+
+// Sent by the ProblemManager when a Problem is created or resolved, i.e. deleted
+type ProblemStatus struct {
+	Problem  Problem
+	Resolved bool
+}
+
+// Sent by the ClusterAllocator for every member it adds to or removes from a Replica Set.
+// Sent once the allocator's transaction is committed, see ClusterAllocator.Commit.
+type AllocatorDecision struct {
+	Removal bool   // the Mongod's desired state was set to destroyed, otherwise the Mongod was spawned
+	Mongod  Mongod // for removals, only ID, ParentSlaveID and ReplicaSetID are valid
 }
 
 type DesiredReplicaSetConstraintStatus struct {
@@ -21,6 +64,59 @@ type DesiredReplicaSetConstraintStatus struct {
 	ReplicaSet                ReplicaSet
 	ConfiguredVolatileCount   uint
 	ConfiguredPersistentCount uint
+	ConfiguredArbiterCount    uint
+	SlaveRejections           []SlavePlacementEvaluation // Why no Slave could host a missing member. Only valid if Unsatisfied=true
+}
+
+// Whether a Slave can host an additional member of a Replica Set
+type SlavePlacementEvaluation struct {
+	SlaveID          int64
+	SlaveHostname    string
+	PersistentMember bool // the kind of member to be placed on the Slave
+	ArbiterMember    bool // PersistentMember is irrelevant for arbiters
+	RejectionReasons []PlacementRejectionReason
+}
+
+func (e SlavePlacementEvaluation) Suitable() bool {
+	return len(e.RejectionReasons) == 0
+}
+
+type PlacementRejectionReason uint
+
+const (
+	_                                                           = 0
+	PlacementRejectionWrongPersistence PlacementRejectionReason = iota
+	PlacementRejectionNoFreePort
+	PlacementRejectionRiskGroupUsed
+	PlacementRejectionNotActive
+	PlacementRejectionAlreadyHostsMember
+	PlacementRejectionInsufficientResources
+)
+
+func (r PlacementRejectionReason) String() string {
+	switch r {
+	case PlacementRejectionWrongPersistence:
+		return "wrong persistence"
+	case PlacementRejectionNoFreePort:
+		return "no free port"
+	case PlacementRejectionRiskGroupUsed:
+		return "risk group already used by the Replica Set"
+	case PlacementRejectionNotActive:
+		return "not active"
+	case PlacementRejectionAlreadyHostsMember:
+		return "already hosting a member of the Replica Set"
+	case PlacementRejectionInsufficientResources:
+		return "insufficient free memory, CPU or disk"
+	default:
+		return "unknown reason"
+	}
+}
+
+// Progress of a SlaveDrain, sent by the ClusterAllocator on every run while the drain is active
+type SlaveDrainStatus struct {
+	Drain            SlaveDrain
+	Slave            Slave
+	RemainingMongods uint // Replica Set members still hosted by the Slave
 }
 
 type ObservedReplicaSetConstraintStatus struct {
@@ -28,8 +124,10 @@ type ObservedReplicaSetConstraintStatus struct {
 	ReplicaSet                ReplicaSet
 	ConfiguredVolatileCount   uint
 	ConfiguredPersistentCount uint
+	ConfiguredArbiterCount    uint
 	ActualVolatileCount       uint
 	ActualPersistentCount     uint
+	ActualArbiterCount        uint
 }
 
 type ReplicaSetInitiationStatus struct {
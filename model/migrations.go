@@ -0,0 +1,276 @@
+package model
+
+import (
+	"fmt"
+	"github.com/jinzhu/gorm"
+	"sort"
+	"strings"
+	"time"
+)
+
+/*
+	Schema migrations
+
+	The initial schema is created from `model/sql/mamid_<dialect>.sql`.
+	Every subsequent schema change is shipped as an additional asset
+
+		model/sql/mamid_<dialect>_migration_<from version>_<to version>.sql
+
+	which upgrades the schema from exactly one schema version to the next.
+	Neither the initial schema nor migrations may contain transaction control statements:
+	the initial schema and all pending steps are applied in a single transaction, each together with the update of the
+	`schema_version` and `schema_migration_<from>_<to>` entries in `mamid_metadata`.
+	Hence a migration may rely on the schema changes of the preceding ones even in a dry run.
+
+	Remember to bump SCHEMA_VERSION when adding a migration.
+*/
+
+type SchemaMigration struct {
+	FromVersion string
+	ToVersion   string
+	asset       string
+}
+
+func (m SchemaMigration) String() string {
+	return fmt.Sprintf("%s -> %s", m.FromVersion, m.ToVersion)
+}
+
+func (m SchemaMigration) metadataKey() string {
+	return fmt.Sprintf("schema_migration_%s_%s", m.FromVersion, m.ToVersion)
+}
+
+const schemaVersionMetadataKey = "schema_version"
+
+func schemaAssetName(dialect string) string {
+	return fmt.Sprintf("model/sql/mamid_%s.sql", dialect)
+}
+
+func migrationAssetPrefix(dialect string) string {
+	return fmt.Sprintf("model/sql/mamid_%s_migration_", dialect)
+}
+
+// Parse the migrations for dialect from a list of asset names
+// Returns the migrations indexed by SchemaMigration.FromVersion
+func parseMigrationAssetNames(dialect string, assetNames []string) (migrations map[string]SchemaMigration, err error) {
+
+	prefix := migrationAssetPrefix(dialect)
+	migrations = make(map[string]SchemaMigration)
+
+	for _, name := range assetNames {
+
+		if !strings.HasPrefix(name, prefix) || !strings.HasSuffix(name, ".sql") {
+			continue
+		}
+
+		versions := strings.Split(strings.TrimSuffix(strings.TrimPrefix(name, prefix), ".sql"), "_")
+		if len(versions) != 2 || versions[0] == "" || versions[1] == "" {
+			return nil, fmt.Errorf("migration asset `%s` is not named `%s<from>_<to>.sql`", name, prefix)
+		}
+
+		if existing, exists := migrations[versions[0]]; exists {
+			return nil, fmt.Errorf("ambiguous migrations from schema version `%s`: `%s` and `%s`", versions[0], existing.asset, name)
+		}
+
+		migrations[versions[0]] = SchemaMigration{
+			FromVersion: versions[0],
+			ToVersion:   versions[1],
+			asset:       name,
+		}
+
+	}
+
+	return migrations, nil
+}
+
+// Compute the ordered list of migrations leading from schema version `from` to `to`
+func migrationPath(migrations map[string]SchemaMigration, from, to string) (path []SchemaMigration, err error) {
+
+	path = make([]SchemaMigration, 0)
+	visited := make(map[string]bool)
+
+	for current := from; current != to; {
+
+		if visited[current] {
+			return nil, fmt.Errorf("migrations contain a cycle at schema version `%s`", current)
+		}
+		visited[current] = true
+
+		step, exists := migrations[current]
+		if !exists {
+			return nil, fmt.Errorf("no migration from schema version `%s` towards `%s`", current, to)
+		}
+
+		path = append(path, step)
+		current = step.ToVersion
+
+	}
+
+	return path, nil
+}
+
+func sortedAssetNames() []string {
+	names := AssetNames()
+	sort.Strings(names)
+	return names
+}
+
+// Determine the migrations necessary to bring the database schema to SCHEMA_VERSION.
+// If the database has not been populated yet, uninitialized is true and
+// pending lists the migrations applied after the initial schema has been created.
+func (dbWrapper *DB) PendingMigrations() (pending []SchemaMigration, uninitialized bool, err error) {
+
+	version := ""
+	if dbWrapper.gormDB.HasTable(&MamidMetadata{}) {
+		if version, err = dbWrapper.schemaVersion(); err != nil {
+			return nil, false, fmt.Errorf("error determining schema version: %s", err)
+		}
+		if version == "" {
+			return nil, false, fmt.Errorf("the database contains `mamid_metadata` but no `%s`", schemaVersionMetadataKey)
+		}
+	} else {
+		uninitialized = true
+	}
+
+	dialect := dbWrapper.sqlDialect()
+
+	migrations, err := parseMigrationAssetNames(dialect, sortedAssetNames())
+	if err != nil {
+		return nil, uninitialized, err
+	}
+
+	if uninitialized {
+		// the initial schema is always the first version a migration starts from
+		version = initialSchemaVersion(migrations)
+	}
+
+	pending, err = migrationPath(migrations, version, SCHEMA_VERSION)
+	if err != nil {
+		return nil, uninitialized, fmt.Errorf("cannot migrate database from schema version `%s` to `%s`: %s", version, SCHEMA_VERSION, err)
+	}
+
+	return pending, uninitialized, nil
+}
+
+// The schema version created by the DDL asset, i.e. the only version no migration leads to.
+// Falls back to SCHEMA_VERSION if there are no migrations at all.
+func initialSchemaVersion(migrations map[string]SchemaMigration) string {
+	targets := make(map[string]bool)
+	for _, m := range migrations {
+		targets[m.ToVersion] = true
+	}
+	for from := range migrations {
+		if !targets[from] {
+			return from
+		}
+	}
+	return SCHEMA_VERSION
+}
+
+// Migrate the database schema to SCHEMA_VERSION.
+// The initial schema (if the database is uninitialized) and all pending migrations are applied in a single transaction,
+// i.e. either the database reaches SCHEMA_VERSION or it is left untouched.
+// With dryRun, that transaction is rolled back after the last migration.
+// Returns the migrations that were (or would have been) applied.
+func (dbWrapper *DB) Migrate(dryRun bool) (applied []SchemaMigration, err error) {
+
+	pending, uninitialized, err := dbWrapper.PendingMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	if !uninitialized && len(pending) == 0 {
+		return pending, nil
+	}
+
+	tx := dbWrapper.gormDB.Begin()
+	if err = tx.Error; err != nil {
+		return nil, fmt.Errorf("error starting migration transaction: %s", err)
+	}
+
+	if uninitialized {
+
+		if dryRun {
+			modelLog.Info("dry run: creating initial database schema")
+		} else {
+			modelLog.Info("creating initial database schema")
+		}
+
+		if err = createInitialSchema(tx, dbWrapper.sqlDialect(), pending); err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+
+	}
+
+	for _, m := range pending {
+
+		if dryRun {
+			modelLog.Infof("dry run: applying migration `%s`", m)
+		} else {
+			modelLog.Infof("applying migration `%s`", m)
+		}
+
+		if err = applyMigration(tx, m); err != nil {
+			tx.Rollback()
+			return nil, fmt.Errorf("error applying migration `%s`: %s", m, err)
+		}
+
+	}
+
+	if dryRun {
+		err = tx.Rollback().Error
+	} else {
+		err = tx.Commit().Error
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error finishing migration transaction: %s", err)
+	}
+
+	return pending, nil
+}
+
+// Run the DDL statements creating the initial schema and persist its version
+func createInitialSchema(tx *gorm.DB, dialect string, pending []SchemaMigration) (err error) {
+
+	ddlStatements, err := Asset(schemaAssetName(dialect))
+	if err != nil {
+		return fmt.Errorf("sql DDL data not found: %s", err)
+	}
+
+	if err = tx.Exec(string(ddlStatements), []interface{}{}).Error; err != nil {
+		return fmt.Errorf("error running DDL statements: %s", err)
+	}
+
+	initialVersion := SCHEMA_VERSION
+	if len(pending) > 0 {
+		initialVersion = pending[0].FromVersion
+	}
+
+	if err = setMetadata(tx, schemaVersionMetadataKey, initialVersion); err != nil {
+		return fmt.Errorf("error setting schema version: %s", err)
+	}
+
+	return nil
+}
+
+func applyMigration(tx *gorm.DB, m SchemaMigration) (err error) {
+
+	statements, err := Asset(m.asset)
+	if err != nil {
+		return fmt.Errorf("sql migration data not found: %s", err)
+	}
+
+	if err = tx.Exec(string(statements), []interface{}{}).Error; err != nil {
+		return fmt.Errorf("error running migration statements: %s", err)
+	}
+
+	if err = setMetadata(tx, m.metadataKey(), time.Now().UTC().Format(time.RFC3339)); err != nil {
+		return fmt.Errorf("error recording migration: %s", err)
+	}
+
+	if err = setMetadata(tx, schemaVersionMetadataKey, m.ToVersion); err != nil {
+		return fmt.Errorf("error setting schema version: %s", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,107 @@
+package model
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestParseMigrationAssetNames(t *testing.T) {
+
+	migrations, err := parseMigrationAssetNames("postgresql", []string{
+		"model/sql/mamid_postgresql.sql",
+		"model/sql/mamid_sqlite3_migration_0.0.1_0.0.2.sql",
+		"model/sql/mamid_postgresql_migration_0.0.1_0.0.2.sql",
+		"model/sql/mamid_postgresql_migration_0.0.2_0.1.0.sql",
+	})
+	assert.NoError(t, err)
+	assert.Len(t, migrations, 2, "should only consider migrations of the requested dialect")
+	assert.Equal(t, "0.0.2", migrations["0.0.1"].ToVersion)
+	assert.Equal(t, "0.1.0", migrations["0.0.2"].ToVersion)
+
+	_, err = parseMigrationAssetNames("postgresql", []string{
+		"model/sql/mamid_postgresql_migration_0.0.1.sql",
+	})
+	assert.Error(t, err, "should not accept migrations without target version")
+
+	_, err = parseMigrationAssetNames("postgresql", []string{
+		"model/sql/mamid_postgresql_migration_0.0.1_0.0.2.sql",
+		"model/sql/mamid_postgresql_migration_0.0.1_0.0.3.sql",
+	})
+	assert.Error(t, err, "should not accept two migrations from the same version")
+
+}
+
+func TestMigrationPath(t *testing.T) {
+
+	migrations := map[string]SchemaMigration{
+		"0.0.1": {FromVersion: "0.0.1", ToVersion: "0.0.2"},
+		"0.0.2": {FromVersion: "0.0.2", ToVersion: "0.1.0"},
+	}
+
+	path, err := migrationPath(migrations, "0.0.1", "0.1.0")
+	assert.NoError(t, err)
+	assert.Equal(t, []SchemaMigration{migrations["0.0.1"], migrations["0.0.2"]}, path)
+
+	path, err = migrationPath(migrations, "0.0.2", "0.1.0")
+	assert.NoError(t, err)
+	assert.Equal(t, []SchemaMigration{migrations["0.0.2"]}, path)
+
+	path, err = migrationPath(migrations, "0.1.0", "0.1.0")
+	assert.NoError(t, err)
+	assert.Empty(t, path, "should not migrate if already at target version")
+
+	_, err = migrationPath(migrations, "0.0.0", "0.1.0")
+	assert.Error(t, err, "should not find a path from an unknown version")
+
+	_, err = migrationPath(migrations, "0.1.0", "0.0.1")
+	assert.Error(t, err, "should not migrate downwards")
+
+	migrations["0.1.0"] = SchemaMigration{FromVersion: "0.1.0", ToVersion: "0.0.1"}
+	_, err = migrationPath(migrations, "0.0.1", "0.2.0")
+	assert.Error(t, err, "should detect cycles")
+
+}
+
+func TestInitialSchemaVersion(t *testing.T) {
+
+	assert.Equal(t, SCHEMA_VERSION, initialSchemaVersion(map[string]SchemaMigration{}))
+
+	assert.Equal(t, "0.0.1", initialSchemaVersion(map[string]SchemaMigration{
+		"0.0.2": {FromVersion: "0.0.2", ToVersion: "0.1.0"},
+		"0.0.1": {FromVersion: "0.0.1", ToVersion: "0.0.2"},
+	}))
+
+}
+
+func TestMigrate_dryRunFromInitialSchema(t *testing.T) {
+
+	db, _, err := openEmptyTestDB()
+	assert.NoError(t, err)
+	defer db.CloseAndDrop()
+
+	pending, uninitialized, err := db.PendingMigrations()
+	assert.NoError(t, err)
+	assert.True(t, uninitialized)
+	tx := db.Begin()
+	assert.NoError(t, createInitialSchema(tx, db.sqlDialect(), pending))
+	assert.NoError(t, tx.Commit().Error)
+
+	version, err := db.schemaVersion()
+	assert.NoError(t, err)
+	assert.Equal(t, "0.0.1", version)
+
+	applied, err := db.Migrate(true)
+	assert.NoError(t, err, "later migrations should see the changes of earlier ones")
+	assert.Equal(t, pending, applied)
+	version, err = db.schemaVersion()
+	assert.NoError(t, err)
+	assert.Equal(t, "0.0.1", version, "a dry run should not change the schema version")
+
+	applied, err = db.Migrate(false)
+	assert.NoError(t, err)
+	assert.Equal(t, pending, applied)
+	version, err = db.schemaVersion()
+	assert.NoError(t, err)
+	assert.Equal(t, SCHEMA_VERSION, version)
+
+}
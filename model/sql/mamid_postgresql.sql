@@ -1,5 +1,3 @@
-START TRANSACTION;
-
 CREATE DOMAIN sharding_role AS VARCHAR(255) CHECK( value IN ('none', 'shardsvr', 'configsvr'));
 
 CREATE TABLE "risk_groups" (
@@ -158,5 +156,3 @@ CREATE TABLE "mongodb_root_credentials" (
 	"username" VARCHAR(255) NOT NULL,
 	"password" VARCHAR(255) NOT NULL
 );
-
-COMMIT;
@@ -0,0 +1,16 @@
+-- Host metrics: the latest sample reported by every Slave
+CREATE TABLE "slave_host_metrics" (
+	"id" BIGSERIAL PRIMARY KEY,
+	"sampled" TIMESTAMP NOT NULL,
+	"cpu_count" INTEGER NOT NULL DEFAULT 0,
+	"load_average1" DOUBLE PRECISION NOT NULL DEFAULT 0,
+	"load_average5" DOUBLE PRECISION NOT NULL DEFAULT 0,
+	"load_average15" DOUBLE PRECISION NOT NULL DEFAULT 0,
+	"memory_total_bytes" BIGINT NOT NULL DEFAULT 0,
+	"memory_available_bytes" BIGINT NOT NULL DEFAULT 0,
+	"swap_total_bytes" BIGINT NOT NULL DEFAULT 0,
+	"swap_free_bytes" BIGINT NOT NULL DEFAULT 0,
+	"data_volume_total_bytes" BIGINT NOT NULL DEFAULT 0,
+	"data_volume_available_bytes" BIGINT NOT NULL DEFAULT 0,
+	"slave_id" BIGINT NOT NULL UNIQUE REFERENCES slaves(id) ON DELETE CASCADE DEFERRABLE INITIALLY DEFERRED
+);
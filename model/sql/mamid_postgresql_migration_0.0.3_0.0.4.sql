@@ -0,0 +1,12 @@
+-- Drain-and-evacuate workflow for Slaves
+CREATE TABLE "slave_drains" (
+	"id" BIGSERIAL PRIMARY KEY,
+	"state" INTEGER NOT NULL,
+	"state_description" TEXT NOT NULL DEFAULT '',
+	"started" TIMESTAMP NOT NULL,
+	"finished" TIMESTAMP NULL,
+	"slave_id" BIGINT NOT NULL UNIQUE REFERENCES slaves(id) ON DELETE CASCADE DEFERRABLE INITIALLY DEFERRED,
+	"replica_set_id" BIGINT NULL REFERENCES replica_sets(id) ON DELETE SET NULL DEFERRABLE INITIALLY DEFERRED,
+	"drained_mongod_id" BIGINT NULL REFERENCES mongods(id) ON DELETE SET NULL DEFERRABLE INITIALLY DEFERRED,
+	"replacement_mongod_id" BIGINT NULL REFERENCES mongods(id) ON DELETE SET NULL DEFERRABLE INITIALLY DEFERRED
+);
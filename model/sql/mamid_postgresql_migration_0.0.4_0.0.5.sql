@@ -0,0 +1,12 @@
+-- Rebalancing: members of Replica Sets moved between Slaves
+CREATE TABLE "mongod_moves" (
+	"id" BIGSERIAL PRIMARY KEY,
+	"state" INTEGER NOT NULL,
+	"started" TIMESTAMP NOT NULL,
+	"finished" TIMESTAMP NULL,
+	"replica_set_id" BIGINT NULL REFERENCES replica_sets(id) ON DELETE SET NULL DEFERRABLE INITIALLY DEFERRED,
+	"source_slave_id" BIGINT NULL REFERENCES slaves(id) ON DELETE SET NULL DEFERRABLE INITIALLY DEFERRED,
+	"target_slave_id" BIGINT NULL REFERENCES slaves(id) ON DELETE SET NULL DEFERRABLE INITIALLY DEFERRED,
+	"source_mongod_id" BIGINT NULL REFERENCES mongods(id) ON DELETE SET NULL DEFERRABLE INITIALLY DEFERRED,
+	"replacement_mongod_id" BIGINT NULL REFERENCES mongods(id) ON DELETE SET NULL DEFERRABLE INITIALLY DEFERRED
+);
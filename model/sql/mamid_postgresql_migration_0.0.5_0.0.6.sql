@@ -0,0 +1,47 @@
+-- Arbiter members: vote in elections but hold no data
+ALTER TABLE replica_sets ADD COLUMN arbiter_member_count INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE mongods ADD COLUMN arbiter BOOLEAN NOT NULL DEFAULT false;
+
+-- member views distinguish arbiters from data-bearing members, new columns are appended
+CREATE OR REPLACE VIEW replica_set_effective_members AS
+	SELECT r.id as replica_set_id, m.id as mongod_id, s.persistent_storage, m.arbiter
+	FROM replica_sets r
+	JOIN mongods m ON m.replica_set_id = r.id
+	JOIN slaves s ON s.id = m.parent_slave_id
+	JOIN mongod_states observed ON observed.id = m.observed_state_id
+	JOIN mongod_states desired ON desired.id = m.desired_state_id
+	WHERE
+	observed.execution_state = 6 -- running
+	AND
+	desired.execution_state = 6; -- running
+
+CREATE OR REPLACE VIEW replica_set_effective_members_for_monitoring AS
+	SELECT r.id as replica_set_id, m.id as mongod_id, s.persistent_storage, m.arbiter
+	FROM replica_sets r
+	JOIN mongods m ON m.replica_set_id = r.id
+	JOIN slaves s ON s.id = m.parent_slave_id
+	JOIN mongod_states observed ON observed.id = m.observed_state_id
+	JOIN mongod_states desired ON desired.id = m.desired_state_id
+	WHERE
+	observed.execution_state = 6 AND s.observation_error_id IS NULL -- running
+	AND
+	desired.execution_state = 6; -- running
+
+CREATE OR REPLACE VIEW replica_set_configured_members AS
+	SELECT
+		r.id as replica_set_id,
+		m.id as mongod_id,
+		s.persistent_storage,
+		m.arbiter
+	FROM replica_sets r
+	JOIN mongods m ON m.replica_set_id = r.id
+	JOIN mongod_states desired_state ON m.desired_state_id = desired_state.id
+	JOIN slaves s ON m.parent_slave_id = s.id
+	WHERE
+		s.configured_state != 3 -- disabled
+		AND
+		desired_state.execution_state NOT IN (
+		3, -- not running
+		2, -- destroyed
+		1 -- force destroyed
+	);
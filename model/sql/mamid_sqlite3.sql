@@ -1,80 +1,71 @@
-CREATE TABLE slaves (
-	"id" integer primary key autoincrement,
-	"hostname" varchar(255),
-	"port" integer,
-	"mongod_port_range_begin" integer,
-	"mongod_port_range_end" integer,
-	"persistent_storage" bool,
-	"configured_state" integer,
-	"risk_group_id" integer NULL REFERENCES risk_groups(id) DEFERRABLE INITIALLY DEFERRED,
-	"observation_error_id" integer NULL REFERENCES msp_errors(id) ON DELETE SET NULL DEFERRABLE INITIALLY DEFERRED
-);
+-- NOTE: SQLite only enforces foreign keys if enabled per connection, see model.OpenDB()
 
--- CREATE UNIQUE INDEX uix_slaves_hostname ON "slaves"("hostname")
+CREATE TABLE "risk_groups" (
+	"id" INTEGER PRIMARY KEY AUTOINCREMENT,
+	"name" VARCHAR(255) UNIQUE
+);
 
 CREATE TABLE "replica_sets" (
-	"id" integer primary key autoincrement,
-	"name" varchar(255),
-	"persistent_member_count" integer,
-	"volatile_member_count" integer,
-	"configure_as_sharding_config_server" bool
+	"id" INTEGER PRIMARY KEY AUTOINCREMENT,
+	"name" VARCHAR(255) UNIQUE,
+	"persistent_member_count" INTEGER,
+	"volatile_member_count" INTEGER,
+	"sharding_role" VARCHAR(255) NOT NULL CHECK( sharding_role IN ('none', 'shardsvr', 'configsvr')),
+	"initiated" BOOLEAN NOT NULL
 );
 
--- CREATE UNIQUE INDEX uix_replica_sets_name ON "replica_sets"("name");
-
-CREATE TABLE "risk_groups" (
-	"id" integer primary key autoincrement,
-	"name" varchar(255)
+CREATE TABLE "msp_errors" (
+	"id" INTEGER PRIMARY KEY AUTOINCREMENT,
+	"identifier" VARCHAR(255),
+	"description" TEXT,
+	"long_description" TEXT
 );
 
--- CREATE UNIQUE INDEX uix_risk_groups_name ON "risk_groups"("name");
-
-CREATE TABLE "mongods" (
-	"id" integer primary key autoincrement,
-	"port" integer,
-	"repl_set_name" varchar(255),
-	"observation_error_id" integer NULL REFERENCES msp_errors(id) ON DELETE SET NULL DEFERRABLE INITIALLY DEFERRED,
-	"last_establish_state_error_id" integer NULL REFERENCES msp_errors(id) ON DELETE SET NULL DEFERRABLE INITIALLY DEFERRED,
-	"parent_slave_id" integer REFERENCES slaves(id) DEFERRABLE INITIALLY DEFERRED,
-	"replica_set_id" integer NULL REFERENCES replica_sets(id) ON DELETE SET NULL DEFERRABLE INITIALLY DEFERRED,
-	"desired_state_id" integer NOT NULL REFERENCES mongod_states(id) ON DELETE CASCADE DEFERRABLE INITIALLY DEFERRED,
-	"observed_state_id" integer NULL REFERENCES mongod_states(id) ON DELETE SET NULL DEFERRABLE INITIALLY DEFERRED
+CREATE TABLE "slaves" (
+	"id" INTEGER PRIMARY KEY AUTOINCREMENT,
+	"hostname" VARCHAR(255) UNIQUE,
+	"port" INTEGER,
+	"mongod_port_range_begin" INTEGER,
+	"mongod_port_range_end" INTEGER,
+	"persistent_storage" BOOLEAN,
+	"configured_state" INTEGER,
+	"risk_group_id" INTEGER NULL REFERENCES risk_groups(id) DEFERRABLE INITIALLY DEFERRED,
+	"observation_error_id" INTEGER NULL REFERENCES msp_errors(id) ON DELETE SET NULL DEFERRABLE INITIALLY DEFERRED
 );
 
+-- SQLite allows forward references in foreign key constraints, hence no ALTER TABLE as in PostgreSQL
 CREATE TABLE "mongod_states" (
-	"id" integer primary key autoincrement,
-	"parent_mongod_id" integer NOT NULL REFERENCES mongods(id) ON DELETE CASCADE DEFERRABLE INITIALLY DEFERRED,
-	"is_sharding_config_server" bool,
-	"execution_state" integer
+	"id" INTEGER PRIMARY KEY AUTOINCREMENT,
+	"parent_mongod_id" INTEGER NOT NULL REFERENCES mongods(id) ON DELETE CASCADE DEFERRABLE INITIALLY DEFERRED,
+	"sharding_role" VARCHAR(255) CHECK( sharding_role IN ('none', 'shardsvr', 'configsvr')),
+	"execution_state" INTEGER
 );
 
-CREATE TABLE "replica_set_members" (
-	"id" integer primary key autoincrement,
-	"hostname" varchar(255),
-	"port" integer,
-	"mongod_state_id" integer REFERENCES mongod_states(id) DEFERRABLE INITIALLY DEFERRED
+CREATE TABLE "mongods" (
+	"id" INTEGER PRIMARY KEY AUTOINCREMENT,
+	"port" INTEGER,
+	"repl_set_name" VARCHAR(255),
+	"observation_error_id" INTEGER NULL REFERENCES msp_errors(id) ON DELETE SET NULL DEFERRABLE INITIALLY DEFERRED, -- error encountered when observing this specific Mongod
+	"last_establish_state_error_id" INTEGER NULL REFERENCES msp_errors(id) ON DELETE SET NULL DEFERRABLE INITIALLY DEFERRED,
+	"parent_slave_id" INTEGER REFERENCES slaves(id) ON DELETE CASCADE DEFERRABLE INITIALLY DEFERRED,
+	"replica_set_id" INTEGER NULL REFERENCES replica_sets(id) ON DELETE SET NULL DEFERRABLE INITIALLY DEFERRED,
+	"desired_state_id" INTEGER NOT NULL REFERENCES mongod_states(id) ON DELETE CASCADE DEFERRABLE INITIALLY DEFERRED,
+	"observed_state_id" INTEGER NULL REFERENCES mongod_states(id) ON DELETE SET NULL DEFERRABLE INITIALLY DEFERRED
+	-- see mamid_postgresql.sql for the semantics of observation_error_id and observed_state_id
 );
 
 CREATE TABLE "problems" (
-	"id" integer primary key autoincrement,
-	"description" varchar(255),
-	"long_description" varchar(255),
-	"problem_type" integer,
-	"first_occurred" datetime,
-	"last_updated" datetime,
-	"slave_id" integer NULL REFERENCES slaves(id) ON DELETE CASCADE DEFERRABLE INITIALLY DEFERRED,
-	"replica_set_id" integer NULL REFERENCES replica_sets(id) ON DELETE CASCADE DEFERRABLE INITIALLY DEFERRED,
-	"mongod_id" integer NULL REFERENCES mongods(id) ON DELETE CASCADE DEFERRABLE INITIALLY DEFERRED
-);
-
-CREATE TABLE "msp_errors" (
-	"id" integer primary key autoincrement,
-	"identifier" varchar(255),
-	"description" varchar(255),
-	"long_description" varchar(255)
+	"id" INTEGER PRIMARY KEY AUTOINCREMENT,
+	"description" TEXT,
+	"long_description" TEXT,
+	"problem_type" INTEGER,
+	"first_occurred" TIMESTAMP,
+	"last_updated" TIMESTAMP,
+	"slave_id" INTEGER NULL REFERENCES slaves(id) ON DELETE CASCADE DEFERRABLE INITIALLY DEFERRED,
+	"replica_set_id" INTEGER NULL REFERENCES replica_sets(id) ON DELETE CASCADE DEFERRABLE INITIALLY DEFERRED,
+	"mongod_id" INTEGER NULL REFERENCES mongods(id) ON DELETE CASCADE DEFERRABLE INITIALLY DEFERRED
 );
 
-DROP VIEW IF EXISTS replica_set_effective_members;
 CREATE VIEW replica_set_effective_members AS
 	SELECT r.id as replica_set_id, m.id as mongod_id, s.persistent_storage
 	FROM replica_sets r
@@ -83,15 +74,25 @@ CREATE VIEW replica_set_effective_members AS
 	JOIN mongod_states observed ON observed.id = m.observed_state_id
 	JOIN mongod_states desired ON desired.id = m.desired_state_id
 	WHERE
-	observed.execution_state = 4 -- running
+	observed.execution_state = 6 -- running
 	AND
-	desired.execution_state = 4; -- running
+	desired.execution_state = 6; -- running
 
+CREATE VIEW replica_set_effective_members_for_monitoring AS
+	SELECT r.id as replica_set_id, m.id as mongod_id, s.persistent_storage
+	FROM replica_sets r
+	JOIN mongods m ON m.replica_set_id = r.id
+	JOIN slaves s ON s.id = m.parent_slave_id
+	JOIN mongod_states observed ON observed.id = m.observed_state_id
+	JOIN mongod_states desired ON desired.id = m.desired_state_id
+	WHERE
+	observed.execution_state = 6 AND s.observation_error_id IS NULL -- running
+	AND
+	desired.execution_state = 6; -- running
 
-DROP VIEW IF EXISTS slave_utilization;
 CREATE VIEW slave_utilization AS
 	SELECT
-		*,
+		subquery.*,
 		CASE WHEN max_mongods = 0 THEN 1 ELSE current_mongods*1.0/max_mongods END AS utilization,
 		(max_mongods - current_mongods) AS free_mongods
 	FROM
@@ -103,9 +104,8 @@ CREATE VIEW slave_utilization AS
 			FROM slaves s
 			LEFT OUTER JOIN mongods m ON m.parent_slave_id = s.id
 			GROUP BY s.id
-		);
+		) subquery;
 
-DROP VIEW IF EXISTS replica_set_configured_members;
 CREATE VIEW replica_set_configured_members AS
 	SELECT
 		r.id as replica_set_id,
@@ -119,7 +119,25 @@ CREATE VIEW replica_set_configured_members AS
 		s.configured_state != 3 -- disabled
 		AND
 		desired_state.execution_state NOT IN (
-		2 -- not running
-		,1 -- destroyed
+		3, -- not running
+		2, -- destroyed
+		1 -- force destroyed
 	);
 
+CREATE TABLE "mamid_metadata" (
+	"key" VARCHAR(255) PRIMARY KEY,
+	"value" TEXT
+);
+
+-- Mongod "keyfiles" for "Internal Authentication" between Mongods in a Replica Set
+CREATE TABLE "mongod_keyfiles" (
+	"id" INTEGER PRIMARY KEY AUTOINCREMENT,
+	"content" TEXT NOT NULL
+);
+
+-- MongoDB credentials with "root" role used by MAMID to configure the cluster
+CREATE TABLE "mongodb_root_credentials" (
+	"id" INTEGER PRIMARY KEY AUTOINCREMENT,
+	"username" VARCHAR(255) NOT NULL,
+	"password" VARCHAR(255) NOT NULL
+);
@@ -0,0 +1,16 @@
+-- Host metrics: the latest sample reported by every Slave
+CREATE TABLE "slave_host_metrics" (
+	"id" INTEGER PRIMARY KEY AUTOINCREMENT,
+	"sampled" TIMESTAMP NOT NULL,
+	"cpu_count" INTEGER NOT NULL DEFAULT 0,
+	"load_average1" REAL NOT NULL DEFAULT 0,
+	"load_average5" REAL NOT NULL DEFAULT 0,
+	"load_average15" REAL NOT NULL DEFAULT 0,
+	"memory_total_bytes" INTEGER NOT NULL DEFAULT 0,
+	"memory_available_bytes" INTEGER NOT NULL DEFAULT 0,
+	"swap_total_bytes" INTEGER NOT NULL DEFAULT 0,
+	"swap_free_bytes" INTEGER NOT NULL DEFAULT 0,
+	"data_volume_total_bytes" INTEGER NOT NULL DEFAULT 0,
+	"data_volume_available_bytes" INTEGER NOT NULL DEFAULT 0,
+	"slave_id" INTEGER NOT NULL UNIQUE REFERENCES slaves(id) ON DELETE CASCADE DEFERRABLE INITIALLY DEFERRED
+);
@@ -0,0 +1,22 @@
+-- Replication monitoring: oplog window of every Replica Set and replication lag of every member, computed from the PRIMARY's status
+CREATE TABLE "replica_set_replications" (
+	"id" INTEGER PRIMARY KEY AUTOINCREMENT,
+	"observed" TIMESTAMP NOT NULL,
+	"oplog_first" TIMESTAMP NULL,
+	"oplog_last" TIMESTAMP NULL,
+	"oplog_window_seconds" REAL NOT NULL DEFAULT 0,
+	"oplog_size_bytes" INTEGER NOT NULL DEFAULT 0,
+	"oplog_max_size_bytes" INTEGER NOT NULL DEFAULT 0,
+	"replica_set_id" INTEGER NOT NULL UNIQUE REFERENCES replica_sets(id) ON DELETE CASCADE DEFERRABLE INITIALLY DEFERRED,
+	"primary_mongod_id" INTEGER NULL REFERENCES mongods(id) ON DELETE SET NULL DEFERRABLE INITIALLY DEFERRED
+);
+
+CREATE TABLE "mongod_replications" (
+	"id" INTEGER PRIMARY KEY AUTOINCREMENT,
+	"observed" TIMESTAMP NOT NULL,
+	"member_state" TEXT NOT NULL DEFAULT '',
+	"optime" TIMESTAMP NULL,
+	"lag_seconds" REAL NOT NULL DEFAULT 0,
+	"mongod_id" INTEGER NOT NULL UNIQUE REFERENCES mongods(id) ON DELETE CASCADE DEFERRABLE INITIALLY DEFERRED,
+	"replica_set_id" INTEGER NOT NULL REFERENCES replica_sets(id) ON DELETE CASCADE DEFERRABLE INITIALLY DEFERRED
+);
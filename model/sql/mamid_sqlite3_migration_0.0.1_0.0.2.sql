@@ -0,0 +1,2 @@
+-- Per Replica Set override of the ClusterAllocator's placement strategy, empty for the default
+ALTER TABLE replica_sets ADD COLUMN placement_strategy VARCHAR(255) NOT NULL DEFAULT '';
@@ -0,0 +1,3 @@
+-- Hierarchical failure domains: risk groups may be nested
+ALTER TABLE risk_groups ADD COLUMN level VARCHAR(255) NOT NULL DEFAULT '';
+ALTER TABLE risk_groups ADD COLUMN parent_id INTEGER NULL REFERENCES risk_groups(id) DEFERRABLE INITIALLY DEFERRED;
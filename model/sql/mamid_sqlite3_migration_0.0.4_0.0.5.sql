@@ -0,0 +1,12 @@
+-- Rebalancing: members of Replica Sets moved between Slaves
+CREATE TABLE "mongod_moves" (
+	"id" INTEGER PRIMARY KEY AUTOINCREMENT,
+	"state" INTEGER NOT NULL,
+	"started" TIMESTAMP NOT NULL,
+	"finished" TIMESTAMP NULL,
+	"replica_set_id" INTEGER NULL REFERENCES replica_sets(id) ON DELETE SET NULL DEFERRABLE INITIALLY DEFERRED,
+	"source_slave_id" INTEGER NULL REFERENCES slaves(id) ON DELETE SET NULL DEFERRABLE INITIALLY DEFERRED,
+	"target_slave_id" INTEGER NULL REFERENCES slaves(id) ON DELETE SET NULL DEFERRABLE INITIALLY DEFERRED,
+	"source_mongod_id" INTEGER NULL REFERENCES mongods(id) ON DELETE SET NULL DEFERRABLE INITIALLY DEFERRED,
+	"replacement_mongod_id" INTEGER NULL REFERENCES mongods(id) ON DELETE SET NULL DEFERRABLE INITIALLY DEFERRED
+);
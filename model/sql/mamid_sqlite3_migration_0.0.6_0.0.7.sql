@@ -0,0 +1,6 @@
+-- Hidden and delayed members: the ClusterAllocator designates data-bearing members of a Replica Set
+ALTER TABLE replica_sets ADD COLUMN hidden_member_count INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE replica_sets ADD COLUMN delayed_member_count INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE replica_sets ADD COLUMN slave_delay_seconds INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE mongods ADD COLUMN hidden BOOLEAN NOT NULL DEFAULT 0;
+ALTER TABLE mongods ADD COLUMN delayed BOOLEAN NOT NULL DEFAULT 0;
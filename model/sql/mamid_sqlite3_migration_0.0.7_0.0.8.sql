@@ -0,0 +1,6 @@
+-- Mongod tuning options per Replica Set, empty or zero values use the Mongod defaults
+ALTER TABLE replica_sets ADD COLUMN storage_engine VARCHAR(255) NOT NULL DEFAULT '';
+ALTER TABLE replica_sets ADD COLUMN wired_tiger_cache_size_gb REAL NOT NULL DEFAULT 0;
+ALTER TABLE replica_sets ADD COLUMN oplog_size_mb INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE replica_sets ADD COLUMN bind_ip VARCHAR(255) NOT NULL DEFAULT '';
+ALTER TABLE replica_sets ADD COLUMN journal_disabled BOOLEAN NOT NULL DEFAULT 0;
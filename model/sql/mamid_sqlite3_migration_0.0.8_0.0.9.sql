@@ -0,0 +1,3 @@
+-- Resource requests per member of a Replica Set, enforced as limits by the slaves, 0 is unlimited
+ALTER TABLE replica_sets ADD COLUMN memory_request_mb INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE replica_sets ADD COLUMN cpu_request_millicores INTEGER NOT NULL DEFAULT 0;
@@ -0,0 +1,34 @@
+-- Capacity-aware scheduling: Slaves declare resource capacities, Replica Sets request disk per member, 0 is undeclared
+ALTER TABLE slaves ADD COLUMN memory_capacity_mb INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE slaves ADD COLUMN cpu_capacity_millicores INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE slaves ADD COLUMN disk_capacity_gb INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE replica_sets ADD COLUMN disk_request_gb INTEGER NOT NULL DEFAULT 0;
+
+-- Resources are requested by the data-bearing members hosted by a Slave.
+-- The utilization of a Slave is the highest utilization of its Mongod port range and its declared resources.
+-- The view is recreated as `s.*` was expanded on creation.
+DROP VIEW slave_utilization;
+CREATE VIEW slave_utilization AS
+	SELECT
+		subquery.*,
+		MAX(
+			CASE WHEN max_mongods = 0 THEN 1 ELSE current_mongods*1.0/max_mongods END,
+			CASE WHEN memory_capacity_mb = 0 THEN 0 ELSE requested_memory_mb*1.0/memory_capacity_mb END,
+			CASE WHEN cpu_capacity_millicores = 0 THEN 0 ELSE requested_cpu_millicores*1.0/cpu_capacity_millicores END,
+			CASE WHEN disk_capacity_gb = 0 THEN 0 ELSE requested_disk_gb*1.0/disk_capacity_gb END
+		) AS utilization,
+		(max_mongods - current_mongods) AS free_mongods
+	FROM
+		(
+			SELECT
+				s.*,
+				s.mongod_port_range_end - s.mongod_port_range_begin AS max_mongods,
+				COUNT(DISTINCT m.id) as current_mongods,
+				COALESCE(SUM(CASE WHEN m.arbiter THEN 0 ELSE r.memory_request_mb END), 0) AS requested_memory_mb,
+				COALESCE(SUM(CASE WHEN m.arbiter THEN 0 ELSE r.cpu_request_millicores END), 0) AS requested_cpu_millicores,
+				COALESCE(SUM(CASE WHEN m.arbiter THEN 0 ELSE r.disk_request_gb END), 0) AS requested_disk_gb
+			FROM slaves s
+			LEFT OUTER JOIN mongods m ON m.parent_slave_id = s.id
+			LEFT OUTER JOIN replica_sets r ON r.id = m.replica_set_id
+			GROUP BY s.id
+		) subquery;
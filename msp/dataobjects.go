@@ -2,6 +2,7 @@ package msp
 
 import (
 	"fmt"
+	"time"
 )
 
 type MongodState string
@@ -56,9 +57,12 @@ func (m MongodCredential) GoString() string {
 }
 
 type ReplicaSetMember struct {
-	HostPort HostPort
-	Priority float64
-	Votes    int
+	HostPort    HostPort
+	Priority    float64
+	Votes       int
+	ArbiterOnly bool  // the member holds no data and only votes in elections
+	Hidden      bool  // the member is invisible to clients, requires Priority 0
+	SlaveDelay  int64 // seconds the member lags behind the PRIMARY, requires Hidden
 }
 
 type Mongod struct {
@@ -68,18 +72,96 @@ type Mongod struct {
 	StatusError             *Error
 	LastEstablishStateError *Error
 	State                   MongodState
+	Options                 MongodOptions // desired options or, when reported by the slave, the options the Mongod is running with
+	Resources               MongodResources
+	ResourceUsage           *MongodResourceUsage // only reported by the slave, nil if the Mongod is not isolated
+	Replication             *ReplicationStatus   // only reported by the slave, nil unless the Mongod is a member of an initiated Replica Set
 }
 
 func (m Mongod) GoString() string {
-	return fmt.Sprintf("msp.Mongod{Port: %d, KeyfileContent:\"<redacted>\", ReplicaSetConfig:%#v, StatusError:%#v, LastEstablishStateError:%#v, State:%#v}",
-		m.Port, m.ReplicaSetConfig, m.StatusError, m.LastEstablishStateError, m.State)
+	return fmt.Sprintf("msp.Mongod{Port: %d, KeyfileContent:\"<redacted>\", ReplicaSetConfig:%#v, StatusError:%#v, LastEstablishStateError:%#v, State:%#v, Options:%#v, Resources:%#v, ResourceUsage:%#v, Replication:%#v}",
+		m.Port, m.ReplicaSetConfig, m.StatusError, m.LastEstablishStateError, m.State, m.Options, m.Resources, m.ResourceUsage, m.Replication)
+}
+
+const (
+	StorageEngineWiredTiger = "wiredTiger"
+	StorageEngineMMAPv1     = "mmapv1"
+)
+
+// Tuning options of a Mongod rendered into its config file by the slave
+// Zero values leave the Mongod's defaults in effect.
+type MongodOptions struct {
+	StorageEngine         string  // StorageEngineWiredTiger or StorageEngineMMAPv1
+	WiredTigerCacheSizeGB float64 // only valid with StorageEngineWiredTiger
+	OplogSizeMB           uint    // only effective when the oplog is created, i.e. on the first start of the Mongod
+	BindIP                string  // comma separated list of IP addresses
+	JournalDisabled       bool
+}
+
+// Resource limits of a Mongod enforced by the slave, zero values are unlimited
+type MongodResources struct {
+	MemoryLimitBytes   uint64
+	CPULimitMillicores uint // 1000 millicores correspond to one CPU
+}
+
+type MongodResourceUsage struct {
+	Limits               MongodResources // limits in effect
+	MemoryCurrentBytes   uint64
+	CPUUsageMicroseconds uint64 // total CPU time consumed since the Mongod's isolation
+}
+
+// Response to a status request
+type SlaveStatus struct {
+	Mongods     []Mongod
+	HostMetrics *HostMetrics // nil if the Slave could not sample its host
 }
 
+// Resource usage of the Slave's host, sampled when the status is requested
+type HostMetrics struct {
+	CPUCount                 uint
+	LoadAverage1             float64
+	LoadAverage5             float64
+	LoadAverage15            float64
+	MemoryTotalBytes         uint64
+	MemoryAvailableBytes     uint64 // estimate of the memory available without swapping, see `MemAvailable` in proc(5)
+	SwapTotalBytes           uint64
+	SwapFreeBytes            uint64
+	DataVolumeTotalBytes     uint64 // the volume containing the Slave's data directory
+	DataVolumeAvailableBytes uint64 // available to unprivileged users
+}
+
+// Replication status as seen by a member of a Replica Set, see replSetGetStatus
+type ReplicationStatus struct {
+	Members           []ReplicationMemberStatus // including the reporting member
+	OplogFirst        time.Time                 // timestamp of the oldest entry in the reporting member's oplog, zero if unknown, e.g. for arbiters
+	OplogLast         time.Time                 // timestamp of the newest entry
+	OplogSizeBytes    uint64
+	OplogMaxSizeBytes uint64 // the oplog is a capped collection, its oldest entries are overwritten once it is full
+}
+
+type ReplicationMemberStatus struct {
+	HostPort HostPort
+	State    string    // e.g. `PRIMARY` or `SECONDARY`, see `stateStr` in replSetGetStatus
+	Optime   time.Time // the last operation applied by the member, zero if unknown, e.g. for arbiters
+	Self     bool      // the reporting member
+}
+
+const ReplicationMemberStatePrimary = "PRIMARY"
+
 type RsInitiateMessage struct {
 	Port             PortNumber
 	ReplicaSetConfig ReplicaSetConfig
 }
 
+// Number of log lines returned if not specified otherwise
+const DefaultMongodLogTail uint = 100
+
+// Tail of the log file of a Mongod (stdout & stderr)
+type MongodLog struct {
+	Port  PortNumber
+	Lines []string // oldest first
+}
+
 type Error struct {
 	// See constants in this package for list of identifiers
 	Identifier      string
@@ -116,3 +198,5 @@ const SlaveReplicaSetConfigError string = "SLAVEREPLSETCONFIG"
 const SlaveMongodProtocolError string = "SLAVEMONGODPROTOERR"
 const NotImplementedError string = "NOTIMPLEMENTED"
 const SlaveShutdownError string = "SLAVESHUTDOWNERR"
+const SlaveMongodCrashLoopError string = "SLAVEMONGODCRASHLOOP" // Mongod crashed repeatedly and is no longer restarted by the slave
+const SlaveMongodLogError string = "SLAVEMONGODLOG"
@@ -11,9 +11,10 @@ import (
 var mspLog = logrus.WithField("module", "msp")
 
 type MSPClient interface {
-	RequestStatus(Target HostPort) ([]Mongod, *Error)
+	RequestStatus(Target HostPort) (SlaveStatus, *Error)
 	InitiateReplicaSet(Target HostPort, msg RsInitiateMessage) *Error
 	EstablishMongodState(Target HostPort, m Mongod) *Error
+	MongodLog(Target HostPort, port PortNumber, tail uint) (MongodLog, *Error)
 }
 
 type MSPClientImpl struct {
@@ -28,14 +29,14 @@ func communicationErrorFromError(err error) *Error {
 	}
 }
 
-func (c MSPClientImpl) RequestStatus(Target HostPort) ([]Mongod, *Error) {
+func (c MSPClientImpl) RequestStatus(Target HostPort) (SlaveStatus, *Error) {
 	resp, err := c.HttpClient.Get(fmt.Sprintf("%smsp/status", constructBaseUrl(Target)))
 	if err == nil {
 		if resp.StatusCode == http.StatusOK {
-			var result []Mongod
+			var result SlaveStatus
 			decodeErr := json.NewDecoder(resp.Body).Decode(&result)
 			if decodeErr != nil {
-				return nil, communicationErrorFromError(decodeErr)
+				return SlaveStatus{}, communicationErrorFromError(decodeErr)
 			}
 			// TODO validation
 			return result, nil
@@ -43,14 +44,14 @@ func (c MSPClientImpl) RequestStatus(Target HostPort) ([]Mongod, *Error) {
 			var slaveError Error
 			decodeErr := json.NewDecoder(resp.Body).Decode(&slaveError)
 			if decodeErr != nil {
-				return nil, communicationErrorFromError(decodeErr)
+				return SlaveStatus{}, communicationErrorFromError(decodeErr)
 			} else if validationErr := slaveError.validateFields(); validationErr != nil {
-				return nil, communicationErrorFromError(validationErr)
+				return SlaveStatus{}, communicationErrorFromError(validationErr)
 			}
-			return nil, &slaveError
+			return SlaveStatus{}, &slaveError
 		}
 	} else {
-		return nil, communicationErrorFromError(err)
+		return SlaveStatus{}, communicationErrorFromError(err)
 	}
 }
 
@@ -135,3 +136,28 @@ func (c MSPClientImpl) InitiateReplicaSet(target HostPort, msg RsInitiateMessage
 	}
 
 }
+
+func (c MSPClientImpl) MongodLog(target HostPort, port PortNumber, tail uint) (MongodLog, *Error) {
+	resp, err := c.HttpClient.Get(fmt.Sprintf("%smsp/mongods/%d/log?tail=%d", constructBaseUrl(target), port, tail))
+	if err != nil {
+		return MongodLog{}, communicationErrorFromError(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var slaveError Error
+		decodeErr := json.NewDecoder(resp.Body).Decode(&slaveError)
+		if decodeErr != nil {
+			return MongodLog{}, communicationErrorFromError(decodeErr)
+		} else if validationErr := slaveError.validateFields(); validationErr != nil {
+			return MongodLog{}, communicationErrorFromError(validationErr)
+		}
+		return MongodLog{}, &slaveError
+	}
+
+	var result MongodLog
+	if decodeErr := json.NewDecoder(resp.Body).Decode(&result); decodeErr != nil {
+		return MongodLog{}, communicationErrorFromError(decodeErr)
+	}
+	return result, nil
+}
@@ -5,29 +5,47 @@ import (
 	"crypto/x509"
 	"encoding/json"
 	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"io/ioutil"
 	"net/http"
+	"strconv"
+	"time"
 )
 
 type Consumer interface {
-	RequestStatus() ([]Mongod, *Error)
+	RequestStatus() (SlaveStatus, *Error)
 	EstablishMongodState(m Mongod) *Error
 	RsInitiate(m RsInitiateMessage) *Error
+	MongodLog(port PortNumber, tail uint) (MongodLog, *Error)
 }
 
 type Listener struct {
-	listenString string
-	consumer     Consumer
-	router       *mux.Router
-	certFile     string
-	keyFile      string
-	tlsConfig    *tls.Config
+	listenString        string
+	metricsListenString string // serves Prometheus metrics over plain HTTP, disabled if empty
+	consumer            Consumer
+	router              *mux.Router
+	certFile            string
+	keyFile             string
+	tlsConfig           *tls.Config
 }
 
-func NewServer(listener Consumer, listenString string, caFile string, certFile string, keyFile string) *Listener {
+var requestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+	Namespace: "mamid",
+	Subsystem: "msp",
+	Name:      "request_duration_seconds",
+	Help:      "Duration of handling MSP requests from the master by request.",
+}, []string{"request"})
+
+func init() {
+	prometheus.MustRegister(requestDuration)
+}
+
+func NewServer(listener Consumer, listenString string, metricsListenString string, caFile string, certFile string, keyFile string) *Listener {
 	s := new(Listener)
 	s.consumer = listener
 	s.listenString = listenString
+	s.metricsListenString = metricsListenString
 	s.certFile = certFile
 	s.keyFile = keyFile
 
@@ -44,16 +62,26 @@ func NewServer(listener Consumer, listenString string, caFile string, certFile s
 	s.tlsConfig.BuildNameToCertificate()
 
 	s.router = mux.NewRouter().StrictSlash(true)
-	s.router.Methods("GET").Path("/msp/status").Name("RequestStatus").HandlerFunc(s.handleRequestStatus)
-	s.router.Methods("POST").Path("/msp/establishMongodState").Name("EstablishMongodState").HandlerFunc(s.handleMspEstablishMongodState)
-	s.router.Methods("POST").Path("/msp/rsInitiate").Name("RsInitiate").HandlerFunc(s.handleRsInitiate)
+	s.router.Methods("GET").Path("/msp/status").Name("RequestStatus").HandlerFunc(instrumented("RequestStatus", s.handleRequestStatus))
+	s.router.Methods("POST").Path("/msp/establishMongodState").Name("EstablishMongodState").HandlerFunc(instrumented("EstablishMongodState", s.handleMspEstablishMongodState))
+	s.router.Methods("POST").Path("/msp/rsInitiate").Name("RsInitiate").HandlerFunc(instrumented("RsInitiate", s.handleRsInitiate))
+	s.router.Methods("GET").Path("/msp/mongods/{port}/log").Name("MongodLog").HandlerFunc(instrumented("MongodLog", s.handleMongodLog))
 
 	return s
 }
 
+// Record the duration of handling the request
+func instrumented(request string, handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		handler(w, r)
+		requestDuration.WithLabelValues(request).Observe(time.Since(start).Seconds())
+	}
+}
+
 func (s Listener) handleRequestStatus(w http.ResponseWriter, r *http.Request) {
 	status, err := s.consumer.RequestStatus()
-	if status != nil {
+	if err == nil {
 		json.NewEncoder(w).Encode(status)
 	} else {
 		w.WriteHeader(http.StatusInternalServerError)
@@ -81,7 +109,36 @@ func (s Listener) handleRsInitiate(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+func (s Listener) handleMongodLog(w http.ResponseWriter, r *http.Request) {
+	port, err := strconv.ParseUint(mux.Vars(r)["port"], 10, 16)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(Error{Identifier: SlaveMongodLogError, Description: "Invalid port number", LongDescription: err.Error()})
+		return
+	}
+	tail := DefaultMongodLogTail
+	if tailStr := r.URL.Query().Get("tail"); tailStr != "" {
+		tail64, err := strconv.ParseUint(tailStr, 10, 32)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(Error{Identifier: SlaveMongodLogError, Description: "Invalid number of log lines", LongDescription: err.Error()})
+			return
+		}
+		tail = uint(tail64)
+	}
+	mongodLog, mspErr := s.consumer.MongodLog(PortNumber(port), tail)
+	if mspErr != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(mspErr)
+		return
+	}
+	json.NewEncoder(w).Encode(mongodLog)
+}
+
 func (s Listener) Run() error {
+	if s.metricsListenString != "" {
+		go s.runMetrics()
+	}
 	server := &http.Server{
 		TLSConfig: s.tlsConfig,
 		Addr:      s.listenString,
@@ -89,3 +146,12 @@ func (s Listener) Run() error {
 	}
 	return server.ListenAndServeTLS(s.certFile, s.keyFile)
 }
+
+// Serve the metrics of the default Prometheus registry, including those registered by the Consumer
+func (s Listener) runMetrics() {
+	metricsRouter := http.NewServeMux()
+	metricsRouter.Handle("/metrics", promhttp.Handler())
+	if err := http.ListenAndServe(s.metricsListenString, metricsRouter); err != nil {
+		mspLog.Fatalf("cannot serve metrics: %s", err)
+	}
+}
@@ -1,9 +1,15 @@
 package main
 
 import (
+	"bufio"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 )
 
 type APIClient struct {
@@ -24,3 +30,124 @@ func (apiclient *APIClient) Receive(host string) (problems []Problem, err error)
 	}
 	return
 }
+
+func (apiclient *APIClient) ReplicaSet(host string, id uint) (replicaSet ReplicaSet, err error) {
+	err = apiclient.getJSON(fmt.Sprintf("%s/api/replicasets/%d", host, id), &replicaSet)
+	return
+}
+
+func (apiclient *APIClient) Slave(host string, id uint) (slave Slave, err error) {
+	err = apiclient.getJSON(fmt.Sprintf("%s/api/slaves/%d", host, id), &slave)
+	return
+}
+
+func (apiclient *APIClient) RiskGroup(host string, id uint) (riskGroup RiskGroup, err error) {
+	err = apiclient.getJSON(fmt.Sprintf("%s/api/riskgroups/%d", host, id), &riskGroup)
+	return
+}
+
+func (apiclient *APIClient) getJSON(url string, v interface{}) error {
+	resp, err := apiclient.httpClient.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("API returned non 200 %d", resp.StatusCode)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+const (
+	EventTypeResync          = "resync"
+	EventTypeProblemCreated  = "problem_created"
+	EventTypeProblemResolved = "problem_resolved"
+)
+
+// The master sends a keepalive every 30 seconds, a connection without any data for longer is considered dead
+const eventStreamIdleTimeout = 90 * time.Second
+
+// Returned by OpenEventStream if the master does not provide an event stream
+var errEventStreamUnsupported = errors.New("master does not provide an event stream")
+
+type EventStream struct {
+	body      io.ReadCloser
+	reader    *bufio.Reader
+	idleTimer *time.Timer
+}
+
+// Connect to the master's event stream, resuming after lastEventID if it is not nil
+func (apiclient *APIClient) OpenEventStream(host string, lastEventID *uint64) (*EventStream, error) {
+	req, err := http.NewRequest("GET", fmt.Sprintf("%s/api/events", host), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "text/event-stream")
+	if lastEventID != nil {
+		req.Header.Set("Last-Event-ID", strconv.FormatUint(*lastEventID, 10))
+	}
+	resp, err := apiclient.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		resp.Body.Close()
+		return nil, errEventStreamUnsupported
+	case resp.StatusCode != http.StatusOK:
+		resp.Body.Close()
+		return nil, fmt.Errorf("API returned non 200 %d", resp.StatusCode)
+	}
+	return &EventStream{
+		body:   resp.Body,
+		reader: bufio.NewReader(resp.Body),
+		// closing the body unblocks Next
+		idleTimer: time.AfterFunc(eventStreamIdleTimeout, func() { resp.Body.Close() }),
+	}, nil
+}
+
+// Block until the next event is received.
+// Returns an error if the connection fails, the stream must be closed and reopened then.
+func (s *EventStream) Next() (Event, error) {
+	var event Event
+	var data []string
+	for {
+		line, err := s.reader.ReadString('\n')
+		if err != nil {
+			return event, err
+		}
+		s.idleTimer.Reset(eventStreamIdleTimeout)
+
+		line = strings.TrimRight(line, "\r\n")
+		if line == "" {
+			if len(data) == 0 {
+				continue
+			}
+			if err := json.Unmarshal([]byte(strings.Join(data, "\n")), &event); err != nil {
+				return event, fmt.Errorf("invalid event data: %s", err)
+			}
+			return event, nil
+		}
+		if strings.HasPrefix(line, ":") {
+			continue // comment, e.g. keepalive
+		}
+		// The event's JSON contains its ID and type, only the data field is relevant
+		if field, value := splitEventStreamLine(line); field == "data" {
+			data = append(data, value)
+		}
+	}
+}
+
+func (s *EventStream) Close() {
+	s.idleTimer.Stop()
+	s.body.Close()
+}
+
+// Split `field: value` as defined by https://www.w3.org/TR/eventsource/#event-stream-interpretation
+func splitEventStreamLine(line string) (field, value string) {
+	colon := strings.Index(line, ":")
+	if colon == -1 {
+		return line, ""
+	}
+	return line[:colon], strings.TrimPrefix(line[colon+1:], " ")
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
 	"github.com/stretchr/testify/assert"
 	"net/http"
@@ -58,3 +59,38 @@ func TestApiClientServerJSONFail(t *testing.T) {
 	assert.Error(t, err)
 	assert.Equal(t, problems, []Problem(nil))
 }
+
+func TestApiClientEventStream(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "41", r.Header.Get("Last-Event-ID"))
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, ": keepalive\n\n")
+		fmt.Fprint(w, "id: 42\nevent: problem_created\n")
+		fmt.Fprint(w, `data: {"id":42,"type":"problem_created","data":{"id":1,"description":"Slave test4 is unreachable","slave_id":2}}`+"\n\n")
+	}))
+	defer server.Close()
+	var client APIClient
+	lastEventID := uint64(41)
+	stream, err := client.OpenEventStream(server.URL, &lastEventID)
+	assert.NoError(t, err)
+	defer stream.Close()
+
+	event, err := stream.Next()
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(42), event.ID)
+	assert.Equal(t, EventTypeProblemCreated, event.Type)
+	var problem Problem
+	assert.NoError(t, json.Unmarshal(event.Data, &problem))
+	assert.Equal(t, uint(1), problem.Id)
+
+	_, err = stream.Next()
+	assert.Error(t, err, "the server closed the stream")
+}
+
+func TestApiClientEventStreamUnsupported(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	defer server.Close()
+	var client APIClient
+	_, err := client.OpenEventStream(server.URL, nil)
+	assert.Equal(t, errEventStreamUnsupported, err)
+}
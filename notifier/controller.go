@@ -3,17 +3,31 @@ package main
 import (
 	"crypto/tls"
 	"crypto/x509"
+	"encoding/json"
 	"encoding/pem"
+	"fmt"
 	"github.com/Sirupsen/logrus"
 	"io/ioutil"
 	"net/http"
 	"os"
 	"os/signal"
+	"sync"
 	"time"
 )
 
+// The delivery state, see DeliveryState
 var lastProblems map[uint]Problem
-var notifiers []Notifier
+var deliveries map[uint][]string
+var lastEventID *uint64
+var stateFile string
+
+// protects the delivery state, dispatchPending runs concurrently with the processing of Problems
+var stateMutex sync.Mutex
+
+var routes []route
+
+const streamReconnectMinBackoff = 1 * time.Second
+const streamReconnectMaxBackoff = 1 * time.Minute
 
 var log = logrus.WithField("module", "slave")
 
@@ -21,7 +35,6 @@ func main() {
 	logrus.SetLevel(logrus.DebugLevel)
 
 	var p Parser
-	var email EmailNotifier
 	// Load contacts from ini file
 	if len(os.Args) != 2 {
 		log.Println("No config file supplied! Usage: notifier <config file>")
@@ -37,18 +50,31 @@ func main() {
 		log.Fatalf("Error loading contacts file `%s`: %#v", config.contactsFile, contactsParseErr)
 	}
 
-	emailContacts := make([]*EmailContact, 0)
 	for i := 0; i < len(contacts); i++ {
 		switch t := contacts[i].(type) {
 		case EmailContact:
-			emailContacts = append(emailContacts, &t)
+			routes = append(routes, route{
+				key:          "email/" + t.Name,
+				subscription: t.Subscription,
+				notifier:     &EmailNotifier{Contacts: []*EmailContact{&t}, Relay: config.relay, MamidHost: config.apiHost},
+			})
+		case WebhookContact:
+			routes = append(routes, route{
+				key:          "webhook/" + t.Name,
+				subscription: t.Subscription,
+				notifier:     &WebhookNotifier{Contacts: []*WebhookContact{&t}, MamidHost: config.apiHost},
+			})
 		}
 	}
-	email.Contacts = emailContacts
-	email.Relay = config.relay
-	email.MamidHost = config.apiHost
-	lastProblems = make(map[uint]Problem)
-	notifiers = append(notifiers, &email)
+	state, err := LoadDeliveryState(config.stateFile)
+	if err != nil {
+		log.Fatalf("Error loading state file `%s`: %s", config.stateFile, err)
+	}
+	lastProblems = state.Problems
+	deliveries = state.Deliveries
+	lastEventID = state.LastEventID
+	stateFile = config.stateFile
+	go dispatchPending()
 	c := make(chan os.Signal, 1)
 	signal.Notify(c, os.Interrupt, os.Kill)
 	go func() {
@@ -87,23 +113,133 @@ func main() {
 			Transport: httpTransport,
 		},
 	}
+	if config.mode == modeStream {
+		streamProblems(&apiClient, config.apiHost)
+	}
+	pollProblems(&apiClient, config.apiHost, config.pollInterval)
+
+}
+
+// Poll the master's Problem list, notifying about new Problems
+func pollProblems(apiClient *APIClient, host string, interval time.Duration) {
 	for {
-		//receive Problems through API
-		currentProblems, err := apiClient.Receive(config.apiHost)
-		if err != nil {
+		if err := syncProblems(apiClient, host); err != nil {
 			log.Errorf("Error querying API: %#v", err)
 		}
-		currentProblems = diffProblems(currentProblems)
-		for i := 0; i < len(currentProblems); i++ {
-			notify(currentProblems[i])
+		time.Sleep(interval)
+	}
+}
+
+// Process the master's event stream, reconnecting if the connection fails.
+// Returns if the master does not provide an event stream.
+func streamProblems(apiClient *APIClient, host string) {
+	backoff := streamReconnectMinBackoff
+	for {
+		stream, err := apiClient.OpenEventStream(host, lastEventID)
+		if err == errEventStreamUnsupported {
+			log.Warn("Master does not provide an event stream, falling back to polling")
+			return
+		} else if err == nil {
+			log.Info("Connected to the master's event stream")
+			backoff = streamReconnectMinBackoff
+			err = processEventStream(apiClient, host, stream)
+			stream.Close()
+		}
+		log.Errorf("Error receiving events, reconnecting in %s: %s", backoff, err)
+		time.Sleep(backoff)
+		if backoff *= 2; backoff > streamReconnectMaxBackoff {
+			backoff = streamReconnectMaxBackoff
+		}
+	}
+}
+
+// Process events until the stream fails
+func processEventStream(apiClient *APIClient, host string, stream *EventStream) error {
+	if lastEventID == nil {
+		// Problems that occurred before the first event are not replayed
+		if err := syncProblems(apiClient, host); err != nil {
+			return err
+		}
+	}
+	for {
+		event, err := stream.Next()
+		if err != nil {
+			return err
+		}
+		if err = handleEvent(apiClient, host, event); err != nil {
+			return err
 		}
-		time.Sleep(10 * time.Second)
 	}
+}
+
+func handleEvent(apiClient *APIClient, host string, event Event) error {
+	stateMutex.Lock()
+	defer stateMutex.Unlock()
+
+	switch event.Type {
+	case EventTypeResync:
+		// Events were missed, e.g. the master restarted
+		if err := syncProblemsLocked(apiClient, host); err != nil {
+			return err
+		}
+	case EventTypeProblemCreated, EventTypeProblemResolved:
+		var problem Problem
+		if err := json.Unmarshal(event.Data, &problem); err != nil {
+			return fmt.Errorf("invalid Problem in event `%d`: %s", event.ID, err)
+		}
+		knownProblem, known := lastProblems[problem.Id]
+		switch {
+		case event.Type == EventTypeProblemCreated && !known:
+			addProblem(apiClient, host, problem)
+			dispatch(time.Now())
+		case event.Type == EventTypeProblemResolved && known:
+			resolve(knownProblem, event.Time)
+		}
+	}
+	id := event.ID
+	lastEventID = &id
+	saveState()
+	return nil
+}
+
+// Fetch the master's Problem list and notify about Problems not notified yet and the resolution of notified Problems that disappeared
+func syncProblems(apiClient *APIClient, host string) error {
+	stateMutex.Lock()
+	defer stateMutex.Unlock()
+	return syncProblemsLocked(apiClient, host)
+}
+
+func syncProblemsLocked(apiClient *APIClient, host string) error {
+	currentProblems, err := apiClient.Receive(host)
+	if err != nil {
+		return err
+	}
+	currentProblems, resolvedProblems := diffProblems(currentProblems)
+	for i := 0; i < len(currentProblems); i++ {
+		addProblem(apiClient, host, currentProblems[i])
+	}
+	now := time.Now()
+	for i := 0; i < len(resolvedProblems); i++ {
+		resolve(resolvedProblems[i], now)
+	}
+	dispatch(now)
+	saveState()
+	return nil
+}
 
+// Failures are logged, the notifier continues with the state in memory
+func saveState() {
+	state := DeliveryState{LastEventID: lastEventID, Problems: lastProblems, Deliveries: deliveries}
+	if err := state.Save(stateFile); err != nil {
+		log.Errorf("Error saving state file `%s`: %s", stateFile, err)
+	}
 }
-func diffProblems(received []Problem) []Problem {
+
+// Returns the received problems that were not known and the known problems that were not received, i.e. were resolved
+func diffProblems(received []Problem) (created []Problem, resolved []Problem) {
 	// Clean old problems
-	for id := range lastProblems {
+	resolved = []Problem{}
+	for id, problem := range lastProblems {
 		var contained bool
 		for i := 0; i < len(received); i++ {
 			contained = received[i].Id == id
@@ -113,9 +249,10 @@ func diffProblems(received []Problem) []Problem {
 		}
 		if !contained {
 			delete(lastProblems, id)
+			resolved = append(resolved, problem)
 		}
 	}
-	// Add problems to the map of already notified problems and remove already notified problems from the resulting slice
+	// Add problems to the map of known problems and remove already known problems from the resulting slice
 	for i := 0; i < len(received); i++ {
 		if _, ok := lastProblems[received[i].Id]; ok {
 			received = append(received[:i], received[i+1:]...)
@@ -124,16 +261,7 @@ func diffProblems(received []Problem) []Problem {
 		}
 		lastProblems[received[i].Id] = received[i]
 	}
-	return received
-}
-
-func notify(problem Problem) {
-	for i := 0; i < len(notifiers); i++ {
-		err := notifiers[i].SendProblem(problem)
-		if err != nil {
-			log.Errorf("Error sending notification: %#v", err)
-		}
-	}
+	return received, resolved
 }
 
 func loadCertificateFromFile(file string) (cert *x509.Certificate, err error) {
@@ -142,6 +270,9 @@ func loadCertificateFromFile(file string) (cert *x509.Certificate, err error) {
 		return
 	}
 	block, _ := pem.Decode(certFile)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM data in `%s`", file)
+	}
 	cert, err = x509.ParseCertificate(block.Bytes)
 	return
 }
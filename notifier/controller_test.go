@@ -1,36 +1,125 @@
 package main
 
 import (
+	"encoding/json"
 	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
 )
 
 func TestDiffProblemsNew(t *testing.T) {
 	lastProblems = make(map[uint]Problem)
 	newProblems := []Problem{Problem{Id: 1}, Problem{Id: 2}, Problem{Id: 3}, Problem{Id: 4}}
-	assert.Equal(t, newProblems, diffProblems(newProblems))
+	created, resolved := diffProblems(newProblems)
+	assert.Equal(t, newProblems, created)
+	assert.Equal(t, []Problem{}, resolved)
 }
 
 func TestDiffProblemsNewProblem(t *testing.T) {
 	lastProblems = make(map[uint]Problem)
 	newProblems := []Problem{Problem{Id: 1}, Problem{Id: 2}, Problem{Id: 3}, Problem{Id: 4}}
-	assert.Equal(t, newProblems, diffProblems(newProblems))
+	created, _ := diffProblems(newProblems)
+	assert.Equal(t, newProblems, created)
 	newProblems = []Problem{Problem{Id: 1}, Problem{Id: 2}, Problem{Id: 3}, Problem{Id: 4}, Problem{Id: 5}}
-	assert.Equal(t, []Problem{newProblems[4]}, diffProblems(newProblems))
+	created, resolved := diffProblems(newProblems)
+	assert.Equal(t, []Problem{newProblems[4]}, created)
+	assert.Equal(t, []Problem{}, resolved)
 }
 
 func TestDiffProblemsNewOtherProblems(t *testing.T) {
 	lastProblems = make(map[uint]Problem)
 	newProblems := []Problem{Problem{Id: 1}, Problem{Id: 2}, Problem{Id: 3}, Problem{Id: 4}}
-	assert.Equal(t, newProblems, diffProblems(newProblems))
+	created, _ := diffProblems(newProblems)
+	assert.Equal(t, newProblems, created)
 	newProblems = []Problem{Problem{Id: 6}, Problem{Id: 7}, Problem{Id: 8}, Problem{Id: 9}, Problem{Id: 10}}
-	assert.Equal(t, newProblems, diffProblems(newProblems))
+	created, resolved := diffProblems(newProblems)
+	assert.Equal(t, newProblems, created)
+	assert.Len(t, resolved, 4)
 }
 
 func TestDiffProblemsLessProblems(t *testing.T) {
 	lastProblems = make(map[uint]Problem)
 	newProblems := []Problem{Problem{Id: 1}, Problem{Id: 2}, Problem{Id: 3}, Problem{Id: 4}}
-	assert.Equal(t, newProblems, diffProblems(newProblems))
+	created, resolved := diffProblems(newProblems)
+	assert.Equal(t, newProblems, created)
+	assert.Equal(t, []Problem{}, resolved)
 	newProblems = []Problem{Problem{Id: 1}}
-	assert.Equal(t, []Problem{}, diffProblems(newProblems))
+	created, resolved = diffProblems(newProblems)
+	assert.Equal(t, []Problem{}, created)
+	assert.Len(t, resolved, 3)
+	for _, problem := range resolved {
+		assert.NotEqual(t, uint(1), problem.Id)
+	}
+}
+
+type recordingNotifier struct {
+	sent     []Problem
+	resolved []Problem
+}
+
+func (n *recordingNotifier) SendProblem(problem Problem) error {
+	n.sent = append(n.sent, problem)
+	return nil
+}
+
+func (n *recordingNotifier) SendResolution(problem Problem, resolved time.Time) error {
+	n.resolved = append(n.resolved, problem)
+	return nil
+}
+
+func TestHandleEvent(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "mamid_test")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	stateFile = filepath.Join(dir, "state.json")
+	lastProblems = make(map[uint]Problem)
+	deliveries = make(map[uint][]string)
+	lastEventID = nil
+	recorder := &recordingNotifier{}
+	filtered := &recordingNotifier{}
+	routes = []route{
+		{key: "test/recorder", notifier: recorder},
+		{key: "test/filtered", notifier: filtered, subscription: Subscription{ProblemTypes: []string{"oplog_window"}}},
+	}
+	defer func() { routes = nil }()
+
+	created := Event{ID: 1, Type: EventTypeProblemCreated, Data: json.RawMessage(`{"id":1,"description":"foo"}`)}
+	// not notified about, e.g. resolved during a previous run
+	unknownResolved := Event{ID: 1, Type: EventTypeProblemResolved, Data: json.RawMessage(`{"id":2,"description":"bar"}`)}
+	assert.NoError(t, handleEvent(nil, "", unknownResolved))
+	assert.Empty(t, recorder.resolved)
+
+	assert.NoError(t, handleEvent(nil, "", created))
+	assert.Equal(t, []Problem{{Id: 1, Description: "foo"}}, recorder.sent)
+
+	// e.g. replayed after a reconnect
+	created.ID = 2
+	assert.NoError(t, handleEvent(nil, "", created))
+	assert.Len(t, recorder.sent, 1, "Problems are notified about once")
+
+	state, err := LoadDeliveryState(stateFile)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(2), *state.LastEventID)
+	assert.Contains(t, state.Problems, uint(1))
+	assert.Equal(t, []string{"test/recorder"}, state.Deliveries[1])
+
+	resolved := Event{ID: 3, Type: EventTypeProblemResolved, Data: json.RawMessage(`{"id":1,"description":"foo"}`)}
+	assert.NoError(t, handleEvent(nil, "", resolved))
+	assert.Empty(t, lastProblems)
+	assert.Equal(t, []Problem{{Id: 1, Description: "foo"}}, recorder.resolved)
+
+	state, err = LoadDeliveryState(stateFile)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(3), *state.LastEventID)
+	assert.Empty(t, state.Problems)
+	assert.Empty(t, state.Deliveries)
+	assert.Empty(t, filtered.sent)
+	assert.Empty(t, filtered.resolved)
+
+	invalid := Event{ID: 4, Type: EventTypeProblemCreated, Data: json.RawMessage(`[]`)}
+	assert.Error(t, handleEvent(nil, "", invalid))
+	assert.Equal(t, uint64(3), *lastEventID, "the event is not processed")
 }
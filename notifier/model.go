@@ -1,6 +1,10 @@
 package main
 
 import (
+	"crypto/x509"
+	"encoding/json"
+	"net/http"
+	"text/template"
 	"time"
 )
 
@@ -8,21 +12,81 @@ type Contact interface {
 }
 
 type EmailContact struct {
-	Name    string
-	Address string
+	Name         string
+	Address      string
+	Subscription Subscription
+}
+
+type WebhookContact struct {
+	Name         string
+	URL          string
+	Template     *template.Template // renders the JSON payload from WebhookPayload
+	Headers      map[string]string
+	Retries      uint          // additional attempts after a failed request
+	Backoff      time.Duration // before the first retry, doubled for each further retry
+	Subscription Subscription
+	client       *http.Client // applies the timeout and TLS client certificate
+}
+
+// The Problems a contact is notified about, see routing.go
+type Subscription struct {
+	ProblemTypes []string      // as in the API's `problem_type`
+	ReplicaSets  []string      // names or IDs
+	Slaves       []string      // hostnames or IDs
+	RiskGroups   []string      // names or IDs of the Slave's risk group or one of its ancestors
+	MinAge       time.Duration // since the Problem first occurred
 }
 
 type SMTPRelay struct {
 	Hostname string
 	MailFrom string
+	TLS      string         // see smtp.go
+	RootCAs  *x509.CertPool // nil to verify the relay against the system's CAs
+	Auth     string         // empty, `plain` or `cram-md5`
+	Username string
+	Password string
 }
 
 type Problem struct {
-	Id              uint      `json:"id"`
-	Description     string    `json:"description"`
-	LongDescription string    `json:"long_description"`
-	FirstOccured    time.Time `json:"first_occured"`
-	LastUpdate      time.Time `json:"last_update"`
-	Slave           *uint     `json:"slave_id"`
-	ReplicaSet      *uint     `json:"replica_set_id"`
+	Id              uint            `json:"id"`
+	Description     string          `json:"description"`
+	LongDescription string          `json:"long_description"`
+	ProblemType     string          `json:"problem_type"`
+	FirstOccurred   time.Time       `json:"first_occurred"`
+	LastUpdated     time.Time       `json:"last_updated"`
+	Slave           *uint           `json:"slave_id"`
+	ReplicaSet      *uint           `json:"replica_set_id"`
+	Context         *ProblemContext `json:"context,omitempty"` // not provided by the API, see fetchProblemContext
+}
+
+// Names of the Replica Set and Slave affected by a Problem, fetched from the API for routing
+type ProblemContext struct {
+	ReplicaSetName string      `json:"replica_set_name"`
+	SlaveHostname  string      `json:"slave_hostname"`
+	RiskGroups     []RiskGroup `json:"risk_groups"` // the Slave's risk group first, followed by its ancestors
+}
+
+type ReplicaSet struct {
+	Id   uint   `json:"id"`
+	Name string `json:"name"`
+}
+
+type Slave struct {
+	Id        uint   `json:"id"`
+	Hostname  string `json:"hostname"`
+	RiskGroup *uint  `json:"risk_group_id"`
+}
+
+type RiskGroup struct {
+	Id     uint   `json:"id"`
+	Name   string `json:"name"`
+	Parent *uint  `json:"parent_id"`
+}
+
+// An event of the master's event stream (GET /api/events)
+type Event struct {
+	ID   uint64          `json:"id"`
+	Type string          `json:"type"`
+	Time time.Time       `json:"time"`
+	Data json.RawMessage `json:"data"` // a Problem for problem events
 }
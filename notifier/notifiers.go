@@ -1,13 +1,14 @@
 package main
 
 import (
-	"encoding/base64"
 	"fmt"
-	"net/smtp"
+	"time"
 )
 
 type Notifier interface {
 	SendProblem(problem Problem) error
+	// The problem was notified about before and disappeared at resolved
+	SendResolution(problem Problem, resolved time.Time) error
 }
 
 type EmailNotifier struct {
@@ -17,40 +18,60 @@ type EmailNotifier struct {
 }
 
 func (n *EmailNotifier) SendProblem(problem Problem) error {
-	content := "A Problem occured: " + problem.Description + "\r\n"
+	message := mailMessage{
+		Subject: "[MAMID] Problem: " + problem.Description,
+		Summary: "A Problem occured: " + problem.Description,
+	}
+	message.Details = append(n.affected(problem), "Detailed Description: "+problem.LongDescription)
+	message.Links = n.links(problem)
+	return n.sendMailToContacts(message)
+}
+
+func (n *EmailNotifier) SendResolution(problem Problem, resolved time.Time) error {
+	message := mailMessage{
+		Subject: "[MAMID] Resolved: " + problem.Description,
+		Summary: "A Problem was resolved after " + problemDuration(problem, resolved) + ": " + problem.Description,
+	}
+	message.Details = append(n.affected(problem),
+		"First occurred: "+problem.FirstOccurred.Format(time.RFC1123Z),
+		"Resolved: "+resolved.Format(time.RFC1123Z))
+	message.Links = n.links(problem)
+	return n.sendMailToContacts(message)
+}
+
+func (n *EmailNotifier) affected(problem Problem) (details []string) {
 	if problem.ReplicaSet != nil {
-		content += fmt.Sprintf("Replica Set id: %d \r\n", *problem.ReplicaSet)
+		details = append(details, fmt.Sprintf("Replica Set id: %d", *problem.ReplicaSet))
 	}
 	if problem.Slave != nil {
-		content += fmt.Sprintf("Slave id: %d \r\n", *problem.Slave)
-	}
-	content += "Detailed Description: " + problem.LongDescription + "\r\n"
-	subject := "[MAMID] Problem: " + problem.Description
-	subject = "Subject: =?utf-8?B?" + base64.StdEncoding.EncodeToString([]byte(subject)) + "?="
-	msg := "From: " + n.Relay.MailFrom + "\r\nContent-Type: text/plain; charset=UTF-8\r\nContent-transfer-encoding: binary\r\n" +
-		subject + "\r\n\r\n" +
-		content
+		details = append(details, fmt.Sprintf("Slave id: %d", *problem.Slave))
+	}
+	return details
+}
+
+func (n *EmailNotifier) links(problem Problem) (links []mailLink) {
 	if problem.ReplicaSet != nil {
-		msg += fmt.Sprintf("\r\nInspect affected Replica Set: %s/#/replicasets/%d \r\n", n.MamidHost, *problem.ReplicaSet)
+		links = append(links, mailLink{"Inspect affected Replica Set", fmt.Sprintf("%s/#/replicasets/%d", n.MamidHost, *problem.ReplicaSet)})
 	}
 	if problem.Slave != nil {
-		msg += fmt.Sprintf("\r\nInspect affected Slave: %s/#/slaves/%d \r\n", n.MamidHost, *problem.Slave)
+		links = append(links, mailLink{"Inspect affected Slave", fmt.Sprintf("%s/#/slaves/%d", n.MamidHost, *problem.Slave)})
+	}
+	return links
+}
+
+// How long the problem lasted until resolved, in seconds precision
+func problemDuration(problem Problem, resolved time.Time) string {
+	if problem.FirstOccurred.IsZero() || resolved.Before(problem.FirstOccurred) {
+		return "an unknown duration"
 	}
-	return n.sendMailToContacts(msg)
+	return (resolved.Sub(problem.FirstOccurred) / time.Second * time.Second).String()
 }
 
-func (n *EmailNotifier) sendMailToContacts(msg string) error {
+// Failures of individual contacts are reported as RecipientErrors, the other contacts receive the message
+func (n *EmailNotifier) sendMailToContacts(message mailMessage) error {
+	recipients := make([]string, len(n.Contacts))
 	for i := 0; i < len(n.Contacts); i++ {
-		err := smtp.SendMail(
-			n.Relay.Hostname,
-			nil,
-			n.Relay.MailFrom,
-			[]string{n.Contacts[i].Address},
-			[]byte("To: "+n.Contacts[i].Address+"\r\n"+msg))
-		if err != nil {
-			return err
-		}
-	}
-
-	return nil
+		recipients[i] = n.Contacts[i].Address
+	}
+	return n.Relay.send(recipients, message)
 }
@@ -0,0 +1,15 @@
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestProblemDuration(t *testing.T) {
+	firstOccurred := time.Date(2016, time.August, 1, 14, 24, 12, 681005208, time.UTC)
+	problem := Problem{Id: 1, FirstOccurred: firstOccurred}
+	assert.Equal(t, "1h30m2s", problemDuration(problem, firstOccurred.Add(90*time.Minute+2500*time.Millisecond)))
+	assert.Equal(t, "an unknown duration", problemDuration(problem, firstOccurred.Add(-time.Second)))
+	assert.Equal(t, "an unknown duration", problemDuration(Problem{Id: 1}, firstOccurred))
+}
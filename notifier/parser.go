@@ -1,13 +1,29 @@
 package main
 
 import (
+	"crypto/x509"
 	"fmt"
 	"github.com/vaughan0/go-ini"
+	"io/ioutil"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
 )
 
+const (
+	modeStream = "stream" // consume the master's event stream, polls if it is not available
+	modePoll   = "poll"   // poll the master's Problem list
+)
+
+const defaultStateFile = "notifier_state.json"
+const defaultPollInterval = 10 * time.Second
+
 type Config struct {
 	relay                                            SMTPRelay
 	apiHost, contactsFile, masterCA, apiCert, apiKey string
+	mode, stateFile                                  string
+	pollInterval                                     time.Duration
 }
 
 type Parser struct {
@@ -20,13 +36,29 @@ func (p *Parser) Parse(path string) ([]Contact, error) {
 	}
 	var contacts []Contact
 	for name, section := range file {
+		subscription, err := parseSubscription(section)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid filter of contact `%s`: %s", name, err)
+		}
 		for key, value := range section {
-			switch key {
-			case "email":
+			switch {
+			case key == "email":
 				var newContact EmailContact
 				newContact.Address = value
 				newContact.Name = name
+				newContact.Subscription = subscription
+				contacts = append(contacts, newContact)
+			case key == "webhook":
+				newContact, err := parseWebhookContact(name, section)
+				if err != nil {
+					return nil, fmt.Errorf("Invalid webhook of contact `%s`: %s", name, err)
+				}
+				newContact.Subscription = subscription
 				contacts = append(contacts, newContact)
+			case strings.HasPrefix(key, "webhook_"):
+				// options of the webhook
+			case key == "problem_types", key == "replica_sets", key == "slaves", key == "risk_groups", key == "min_age":
+				// filters, see parseSubscription
 			default:
 				log.Infof("Ignoring unknown notifier `%s`", key)
 			}
@@ -35,6 +67,60 @@ func (p *Parser) Parse(path string) ([]Contact, error) {
 	return contacts, err
 }
 
+// A webhook is configured by the `webhook` URL and the `webhook_*` options in the contact's section
+func parseWebhookContact(name string, section ini.Section) (contact WebhookContact, err error) {
+	contact.Name = name
+	contact.URL = section["webhook"]
+	if u, err := url.Parse(contact.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return contact, fmt.Errorf("invalid URL `%s`", contact.URL)
+	}
+
+	var templateText []byte
+	if templateFile, ok := section["webhook_template"]; ok {
+		if templateText, err = ioutil.ReadFile(templateFile); err != nil {
+			return
+		}
+	}
+	if contact.Template, err = parseWebhookTemplate(name, string(templateText)); err != nil {
+		return
+	}
+
+	contact.Headers = make(map[string]string)
+	for key, value := range section {
+		if strings.HasPrefix(key, "webhook_header_") {
+			contact.Headers[strings.TrimPrefix(key, "webhook_header_")] = value
+		}
+	}
+
+	contact.Retries = defaultWebhookRetries
+	if retries, ok := section["webhook_retries"]; ok {
+		var parsed uint64
+		if parsed, err = strconv.ParseUint(retries, 10, 32); err != nil {
+			return contact, fmt.Errorf("invalid `webhook_retries` `%s`", retries)
+		}
+		contact.Retries = uint(parsed)
+	}
+	contact.Backoff = defaultWebhookBackoff
+	if backoff, ok := section["webhook_backoff"]; ok {
+		if contact.Backoff, err = time.ParseDuration(backoff); err != nil {
+			return contact, fmt.Errorf("invalid `webhook_backoff` `%s`", backoff)
+		}
+	}
+	timeout := defaultWebhookTimeout
+	if value, ok := section["webhook_timeout"]; ok {
+		if timeout, err = time.ParseDuration(value); err != nil || timeout <= 0 {
+			return contact, fmt.Errorf("invalid `webhook_timeout` `%s`", value)
+		}
+	}
+
+	certFile, keyFile := section["webhook_cert"], section["webhook_key"]
+	if (certFile == "") != (keyFile == "") {
+		return contact, fmt.Errorf("both, `webhook_cert` and `webhook_key` have to be defined")
+	}
+	contact.client, err = newWebhookClient(timeout, certFile, keyFile, section["webhook_ca"])
+	return
+}
+
 func (p *Parser) ParseConfig(path string) (config Config, err error) {
 	file, err := ini.LoadFile(path)
 	if err != nil {
@@ -65,6 +151,26 @@ func (p *Parser) ParseConfig(path string) (config Config, err error) {
 		return
 	}
 
+	config.mode = modeStream
+	if mode, ok := notifier["mode"]; ok {
+		if mode != modeStream && mode != modePoll {
+			err = fmt.Errorf("Invalid 'mode' `%s` in 'notifier' section in config file, must be `%s` or `%s`", mode, modeStream, modePoll)
+			return
+		}
+		config.mode = mode
+	}
+	config.stateFile = defaultStateFile
+	if stateFile, ok := notifier["state_file"]; ok {
+		config.stateFile = stateFile
+	}
+	config.pollInterval = defaultPollInterval
+	if pollInterval, ok := notifier["poll_interval"]; ok {
+		if config.pollInterval, err = time.ParseDuration(pollInterval); err != nil || config.pollInterval <= 0 {
+			err = fmt.Errorf("Invalid 'poll_interval' `%s` in 'notifier' section in config file", pollInterval)
+			return
+		}
+	}
+
 	// SMTP section
 	smtp, ok := file["smtp"]
 	if !ok {
@@ -81,5 +187,38 @@ func (p *Parser) ParseConfig(path string) (config Config, err error) {
 		err = fmt.Errorf("Missing 'relay_host' veriable in 'smtp' section in config file")
 		return
 	}
+	config.relay.TLS = smtpTLSAuto
+	if tlsMode, ok := smtp["tls"]; ok {
+		switch tlsMode {
+		case smtpTLSAuto, smtpTLSStartTLS, smtpTLSImplicit, smtpTLSNone:
+			config.relay.TLS = tlsMode
+		default:
+			err = fmt.Errorf("Invalid 'tls' `%s` in 'smtp' section in config file, must be one of `%s`, `%s`, `%s`, `%s`",
+				tlsMode, smtpTLSAuto, smtpTLSStartTLS, smtpTLSImplicit, smtpTLSNone)
+			return
+		}
+	}
+	if caFile, ok := smtp["ca_file"]; ok {
+		var caCert *x509.Certificate
+		if caCert, err = loadCertificateFromFile(caFile); err != nil {
+			err = fmt.Errorf("Error loading 'ca_file' `%s` in 'smtp' section in config file: %s", caFile, err)
+			return
+		}
+		config.relay.RootCAs = x509.NewCertPool()
+		config.relay.RootCAs.AddCert(caCert)
+	}
+	config.relay.Auth = smtp["auth"]
+	switch config.relay.Auth {
+	case smtpAuthNone:
+	case smtpAuthPlain, smtpAuthCRAMMD5:
+		if config.relay.Username, ok = smtp["username"]; !ok {
+			err = fmt.Errorf("Missing 'username' veriable in 'smtp' section in config file, required by 'auth'")
+			return
+		}
+		config.relay.Password = smtp["password"]
+	default:
+		err = fmt.Errorf("Invalid 'auth' `%s` in 'smtp' section in config file, must be `%s` or `%s`", config.relay.Auth, smtpAuthPlain, smtpAuthCRAMMD5)
+		return
+	}
 	return
 }
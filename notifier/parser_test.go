@@ -5,6 +5,7 @@ import (
 	"io/ioutil"
 	"os"
 	"testing"
+	"time"
 )
 
 func TestConfigFile(t *testing.T) {
@@ -32,6 +33,34 @@ func TestConfigFile(t *testing.T) {
 	assert.Equal(t, config.apiHost, "localhost:8080")
 	assert.Equal(t, config.relay.Hostname, "localhost:25")
 	assert.Equal(t, config.relay.MailFrom, "test@localhost")
+	assert.Equal(t, config.mode, modeStream)
+	assert.Equal(t, config.stateFile, defaultStateFile)
+	assert.Equal(t, config.pollInterval, defaultPollInterval)
+	assert.Equal(t, config.relay.TLS, smtpTLSAuto)
+	assert.Equal(t, config.relay.Auth, smtpAuthNone)
+}
+
+func TestConfigFileMode(t *testing.T) {
+	var p Parser
+	for mode, valid := range map[string]bool{"mode=poll\npoll_interval=1m": true, "mode=push": false, "poll_interval=-1s": false} {
+		tmpFile, err := ioutil.TempFile(os.TempDir(), "mamid_test")
+		assert.NoError(t, err)
+		_, err = tmpFile.WriteString("[smtp]\nrelay_host=localhost:25\nmail_from=test@localhost\n")
+		assert.NoError(t, err)
+		_, err = tmpFile.WriteString("[notifier]\napi_host=localhost:8080\ncontacts=contacts.ini\n" + mode + "\n")
+		assert.NoError(t, err)
+		tmpFile.Close()
+
+		config, err := p.ParseConfig(tmpFile.Name())
+		os.Remove(tmpFile.Name())
+		if valid {
+			assert.NoError(t, err)
+			assert.Equal(t, modePoll, config.mode)
+			assert.Equal(t, time.Minute, config.pollInterval)
+		} else {
+			assert.Error(t, err, mode)
+		}
+	}
 }
 
 func TestConfigFileMissingFile(t *testing.T) {
@@ -155,3 +184,91 @@ func TestContactsFileNoFile(t *testing.T) {
 	_, err := p.Parse("")
 	assert.Error(t, err)
 }
+
+func TestContactsFileWebhook(t *testing.T) {
+	tmpFile, err := ioutil.TempFile(os.TempDir(), "mamid_test")
+	assert.NoError(t, err)
+	defer os.Remove(tmpFile.Name())
+	_, err = tmpFile.WriteString("[chat]\n")
+	assert.NoError(t, err)
+	_, err = tmpFile.WriteString("webhook=https://chat.example.com/hooks/mamid\n")
+	assert.NoError(t, err)
+	_, err = tmpFile.WriteString("webhook_header_Authorization=Bearer secret\n")
+	assert.NoError(t, err)
+	_, err = tmpFile.WriteString("webhook_retries=5\n")
+	assert.NoError(t, err)
+	tmpFile.Sync()
+	tmpFile.Close()
+
+	var p Parser
+	contacts, err := p.Parse(tmpFile.Name())
+	assert.NoError(t, err)
+	if assert.Equal(t, len(contacts), 1) {
+		contact := contacts[0].(WebhookContact)
+		assert.Equal(t, "chat", contact.Name)
+		assert.Equal(t, "https://chat.example.com/hooks/mamid", contact.URL)
+		assert.Equal(t, map[string]string{"Authorization": "Bearer secret"}, contact.Headers)
+		assert.Equal(t, uint(5), contact.Retries)
+		assert.Equal(t, defaultWebhookBackoff, contact.Backoff)
+		assert.Equal(t, defaultWebhookTimeout, contact.client.Timeout)
+	}
+}
+
+func TestContactsFileFilters(t *testing.T) {
+	tmpFile, err := ioutil.TempFile(os.TempDir(), "mamid_test")
+	assert.NoError(t, err)
+	defer os.Remove(tmpFile.Name())
+	_, err = tmpFile.WriteString("[dba]\n")
+	assert.NoError(t, err)
+	_, err = tmpFile.WriteString("email=dba@localhost\n")
+	assert.NoError(t, err)
+	_, err = tmpFile.WriteString("replica_sets=shop\n")
+	assert.NoError(t, err)
+	_, err = tmpFile.WriteString("min_age=1m\n")
+	assert.NoError(t, err)
+	tmpFile.Sync()
+	tmpFile.Close()
+
+	var p Parser
+	contacts, err := p.Parse(tmpFile.Name())
+	assert.NoError(t, err)
+	if assert.Equal(t, len(contacts), 1) {
+		assert.Equal(t, Subscription{ReplicaSets: []string{"shop"}, MinAge: time.Minute}, contacts[0].(EmailContact).Subscription)
+	}
+}
+
+func TestConfigFileSMTP(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "mamid_test")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	caFile, _ := writeTestCertificate(t, dir)
+
+	var p Parser
+	for options, valid := range map[string]bool{
+		"tls=implicit\nca_file=" + caFile + "\nauth=cram-md5\nusername=notifier\npassword=secret": true,
+		"tls=ssl":                       false,
+		"ca_file=/nonexistent":          false,
+		"auth=login\nusername=notifier": false,
+		"auth=plain":                    false,
+	} {
+		tmpFile, err := ioutil.TempFile(dir, "mamid_test")
+		assert.NoError(t, err)
+		_, err = tmpFile.WriteString("[notifier]\napi_host=localhost:8080\ncontacts=contacts.ini\n")
+		assert.NoError(t, err)
+		_, err = tmpFile.WriteString("[smtp]\nrelay_host=localhost:465\nmail_from=test@localhost\n" + options + "\n")
+		assert.NoError(t, err)
+		tmpFile.Close()
+
+		config, err := p.ParseConfig(tmpFile.Name())
+		if valid {
+			assert.NoError(t, err)
+			assert.Equal(t, smtpTLSImplicit, config.relay.TLS)
+			assert.NotNil(t, config.relay.RootCAs)
+			assert.Equal(t, smtpAuthCRAMMD5, config.relay.Auth)
+			assert.Equal(t, "notifier", config.relay.Username)
+			assert.Equal(t, "secret", config.relay.Password)
+		} else {
+			assert.Error(t, err, options)
+		}
+	}
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"fmt"
+	"github.com/vaughan0/go-ini"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+	Routing
+
+	Every contact may restrict the Problems it is notified about in its section of the contacts file:
+
+		[dba]
+		email=dba@name.tld
+		# comma separated lists, a Problem must match every configured filter
+		problem_types=replication_lag,oplog_window
+		replica_sets=shop,42
+		slaves=host1.name.tld
+		risk_groups=rack1
+		# the Problem must persist this long before the contact is notified
+		min_age=5m
+
+	Replica Sets, Slaves and risk groups can be given by name or ID, a risk group matches the Slave's risk group and all of its ancestors.
+	Problems that concern no Slave do not match a `slaves` or `risk_groups` filter, Problems that concern no Replica Set no `replica_sets` filter.
+
+	A contact is notified about the resolution of a Problem only if it was notified about the Problem.
+*/
+
+// Problems are checked for pending notifications, i.e. with `min_age`, at this interval
+const pendingDispatchInterval = 10 * time.Second
+
+// Risk groups are nested a few levels only, the limit guards against cycles
+const maxRiskGroupDepth = 16
+
+// A contact and the notifier delivering to it
+type route struct {
+	key          string // identifies the contact in the delivery state, e.g. `email/eve`
+	subscription Subscription
+	notifier     Notifier
+}
+
+func parseSubscription(section ini.Section) (subscription Subscription, err error) {
+	list := func(key string) (values []string) {
+		for _, value := range strings.Split(section[key], ",") {
+			if value = strings.TrimSpace(value); value != "" {
+				values = append(values, value)
+			}
+		}
+		return values
+	}
+	subscription.ProblemTypes = list("problem_types")
+	subscription.ReplicaSets = list("replica_sets")
+	subscription.Slaves = list("slaves")
+	subscription.RiskGroups = list("risk_groups")
+	if minAge, ok := section["min_age"]; ok {
+		if subscription.MinAge, err = time.ParseDuration(minAge); err != nil || subscription.MinAge < 0 {
+			return subscription, fmt.Errorf("invalid `min_age` `%s`", minAge)
+		}
+	}
+	return subscription, nil
+}
+
+// Whether the filters require the ProblemContext
+func (s Subscription) needsContext() bool {
+	return len(s.ReplicaSets) > 0 || len(s.Slaves) > 0 || len(s.RiskGroups) > 0
+}
+
+// Whether the Problem matches the filters, regardless of its age
+func (s Subscription) Matches(problem Problem) bool {
+	context := ProblemContext{}
+	if problem.Context != nil {
+		context = *problem.Context
+	}
+	if len(s.ProblemTypes) > 0 && !matchesAny(s.ProblemTypes, problem.ProblemType) {
+		return false
+	}
+	if len(s.ReplicaSets) > 0 && (problem.ReplicaSet == nil || !matchesAny(s.ReplicaSets, formatID(*problem.ReplicaSet), context.ReplicaSetName)) {
+		return false
+	}
+	if len(s.Slaves) > 0 && (problem.Slave == nil || !matchesAny(s.Slaves, formatID(*problem.Slave), context.SlaveHostname)) {
+		return false
+	}
+	if len(s.RiskGroups) > 0 {
+		var candidates []string
+		for _, riskGroup := range context.RiskGroups {
+			candidates = append(candidates, formatID(riskGroup.Id), riskGroup.Name)
+		}
+		if !matchesAny(s.RiskGroups, candidates...) {
+			return false
+		}
+	}
+	return true
+}
+
+// Whether the Problem persisted for MinAge at now, Problems of unknown age are considered old enough
+func (s Subscription) isDue(problem Problem, now time.Time) bool {
+	return problem.FirstOccurred.IsZero() || now.Sub(problem.FirstOccurred) >= s.MinAge
+}
+
+func matchesAny(filter []string, candidates ...string) bool {
+	for _, value := range filter {
+		for _, candidate := range candidates {
+			if candidate != "" && value == candidate {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func formatID(id uint) string {
+	return strconv.FormatUint(uint64(id), 10)
+}
+
+// Fetch the names of the Replica Set, Slave and risk groups affected by the Problem.
+// Failures are logged, filters on names do not match then.
+func fetchProblemContext(apiClient *APIClient, host string, problem Problem) *ProblemContext {
+	context := &ProblemContext{}
+	if problem.ReplicaSet != nil {
+		replicaSet, err := apiClient.ReplicaSet(host, *problem.ReplicaSet)
+		if err != nil {
+			log.Errorf("Error fetching Replica Set `%d` of Problem `%d`: %s", *problem.ReplicaSet, problem.Id, err)
+		}
+		context.ReplicaSetName = replicaSet.Name
+	}
+	if problem.Slave != nil {
+		slave, err := apiClient.Slave(host, *problem.Slave)
+		if err != nil {
+			log.Errorf("Error fetching Slave `%d` of Problem `%d`: %s", *problem.Slave, problem.Id, err)
+		}
+		context.SlaveHostname = slave.Hostname
+		for riskGroupID := slave.RiskGroup; riskGroupID != nil && len(context.RiskGroups) < maxRiskGroupDepth; {
+			riskGroup, err := apiClient.RiskGroup(host, *riskGroupID)
+			if err != nil {
+				log.Errorf("Error fetching risk group `%d` of Problem `%d`: %s", *riskGroupID, problem.Id, err)
+				break
+			}
+			context.RiskGroups = append(context.RiskGroups, riskGroup)
+			riskGroupID = riskGroup.Parent
+		}
+	}
+	return context
+}
+
+// Record a Problem that is not known yet, fetching its context if a route requires it
+func addProblem(apiClient *APIClient, host string, problem Problem) {
+	for _, r := range routes {
+		if r.subscription.needsContext() {
+			problem.Context = fetchProblemContext(apiClient, host, problem)
+			break
+		}
+	}
+	lastProblems[problem.Id] = problem
+}
+
+// Notify every contact about the open Problems that match its subscription, are due and were not notified yet.
+// Returns the number of notifications sent.
+func dispatch(now time.Time) (sent int) {
+	for id, problem := range lastProblems {
+		for _, r := range routes {
+			if isDelivered(id, r.key) || !r.subscription.Matches(problem) || !r.subscription.isDue(problem, now) {
+				continue
+			}
+			// Failed notifications are not repeated, like a lost mail
+			deliveries[id] = append(deliveries[id], r.key)
+			sent++
+			if err := r.notifier.SendProblem(problem); err != nil {
+				log.Errorf("Error sending notification to `%s`: %s", r.key, err)
+			}
+		}
+	}
+	return sent
+}
+
+// Notify the contacts that were notified about the Problem about its resolution and forget the Problem
+func resolve(problem Problem, resolved time.Time) {
+	for _, key := range deliveries[problem.Id] {
+		for _, r := range routes {
+			if r.key != key {
+				continue
+			}
+			if err := r.notifier.SendResolution(problem, resolved); err != nil {
+				log.Errorf("Error sending notification to `%s`: %s", r.key, err)
+			}
+		}
+	}
+	delete(deliveries, problem.Id)
+	delete(lastProblems, problem.Id)
+}
+
+func isDelivered(problemID uint, key string) bool {
+	for _, delivered := range deliveries[problemID] {
+		if delivered == key {
+			return true
+		}
+	}
+	return false
+}
+
+// Send notifications whose `min_age` passed in the meantime
+func dispatchPending() {
+	for range time.Tick(pendingDispatchInterval) {
+		stateMutex.Lock()
+		if dispatch(time.Now()) > 0 {
+			saveState()
+		}
+		stateMutex.Unlock()
+	}
+}
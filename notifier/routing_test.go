@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/vaughan0/go-ini"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestParseSubscription(t *testing.T) {
+	subscription, err := parseSubscription(ini.Section{
+		"email":         "dba@localhost",
+		"problem_types": "replication_lag, oplog_window",
+		"replica_sets":  "shop,42,",
+		"min_age":       "5m",
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, Subscription{
+		ProblemTypes: []string{"replication_lag", "oplog_window"},
+		ReplicaSets:  []string{"shop", "42"},
+		MinAge:       5 * time.Minute,
+	}, subscription)
+
+	_, err = parseSubscription(ini.Section{"min_age": "5"})
+	assert.Error(t, err)
+}
+
+func TestSubscription_Matches(t *testing.T) {
+	replicaSetID, slaveID := uint(42), uint(2)
+	replicaSetProblem := Problem{Id: 1, ProblemType: "replication_lag", ReplicaSet: &replicaSetID, Context: &ProblemContext{ReplicaSetName: "shop"}}
+	slaveProblem := Problem{Id: 2, ProblemType: "connection", Slave: &slaveID, Context: &ProblemContext{
+		SlaveHostname: "host2",
+		RiskGroups:    []RiskGroup{{Id: 3, Name: "rack1"}, {Id: 1, Name: "dc1"}},
+	}}
+
+	for _, c := range []struct {
+		subscription Subscription
+		problem      Problem
+		matches      bool
+	}{
+		{Subscription{}, replicaSetProblem, true},
+		{Subscription{ProblemTypes: []string{"connection"}}, slaveProblem, true},
+		{Subscription{ProblemTypes: []string{"connection"}}, replicaSetProblem, false},
+		{Subscription{ReplicaSets: []string{"shop"}}, replicaSetProblem, true},
+		{Subscription{ReplicaSets: []string{"42"}}, replicaSetProblem, true},
+		{Subscription{ReplicaSets: []string{"blog"}}, replicaSetProblem, false},
+		{Subscription{ReplicaSets: []string{"shop"}}, slaveProblem, false},
+		{Subscription{ReplicaSets: []string{"shop"}, ProblemTypes: []string{"oplog_window"}}, replicaSetProblem, false},
+		{Subscription{Slaves: []string{"host2"}}, slaveProblem, true},
+		{Subscription{Slaves: []string{"2"}}, slaveProblem, true},
+		{Subscription{Slaves: []string{"host1"}}, slaveProblem, false},
+		{Subscription{Slaves: []string{"host2"}}, replicaSetProblem, false},
+		{Subscription{RiskGroups: []string{"rack1"}}, slaveProblem, true},
+		{Subscription{RiskGroups: []string{"dc1"}}, slaveProblem, true},
+		{Subscription{RiskGroups: []string{"1"}}, slaveProblem, true},
+		{Subscription{RiskGroups: []string{"rack2"}}, slaveProblem, false},
+		{Subscription{RiskGroups: []string{"rack1"}}, replicaSetProblem, false},
+		{Subscription{Slaves: []string{"host2"}}, Problem{Id: 3, Slave: &slaveID}, false},
+	} {
+		assert.Equal(t, c.matches, c.subscription.Matches(c.problem), "%+v %+v", c.subscription, c.problem)
+	}
+}
+
+func TestDispatch_minAge(t *testing.T) {
+	now := time.Date(2016, time.August, 1, 14, 0, 0, 0, time.UTC)
+	lastProblems = map[uint]Problem{1: {Id: 1, FirstOccurred: now}}
+	deliveries = make(map[uint][]string)
+	immediate, delayed := &recordingNotifier{}, &recordingNotifier{}
+	routes = []route{
+		{key: "test/immediate", notifier: immediate},
+		{key: "test/delayed", notifier: delayed, subscription: Subscription{MinAge: 5 * time.Minute}},
+	}
+	defer func() { routes = nil }()
+
+	assert.Equal(t, 1, dispatch(now))
+	assert.Len(t, immediate.sent, 1)
+	assert.Empty(t, delayed.sent)
+
+	assert.Equal(t, 0, dispatch(now.Add(time.Minute)))
+	assert.Equal(t, 1, dispatch(now.Add(5*time.Minute)))
+	assert.Len(t, immediate.sent, 1)
+	assert.Len(t, delayed.sent, 1)
+
+	// Resolved before the minimum age
+	lastProblems[2] = Problem{Id: 2, FirstOccurred: now}
+	assert.Equal(t, 1, dispatch(now.Add(time.Minute)))
+	resolve(lastProblems[2], now.Add(2*time.Minute))
+	assert.Len(t, immediate.resolved, 1)
+	assert.Empty(t, delayed.resolved, "contacts are notified about resolutions of Problems they were notified about only")
+	assert.Equal(t, 0, dispatch(now.Add(10*time.Minute)))
+	assert.Len(t, delayed.sent, 1)
+}
+
+func TestFetchProblemContext(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/replicasets/42":
+			fmt.Fprint(w, `{"id":42,"name":"shop"}`)
+		case "/api/slaves/2":
+			fmt.Fprint(w, `{"id":2,"hostname":"host2","risk_group_id":3}`)
+		case "/api/riskgroups/3":
+			fmt.Fprint(w, `{"id":3,"name":"rack1","parent_id":1}`)
+		case "/api/riskgroups/1":
+			fmt.Fprint(w, `{"id":1,"name":"dc1","parent_id":null}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	var client APIClient
+	replicaSetID, slaveID, unknownID, dc1ID := uint(42), uint(2), uint(7), uint(1)
+	context := fetchProblemContext(&client, server.URL, Problem{Id: 1, ReplicaSet: &replicaSetID, Slave: &slaveID})
+	assert.Equal(t, &ProblemContext{
+		ReplicaSetName: "shop",
+		SlaveHostname:  "host2",
+		RiskGroups:     []RiskGroup{{Id: 3, Name: "rack1", Parent: &dc1ID}, {Id: 1, Name: "dc1"}},
+	}, context)
+
+	context = fetchProblemContext(&client, server.URL, Problem{Id: 2, Slave: &unknownID})
+	assert.Equal(t, &ProblemContext{}, context, "the Slave was deleted")
+}
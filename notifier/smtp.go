@@ -0,0 +1,290 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"fmt"
+	"html/template"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"strings"
+	"time"
+)
+
+/*
+	SMTP delivery
+
+	Mails are delivered to the relay in a single session per notification, one transaction per recipient.
+	A recipient rejected by the relay does not prevent delivery to the others.
+
+	The connection is secured depending on SMTPRelay.TLS:
+
+		auto      STARTTLS if the relay offers it (default)
+		starttls  STARTTLS, the relay must offer it
+		implicit  TLS from the start (SMTPS, usually port 465)
+		none      no encryption
+
+	The relay's certificate is verified against SMTPRelay.RootCAs or the system's CAs if nil.
+	PLAIN authentication requires an encrypted connection unless the relay is localhost.
+*/
+
+const (
+	smtpTLSAuto     = "auto"
+	smtpTLSStartTLS = "starttls"
+	smtpTLSImplicit = "implicit"
+	smtpTLSNone     = "none"
+)
+
+const (
+	smtpAuthNone    = ""
+	smtpAuthPlain   = "plain"
+	smtpAuthCRAMMD5 = "cram-md5"
+)
+
+// Deadline of an SMTP session
+const smtpTimeout = 1 * time.Minute
+
+// A mail with plain text and HTML alternatives
+type mailMessage struct {
+	Subject string
+	Summary string
+	Details []string
+	Links   []mailLink
+}
+
+type mailLink struct {
+	Title string
+	URL   string
+}
+
+var mailHTMLTemplate = template.Must(template.New("mail").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="UTF-8"><title>{{.Subject}}</title></head>
+<body>
+<p><strong>{{.Summary}}</strong></p>
+{{if .Details}}<ul>
+{{range .Details}}<li>{{.}}</li>
+{{end}}</ul>
+{{end}}{{range .Links}}<p><a href="{{.URL}}">{{.Title}}</a></p>
+{{end}}</body>
+</html>
+`))
+
+func (m mailMessage) text() string {
+	var text bytes.Buffer
+	text.WriteString(m.Summary + "\r\n")
+	for _, detail := range m.Details {
+		text.WriteString(detail + "\r\n")
+	}
+	for _, link := range m.Links {
+		text.WriteString("\r\n" + link.Title + ": " + link.URL + "\r\n")
+	}
+	return text.String()
+}
+
+// Render the message for the recipient as MIME multipart/alternative
+func (m mailMessage) render(from string, to string, date time.Time) ([]byte, error) {
+	var body bytes.Buffer
+	parts := multipart.NewWriter(&body)
+	if err := writeQuotedPrintablePart(parts, "text/plain; charset=UTF-8", func(w io.Writer) error {
+		_, err := io.WriteString(w, m.text())
+		return err
+	}); err != nil {
+		return nil, err
+	}
+	if err := writeQuotedPrintablePart(parts, "text/html; charset=UTF-8", func(w io.Writer) error {
+		return mailHTMLTemplate.Execute(w, m)
+	}); err != nil {
+		return nil, err
+	}
+	if err := parts.Close(); err != nil {
+		return nil, err
+	}
+
+	messageID, err := newMessageID(from)
+	if err != nil {
+		return nil, err
+	}
+
+	var msg bytes.Buffer
+	for _, header := range [][2]string{
+		{"From", from},
+		{"To", to},
+		{"Subject", mime.BEncoding.Encode("utf-8", m.Subject)},
+		{"Date", date.Format(time.RFC1123Z)},
+		{"Message-ID", messageID},
+		{"MIME-Version", "1.0"},
+		{"Content-Type", fmt.Sprintf("multipart/alternative; boundary=%q", parts.Boundary())},
+	} {
+		msg.WriteString(header[0] + ": " + header[1] + "\r\n")
+	}
+	msg.WriteString("\r\n")
+	msg.Write(body.Bytes())
+	return msg.Bytes(), nil
+}
+
+func writeQuotedPrintablePart(parts *multipart.Writer, contentType string, write func(io.Writer) error) error {
+	part, err := parts.CreatePart(textproto.MIMEHeader{
+		"Content-Type":              {contentType},
+		"Content-Transfer-Encoding": {"quoted-printable"},
+	})
+	if err != nil {
+		return err
+	}
+	encoder := quotedprintable.NewWriter(part)
+	if err = write(encoder); err != nil {
+		return err
+	}
+	return encoder.Close()
+}
+
+// A unique Message-ID in the domain of the sender's address
+func newMessageID(from string) (string, error) {
+	random := make([]byte, 12)
+	if _, err := rand.Read(random); err != nil {
+		return "", err
+	}
+	domain := "mamid.localhost"
+	if at := strings.LastIndex(from, "@"); at != -1 && at < len(from)-1 {
+		domain = strings.Trim(from[at+1:], "<> ")
+	}
+	return fmt.Sprintf("<%d.%s@%s>", time.Now().UnixNano(), hex.EncodeToString(random), domain), nil
+}
+
+// Failures of individual recipients of a mail
+type RecipientErrors map[string]error
+
+func (e RecipientErrors) Error() string {
+	var failures []string
+	for recipient, err := range e {
+		failures = append(failures, fmt.Sprintf("`%s`: %s", recipient, err))
+	}
+	return "could not deliver mail to " + strings.Join(failures, ", ")
+}
+
+func (r SMTPRelay) tlsConfig() *tls.Config {
+	host, _, err := net.SplitHostPort(r.Hostname)
+	if err != nil {
+		host = r.Hostname
+	}
+	return &tls.Config{ServerName: host, RootCAs: r.RootCAs}
+}
+
+// Connect, secure the connection and authenticate
+func (r SMTPRelay) dial() (*smtp.Client, error) {
+	var conn net.Conn
+	var err error
+	if r.TLS == smtpTLSImplicit {
+		conn, err = tls.DialWithDialer(&net.Dialer{Timeout: smtpTimeout}, "tcp", r.Hostname, r.tlsConfig())
+	} else {
+		conn, err = net.DialTimeout("tcp", r.Hostname, smtpTimeout)
+	}
+	if err != nil {
+		return nil, err
+	}
+	conn.SetDeadline(time.Now().Add(smtpTimeout))
+
+	tlsConfig := r.tlsConfig()
+	client, err := smtp.NewClient(conn, tlsConfig.ServerName)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	if r.TLS == smtpTLSAuto || r.TLS == smtpTLSStartTLS {
+		if ok, _ := client.Extension("STARTTLS"); ok {
+			if err = client.StartTLS(tlsConfig); err != nil {
+				client.Close()
+				return nil, fmt.Errorf("STARTTLS failed: %s", err)
+			}
+		} else if r.TLS == smtpTLSStartTLS {
+			client.Close()
+			return nil, fmt.Errorf("relay `%s` does not support STARTTLS", r.Hostname)
+		}
+	}
+
+	var auth smtp.Auth
+	switch r.Auth {
+	case smtpAuthPlain:
+		auth = smtp.PlainAuth("", r.Username, r.Password, tlsConfig.ServerName)
+	case smtpAuthCRAMMD5:
+		auth = smtp.CRAMMD5Auth(r.Username, r.Password)
+	}
+	if auth != nil {
+		if ok, _ := client.Extension("AUTH"); !ok {
+			client.Close()
+			return nil, fmt.Errorf("relay `%s` does not support authentication", r.Hostname)
+		}
+		if err = client.Auth(auth); err != nil {
+			client.Close()
+			return nil, fmt.Errorf("authentication failed: %s", err)
+		}
+	}
+
+	return client, nil
+}
+
+// Deliver the message to every recipient.
+// Returns RecipientErrors if recipients failed after the session with the relay was established.
+func (r SMTPRelay) send(recipients []string, message mailMessage) error {
+	if len(recipients) == 0 {
+		return nil
+	}
+	client, err := r.dial()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	failures := make(RecipientErrors)
+	now := time.Now()
+	for i, recipient := range recipients {
+		err := r.sendTo(client, recipient, message, now)
+		if err == nil {
+			continue
+		}
+		failures[recipient] = err
+		if _, rejected := err.(*textproto.Error); !rejected {
+			// the connection failed, e.g. timed out
+			for _, remaining := range recipients[i+1:] {
+				failures[remaining] = err
+			}
+			return failures
+		}
+		client.Reset()
+	}
+	client.Quit()
+
+	if len(failures) > 0 {
+		return failures
+	}
+	return nil
+}
+
+func (r SMTPRelay) sendTo(client *smtp.Client, recipient string, message mailMessage, date time.Time) error {
+	msg, err := message.render(r.MailFrom, recipient, date)
+	if err != nil {
+		return err
+	}
+	if err = client.Mail(r.MailFrom); err != nil {
+		return err
+	}
+	if err = client.Rcpt(recipient); err != nil {
+		return err
+	}
+	data, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err = data.Write(msg); err != nil {
+		return err
+	}
+	return data.Close()
+}
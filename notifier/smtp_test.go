@@ -0,0 +1,321 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/textproto"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const (
+	fakeSMTPUsername = "notifier"
+	fakeSMTPPassword = "secret"
+)
+
+// A minimal SMTP server recording the delivered messages
+type fakeSMTPServer struct {
+	listener  net.Listener
+	startTLS  *tls.Config // STARTTLS is offered if not nil
+	auth      bool        // PLAIN and CRAM-MD5 authentication is offered and required if true
+	rejected  map[string]bool
+	mutex     sync.Mutex
+	messages  map[string][]byte // by recipient
+	encrypted bool              // whether the last message was received over an encrypted connection
+}
+
+func startFakeSMTPServer(t *testing.T, listener net.Listener) *fakeSMTPServer {
+	s := &fakeSMTPServer{listener: listener, rejected: make(map[string]bool), messages: make(map[string][]byte)}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(t, conn)
+		}
+	}()
+	return s
+}
+
+func (s *fakeSMTPServer) serve(t *testing.T, conn net.Conn) {
+	defer conn.Close()
+	_, encrypted := conn.(*tls.Conn)
+	text := textproto.NewConn(conn)
+	text.PrintfLine("220 localhost fake ESMTP")
+	authenticated := !s.auth
+	var recipient string
+	for {
+		line, err := text.ReadLine()
+		if err != nil {
+			return
+		}
+		command := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+		switch {
+		case command == "EHLO":
+			text.PrintfLine("250-localhost")
+			if s.startTLS != nil && !encrypted {
+				text.PrintfLine("250-STARTTLS")
+			}
+			if s.auth {
+				text.PrintfLine("250-AUTH PLAIN CRAM-MD5")
+			}
+			text.PrintfLine("250 8BITMIME")
+		case command == "STARTTLS" && s.startTLS != nil:
+			text.PrintfLine("220 ready")
+			tlsConn := tls.Server(conn, s.startTLS)
+			if err := tlsConn.Handshake(); err != nil {
+				return
+			}
+			conn, encrypted = tlsConn, true
+			text = textproto.NewConn(conn)
+		case strings.HasPrefix(line, "AUTH PLAIN "):
+			credentials, _ := base64.StdEncoding.DecodeString(strings.TrimPrefix(line, "AUTH PLAIN "))
+			authenticated = string(credentials) == "\x00"+fakeSMTPUsername+"\x00"+fakeSMTPPassword
+			s.replyAuth(text, authenticated)
+		case line == "AUTH CRAM-MD5":
+			challenge := "<1.1@localhost>"
+			text.PrintfLine("334 %s", base64.StdEncoding.EncodeToString([]byte(challenge)))
+			response, _ := text.ReadLine()
+			decoded, _ := base64.StdEncoding.DecodeString(response)
+			mac := hmac.New(md5.New, []byte(fakeSMTPPassword))
+			mac.Write([]byte(challenge))
+			authenticated = string(decoded) == fakeSMTPUsername+" "+hex.EncodeToString(mac.Sum(nil))
+			s.replyAuth(text, authenticated)
+		case command == "MAIL":
+			if !authenticated {
+				text.PrintfLine("530 authentication required")
+				continue
+			}
+			text.PrintfLine("250 ok")
+		case command == "RCPT":
+			recipient = strings.Trim(strings.TrimPrefix(line, "RCPT TO:"), "<>")
+			if s.rejected[recipient] {
+				text.PrintfLine("550 no such user")
+				continue
+			}
+			text.PrintfLine("250 ok")
+		case command == "DATA":
+			text.PrintfLine("354 go ahead")
+			data, err := text.ReadDotBytes()
+			if !assert.NoError(t, err) {
+				return
+			}
+			s.mutex.Lock()
+			s.messages[recipient] = data
+			s.encrypted = encrypted
+			s.mutex.Unlock()
+			text.PrintfLine("250 ok")
+		case command == "RSET" || command == "NOOP":
+			text.PrintfLine("250 ok")
+		case command == "QUIT":
+			text.PrintfLine("221 bye")
+			return
+		default:
+			text.PrintfLine("502 not implemented")
+		}
+	}
+}
+
+func (s *fakeSMTPServer) replyAuth(text *textproto.Conn, authenticated bool) {
+	if authenticated {
+		text.PrintfLine("235 ok")
+	} else {
+		text.PrintfLine("535 invalid credentials")
+	}
+}
+
+func (s *fakeSMTPServer) wasEncrypted() bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.encrypted
+}
+
+func (s *fakeSMTPServer) message(recipient string) ([]byte, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	message, ok := s.messages[recipient]
+	return message, ok
+}
+
+func loadTestTLS(t *testing.T) (serverConfig *tls.Config, rootCAs *x509.CertPool, cleanup func()) {
+	dir, err := ioutil.TempDir(os.TempDir(), "mamid_test")
+	assert.NoError(t, err)
+	certFile, keyFile := writeTestCertificate(t, dir)
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	assert.NoError(t, err)
+	caCert, err := loadCertificateFromFile(certFile)
+	assert.NoError(t, err)
+	rootCAs = x509.NewCertPool()
+	rootCAs.AddCert(caCert)
+	return &tls.Config{Certificates: []tls.Certificate{cert}}, rootCAs, func() { os.RemoveAll(dir) }
+}
+
+// Parse a message into its headers and the decoded bodies by content type
+func parseTestMail(t *testing.T, data []byte) (mail.Header, map[string]string) {
+	assert.Contains(t, string(data), "Content-Transfer-Encoding: quoted-printable")
+	msg, err := mail.ReadMessage(bytes.NewReader(data))
+	if !assert.NoError(t, err) {
+		return nil, nil
+	}
+	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	assert.NoError(t, err)
+	assert.Equal(t, "multipart/alternative", mediaType)
+
+	bodies := make(map[string]string)
+	parts := multipart.NewReader(msg.Body, params["boundary"])
+	for {
+		part, err := parts.NextPart()
+		if err != nil {
+			break
+		}
+		contentType, _, _ := mime.ParseMediaType(part.Header.Get("Content-Type"))
+		// the multipart reader decodes quoted-printable parts and removes the header
+		var reader io.Reader = part
+		if part.Header.Get("Content-Transfer-Encoding") == "quoted-printable" {
+			reader = quotedprintable.NewReader(part)
+		}
+		body, err := ioutil.ReadAll(reader)
+		assert.NoError(t, err)
+		bodies[contentType] = string(body)
+	}
+	return msg.Header, bodies
+}
+
+func TestEmailNotifier_SendProblem(t *testing.T) {
+	serverTLS, rootCAs, cleanup := loadTestTLS(t)
+	defer cleanup()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer listener.Close()
+	server := startFakeSMTPServer(t, listener)
+	server.startTLS = serverTLS
+	server.auth = true
+	server.rejected["bob@localhost"] = true
+
+	notifier := EmailNotifier{
+		Contacts: []*EmailContact{{Address: "alice@localhost"}, {Address: "bob@localhost"}, {Address: "carol@localhost"}},
+		Relay: SMTPRelay{
+			Hostname: listener.Addr().String(),
+			MailFrom: "mamid@example.com",
+			TLS:      smtpTLSStartTLS,
+			RootCAs:  rootCAs,
+			Auth:     smtpAuthPlain,
+			Username: fakeSMTPUsername,
+			Password: fakeSMTPPassword,
+		},
+		MamidHost: "http://mamid",
+	}
+	replicaSetID := uint(42)
+	err = notifier.SendProblem(Problem{Id: 1, Description: "Replica Set <shop> is degraded", ReplicaSet: &replicaSetID})
+
+	if assert.IsType(t, RecipientErrors{}, err) {
+		assert.Len(t, err.(RecipientErrors), 1)
+		assert.Contains(t, err.(RecipientErrors), "bob@localhost", "rejected recipients do not prevent delivery to others")
+	}
+	assert.True(t, server.wasEncrypted())
+	_, delivered := server.message("bob@localhost")
+	assert.False(t, delivered)
+	_, delivered = server.message("carol@localhost")
+	assert.True(t, delivered)
+
+	data, delivered := server.message("alice@localhost")
+	if !assert.True(t, delivered) {
+		return
+	}
+	header, bodies := parseTestMail(t, data)
+	assert.Equal(t, "mamid@example.com", header.Get("From"))
+	assert.Equal(t, "alice@localhost", header.Get("To"))
+	subject, err := new(mime.WordDecoder).DecodeHeader(header.Get("Subject"))
+	assert.NoError(t, err)
+	assert.Equal(t, "[MAMID] Problem: Replica Set <shop> is degraded", subject)
+	date, err := header.Date()
+	assert.NoError(t, err)
+	assert.WithinDuration(t, time.Now(), date, time.Minute)
+	assert.Regexp(t, `^<[0-9]+\.[0-9a-f]+@example\.com>$`, header.Get("Message-ID"))
+
+	assert.Contains(t, bodies["text/plain"], "A Problem occured: Replica Set <shop> is degraded")
+	assert.Contains(t, bodies["text/plain"], "Inspect affected Replica Set: http://mamid/#/replicasets/42")
+	assert.Contains(t, bodies["text/html"], "Replica Set &lt;shop&gt; is degraded")
+	assert.Contains(t, bodies["text/html"], `<a href="http://mamid/#/replicasets/42">Inspect affected Replica Set</a>`)
+}
+
+func TestEmailNotifier_implicitTLS(t *testing.T) {
+	serverTLS, rootCAs, cleanup := loadTestTLS(t)
+	defer cleanup()
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", serverTLS)
+	assert.NoError(t, err)
+	defer listener.Close()
+	server := startFakeSMTPServer(t, listener)
+	server.auth = true
+
+	relay := SMTPRelay{
+		Hostname: listener.Addr().String(),
+		MailFrom: "mamid@example.com",
+		TLS:      smtpTLSImplicit,
+		RootCAs:  rootCAs,
+		Auth:     smtpAuthCRAMMD5,
+		Username: fakeSMTPUsername,
+		Password: fakeSMTPPassword,
+	}
+	notifier := EmailNotifier{Contacts: []*EmailContact{{Address: "alice@localhost"}}, Relay: relay}
+	firstOccurred := time.Now().Add(-time.Hour)
+	assert.NoError(t, notifier.SendResolution(Problem{Id: 1, Description: "foo", FirstOccurred: firstOccurred}, firstOccurred.Add(time.Minute)))
+	assert.True(t, server.wasEncrypted())
+	data, delivered := server.message("alice@localhost")
+	if assert.True(t, delivered) {
+		_, bodies := parseTestMail(t, data)
+		assert.Contains(t, bodies["text/plain"], "A Problem was resolved after 1m0s: foo")
+	}
+
+	notifier.Relay.Password = "wrong"
+	assert.Error(t, notifier.SendProblem(Problem{Id: 1}))
+
+	notifier.Relay.Password = fakeSMTPPassword
+	notifier.Relay.RootCAs = nil
+	assert.Error(t, notifier.SendProblem(Problem{Id: 1}), "the relay's certificate is not signed by a system CA")
+}
+
+func TestEmailNotifier_startTLSRequired(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer listener.Close()
+	server := startFakeSMTPServer(t, listener)
+
+	notifier := EmailNotifier{
+		Contacts: []*EmailContact{{Address: "alice@localhost"}},
+		Relay:    SMTPRelay{Hostname: listener.Addr().String(), MailFrom: "mamid@example.com", TLS: smtpTLSStartTLS},
+	}
+	assert.Error(t, notifier.SendProblem(Problem{Id: 1}))
+	_, delivered := server.message("alice@localhost")
+	assert.False(t, delivered)
+
+	// Without STARTTLS offered, `auto` delivers unencrypted
+	notifier.Relay.TLS = smtpTLSAuto
+	assert.NoError(t, notifier.SendProblem(Problem{Id: 1}))
+	_, delivered = server.message("alice@localhost")
+	assert.True(t, delivered)
+	assert.False(t, server.wasEncrypted())
+}
+
+func TestRecipientErrors(t *testing.T) {
+	err := RecipientErrors{"bob@localhost": fmt.Errorf("550 no such user")}
+	assert.Equal(t, "could not deliver mail to `bob@localhost`: 550 no such user", err.Error())
+}
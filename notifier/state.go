@@ -0,0 +1,69 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+/*
+	Delivery state
+
+	The notifier records the open Problems, the contacts notified about each of them and the ID of the last event it processed in the state file.
+	After a restart, it resumes the master's event stream after that event and does not notify contacts about Problems again.
+	The state is saved after the notifications for an event were sent, i.e. a crash in between may duplicate notifications but does not lose them.
+*/
+
+type DeliveryState struct {
+	LastEventID *uint64           `json:"last_event_id"` // nil if no event was processed yet
+	Problems    map[uint]Problem  `json:"problems"`      // open Problems
+	Deliveries  map[uint][]string `json:"deliveries"`    // by Problem, the routes that were notified, see route.key
+}
+
+// Load the state from path, a missing file yields an empty state
+func LoadDeliveryState(path string) (state DeliveryState, err error) {
+	state.Problems = make(map[uint]Problem)
+	state.Deliveries = make(map[uint][]string)
+	content, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return state, nil
+	} else if err != nil {
+		return state, err
+	}
+	if err = json.Unmarshal(content, &state); err != nil {
+		return state, err
+	}
+	if state.Problems == nil {
+		state.Problems = make(map[uint]Problem)
+	}
+	if state.Deliveries == nil {
+		state.Deliveries = make(map[uint][]string)
+	}
+	return state, nil
+}
+
+// Save the state to path, replacing the previous state atomically
+func (s DeliveryState) Save(path string) error {
+	content, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	tmpFile, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpFile.Name()) // fails after a successful rename
+	if _, err = tmpFile.Write(content); err != nil {
+		tmpFile.Close()
+		return err
+	}
+	if err = tmpFile.Sync(); err != nil {
+		tmpFile.Close()
+		return err
+	}
+	if err = tmpFile.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmpFile.Name(), path)
+}
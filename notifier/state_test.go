@@ -0,0 +1,37 @@
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDeliveryState(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "mamid_test")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "state.json")
+
+	state, err := LoadDeliveryState(path)
+	assert.NoError(t, err, "a missing state file is an empty state")
+	assert.Nil(t, state.LastEventID)
+	assert.Empty(t, state.Problems)
+	assert.Empty(t, state.Deliveries)
+
+	lastEventID := uint64(42)
+	state.LastEventID = &lastEventID
+	state.Problems[1] = Problem{Id: 1, Description: "foo"}
+	state.Deliveries[1] = []string{"email/eve"}
+	assert.NoError(t, state.Save(path))
+	assert.NoError(t, state.Save(path), "replaces the existing file")
+
+	loaded, err := LoadDeliveryState(path)
+	assert.NoError(t, err)
+	assert.Equal(t, state, loaded)
+
+	files, err := ioutil.ReadDir(dir)
+	assert.NoError(t, err)
+	assert.Len(t, files, 1, "no temporary files are left")
+}
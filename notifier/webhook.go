@@ -0,0 +1,194 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"text/template"
+	"time"
+)
+
+/*
+	Webhooks
+
+	A webhook contact receives a POST request with a JSON payload for every Problem and its resolution.
+	The payload is rendered from a text/template with a WebhookPayload, the `json` function encodes a value as JSON, e.g.
+
+		{"text": {{json .Description}}, "resolved": {{.Resolution}}}
+
+	Requests that fail or are answered with 5xx or 429 are retried, other responses than 2xx are not.
+*/
+
+const (
+	webhookEventProblem    = "problem"
+	webhookEventResolution = "resolution"
+)
+
+const (
+	defaultWebhookRetries = 3
+	defaultWebhookBackoff = 1 * time.Second
+	defaultWebhookTimeout = 10 * time.Second
+)
+
+const defaultWebhookTemplate = `{"event": {{json .Event}}, "id": {{.Id}}, "description": {{json .Description}}, ` +
+	`"long_description": {{json .LongDescription}}, "first_occurred": {{json .FirstOccurred}}, ` +
+	`"resolved": {{if .Resolution}}{{json .Resolved}}{{else}}null{{end}}, "duration": {{json .Duration}}, ` +
+	`"slave_id": {{json .Slave}}, "replica_set_id": {{json .ReplicaSet}}, "url": {{json .URL}}}`
+
+// The data a webhook payload template is rendered with
+type WebhookPayload struct {
+	Problem
+	Event      string    // `problem` or `resolution`
+	Resolution bool      // Event == `resolution`
+	Resolved   time.Time // only valid for resolutions
+	Duration   string    // how long the Problem lasted, only valid for resolutions
+	URL        string    // of the affected Replica Set or Slave in the web interface, empty if neither is known
+}
+
+var webhookTemplateFuncs = template.FuncMap{
+	"json": func(v interface{}) (string, error) {
+		encoded, err := json.Marshal(v)
+		return string(encoded), err
+	},
+}
+
+// Parse a payload template, the default template if text is empty
+func parseWebhookTemplate(name string, text string) (*template.Template, error) {
+	if text == "" {
+		text = defaultWebhookTemplate
+	}
+	return template.New(name).Funcs(webhookTemplateFuncs).Parse(text)
+}
+
+// A client with the timeout applied to each request, presenting the client certificate if certFile is not empty
+func newWebhookClient(timeout time.Duration, certFile, keyFile, caFile string) (*http.Client, error) {
+	tlsConfig := &tls.Config{}
+	if certFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("could not load keypair `%s`, `%s`: %s", certFile, keyFile, err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	if caFile != "" {
+		caCert, err := loadCertificateFromFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("could not load CA file `%s`: %s", caFile, err)
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		tlsConfig.RootCAs.AddCert(caCert)
+	}
+	return &http.Client{
+		Transport: &http.Transport{
+			Proxy:           http.ProxyFromEnvironment,
+			TLSClientConfig: tlsConfig,
+		},
+		Timeout: timeout,
+	}, nil
+}
+
+type WebhookNotifier struct {
+	Contacts  []*WebhookContact
+	MamidHost string
+}
+
+func (n *WebhookNotifier) SendProblem(problem Problem) error {
+	return n.sendToContacts(WebhookPayload{
+		Problem: problem,
+		Event:   webhookEventProblem,
+		URL:     n.problemURL(problem),
+	})
+}
+
+func (n *WebhookNotifier) SendResolution(problem Problem, resolved time.Time) error {
+	return n.sendToContacts(WebhookPayload{
+		Problem:    problem,
+		Event:      webhookEventResolution,
+		Resolution: true,
+		Resolved:   resolved,
+		Duration:   problemDuration(problem, resolved),
+		URL:        n.problemURL(problem),
+	})
+}
+
+func (n *WebhookNotifier) problemURL(problem Problem) string {
+	if problem.ReplicaSet != nil {
+		return fmt.Sprintf("%s/#/replicasets/%d", n.MamidHost, *problem.ReplicaSet)
+	}
+	if problem.Slave != nil {
+		return fmt.Sprintf("%s/#/slaves/%d", n.MamidHost, *problem.Slave)
+	}
+	return ""
+}
+
+// Send the payload to all contacts, a failing contact does not prevent delivery to the others
+func (n *WebhookNotifier) sendToContacts(payload WebhookPayload) error {
+	var failures []string
+	for _, contact := range n.Contacts {
+		if err := contact.send(payload); err != nil {
+			failures = append(failures, fmt.Sprintf("webhook `%s`: %s", contact.Name, err))
+		}
+	}
+	if len(failures) > 0 {
+		return fmt.Errorf("%s", strings.Join(failures, "; "))
+	}
+	return nil
+}
+
+func (c *WebhookContact) send(payload WebhookPayload) error {
+	var body bytes.Buffer
+	if err := c.Template.Execute(&body, payload); err != nil {
+		return fmt.Errorf("could not render payload: %s", err)
+	}
+	var decoded interface{}
+	if err := json.Unmarshal(body.Bytes(), &decoded); err != nil {
+		return fmt.Errorf("rendered payload is not valid JSON: %s", err)
+	}
+
+	backoff := c.Backoff
+	for attempt := uint(0); ; attempt++ {
+		retry, err := c.post(body.Bytes())
+		if err == nil {
+			return nil
+		}
+		if !retry || attempt >= c.Retries {
+			return err
+		}
+		log.Warnf("Webhook `%s` failed, retrying in %s: %s", c.Name, backoff, err)
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// POST the body, retry is true if the request may succeed when repeated
+func (c *WebhookContact) post(body []byte) (retry bool, err error) {
+	req, err := http.NewRequest("POST", c.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for name, value := range c.Headers {
+		req.Header.Set(name, value)
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body) // allow reusing the connection
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return false, nil
+	case resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests:
+		return true, fmt.Errorf("endpoint returned %d", resp.StatusCode)
+	default:
+		return false, fmt.Errorf("endpoint returned %d", resp.StatusCode)
+	}
+}
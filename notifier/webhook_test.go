@@ -0,0 +1,247 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"github.com/stretchr/testify/assert"
+	"github.com/vaughan0/go-ini"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+type webhookRequest struct {
+	header http.Header
+	body   map[string]interface{}
+}
+
+// Records requests and answers with the given status codes, the last one is repeated
+func createWebhookMock(t *testing.T, statusCodes ...int) (server *httptest.Server, requests func() []webhookRequest) {
+	var mutex sync.Mutex
+	var received []webhookRequest
+	server = httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		assert.Equal(t, "POST", r.Method)
+		request := webhookRequest{header: r.Header}
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&request.body))
+		received = append(received, request)
+		w.WriteHeader(statusCodes[0])
+		if len(statusCodes) > 1 {
+			statusCodes = statusCodes[1:]
+		}
+	}))
+	return server, func() []webhookRequest {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return received
+	}
+}
+
+func TestWebhookNotifier_SendProblem(t *testing.T) {
+	server, requests := createWebhookMock(t, http.StatusOK)
+	server.Start()
+	defer server.Close()
+
+	contact, err := parseWebhookContact("chat", ini.Section{
+		"webhook":                      server.URL,
+		"webhook_header_Authorization": "Bearer secret",
+	})
+	assert.NoError(t, err)
+	notifier := WebhookNotifier{Contacts: []*WebhookContact{&contact}, MamidHost: "http://mamid"}
+
+	slaveID := uint(2)
+	firstOccurred := time.Date(2016, time.August, 1, 14, 0, 0, 0, time.UTC)
+	problem := Problem{Id: 1, Description: "Slave \"test\" is unreachable", FirstOccurred: firstOccurred, Slave: &slaveID}
+	assert.NoError(t, notifier.SendProblem(problem))
+	assert.NoError(t, notifier.SendResolution(problem, firstOccurred.Add(time.Hour)))
+
+	received := requests()
+	if assert.Len(t, received, 2) {
+		assert.Equal(t, "application/json", received[0].header.Get("Content-Type"))
+		assert.Equal(t, "Bearer secret", received[0].header.Get("Authorization"))
+		assert.Equal(t, "problem", received[0].body["event"])
+		assert.Equal(t, float64(1), received[0].body["id"])
+		assert.Equal(t, "Slave \"test\" is unreachable", received[0].body["description"])
+		assert.Equal(t, float64(2), received[0].body["slave_id"])
+		assert.Nil(t, received[0].body["replica_set_id"])
+		assert.Nil(t, received[0].body["resolved"])
+		assert.Equal(t, "http://mamid/#/slaves/2", received[0].body["url"])
+
+		assert.Equal(t, "resolution", received[1].body["event"])
+		assert.Equal(t, "2016-08-01T15:00:00Z", received[1].body["resolved"])
+		assert.Equal(t, "1h0m0s", received[1].body["duration"])
+	}
+}
+
+func TestWebhookNotifier_template(t *testing.T) {
+	server, requests := createWebhookMock(t, http.StatusNoContent)
+	server.Start()
+	defer server.Close()
+
+	templateFile, err := ioutil.TempFile(os.TempDir(), "mamid_test")
+	assert.NoError(t, err)
+	defer os.Remove(templateFile.Name())
+	_, err = templateFile.WriteString(`{"text": {{if .Resolution}}{{json (printf "Resolved after %s: %s" .Duration .Description)}}{{else}}{{json .Description}}{{end}}}`)
+	assert.NoError(t, err)
+	templateFile.Close()
+
+	contact, err := parseWebhookContact("chat", ini.Section{"webhook": server.URL, "webhook_template": templateFile.Name()})
+	assert.NoError(t, err)
+	notifier := WebhookNotifier{Contacts: []*WebhookContact{&contact}}
+
+	firstOccurred := time.Date(2016, time.August, 1, 14, 0, 0, 0, time.UTC)
+	problem := Problem{Id: 1, Description: "foo", FirstOccurred: firstOccurred}
+	assert.NoError(t, notifier.SendProblem(problem))
+	assert.NoError(t, notifier.SendResolution(problem, firstOccurred.Add(time.Minute)))
+
+	received := requests()
+	if assert.Len(t, received, 2) {
+		assert.Equal(t, map[string]interface{}{"text": "foo"}, received[0].body)
+		assert.Equal(t, map[string]interface{}{"text": "Resolved after 1m0s: foo"}, received[1].body)
+	}
+}
+
+func TestWebhookNotifier_invalidTemplateOutput(t *testing.T) {
+	server, requests := createWebhookMock(t, http.StatusOK)
+	server.Start()
+	defer server.Close()
+
+	contact := WebhookContact{Name: "chat", URL: server.URL, client: http.DefaultClient}
+	contact.Template, _ = parseWebhookTemplate("chat", `{"text": {{.Description}}}`)
+	notifier := WebhookNotifier{Contacts: []*WebhookContact{&contact}}
+
+	assert.Error(t, notifier.SendProblem(Problem{Id: 1, Description: "foo"}), "the description is not quoted")
+	assert.Empty(t, requests())
+}
+
+func TestWebhookNotifier_retries(t *testing.T) {
+	server, requests := createWebhookMock(t, http.StatusServiceUnavailable, http.StatusTooManyRequests, http.StatusOK)
+	server.Start()
+	defer server.Close()
+
+	contact, err := parseWebhookContact("chat", ini.Section{"webhook": server.URL, "webhook_retries": "2", "webhook_backoff": "1ms"})
+	assert.NoError(t, err)
+	notifier := WebhookNotifier{Contacts: []*WebhookContact{&contact}}
+	assert.NoError(t, notifier.SendProblem(Problem{Id: 1}))
+	assert.Len(t, requests(), 3)
+
+	// Retries exhausted
+	server2, requests2 := createWebhookMock(t, http.StatusInternalServerError)
+	server2.Start()
+	defer server2.Close()
+	contact.URL = server2.URL
+	assert.Error(t, notifier.SendProblem(Problem{Id: 1}))
+	assert.Len(t, requests2(), 3)
+
+	// Client errors are not retried
+	server3, requests3 := createWebhookMock(t, http.StatusBadRequest)
+	server3.Start()
+	defer server3.Close()
+	contact.URL = server3.URL
+	assert.Error(t, notifier.SendProblem(Problem{Id: 1}))
+	assert.Len(t, requests3(), 1)
+}
+
+func TestWebhookNotifier_timeout(t *testing.T) {
+	done := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-done
+	}))
+	defer server.Close()
+	defer close(done)
+
+	contact, err := parseWebhookContact("chat", ini.Section{"webhook": server.URL, "webhook_retries": "0", "webhook_timeout": "50ms"})
+	assert.NoError(t, err)
+	notifier := WebhookNotifier{Contacts: []*WebhookContact{&contact}}
+	start := time.Now()
+	assert.Error(t, notifier.SendProblem(Problem{Id: 1}))
+	assert.True(t, time.Since(start) < 5*time.Second)
+}
+
+// Write a self-signed certificate and its key to dir, usable by clients and by servers on localhost and as its own CA
+func writeTestCertificate(t *testing.T, dir string) (certFile, keyFile string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	template := x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "notifier"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		DNSNames:              []string{"localhost"},
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1)},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	assert.NoError(t, err)
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	assert.NoError(t, err)
+
+	certFile, keyFile = filepath.Join(dir, "test.crt"), filepath.Join(dir, "test.key")
+	assert.NoError(t, ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600))
+	assert.NoError(t, ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600))
+	return
+}
+
+func TestWebhookNotifier_clientCertificate(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "mamid_test")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	certFile, keyFile := writeTestCertificate(t, dir)
+
+	server, requests := createWebhookMock(t, http.StatusOK)
+	server.TLS = &tls.Config{ClientAuth: tls.RequireAnyClientCert}
+	server.StartTLS()
+	defer server.Close()
+	caFile := filepath.Join(dir, "ca.crt")
+	assert.NoError(t, ioutil.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}), 0600))
+
+	contact, err := parseWebhookContact("chat", ini.Section{
+		"webhook":         server.URL,
+		"webhook_ca":      caFile,
+		"webhook_retries": "0",
+	})
+	assert.NoError(t, err)
+	notifier := WebhookNotifier{Contacts: []*WebhookContact{&contact}}
+	assert.Error(t, notifier.SendProblem(Problem{Id: 1}), "the server requires a client certificate")
+
+	contact, err = parseWebhookContact("chat", ini.Section{
+		"webhook":      server.URL,
+		"webhook_ca":   caFile,
+		"webhook_cert": certFile,
+		"webhook_key":  keyFile,
+	})
+	assert.NoError(t, err)
+	notifier.Contacts = []*WebhookContact{&contact}
+	assert.NoError(t, notifier.SendProblem(Problem{Id: 1}))
+	assert.Len(t, requests(), 1)
+}
+
+func TestParseWebhookContact_invalid(t *testing.T) {
+	for _, section := range []ini.Section{
+		{"webhook": "localhost:8080"},
+		{"webhook": "http://localhost", "webhook_retries": "-1"},
+		{"webhook": "http://localhost", "webhook_timeout": "0s"},
+		{"webhook": "http://localhost", "webhook_cert": "client.crt"},
+		{"webhook": "http://localhost", "webhook_template": "/nonexistent"},
+	} {
+		_, err := parseWebhookContact("chat", section)
+		assert.Error(t, err, "%v", section)
+	}
+}
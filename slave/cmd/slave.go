@@ -6,6 +6,7 @@ import (
 	"github.com/KIT-MAMID/mamid/msp"
 	. "github.com/KIT-MAMID/mamid/slave"
 	"github.com/Sirupsen/logrus"
+	"github.com/prometheus/client_golang/prometheus"
 	"os/exec"
 	"time"
 )
@@ -18,13 +19,32 @@ var DefaultMongodResponseTimeout, _ = time.ParseDuration("3s")     // seconds
 var DefaultMongodSoftShutdownTimeout, _ = time.ParseDuration("3s") // seconds
 var DefaultMongodHardShutdownTimeout, _ = time.ParseDuration("5s") // seconds
 
+const DefaultMongodRestartMode = "on-failure"
+const DefaultMongodCrashLoopThreshold = 5
+const DefaultMongodLogMaxSize = 100 * 1024 * 1024 // bytes
+const DefaultMongodLogMaxBackups = 5
+const DefaultCgroupsMountPoint = "/sys/fs/cgroup"
+const DefaultCgroupsGroup = "mamid"
+const DefaultProcDir = "/proc"
+
+var DefaultMongodRestartBackoffInitial, _ = time.ParseDuration("1s")
+var DefaultMongodRestartBackoffMax, _ = time.ParseDuration("1m")
+var DefaultMongodCrashLoopCooldown, _ = time.ParseDuration("10m")
+var DefaultMongodStableUptime, _ = time.ParseDuration("1m")
+
 func main() {
 
 	logrus.SetLevel(logrus.DebugLevel)
 
 	var (
 		mongodExecutable, dataDir, listenString, x509CertFile, x509KeyFile, caCert  string
+		metricsListenString                                                         string
 		mongodResponseTimeout, mongodSoftShutdownTimeout, mongodHardShutdownTimeout time.Duration
+		mongodRestartMode                                                           string
+		restartPolicy                                                               RestartPolicy
+		logRotation                                                                 LogRotationPolicy
+		cgroupsMountPoint, cgroupsGroup                                             string
+		procDir                                                                     string
 	)
 
 	flag.StringVar(&dataDir, "data", "", "Persistent data and slave configuration directory")
@@ -38,7 +58,35 @@ func main() {
 	flag.DurationVar(&mongodHardShutdownTimeout, "mongod.shutdownTimeout.hard", DefaultMongodHardShutdownTimeout,
 		"Duration to wait after issuing a shutdown call before the Mongod is killed (SIGKILL). Specify with suffix [ms,s,min,...]")
 
+	flag.StringVar(&mongodRestartMode, "mongod.restart", DefaultMongodRestartMode,
+		"When to restart exited Mongods without waiting for the master [never,on-failure,always]")
+	flag.DurationVar(&restartPolicy.InitialBackoff, "mongod.restart.backoff.initial", DefaultMongodRestartBackoffInitial,
+		"Delay of the first restart of a crashed Mongod, doubled with every consecutive crash. Specify with suffix [ms,s,min,...]")
+	flag.DurationVar(&restartPolicy.MaxBackoff, "mongod.restart.backoff.max", DefaultMongodRestartBackoffMax,
+		"Maximum delay of a restart of a crashed Mongod. Specify with suffix [ms,s,min,...]")
+	flag.UintVar(&restartPolicy.CrashLoopThreshold, "mongod.crashLoop.threshold", DefaultMongodCrashLoopThreshold,
+		"Number of consecutive crashes after which a Mongod is considered crash looping and no longer restarted (0 = never)")
+	flag.DurationVar(&restartPolicy.CrashLoopCooldown, "mongod.crashLoop.cooldown", DefaultMongodCrashLoopCooldown,
+		"Duration after which a crash looping Mongod may be spawned again (0 = only after the master stopped it). Specify with suffix [ms,s,min,...]")
+	flag.DurationVar(&restartPolicy.StableUptime, "mongod.crashLoop.stableUptime", DefaultMongodStableUptime,
+		"Uptime after which an exit of a Mongod is no longer counted as a consecutive crash. Specify with suffix [ms,s,min,...]")
+
+	flag.Int64Var(&logRotation.MaxSize, "mongod.log.maxSize", DefaultMongodLogMaxSize,
+		"Size in bytes after which the log file of a Mongod is rotated (0 = never)")
+	flag.UintVar(&logRotation.MaxBackups, "mongod.log.maxBackups", DefaultMongodLogMaxBackups,
+		"Number of rotated log files kept per Mongod")
+
+	flag.StringVar(&cgroupsMountPoint, "cgroups.mountPoint", DefaultCgroupsMountPoint,
+		"Mount point of the cgroup v2 hierarchy used to isolate Mongods (empty = no isolation)")
+	flag.StringVar(&cgroupsGroup, "cgroups.group", DefaultCgroupsGroup,
+		"cgroup delegated to the slave, relative to -cgroups.mountPoint, every Mongod is placed into a child group")
+
+	flag.StringVar(&procDir, "hostMetrics.procDir", DefaultProcDir,
+		"Mount point of the proc filesystem to read load and memory usage reported to the master from (empty = no host metrics)")
+
 	flag.StringVar(&listenString, "listen", ":8081", "net.Listen() string, e.g. addr:port")
+	flag.StringVar(&metricsListenString, "metrics.listen", "",
+		"net.Listen() string for serving Prometheus metrics at /metrics over plain HTTP, e.g. localhost:8083 (empty = no metrics)")
 	flag.StringVar(&x509CertFile, "slave.auth.cert", "", "The x509 cert file for the slave server")
 	flag.StringVar(&x509KeyFile, "slave.auth.key", "", "The x509 key file for x509 cert the slave server")
 	flag.StringVar(&caCert, "master.verifyCA", "", "The x509 ca that signed the certificates and to authenticate the master against")
@@ -59,13 +107,29 @@ func main() {
 		log.Fatal("No master verification ca passed; specify with -master.verifyCA=/path/to/cert")
 	}
 
+	var err error
+	if restartPolicy.Mode, err = ParseRestartMode(mongodRestartMode); err != nil {
+		log.Fatal(err)
+	}
+
 	// Application setup
 
 	processManager := NewProcessManager(mongodExecutable, dataDir)
+	processManager.RestartPolicy = restartPolicy
+	processManager.LogRotation = logRotation
+	if cgroupsMountPoint != "" {
+		if processManager.Cgroups, err = NewCgroups(cgroupsMountPoint, cgroupsGroup); err != nil {
+			log.Warnf("cgroups unavailable, Mongods run without resource limits: %s", err)
+		}
+	}
 	if err := processManager.CreateManagedDirs(); err != nil {
 		log.Fatal(fmt.Sprintf("cannot not create or access slave data directory `%s` (-data): %s", dataDir, err))
 	}
 	processManager.Run()
+	prometheus.MustRegister(processManager)
+	if err := processManager.AdoptProcesses(); err != nil {
+		log.Errorf("cannot adopt Mongods spawned before the slave restart: %s", err)
+	}
 
 	configurator := &ConcreteMongodConfigurator{
 		MongodSoftShutdownTimeout: mongodSoftShutdownTimeout,
@@ -73,8 +137,11 @@ func main() {
 	}
 
 	controller := NewController(processManager, configurator, mongodHardShutdownTimeout)
+	if procDir != "" {
+		controller.HostMetrics = &HostMetricsSampler{ProcDir: procDir, DataDir: dataDir}
+	}
 
-	server := msp.NewServer(controller, listenString, caCert, x509CertFile, x509KeyFile)
+	server := msp.NewServer(controller, listenString, metricsListenString, caCert, x509CertFile, x509KeyFile)
 	if err := server.Run(); err != nil {
 		log.Fatal(err)
 	}
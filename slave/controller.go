@@ -12,23 +12,30 @@ type Controller struct {
 	configurator              MongodConfigurator                      // exclusive configuration of a Mongod (identified by port number) protected by busyTable
 	mongodCredentials         map[msp.PortNumber]msp.MongodCredential // protected by busyTable
 	mongodHardShutdownTimeout time.Duration
+	HostMetrics               *HostMetricsSampler // nil if no host metrics are reported
 }
 
+// Credentials persisted by processManager are loaded to connect to adopted Mongods (see ProcessManager.AdoptProcesses())
 func NewController(processManager *ProcessManager, configurator MongodConfigurator, mongodHardShutdownTimeout time.Duration) *Controller {
+	credentials, err := processManager.PersistedCredentials()
+	if err != nil {
+		log.Errorf("controller: could not load persisted Mongod credentials: %s", err)
+		credentials = make(map[msp.PortNumber]msp.MongodCredential)
+	}
 	return &Controller{
 		busyTable:                 NewBusyTable(),
 		procManager:               processManager,
 		configurator:              configurator,
-		mongodCredentials:         make(map[msp.PortNumber]msp.MongodCredential),
+		mongodCredentials:         credentials,
 		mongodHardShutdownTimeout: mongodHardShutdownTimeout,
 	}
 }
 
-func (c *Controller) RequestStatus() ([]msp.Mongod, *msp.Error) {
+func (c *Controller) RequestStatus() (msp.SlaveStatus, *msp.Error) {
 
 	replSetNameByPortNumber, err := c.procManager.parseProcessDirTree()
 	if err != nil {
-		return []msp.Mongod{}, &msp.Error{
+		return msp.SlaveStatus{}, &msp.Error{
 			Identifier:      msp.SlaveGetMongodStatusError,
 			Description:     fmt.Sprintf("Unable to read mongods from db directory"),
 			LongDescription: fmt.Sprintf("ProcessManager.ExistingDataDirectories() failed: %s", err),
@@ -66,8 +73,18 @@ func (c *Controller) RequestStatus() ([]msp.Mongod, *msp.Error) {
 					}
 				} else {
 					//Could get state successfully
+					mongod.ResourceUsage = c.procManager.ResourceUsage(port)
 					resultsChan <- mongod
 				}
+			} else if status, _ := c.procManager.SupervisionStatus(port); status.CrashLoop {
+				resultsChan <- msp.Mongod{
+					Port: port,
+					ReplicaSetConfig: msp.ReplicaSetConfig{
+						ReplicaSetName: replSetName,
+					},
+					StatusError: crashLoopError(port, status),
+					State:       msp.MongodStateNotRunning,
+				}
 			} else {
 				//Process is not running
 				resultsChan <- msp.Mongod{
@@ -91,7 +108,23 @@ func (c *Controller) RequestStatus() ([]msp.Mongod, *msp.Error) {
 		}
 	}
 
-	return mongods, nil
+	return msp.SlaveStatus{
+		Mongods:     mongods,
+		HostMetrics: c.sampleHostMetrics(),
+	}, nil
+}
+
+// Failures are logged as the status of the Mongods is still valid
+func (c *Controller) sampleHostMetrics() *msp.HostMetrics {
+	if c.HostMetrics == nil {
+		return nil
+	}
+	metrics, err := c.HostMetrics.Sample()
+	if err != nil {
+		log.Errorf("controller: could not sample host metrics: %s", err)
+		return nil
+	}
+	return &metrics
 }
 
 func (c *Controller) EstablishMongodState(m msp.Mongod) *msp.Error {
@@ -104,10 +137,26 @@ func (c *Controller) EstablishMongodState(m msp.Mongod) *msp.Error {
 
 	case msp.MongodStateRunning:
 
+		// options changed by the master take effect on the next start of the Mongod, resource limits immediately
+		if c.procManager.HasProcess(m.Port) || c.procManager.RestartPending(m.Port) {
+			if err := c.procManager.UpdateMongodConfig(m); err != nil {
+				log.Errorf("controller: could not update config file of Mongod on port `%d`: %s", m.Port, err)
+			}
+			c.procManager.UpdateResourceLimits(m)
+		}
+
 		// check if still existing after locking [else possible race condition: process might have been in destruction phase while we were waiting for the lock],
 		// else we need to respawn
 		if !c.procManager.HasProcess(m.Port) {
 
+			if c.procManager.RestartPending(m.Port) {
+				log.Debugf("controller: Mongod on port `%d` will be restarted by the ProcessManager", m.Port)
+				return nil
+			}
+			if status, _ := c.procManager.SupervisionStatus(m.Port); status.CrashLoop {
+				return crashLoopError(m.Port, status)
+			}
+
 			err := c.procManager.SpawnProcess(m)
 
 			if err != nil {
@@ -120,6 +169,10 @@ func (c *Controller) EstablishMongodState(m msp.Mongod) *msp.Error {
 			}
 		}
 
+		if err := c.procManager.PersistCredential(m); err != nil {
+			log.Errorf("controller: could not persist credential of Mongod on port `%d`: %s", m.Port, err)
+		}
+
 		applyErr := c.configurator.ApplyMongodConfiguration(m)
 		if applyErr != nil {
 			log.Errorf("controller: error applying Mongod configuration: %s", applyErr)
@@ -128,6 +181,7 @@ func (c *Controller) EstablishMongodState(m msp.Mongod) *msp.Error {
 
 	case msp.MongodStateNotRunning:
 
+		c.procManager.ExpectShutdown(m.Port)
 		stopErr := c.configurator.ApplyMongodConfiguration(m)
 		if stopErr != nil {
 			log.WithField("error", stopErr).Errorf("could not soft shutdown mongod on port `%d`", m.Port)
@@ -136,6 +190,7 @@ func (c *Controller) EstablishMongodState(m msp.Mongod) *msp.Error {
 
 	case msp.MongodStateDestroyed:
 
+		c.procManager.ExpectShutdown(m.Port)
 		stopErr := c.configurator.ApplyMongodConfiguration(m)
 		if stopErr != nil {
 			log.WithField("error", stopErr).Errorf("could not soft shutdown Mongod on port `%d`", m.Port)
@@ -181,7 +236,29 @@ func (c *Controller) EstablishMongodState(m msp.Mongod) *msp.Error {
 
 }
 
+func crashLoopError(port msp.PortNumber, status ProcessSupervisionStatus) *msp.Error {
+	return &msp.Error{
+		Identifier:  msp.SlaveMongodCrashLoopError,
+		Description: fmt.Sprintf("Mongod on port `%d` is crash looping", port),
+		LongDescription: fmt.Sprintf("The Mongod crashed %d times in a row, last exit code `%d` at %s. It is not restarted by the slave.",
+			status.CrashCount, status.LastExitCode, status.LastExit.Format(time.RFC3339)),
+	}
+}
+
 func (c *Controller) RsInitiate(m msp.RsInitiateMessage) *msp.Error {
 	defer c.busyTable.AcquireLock(m.Port).Unlock()
 	return c.configurator.InitiateReplicaSet(m)
 }
+
+func (c *Controller) MongodLog(port msp.PortNumber, tail uint) (msp.MongodLog, *msp.Error) {
+	lines, err := c.procManager.MongodLogTail(port, tail)
+	if err != nil {
+		log.Errorf("controller: error reading log of Mongod on port `%d`: %s", port, err)
+		return msp.MongodLog{}, &msp.Error{
+			Identifier:      msp.SlaveMongodLogError,
+			Description:     fmt.Sprintf("Unable to read the log of the Mongod on port `%d`", port),
+			LongDescription: fmt.Sprintf("ProcessManager.MongodLogTail() failed: %s", err),
+		}
+	}
+	return msp.MongodLog{Port: port, Lines: lines}, nil
+}
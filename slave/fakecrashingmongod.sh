@@ -0,0 +1,7 @@
+#!/bin/sh
+echo "db version v3.2"
+if [ $# -gt 0 ]; then
+    if [ $1 != "--version" ]; then
+        exit 3;
+    fi
+fi
@@ -0,0 +1,111 @@
+package slave
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/KIT-MAMID/mamid/msp"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+/*
+	Host metrics
+
+	The status reported to the master includes a sample of the host's resource usage.
+	Load and memory are read from the proc filesystem (see proc(5)), the size of the data volume from statfs(2) of the slave's data directory.
+*/
+
+type HostMetricsSampler struct {
+	ProcDir string // mount point of the proc filesystem
+	DataDir string // the slave's data directory
+}
+
+func (s HostMetricsSampler) Sample() (metrics msp.HostMetrics, err error) {
+
+	metrics.CPUCount = uint(runtime.NumCPU())
+
+	if metrics.LoadAverage1, metrics.LoadAverage5, metrics.LoadAverage15, err = readLoadAverage(filepath.Join(s.ProcDir, "loadavg")); err != nil {
+		return metrics, fmt.Errorf("could not read load average: %s", err)
+	}
+
+	meminfo, err := readMeminfo(filepath.Join(s.ProcDir, "meminfo"))
+	if err != nil {
+		return metrics, fmt.Errorf("could not read memory usage: %s", err)
+	}
+	metrics.MemoryTotalBytes = meminfo["MemTotal"]
+	if available, ok := meminfo["MemAvailable"]; ok {
+		metrics.MemoryAvailableBytes = available
+	} else { // kernels before 3.14
+		metrics.MemoryAvailableBytes = meminfo["MemFree"] + meminfo["Buffers"] + meminfo["Cached"]
+	}
+	metrics.SwapTotalBytes = meminfo["SwapTotal"]
+	metrics.SwapFreeBytes = meminfo["SwapFree"]
+
+	var stat syscall.Statfs_t
+	if err = syscall.Statfs(s.DataDir, &stat); err != nil {
+		return metrics, fmt.Errorf("could not stat data volume: %s", os.NewSyscallError("statfs", err))
+	}
+	metrics.DataVolumeTotalBytes = uint64(stat.Blocks) * uint64(stat.Bsize)
+	metrics.DataVolumeAvailableBytes = uint64(stat.Bavail) * uint64(stat.Bsize)
+
+	return metrics, nil
+
+}
+
+// Parse the first three fields of `/proc/loadavg`
+func readLoadAverage(path string) (load1, load5, load15 float64, err error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return
+	}
+	fields := strings.Fields(string(content))
+	if len(fields) < 3 {
+		return 0, 0, 0, fmt.Errorf("unexpected format of `%s`", path)
+	}
+	loads := make([]float64, 3)
+	for i := range loads {
+		if loads[i], err = strconv.ParseFloat(fields[i], 64); err != nil {
+			return
+		}
+	}
+	return loads[0], loads[1], loads[2], nil
+}
+
+// Parse `/proc/meminfo` into bytes by key, e.g. `MemTotal`
+func readMeminfo(path string) (meminfo map[string]uint64, err error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	meminfo = make(map[string]uint64)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		// e.g. `MemTotal:       16314424 kB`
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		value, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("unexpected value in `%s`: %s", path, err)
+		}
+		if len(fields) == 3 && fields[2] == "kB" {
+			value *= 1024
+		}
+		meminfo[strings.TrimSuffix(fields[0], ":")] = value
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, err
+	}
+	if _, ok := meminfo["MemTotal"]; !ok {
+		return nil, fmt.Errorf("no `MemTotal` in `%s`", path)
+	}
+	return meminfo, nil
+}
@@ -5,6 +5,7 @@ import (
 	"github.com/KIT-MAMID/mamid/msp"
 	"gopkg.in/mgo.v2"
 	"gopkg.in/mgo.v2/bson"
+	"time"
 )
 
 type mgoContext struct {
@@ -70,6 +71,51 @@ func (ctx *mgoContext) ReplSetGetStatus(status *bson.M) (replSetMemberState repl
 	return
 }
 
+const oplogDatabase, oplogCollection = "local", "oplog.rs"
+
+// Timestamps of the oldest and the newest entry in the oplog
+func (ctx *mgoContext) OplogTimestamps() (first, last time.Time, err *msp.Error) {
+
+	oplog := ctx.Session.DB(oplogDatabase).C(oplogCollection)
+	var entry struct {
+		Timestamp bson.MongoTimestamp `bson:"ts"`
+	}
+	for _, sort := range []string{"$natural", "-$natural"} {
+		if findErr := oplog.Find(nil).Sort(sort).Limit(1).One(&entry); findErr != nil {
+			return first, last, &msp.Error{
+				Identifier:      msp.SlaveGetMongodStatusError,
+				Description:     fmt.Sprintf("Reading the oplog of Mongod instance on port `%d` failed", ctx.Port),
+				LongDescription: fmt.Sprintf("querying %s.%s sorted by `%s` failed: %s", oplogDatabase, oplogCollection, sort, findErr),
+			}
+		}
+		if sort == "$natural" {
+			first = mongoTimestampTime(entry.Timestamp)
+		} else {
+			last = mongoTimestampTime(entry.Timestamp)
+		}
+	}
+	return first, last, nil
+
+}
+
+// Current and maximum size of the oplog
+func (ctx *mgoContext) OplogSize() (size, maxSize uint64, err *msp.Error) {
+	var stats bson.M
+	if runErr := ctx.Session.DB(oplogDatabase).Run(bson.D{{Name: "collStats", Value: oplogCollection}}, &stats); runErr != nil {
+		return 0, 0, &msp.Error{
+			Identifier:      msp.SlaveGetMongodStatusError,
+			Description:     fmt.Sprintf("Getting the oplog size of Mongod instance on port `%d` failed", ctx.Port),
+			LongDescription: fmt.Sprintf("collStats of %s.%s failed: %s", oplogDatabase, oplogCollection, runErr),
+		}
+	}
+	return uint64(bsonInt64(stats["size"])), uint64(bsonInt64(stats["maxSize"])), nil
+}
+
+// The upper 32 bits of a MongoDB timestamp are seconds since the epoch, the lower ones an ordinal
+func mongoTimestampTime(ts bson.MongoTimestamp) time.Time {
+	return time.Unix(int64(ts>>32), 0)
+}
+
 func (ctx *mgoContext) ReplSetGetConfig() (bson.M, *msp.Error) {
 
 	configResult := bson.M{}
@@ -98,20 +144,30 @@ func (ctx *mgoContext) ReplSetReconfig(config bson.M) *msp.Error {
 	return nil
 }
 
-// Parse sharding command line options
-// return an empty string and err = nil if option not specified but no other error occurred
-func (ctx *mgoContext) ParseCmdLineShardingRole() (role string, err *msp.Error) {
+func (ctx *mgoContext) parsedCmdLineOpts() (bson.M, *msp.Error) {
 
 	cmdLineOptsRes := bson.M{}
 	if err := ctx.Session.Run("getCmdLineOpts", &cmdLineOptsRes); err != nil {
-		return "", &msp.Error{
+		return nil, &msp.Error{
 			Identifier:      msp.SlaveGetMongodStatusError,
 			Description:     fmt.Sprintf("Getting command line options from Mongod instance on port `%d` failed", ctx.Port),
 			LongDescription: fmt.Sprintf("getCmdLineOpts failed with error: %#v", err),
 		}
 	}
 
-	parsed := cmdLineOptsRes["parsed"].(bson.M)
+	parsed, _ := cmdLineOptsRes["parsed"].(bson.M)
+	return parsed, nil
+}
+
+// Parse sharding command line options
+// return an empty string and err = nil if option not specified but no other error occurred
+func (ctx *mgoContext) ParseCmdLineShardingRole() (role string, err *msp.Error) {
+
+	parsed, err := ctx.parsedCmdLineOpts()
+	if err != nil {
+		return "", err
+	}
+
 	sharding, ok := parsed["sharding"]
 	if ok {
 		clusterRole, ok := sharding.(bson.M)["clusterRole"]
@@ -122,6 +178,37 @@ func (ctx *mgoContext) ParseCmdLineShardingRole() (role string, err *msp.Error)
 	return
 }
 
+// Parse the options the Mongod was started with, including those from its config file
+// Options not specified are left at their zero value.
+func (ctx *mgoContext) ParseCmdLineMongodOptions() (options msp.MongodOptions, err *msp.Error) {
+
+	parsed, err := ctx.parsedCmdLineOpts()
+	if err != nil {
+		return options, err
+	}
+
+	if storage, ok := parsed["storage"].(bson.M); ok {
+		options.StorageEngine, _ = storage["engine"].(string)
+		if journal, ok := storage["journal"].(bson.M); ok {
+			if enabled, ok := journal["enabled"].(bool); ok {
+				options.JournalDisabled = !enabled
+			}
+		}
+		if wiredTiger, ok := storage["wiredTiger"].(bson.M); ok {
+			if engineConfig, ok := wiredTiger["engineConfig"].(bson.M); ok {
+				options.WiredTigerCacheSizeGB = bsonFloat64(engineConfig["cacheSizeGB"])
+			}
+		}
+	}
+	if network, ok := parsed["net"].(bson.M); ok {
+		options.BindIP, _ = network["bindIp"].(string)
+	}
+	if replication, ok := parsed["replication"].(bson.M); ok {
+		options.OplogSizeMB = uint(bsonInt64(replication["oplogSizeMB"]))
+	}
+	return
+}
+
 func (ctx *mgoContext) ShutdownWithTimeout(seconds int64) *msp.Error {
 	var result interface{}
 	err := ctx.Session.Run(bson.D{{"shutdown", 1}, {"timeoutSecs", seconds}}, result)
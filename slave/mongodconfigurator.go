@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"github.com/KIT-MAMID/mamid/msp"
 	"gopkg.in/mgo.v2/bson"
+	"net"
 	"strconv"
 	"strings"
 	"time"
@@ -14,6 +15,7 @@ const (
 	replSetPrimary    = 1
 	replSetSecondary  = 2
 	replSetRecovering = 3
+	replSetArbiter    = 7
 	replSetUnknown    = 6
 	replSetRemoved    = 10
 )
@@ -43,12 +45,18 @@ func (c *ConcreteMongodConfigurator) fetchConfiguration(ctx *mgoContext) (mongod
 	if err = ctx.IsMaster(&isMasterRes); err != nil {
 		return
 	}
+
+	if mongod.Options, err = ctx.ParseCmdLineMongodOptions(); err != nil {
+		return
+	}
+
 	if _, exists := isMasterRes["setName"]; !exists {
 		return msp.Mongod{
 			Port:                    ctx.Port,
 			StatusError:             nil,
 			LastEstablishStateError: nil,
 			State: msp.MongodStateUninitialized,
+			Options:                 mongod.Options,
 		}, nil
 	}
 
@@ -66,6 +74,8 @@ func (c *ConcreteMongodConfigurator) fetchConfiguration(ctx *mgoContext) (mongod
 		mongod.State = msp.MongodStateRunning
 	case replSetSecondary:
 		mongod.State = msp.MongodStateRunning
+	case replSetArbiter:
+		mongod.State = msp.MongodStateRunning
 	case replSetRemoved:
 		mongod.State = msp.MongodStateRemoved
 	}
@@ -91,10 +101,15 @@ func (c *ConcreteMongodConfigurator) fetchConfiguration(ctx *mgoContext) (mongod
 			remotePort, _ := strconv.Atoi(pair[1])
 			priority := member.(bson.M)["priority"].(float64)
 			votes := member.(bson.M)["votes"].(int)
+			arbiterOnly, _ := member.(bson.M)["arbiterOnly"].(bool)
+			hidden, _ := member.(bson.M)["hidden"].(bool)
 			members[k] = msp.ReplicaSetMember{
-				HostPort: msp.HostPort{Hostname: pair[0], Port: msp.PortNumber(remotePort)},
-				Priority: priority,
-				Votes:    votes,
+				HostPort:    msp.HostPort{Hostname: pair[0], Port: msp.PortNumber(remotePort)},
+				Priority:    priority,
+				Votes:       votes,
+				ArbiterOnly: arbiterOnly,
+				Hidden:      hidden,
+				SlaveDelay:  bsonInt64(member.(bson.M)["slaveDelay"]),
 			}
 		}
 	} else {
@@ -123,9 +138,65 @@ func (c *ConcreteMongodConfigurator) fetchConfiguration(ctx *mgoContext) (mongod
 	}
 	mongod.ReplicaSetConfig.ShardingRole = shardingRole
 
+	mongod.Replication = fetchReplicationStatus(ctx, status, state)
+
 	return mongod, nil
 }
 
+// Failures to read the oplog are logged as the status of the members is still valid
+func fetchReplicationStatus(ctx *mgoContext, status bson.M, state replSetState) *msp.ReplicationStatus {
+
+	replication := &msp.ReplicationStatus{
+		Members: parseReplicationMembers(status),
+	}
+
+	if state == replSetArbiter {
+		return replication // arbiters hold no data, hence no oplog
+	}
+
+	var err *msp.Error
+	if replication.OplogFirst, replication.OplogLast, err = ctx.OplogTimestamps(); err != nil {
+		log.Errorf("%s: %s", err.Description, err.LongDescription)
+	}
+	if replication.OplogSizeBytes, replication.OplogMaxSizeBytes, err = ctx.OplogSize(); err != nil {
+		log.Errorf("%s: %s", err.Description, err.LongDescription)
+	}
+
+	return replication
+}
+
+// Parse the `members` of a replSetGetStatus result, skipping unparseable members
+func parseReplicationMembers(status bson.M) (members []msp.ReplicationMemberStatus) {
+	statusMembers, _ := status["members"].([]interface{})
+	for _, m := range statusMembers {
+		member, ok := m.(bson.M)
+		if !ok {
+			continue
+		}
+		name, _ := member["name"].(string)
+		host, portStr, err := net.SplitHostPort(name)
+		if err != nil {
+			log.Errorf("cannot parse name `%s` of Replica Set member: %s", name, err)
+			continue
+		}
+		port, err := strconv.ParseUint(portStr, 10, 16)
+		if err != nil {
+			log.Errorf("cannot parse port of Replica Set member `%s`: %s", name, err)
+			continue
+		}
+		state, _ := member["stateStr"].(string)
+		optime, _ := member["optimeDate"].(time.Time)
+		self, _ := member["self"].(bool)
+		members = append(members, msp.ReplicationMemberStatus{
+			HostPort: msp.HostPort{Hostname: host, Port: msp.PortNumber(port)},
+			State:    state,
+			Optime:   optime,
+			Self:     self,
+		})
+	}
+	return members
+}
+
 func (c *ConcreteMongodConfigurator) MongodConfiguration(port msp.PortNumber, cred msp.MongodCredential) (msp.Mongod, *msp.Error) {
 
 	ctx, err := c.connect(port, "", cred)
@@ -285,6 +356,33 @@ func updateConfig(currentConfig bson.M, m msp.Mongod) (bson.M, *msp.Error) {
 	return config, nil
 }
 
+// Numbers in documents returned by mongod are int or int64 depending on their size, 0 if absent
+func bsonInt64(value interface{}) int64 {
+	switch v := value.(type) {
+	case int:
+		return int64(v)
+	case int64:
+		return v
+	case float64:
+		return int64(v)
+	default:
+		return 0
+	}
+}
+
+func bsonFloat64(value interface{}) float64 {
+	switch v := value.(type) {
+	case int:
+		return float64(v)
+	case int64:
+		return float64(v)
+	case float64:
+		return v
+	default:
+		return 0
+	}
+}
+
 func updateMembersList(currentConfig bson.M, desiredMembers []msp.ReplicaSetMember) ([]bson.M, *msp.Error) {
 	//Update config members list
 	//Only use ids not used before for new members
@@ -334,6 +432,9 @@ func updateMembersList(currentConfig bson.M, desiredMembers []msp.ReplicaSetMemb
 		member["host"] = hostPortString
 		member["priority"] = desiredMember.Priority
 		member["votes"] = desiredMember.Votes
+		member["arbiterOnly"] = desiredMember.ArbiterOnly // cannot be changed for existing members, but a Mongod never changes its kind
+		member["hidden"] = desiredMember.Hidden
+		member["slaveDelay"] = desiredMember.SlaveDelay
 
 		resultingMembers = append(resultingMembers, member)
 
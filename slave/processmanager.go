@@ -4,41 +4,74 @@ import (
 	"fmt"
 	"github.com/KIT-MAMID/mamid/msp"
 	"os/exec"
+	"sync"
+	"syscall"
+	"time"
 )
 
 type ProcessManager struct {
-	killChan         chan msp.PortNumber
+	RestartPolicy    RestartPolicy     // supervision of exited Mongods, see procmgr_supervision.go
+	LogRotation      LogRotationPolicy // see procmgr_log.go
+	Cgroups          *Cgroups          // resource isolation, nil if unavailable, see procmgr_cgroup.go
+	exitChan         chan processExit
 	command          string
 	dataDir          string
+	mutex            sync.Mutex // protects runningProcesses and supervised
 	runningProcesses map[msp.PortNumber]*exec.Cmd
+	supervised       map[msp.PortNumber]*supervisedProcess
 }
 
 func NewProcessManager(command string, dataDir string) *ProcessManager {
 	return &ProcessManager{
-		killChan:         make(chan msp.PortNumber),
+		exitChan:         make(chan processExit),
 		command:          command,
 		dataDir:          dataDir,
 		runningProcesses: make(map[msp.PortNumber]*exec.Cmd),
+		supervised:       make(map[msp.PortNumber]*supervisedProcess),
 	}
 }
 
 func (p *ProcessManager) Run() {
 	go func() {
 		for {
-			port := <-p.killChan
-			delete(p.runningProcesses, port)
+			exit := <-p.exitChan
+			p.mutex.Lock()
+			delete(p.runningProcesses, exit.port)
+			p.handleExit(exit)
+			p.mutex.Unlock()
 		}
 	}()
+	if p.LogRotation.MaxSize > 0 {
+		go p.rotateLogsPeriodically()
+	}
 }
 
 func (p *ProcessManager) HasProcess(port msp.PortNumber) bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
 	_, exists := p.runningProcesses[port]
 	return exists
 }
 
 // Spawn a new Mongod process
 //   The Mongod's `--keyfile` is only updated when it is spawned
+//   The Mongod's config file is updated, see UpdateMongodConfig()
+//   The Mongod's resource limits are updated, see UpdateResourceLimits()
+//   A pending restart of the Mongod is cancelled
 func (p *ProcessManager) SpawnProcess(m msp.Mongod) (err error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	defer func() {
+		if err != nil {
+			mongodSpawnFailures.WithLabelValues("master").Inc()
+		}
+	}()
+
+	if status, looping := p.crashLoopStatus(m.Port); looping {
+		return fmt.Errorf("Mongod is crash looping (%d consecutive crashes, last exit code %d)", status.CrashCount, status.LastExitCode)
+	}
+
 	if ok, err := p.checkMongoDVersion(); err != nil || !ok {
 		if err != nil {
 			return fmt.Errorf("processmanager.checkMongoDVersion() failed with: %s", err)
@@ -53,29 +86,69 @@ func (p *ProcessManager) SpawnProcess(m msp.Mongod) (err error) {
 		return
 	}
 
-	args := p.buildMongodCommandLine(m)
+	if err = p.UpdateMongodConfig(m); err != nil {
+		return fmt.Errorf("could not write config file: %s", err)
+	}
+
+	p.UpdateResourceLimits(m)
+
+	return p.startProcess(m, p.buildMongodCommandLine(m))
+
+}
+
+// Start the Mongod process and supervise it
+// p.mutex must be held by the caller
+func (p *ProcessManager) startProcess(m msp.Mongod, args []string) error {
+
+	logFile, err := p.openProcessLogFile(m)
+	if err != nil {
+		return fmt.Errorf("could not open log file: %s", err)
+	}
+	defer logFile.Close() // the Mongod inherits a duplicate of the file descriptor
+
 	log.Debugf("spwaning Mongod with arguments: %v", args)
 	cmd := exec.Command(p.command, args...)
+	cmd.Stdout = logFile
+	cmd.Stderr = logFile
 	if err := cmd.Start(); err != nil {
 		return err
 	}
 
+	if p.Cgroups != nil {
+		if err := p.Cgroups.addProcess(m.Port, cmd.Process.Pid); err != nil {
+			log.Errorf("could not move Mongod on port `%d` into its cgroup, it runs without resource limits: %s", m.Port, err)
+		}
+	}
+
+	if err := p.writeProcessMetadata(m, cmd); err != nil {
+		log.Errorf("could not persist process metadata of Mongod on port `%d`, it will not be adopted after a slave restart: %s", m.Port, err)
+	}
+
 	go func() {
+		exitCode := -1
 		processState, err := cmd.Process.Wait()
 		if err != nil {
 			log.Errorf("error waiting for process `%v`: %s", cmd, err)
-		} else if !processState.Success() {
-			log.Errorf("Mongod exited unsuccessfully: %v", processState)
+		} else {
+			if status, ok := processState.Sys().(syscall.WaitStatus); ok && status.Exited() {
+				exitCode = status.ExitStatus()
+			}
+			if !processState.Success() {
+				log.Errorf("Mongod exited unsuccessfully: %v", processState)
+			}
 		}
-		p.killChan <- m.Port
+		p.exitChan <- processExit{port: m.Port, exitCode: exitCode}
 	}()
 
 	p.runningProcesses[m.Port] = cmd
+	p.supervise(m, args)
 	return nil
 
 }
 
 func (p *ProcessManager) RunningProcesses() []msp.PortNumber {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
 	ports := make([]msp.PortNumber, 0, len(p.runningProcesses))
 	for port := range p.runningProcesses {
 		ports = append(ports, port)
@@ -84,6 +157,9 @@ func (p *ProcessManager) RunningProcesses() []msp.PortNumber {
 }
 
 func (p *ProcessManager) KillProcess(port msp.PortNumber) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.expectExit(port)
 	if cmd, exists := p.runningProcesses[port]; exists {
 		return cmd.Process.Kill()
 	}
@@ -93,8 +169,11 @@ func (p *ProcessManager) KillProcess(port msp.PortNumber) error {
 // killProcess is destructive. Even when there was an error (already killed, stuck state, permissions lost), we do not care. The error is purely informational that _something_ went wrong.
 // This function is to be used for complete clean restart/shutdown only.
 func (p *ProcessManager) KillProcesses() error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
 	var err error = nil
-	for _, cmd := range p.runningProcesses {
+	for port, cmd := range p.runningProcesses {
+		p.expectExit(port)
 		curErr := cmd.Process.Kill()
 		if err == nil {
 			err = curErr
@@ -103,3 +182,24 @@ func (p *ProcessManager) KillProcesses() error {
 	p.runningProcesses = make(map[msp.PortNumber]*exec.Cmd)
 	return err
 }
+
+// Interval at which adopted processes are checked for liveness
+var adoptedProcessPollInterval = 1 * time.Second
+
+// Register a Mongod process that was not spawned by this ProcessManager and supervise it
+// Its exit code is unknown as it cannot be waited for, the exit is treated as a crash.
+// p.mutex must be held by the caller
+func (p *ProcessManager) adoptProcess(m msp.Mongod, cmd *exec.Cmd, metadata processMetadata) {
+
+	go func() {
+		for processAlive(metadata) {
+			time.Sleep(adoptedProcessPollInterval)
+		}
+		log.Errorf("adopted Mongod on port `%d` (pid `%d`) exited", m.Port, metadata.PID)
+		p.exitChan <- processExit{port: m.Port, exitCode: -1}
+	}()
+
+	p.runningProcesses[m.Port] = cmd
+	p.supervise(m, metadata.Args[1:])
+
+}
@@ -1,18 +1,24 @@
 package slave
 
 import (
+	"fmt"
 	"github.com/KIT-MAMID/mamid/msp"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/assert"
+	"gopkg.in/mgo.v2/bson"
 	"io/ioutil"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strings"
 	"syscall"
 	"testing"
 	"time"
 )
 
 func (p *ProcessManager) GetProcess(port msp.PortNumber) *exec.Cmd {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
 	return p.runningProcesses[port]
 }
 
@@ -70,16 +76,14 @@ func TestProcessManager_SpawnProcess(t *testing.T) {
 
 	assert.Equal(t, []string{
 		"./fakemongod.sh",
-		"--dbpath",
-		filepath.Join(dataDir, "mongods", "10:replSet", "dbpath"),
-		"--port",
-		"10",
-		"--replSet",
-		"replSet",
-		"--keyFile",
-		filepath.Join(dataDir, "mongods", "10:replSet", "conf", "keyfile"),
+		"--config",
+		filepath.Join(dataDir, "mongods", "10:replSet", "conf", "mongod.conf"),
 	}, cmd.Args)
 
+	config, err := ioutil.ReadFile(filepath.Join(dataDir, "mongods", "10:replSet", "conf", "mongod.conf"))
+	assert.NoError(t, err)
+	assert.Contains(t, string(config), "replSetName: \"replSet\"")
+
 	// cleanup
 	err = cmd.Process.Signal(syscall.SIGKILL)
 	if err != nil {
@@ -204,3 +208,501 @@ func TestProcessManager_KillProcesses(t *testing.T) {
 	cmd1.Process.Signal(syscall.SIGKILL)
 	cmd2.Process.Signal(syscall.SIGKILL)
 }
+
+func TestProcessManager_AdoptProcesses(t *testing.T) {
+	var err error
+
+	adoptedProcessPollInterval = 10 * time.Millisecond
+
+	p := NewProcessManager("./fakemongod.sh", dataDir)
+	p.Run()
+
+	m := msp.Mongod{
+		Port: 12,
+		ReplicaSetConfig: msp.ReplicaSetConfig{
+			ReplicaSetName: "replSet",
+		},
+	}
+	err = p.SpawnProcess(m)
+	assert.NoError(t, err)
+	cmd := p.GetProcess(12)
+
+	info, err := os.Stat(p.processMetadataPath(m))
+	if assert.NoError(t, err) {
+		assert.Equal(t, os.FileMode(KeyfilePermissions), info.Mode().Perm())
+	}
+
+	// Simulate a slave restart
+	adopting := NewProcessManager("./fakemongod.sh", dataDir)
+	adopting.Run()
+	assert.NoError(t, adopting.AdoptProcesses())
+
+	assert.Equal(t, []msp.PortNumber{12}, adopting.RunningProcesses())
+	adopted := adopting.GetProcess(12)
+	assert.Equal(t, cmd.Process.Pid, adopted.Process.Pid)
+	assert.Equal(t, cmd.Args, adopted.Args)
+
+	assert.NoError(t, adopting.KillProcess(12))
+	cmd.Process.Wait()                //Less racy tests
+	time.Sleep(50 * time.Millisecond) // give goroutines a chance to cleanup
+
+	assert.Empty(t, adopting.RunningProcesses())
+	_, err = os.Stat(p.processMetadataPath(m))
+	assert.True(t, os.IsNotExist(err), "process metadata should be removed after exit")
+
+	// Exited processes are not adopted
+	restarted := NewProcessManager("./fakemongod.sh", dataDir)
+	restarted.Run()
+	assert.NoError(t, restarted.AdoptProcesses())
+	assert.Empty(t, restarted.RunningProcesses())
+}
+
+func TestProcessManager_PersistCredential(t *testing.T) {
+
+	p := NewProcessManager("./fakemongod.sh", dataDir)
+
+	m := msp.Mongod{
+		Port: 13,
+		ReplicaSetConfig: msp.ReplicaSetConfig{
+			ReplicaSetName: "replSet",
+			RootCredential: msp.MongodCredential{Username: "root", Password: "secret"},
+		},
+	}
+	assert.NoError(t, p.createDirSkeleton(m))
+	defer p.destroyDataDirectory(m)
+
+	assert.NoError(t, p.PersistCredential(m))
+
+	info, err := os.Stat(p.processCredentialPath(m))
+	if assert.NoError(t, err) {
+		assert.Equal(t, os.FileMode(KeyfilePermissions), info.Mode().Perm())
+	}
+
+	credentials, err := p.PersistedCredentials()
+	assert.NoError(t, err)
+	assert.Equal(t, m.ReplicaSetConfig.RootCredential, credentials[13])
+}
+
+// Wait until condition holds or timeout passes
+func eventually(timeout time.Duration, condition func() bool) bool {
+	for deadline := time.Now().Add(timeout); time.Now().Before(deadline); time.Sleep(5 * time.Millisecond) {
+		if condition() {
+			return true
+		}
+	}
+	return condition()
+}
+
+func TestProcessManager_RestartOnFailure(t *testing.T) {
+	var err error
+
+	p := NewProcessManager("./fakemongod.sh", dataDir)
+	p.RestartPolicy = RestartPolicy{
+		Mode:               RestartOnFailure,
+		InitialBackoff:     1 * time.Millisecond,
+		MaxBackoff:         10 * time.Millisecond,
+		CrashLoopThreshold: 3,
+		StableUptime:       1 * time.Minute,
+	}
+	p.Run()
+
+	err = p.SpawnProcess(msp.Mongod{
+		Port: 14,
+		ReplicaSetConfig: msp.ReplicaSetConfig{
+			ReplicaSetName: "replSet",
+		},
+	})
+	assert.NoError(t, err)
+	crashed := p.GetProcess(14)
+
+	// Crash
+	crashed.Process.Signal(syscall.SIGKILL)
+
+	restarted := eventually(time.Second, func() bool {
+		return p.HasProcess(14) && p.GetProcess(14) != crashed
+	})
+	if !assert.True(t, restarted, "crashed Mongod should be restarted") {
+		return
+	}
+	assert.Equal(t, crashed.Args, p.GetProcess(14).Args)
+
+	status, exists := p.SupervisionStatus(14)
+	assert.True(t, exists)
+	assert.EqualValues(t, 1, status.CrashCount)
+	assert.Equal(t, -1, status.LastExitCode)
+	assert.False(t, status.CrashLoop)
+
+	// Killed Mongods are not restarted
+	cmd := p.GetProcess(14)
+	assert.NoError(t, p.KillProcess(14))
+	assert.True(t, eventually(time.Second, func() bool { return !p.HasProcess(14) }))
+	time.Sleep(20 * time.Millisecond)
+	assert.False(t, p.HasProcess(14))
+	assert.False(t, p.RestartPending(14))
+
+	status, _ = p.SupervisionStatus(14)
+	assert.EqualValues(t, 0, status.CrashCount)
+
+	// cleanup
+	cmd.Process.Signal(syscall.SIGKILL)
+}
+
+func TestProcessManager_CrashLoop(t *testing.T) {
+	var err error
+
+	p := NewProcessManager("./fakecrashingmongod.sh", dataDir)
+	p.RestartPolicy = RestartPolicy{
+		Mode:               RestartOnFailure,
+		InitialBackoff:     1 * time.Millisecond,
+		MaxBackoff:         10 * time.Millisecond,
+		CrashLoopThreshold: 3,
+		StableUptime:       1 * time.Minute,
+	}
+	p.Run()
+
+	m := msp.Mongod{
+		Port: 15,
+		ReplicaSetConfig: msp.ReplicaSetConfig{
+			ReplicaSetName: "replSet",
+		},
+	}
+	err = p.SpawnProcess(m)
+	assert.NoError(t, err)
+
+	looping := eventually(time.Second, func() bool {
+		status, _ := p.SupervisionStatus(15)
+		return status.CrashLoop
+	})
+	if !assert.True(t, looping, "Mongod should be detected as crash looping") {
+		return
+	}
+
+	status, _ := p.SupervisionStatus(15)
+	assert.EqualValues(t, 3, status.CrashCount)
+	assert.Equal(t, 3, status.LastExitCode)
+	assert.False(t, p.HasProcess(15))
+	assert.False(t, p.RestartPending(15))
+	assert.Error(t, p.SpawnProcess(m), "crash looping Mongod must not be spawned")
+
+	// Stopping the Mongod ends the crash loop
+	p.ExpectShutdown(15)
+	status, _ = p.SupervisionStatus(15)
+	assert.False(t, status.CrashLoop)
+
+	p.RestartPolicy.CrashLoopThreshold = 0
+	p.RestartPolicy.Mode = RestartNever
+	assert.NoError(t, p.SpawnProcess(m))
+}
+
+func TestProcessManager_MongodLogTail(t *testing.T) {
+	var err error
+
+	p := NewProcessManager("./fakemongod.sh", dataDir)
+	p.Run()
+
+	err = p.SpawnProcess(msp.Mongod{
+		Port: 16,
+		ReplicaSetConfig: msp.ReplicaSetConfig{
+			ReplicaSetName: "replSet",
+		},
+	})
+	assert.NoError(t, err)
+	cmd := p.GetProcess(16)
+
+	var lines []string
+	logged := eventually(time.Second, func() bool {
+		lines, err = p.MongodLogTail(16, 10)
+		return err == nil && len(lines) > 0
+	})
+	if assert.True(t, logged, "output of the Mongod should be logged") {
+		assert.Equal(t, []string{"db version v3.2"}, lines)
+	}
+
+	_, err = p.MongodLogTail(17, 10)
+	assert.Error(t, err, "no Mongod on port 17")
+
+	// cleanup
+	p.KillProcess(16)
+	cmd.Process.Wait()
+}
+
+func TestRotateLogFile(t *testing.T) {
+
+	dir, err := ioutil.TempDir(dataDir, "log")
+	assert.NoError(t, err)
+	path := filepath.Join(dir, "mongod.log")
+
+	writeLines := func(lines ...string) {
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, logFilePermissions)
+		assert.NoError(t, err)
+		for _, line := range lines {
+			f.WriteString(line + "\n")
+		}
+		f.Close()
+	}
+
+	policy := LogRotationPolicy{MaxSize: 10, MaxBackups: 2}
+
+	writeLines("a")
+	assert.NoError(t, rotateLogFile(path, policy))
+	lines, err := tailLines(path, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a"}, lines, "should not rotate files smaller than MaxSize")
+
+	writeLines("b", "c", "d", "e", "f")
+	assert.NoError(t, rotateLogFile(path, policy))
+	lines, err = tailLines(path, 10)
+	assert.NoError(t, err)
+	assert.Empty(t, lines)
+	lines, err = tailLines(path+".1", 10)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a", "b", "c", "d", "e", "f"}, lines)
+
+	writeLines("g", "h", "i", "j", "k")
+	assert.NoError(t, rotateLogFile(path, policy))
+	writeLines("l", "m", "n", "o", "p")
+	assert.NoError(t, rotateLogFile(path, policy))
+
+	_, err = os.Stat(path + ".3")
+	assert.True(t, os.IsNotExist(err), "should keep at most MaxBackups rotated files")
+	lines, err = tailLines(path+".2", 10)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"g", "h", "i", "j", "k"}, lines)
+
+	lines, err = tailLines(path+".1", 3)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"n", "o", "p"}, lines)
+}
+
+func TestProcessManager_renderMongodConfig(t *testing.T) {
+
+	p := NewProcessManager("./fakemongod.sh", "/data")
+	m := msp.Mongod{
+		Port: 10,
+		ReplicaSetConfig: msp.ReplicaSetConfig{
+			ReplicaSetName: "replSet",
+			ShardingRole:   msp.ShardingRoleShardServer,
+		},
+	}
+
+	assert.Equal(t, `# generated by the MAMID slave, changes are overwritten
+storage:
+  dbPath: "/data/mongods/10:replSet/dbpath"
+net:
+  port: 10
+replication:
+  replSetName: "replSet"
+security:
+  keyFile: "/data/mongods/10:replSet/conf/keyfile"
+sharding:
+  clusterRole: shardsvr
+`, p.renderMongodConfig(m))
+
+	m.ReplicaSetConfig.ShardingRole = msp.ShardingRoleNone
+	m.Options = msp.MongodOptions{
+		StorageEngine:         msp.StorageEngineWiredTiger,
+		WiredTigerCacheSizeGB: 1.5,
+		OplogSizeMB:           1024,
+		BindIP:                "127.0.0.1,10.0.0.1",
+		JournalDisabled:       true,
+	}
+
+	assert.Equal(t, `# generated by the MAMID slave, changes are overwritten
+storage:
+  dbPath: "/data/mongods/10:replSet/dbpath"
+  engine: "wiredTiger"
+  journal:
+    enabled: false
+  wiredTiger:
+    engineConfig:
+      cacheSizeGB: 1.5
+net:
+  port: 10
+  bindIp: "127.0.0.1,10.0.0.1"
+replication:
+  replSetName: "replSet"
+  oplogSizeMB: 1024
+security:
+  keyFile: "/data/mongods/10:replSet/conf/keyfile"
+`, p.renderMongodConfig(m))
+
+}
+
+// A directory tree resembling a cgroup v2 hierarchy, files created by the kernel have to be created by the test
+func createFakeCgroupfs(t *testing.T, controllers string) string {
+	mountPoint, err := ioutil.TempDir(dataDir, "cgroupfs-")
+	assert.NoError(t, err)
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(mountPoint, "cgroup.controllers"), []byte(controllers+"\n"), 0644))
+	return mountPoint
+}
+
+func TestNewCgroups_unavailable(t *testing.T) {
+
+	_, err := NewCgroups(filepath.Join(dataDir, "nonexistent"), "mamid")
+	assert.Error(t, err, "hierarchies without cgroup.controllers are not cgroup v2")
+
+	_, err = NewCgroups(createFakeCgroupfs(t, "io pids"), "mamid")
+	assert.Error(t, err, "neither memory nor cpu controller available")
+
+}
+
+func TestProcessManager_SpawnProcess_cgroups(t *testing.T) {
+
+	mountPoint := createFakeCgroupfs(t, "cpuset cpu io memory pids")
+	cgroups, err := NewCgroups(mountPoint, "system.slice/mamid")
+	if !assert.NoError(t, err) {
+		return
+	}
+	for _, dir := range []string{"", "system.slice", "system.slice/mamid"} {
+		subtreeControl, err := ioutil.ReadFile(filepath.Join(mountPoint, dir, "cgroup.subtree_control"))
+		assert.NoError(t, err)
+		assert.Contains(t, string(subtreeControl), "+memory")
+		assert.Contains(t, string(subtreeControl), "+cpu")
+	}
+
+	p := NewProcessManager("./fakemongod.sh", dataDir)
+	p.Cgroups = cgroups
+	p.Run()
+
+	m := msp.Mongod{
+		Port: 16,
+		ReplicaSetConfig: msp.ReplicaSetConfig{
+			ReplicaSetName: "replSet",
+		},
+		Resources: msp.MongodResources{
+			MemoryLimitBytes:   512 * 1024 * 1024,
+			CPULimitMillicores: 1500,
+		},
+	}
+	assert.NoError(t, p.SpawnProcess(m))
+	cmd := p.GetProcess(16)
+	defer p.KillProcess(16)
+
+	group := filepath.Join(mountPoint, "system.slice", "mamid", "mongod-16")
+	readGroupFile := func(name string) string {
+		content, err := ioutil.ReadFile(filepath.Join(group, name))
+		assert.NoError(t, err)
+		return string(content)
+	}
+	assert.Equal(t, "536870912", readGroupFile("memory.max"))
+	assert.Equal(t, "150000 100000", readGroupFile("cpu.max"))
+	assert.Equal(t, fmt.Sprintf("%d", cmd.Process.Pid), readGroupFile("cgroup.procs"))
+
+	// files maintained by the kernel
+	assert.Nil(t, p.ResourceUsage(16), "usage cannot be reported without memory.current")
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(group, "memory.current"), []byte("1048576\n"), 0644))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(group, "cpu.stat"), []byte("usage_usec 2500\nuser_usec 2000\nsystem_usec 500\n"), 0644))
+
+	assert.Equal(t, &msp.MongodResourceUsage{
+		Limits:               m.Resources,
+		MemoryCurrentBytes:   1048576,
+		CPUUsageMicroseconds: 2500,
+	}, p.ResourceUsage(16))
+
+	// limits are updated in place
+	m.Resources = msp.MongodResources{}
+	p.UpdateResourceLimits(m)
+	assert.Equal(t, "max", readGroupFile("memory.max"))
+	assert.Equal(t, "max 100000", readGroupFile("cpu.max"))
+	assert.Equal(t, msp.MongodResources{}, p.ResourceUsage(16).Limits)
+
+	assert.NoError(t, p.KillProcess(16))
+	assert.NoError(t, p.destroyDataDirectory(m))
+	_, err = os.Stat(group)
+	assert.True(t, os.IsNotExist(err), "cgroup should be removed with the Mongod's data")
+
+	assert.Nil(t, (&ProcessManager{}).ResourceUsage(16), "no usage without cgroups")
+
+}
+
+func TestHostMetricsSampler_Sample(t *testing.T) {
+
+	procDir, err := ioutil.TempDir(dataDir, "proc-")
+	assert.NoError(t, err)
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(procDir, "loadavg"), []byte("0.50 1.25 2.00 1/123 4567\n"), 0644))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(procDir, "meminfo"), []byte(
+		"MemTotal:        2048 kB\n"+
+			"MemFree:          512 kB\n"+
+			"MemAvailable:    1024 kB\n"+
+			"SwapTotal:        256 kB\n"+
+			"SwapFree:         128 kB\n"+
+			"HugePages_Total:    0\n"), 0644))
+
+	metrics, err := HostMetricsSampler{ProcDir: procDir, DataDir: dataDir}.Sample()
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.True(t, metrics.CPUCount > 0)
+	assert.Equal(t, 0.5, metrics.LoadAverage1)
+	assert.Equal(t, 1.25, metrics.LoadAverage5)
+	assert.Equal(t, 2.0, metrics.LoadAverage15)
+	assert.EqualValues(t, 2048*1024, metrics.MemoryTotalBytes)
+	assert.EqualValues(t, 1024*1024, metrics.MemoryAvailableBytes)
+	assert.EqualValues(t, 256*1024, metrics.SwapTotalBytes)
+	assert.EqualValues(t, 128*1024, metrics.SwapFreeBytes)
+	assert.True(t, metrics.DataVolumeTotalBytes > 0)
+	assert.True(t, metrics.DataVolumeAvailableBytes <= metrics.DataVolumeTotalBytes)
+
+	_, err = HostMetricsSampler{ProcDir: filepath.Join(dataDir, "nonexistent"), DataDir: dataDir}.Sample()
+	assert.Error(t, err)
+
+}
+
+func TestParseReplicationMembers(t *testing.T) {
+
+	optime := time.Date(2016, time.November, 1, 12, 0, 0, 0, time.UTC)
+	members := parseReplicationMembers(bson.M{
+		"set": "repl1",
+		"members": []interface{}{
+			bson.M{"name": "host1:2000", "stateStr": "PRIMARY", "optimeDate": optime, "self": true},
+			bson.M{"name": "host2:2001", "stateStr": "SECONDARY", "optimeDate": optime.Add(-time.Minute)},
+			bson.M{"name": "host3:2002", "stateStr": "ARBITER"},
+			bson.M{"name": "invalid", "stateStr": "SECONDARY"},
+		},
+	})
+
+	if assert.Len(t, members, 3, "members with invalid names are skipped") {
+		assert.Equal(t, msp.ReplicationMemberStatus{
+			HostPort: msp.HostPort{Hostname: "host1", Port: 2000},
+			State:    msp.ReplicationMemberStatePrimary,
+			Optime:   optime,
+			Self:     true,
+		}, members[0])
+		assert.Equal(t, optime.Add(-time.Minute), members[1].Optime)
+		assert.False(t, members[1].Self)
+		assert.True(t, members[2].Optime.IsZero(), "arbiters have no optime")
+	}
+
+	assert.Equal(t, time.Unix(1478001600, 0), mongoTimestampTime(bson.MongoTimestamp(1478001600<<32|7)))
+
+}
+
+func TestProcessManager_Collect(t *testing.T) {
+	p := NewProcessManager("mongod", "/nonexistent")
+	p.RestartPolicy.CrashLoopThreshold = 3
+	p.runningProcesses[27017] = &exec.Cmd{}
+	p.supervised[27017] = &supervisedProcess{
+		mongod: msp.Mongod{Port: 27017, ReplicaSetConfig: msp.ReplicaSetConfig{ReplicaSetName: "repl1"}},
+	}
+	p.supervised[27018] = &supervisedProcess{
+		ProcessSupervisionStatus: ProcessSupervisionStatus{CrashCount: 3, CrashLoop: true},
+		mongod:                   msp.Mongod{Port: 27018, ReplicaSetConfig: msp.ReplicaSetConfig{ReplicaSetName: "repl2"}},
+	}
+
+	expected := `
+# HELP mamid_slave_managed_mongods Number of running Mongods managed by the slave.
+# TYPE mamid_slave_managed_mongods gauge
+mamid_slave_managed_mongods 1
+# HELP mamid_slave_mongod_state State of a Mongod spawned by the slave, 1 for the current state.
+# TYPE mamid_slave_mongod_state gauge
+mamid_slave_mongod_state{port="27017",replica_set="repl1",state="crash_loop"} 0
+mamid_slave_mongod_state{port="27017",replica_set="repl1",state="exited"} 0
+mamid_slave_mongod_state{port="27017",replica_set="repl1",state="restart_pending"} 0
+mamid_slave_mongod_state{port="27017",replica_set="repl1",state="running"} 1
+mamid_slave_mongod_state{port="27018",replica_set="repl2",state="crash_loop"} 1
+mamid_slave_mongod_state{port="27018",replica_set="repl2",state="exited"} 0
+mamid_slave_mongod_state{port="27018",replica_set="repl2",state="restart_pending"} 0
+mamid_slave_mongod_state{port="27018",replica_set="repl2",state="running"} 0
+`
+	assert.NoError(t, testutil.CollectAndCompare(p, strings.NewReader(expected), "mamid_slave_managed_mongods", "mamid_slave_mongod_state"))
+}
@@ -0,0 +1,251 @@
+package slave
+
+import (
+	"fmt"
+	"github.com/KIT-MAMID/mamid/msp"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+/*
+	Resource isolation
+
+	With cgroups v2 (https://www.kernel.org/doc/Documentation/cgroup-v2.txt), every Mongod is placed into its own group
+	below a group delegated to the slave, e.g. /sys/fs/cgroup/mamid/mongod-27017.
+	The group's `memory.max` and `cpu.max` enforce the msp.MongodResources of the Mongod.
+	Limits are updated in place whenever the master establishes the Mongod's state and survive restarts of the Mongod and the slave.
+
+	A Mongod is moved into its group right after it was started.
+	If cgroups are unavailable or a Mongod cannot be moved into its group, the Mongod runs without limits.
+*/
+
+const cgroupCPUPeriod = 100000 // microseconds
+const cgroupUnlimited = "max"
+
+type Cgroups struct {
+	mountPoint  string
+	group       string          // relative to mountPoint, contains one group per Mongod
+	controllers map[string]bool // controllers enabled for the Mongods' groups
+}
+
+// Prepare the group for the Mongods' groups, enabling the `memory` and `cpu` controllers along the path from the mount point.
+// Returns an error if mountPoint is not a cgroup v2 hierarchy or none of the controllers can be enabled.
+func NewCgroups(mountPoint string, group string) (c *Cgroups, err error) {
+
+	c = &Cgroups{
+		mountPoint:  mountPoint,
+		group:       filepath.Clean(group),
+		controllers: make(map[string]bool),
+	}
+
+	available, err := readCgroupControllers(filepath.Join(mountPoint, "cgroup.controllers"))
+	if err != nil {
+		return nil, fmt.Errorf("`%s` is not a cgroup v2 hierarchy: %s", mountPoint, err)
+	}
+	for _, controller := range []string{"memory", "cpu"} {
+		if available[controller] {
+			c.controllers[controller] = true
+		} else {
+			log.Warnf("cgroup controller `%s` is not available in `%s`, Mongods run without %s limits", controller, mountPoint, controller)
+		}
+	}
+	if len(c.controllers) == 0 {
+		return nil, fmt.Errorf("neither the `memory` nor the `cpu` cgroup controller is available in `%s`", mountPoint)
+	}
+
+	if err = os.MkdirAll(c.groupPath(), os.ModeDir|0755); err != nil {
+		return nil, fmt.Errorf("could not create cgroup `%s`: %s", c.groupPath(), err)
+	}
+
+	// Controllers must be enabled in the subtree_control of every ancestor of the Mongods' groups
+	var enable []string
+	for controller := range c.controllers {
+		enable = append(enable, "+"+controller)
+	}
+	dir := mountPoint
+	for _, element := range append([]string{""}, strings.Split(c.group, string(filepath.Separator))...) {
+		dir = filepath.Join(dir, element)
+		if err = ioutil.WriteFile(filepath.Join(dir, "cgroup.subtree_control"), []byte(strings.Join(enable, " ")), 0644); err != nil {
+			return nil, fmt.Errorf("could not enable cgroup controllers in `%s`: %s", dir, err)
+		}
+	}
+
+	return c, nil
+
+}
+
+func (c *Cgroups) groupPath() string {
+	return filepath.Join(c.mountPoint, c.group)
+}
+
+func (c *Cgroups) mongodGroupPath(port msp.PortNumber) string {
+	return filepath.Join(c.groupPath(), fmt.Sprintf("mongod-%d", port))
+}
+
+// Create the group of the Mongod if necessary and update its limits
+func (c *Cgroups) applyLimits(port msp.PortNumber, resources msp.MongodResources) error {
+
+	path := c.mongodGroupPath(port)
+	if err := os.MkdirAll(path, os.ModeDir|0755); err != nil {
+		return err
+	}
+
+	if c.controllers["memory"] {
+		memoryMax := cgroupUnlimited
+		if resources.MemoryLimitBytes > 0 {
+			memoryMax = strconv.FormatUint(resources.MemoryLimitBytes, 10)
+		}
+		if err := ioutil.WriteFile(filepath.Join(path, "memory.max"), []byte(memoryMax), 0644); err != nil {
+			return err
+		}
+	}
+
+	if c.controllers["cpu"] {
+		cpuQuota := cgroupUnlimited
+		if resources.CPULimitMillicores > 0 {
+			cpuQuota = strconv.FormatUint(uint64(resources.CPULimitMillicores)*cgroupCPUPeriod/1000, 10)
+		}
+		cpuMax := fmt.Sprintf("%s %d", cpuQuota, cgroupCPUPeriod)
+		if err := ioutil.WriteFile(filepath.Join(path, "cpu.max"), []byte(cpuMax), 0644); err != nil {
+			return err
+		}
+	}
+
+	return nil
+
+}
+
+// Move the process into the group of the Mongod, the group must exist
+func (c *Cgroups) addProcess(port msp.PortNumber, pid int) error {
+	return ioutil.WriteFile(filepath.Join(c.mongodGroupPath(port), "cgroup.procs"), []byte(strconv.Itoa(pid)), 0644)
+}
+
+// Remove the group of the Mongod, it must not contain processes
+func (c *Cgroups) remove(port msp.PortNumber) error {
+	return os.RemoveAll(c.mongodGroupPath(port))
+}
+
+// Limits in effect for the Mongod and its current usage
+func (c *Cgroups) usage(port msp.PortNumber) (usage msp.MongodResourceUsage, err error) {
+
+	path := c.mongodGroupPath(port)
+
+	if c.controllers["memory"] {
+		if usage.Limits.MemoryLimitBytes, err = readCgroupLimit(filepath.Join(path, "memory.max")); err != nil {
+			return
+		}
+		if usage.MemoryCurrentBytes, err = readCgroupUint(filepath.Join(path, "memory.current")); err != nil {
+			return
+		}
+	}
+
+	if c.controllers["cpu"] {
+		var cpuMax []byte
+		if cpuMax, err = ioutil.ReadFile(filepath.Join(path, "cpu.max")); err != nil {
+			return
+		}
+		if usage.Limits.CPULimitMillicores, err = parseCgroupCPUMax(string(cpuMax)); err != nil {
+			return
+		}
+		if usage.CPUUsageMicroseconds, err = readCgroupStat(filepath.Join(path, "cpu.stat"), "usage_usec"); err != nil {
+			return
+		}
+	}
+
+	return usage, nil
+
+}
+
+func readCgroupControllers(path string) (controllers map[string]bool, err error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	controllers = make(map[string]bool)
+	for _, controller := range strings.Fields(string(content)) {
+		controllers[controller] = true
+	}
+	return controllers, nil
+}
+
+func readCgroupUint(path string) (uint64, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseUint(strings.TrimSpace(string(content)), 10, 64)
+}
+
+// Like readCgroupUint, `max` is read as 0
+func readCgroupLimit(path string) (uint64, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	if value := strings.TrimSpace(string(content)); value != cgroupUnlimited {
+		return strconv.ParseUint(value, 10, 64)
+	}
+	return 0, nil
+}
+
+// The value of key in a flat keyed file, e.g. `cpu.stat`
+func readCgroupStat(path string, key string) (uint64, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	for _, line := range strings.Split(string(content), "\n") {
+		if fields := strings.Fields(line); len(fields) == 2 && fields[0] == key {
+			return strconv.ParseUint(fields[1], 10, 64)
+		}
+	}
+	return 0, fmt.Errorf("no key `%s` in `%s`", key, path)
+}
+
+// Parse `$QUOTA $PERIOD` into millicores, `max` is read as 0
+func parseCgroupCPUMax(cpuMax string) (millicores uint, err error) {
+	fields := strings.Fields(cpuMax)
+	if len(fields) != 2 {
+		return 0, fmt.Errorf("unexpected format of cpu.max `%s`", cpuMax)
+	}
+	if fields[0] == cgroupUnlimited {
+		return 0, nil
+	}
+	quota, err := strconv.ParseUint(fields[0], 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	period, err := strconv.ParseUint(fields[1], 10, 64)
+	if err != nil || period == 0 {
+		return 0, fmt.Errorf("invalid period in cpu.max `%s`", cpuMax)
+	}
+	return uint(quota * 1000 / period), nil
+}
+
+// Create the Mongod's group and update its limits, failures are logged as the Mongod can run without limits
+func (p *ProcessManager) UpdateResourceLimits(m msp.Mongod) {
+	if p.Cgroups == nil {
+		return
+	}
+	if err := p.Cgroups.applyLimits(m.Port, m.Resources); err != nil {
+		log.Errorf("could not apply resource limits of Mongod on port `%d`, it runs without limits: %s", m.Port, err)
+	}
+}
+
+// Limits in effect for the Mongod and its current usage, nil if the Mongod is not isolated
+func (p *ProcessManager) ResourceUsage(port msp.PortNumber) *msp.MongodResourceUsage {
+	if p.Cgroups == nil {
+		return nil
+	}
+	usage, err := p.Cgroups.usage(port)
+	if os.IsNotExist(err) {
+		return nil // e.g. adopted Mongods spawned without isolation
+	} else if err != nil {
+		log.Errorf("could not read resource usage of Mongod on port `%d`: %s", port, err)
+		return nil
+	}
+	return &usage
+}
@@ -11,24 +11,11 @@ import (
 
 const mongodMinRequiredVersion = ">= 3.2"
 
+// All options are passed in the config file, see procmgr_config.go
 func (p *ProcessManager) buildMongodCommandLine(m msp.Mongod) (args []string) {
-
-	args = []string{
-		"--dbpath", p.processDBPathDir(m),
-		"--port", fmt.Sprintf("%d", m.Port),
-		"--replSet", m.ReplicaSetConfig.ReplicaSetName,
-		"--keyFile", p.processKeyfilePath(m),
-	}
-
-	switch m.ReplicaSetConfig.ShardingRole {
-	case msp.ShardingRoleShardServer:
-		args = append(args, "--shardsvr")
-	case msp.ShardingRoleConfigServer:
-		args = append(args, "--configsvr")
+	return []string{
+		"--config", p.processConfigPath(m),
 	}
-
-	return args
-
 }
 
 func (p *ProcessManager) checkMongoDVersion() (bool, error) {
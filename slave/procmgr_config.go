@@ -0,0 +1,80 @@
+package slave
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/KIT-MAMID/mamid/msp"
+	"path/filepath"
+	"strconv"
+)
+
+/*
+	Mongod config files
+
+	Every Mongod is started with a YAML config file rendered from the msp.Mongod into the process's conf directory,
+	see https://docs.mongodb.com/v3.2/reference/configuration-options/
+	The file is rewritten whenever the master establishes the Mongod's state, i.e. changed options take effect
+	the next time the Mongod is started, including restarts by the ProcessManager's supervision.
+*/
+
+func (p *ProcessManager) processConfigPath(m msp.Mongod) string {
+	return filepath.Join(p.processConfDir(m), "mongod.conf")
+}
+
+// Update or create the config file of a Mongod. The process root directory must exist.
+func (p *ProcessManager) UpdateMongodConfig(m msp.Mongod) error {
+	content := p.renderMongodConfig(m)
+	if equal, err := fileContentEqualToBytes(p.processConfigPath(m), content); err != nil {
+		return err
+	} else if equal {
+		return nil
+	}
+	return writeFileAtomically(p.processConfigPath(m), []byte(content))
+}
+
+func (p *ProcessManager) renderMongodConfig(m msp.Mongod) string {
+
+	var b bytes.Buffer
+	o := m.Options
+
+	b.WriteString("# generated by the MAMID slave, changes are overwritten\n")
+
+	b.WriteString("storage:\n")
+	fmt.Fprintf(&b, "  dbPath: %s\n", strconv.Quote(p.processDBPathDir(m)))
+	if o.StorageEngine != "" {
+		fmt.Fprintf(&b, "  engine: %s\n", strconv.Quote(o.StorageEngine))
+	}
+	if o.JournalDisabled {
+		b.WriteString("  journal:\n")
+		b.WriteString("    enabled: false\n")
+	}
+	if o.WiredTigerCacheSizeGB > 0 {
+		b.WriteString("  wiredTiger:\n")
+		b.WriteString("    engineConfig:\n")
+		fmt.Fprintf(&b, "      cacheSizeGB: %s\n", strconv.FormatFloat(o.WiredTigerCacheSizeGB, 'f', -1, 64))
+	}
+
+	b.WriteString("net:\n")
+	fmt.Fprintf(&b, "  port: %d\n", m.Port)
+	if o.BindIP != "" {
+		fmt.Fprintf(&b, "  bindIp: %s\n", strconv.Quote(o.BindIP))
+	}
+
+	b.WriteString("replication:\n")
+	fmt.Fprintf(&b, "  replSetName: %s\n", strconv.Quote(m.ReplicaSetConfig.ReplicaSetName))
+	if o.OplogSizeMB > 0 {
+		fmt.Fprintf(&b, "  oplogSizeMB: %d\n", o.OplogSizeMB)
+	}
+
+	b.WriteString("security:\n")
+	fmt.Fprintf(&b, "  keyFile: %s\n", strconv.Quote(p.processKeyfilePath(m)))
+
+	switch m.ReplicaSetConfig.ShardingRole {
+	case msp.ShardingRoleShardServer, msp.ShardingRoleConfigServer:
+		b.WriteString("sharding:\n")
+		fmt.Fprintf(&b, "  clusterRole: %s\n", m.ReplicaSetConfig.ShardingRole)
+	}
+
+	return b.String()
+
+}
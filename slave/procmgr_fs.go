@@ -55,6 +55,10 @@ func (p *ProcessManager) createDirSkeleton(m msp.Mongod) (err error) {
 		goto wrapError
 	}
 
+	if err = os.MkdirAll(p.processLogDir(m), os.ModeDir|skeletonDirPermissions); err != nil {
+		goto wrapError
+	}
+
 	return nil
 
 wrapError:
@@ -63,6 +67,11 @@ wrapError:
 }
 
 func (p *ProcessManager) destroyDataDirectory(m msp.Mongod) error {
+	if p.Cgroups != nil {
+		if err := p.Cgroups.remove(m.Port); err != nil {
+			log.Errorf("could not remove cgroup of Mongod on port `%d`: %s", m.Port, err)
+		}
+	}
 	return os.RemoveAll(p.processRootDir(m))
 }
 
@@ -0,0 +1,206 @@
+package slave
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/KIT-MAMID/mamid/msp"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+/*
+	Mongod logs
+
+	The stdout and stderr of a Mongod are appended to a log file in the process root directory.
+	The Mongod inherits the file descriptor, i.e. it keeps logging when the slave is restarted (see AdoptProcesses()).
+
+	Log files are rotated by copying them to numbered backups and truncating them once they exceed LogRotationPolicy.MaxSize.
+	Lines written between the copy and the truncation are lost.
+*/
+
+const logFilePermissions = 0600
+const tailChunkSize = 4096
+
+// Interval at which the sizes of log files are checked
+var logRotationCheckInterval = 10 * time.Second
+
+type LogRotationPolicy struct {
+	MaxSize    int64 // size in bytes after which a log file is rotated, 0 disables rotation
+	MaxBackups uint  // number of rotated log files kept
+}
+
+func (p *ProcessManager) processLogDir(m msp.Mongod) string {
+	return filepath.Join(p.processRootDir(m), "log")
+}
+
+func (p *ProcessManager) processLogPath(m msp.Mongod) string {
+	return filepath.Join(p.processLogDir(m), "mongod.log")
+}
+
+func (p *ProcessManager) openProcessLogFile(m msp.Mongod) (*os.File, error) {
+	if err := os.MkdirAll(p.processLogDir(m), os.ModeDir|skeletonDirPermissions); err != nil {
+		return nil, err
+	}
+	return os.OpenFile(p.processLogPath(m), os.O_WRONLY|os.O_CREATE|os.O_APPEND, logFilePermissions)
+}
+
+// Periodically rotate the log files of supervised Mongods
+func (p *ProcessManager) rotateLogsPeriodically() {
+	for range time.Tick(logRotationCheckInterval) {
+		p.RotateLogs()
+	}
+}
+
+// Rotate the log files of all supervised Mongods exceeding LogRotation.MaxSize
+func (p *ProcessManager) RotateLogs() {
+
+	p.mutex.Lock()
+	paths := make([]string, 0, len(p.supervised))
+	for _, s := range p.supervised {
+		paths = append(paths, p.processLogPath(s.mongod))
+	}
+	policy := p.LogRotation
+	p.mutex.Unlock()
+
+	for _, path := range paths {
+		if err := rotateLogFile(path, policy); err != nil {
+			log.Errorf("could not rotate log file `%s`: %s", path, err)
+		}
+	}
+
+}
+
+func rotateLogFile(path string, policy LogRotationPolicy) error {
+
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if policy.MaxSize == 0 || info.Size() < policy.MaxSize {
+		return nil
+	}
+
+	backupPath := func(i uint) string {
+		return fmt.Sprintf("%s.%d", path, i)
+	}
+
+	if policy.MaxBackups > 0 {
+		for i := policy.MaxBackups - 1; i >= 1; i-- {
+			if err := os.Rename(backupPath(i), backupPath(i+1)); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+		if err := copyFile(path, backupPath(1)); err != nil {
+			return err
+		}
+	}
+
+	return os.Truncate(path, 0)
+
+}
+
+func copyFile(src, dst string) error {
+
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, logFilePermissions)
+	if err != nil {
+		return err
+	}
+
+	if _, err = io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+
+}
+
+// The last n lines of the log of the Mongod on port, oldest first
+// The Mongod does not need to be running.
+func (p *ProcessManager) MongodLogTail(port msp.PortNumber, n uint) (lines []string, err error) {
+
+	replSetNameByPortNumber, err := p.parseProcessDirTree()
+	if err != nil {
+		return nil, fmt.Errorf("could not read process directories: %s", err)
+	}
+	replSetName, exists := replSetNameByPortNumber[port]
+	if !exists {
+		return nil, fmt.Errorf("no Mongod on port `%d`", port)
+	}
+	path := p.processLogPath(msp.Mongod{Port: port, ReplicaSetConfig: msp.ReplicaSetConfig{ReplicaSetName: replSetName}})
+
+	if lines, err = tailLines(path, n); err != nil {
+		return nil, err
+	}
+
+	// Continue with the most recent backup if the log file was rotated recently
+	if uint(len(lines)) < n {
+		backupLines, err := tailLines(path+".1", n-uint(len(lines)))
+		if err != nil {
+			return nil, err
+		}
+		lines = append(backupLines, lines...)
+	}
+
+	return lines, nil
+
+}
+
+// The last n lines of the file, a nonexistent file is treated as empty
+func tailLines(path string, n uint) (lines []string, err error) {
+
+	lines = []string{}
+	if n == 0 {
+		return lines, nil
+	}
+
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return lines, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	// Read chunks from the end until the buffer contains n complete lines
+	var buf []byte
+	offset := info.Size()
+	for offset > 0 && uint(bytes.Count(buf, []byte("\n"))) <= n {
+		chunkSize := int64(tailChunkSize)
+		if chunkSize > offset {
+			chunkSize = offset
+		}
+		offset -= chunkSize
+		chunk := make([]byte, chunkSize)
+		if _, err := f.ReadAt(chunk, offset); err != nil {
+			return nil, err
+		}
+		buf = append(chunk, buf...)
+	}
+
+	content := strings.TrimSuffix(string(buf), "\n")
+	if content == "" {
+		return lines, nil
+	}
+	lines = strings.Split(content, "\n")
+	if uint(len(lines)) > n {
+		lines = lines[uint(len(lines))-n:]
+	}
+	return lines, nil
+
+}
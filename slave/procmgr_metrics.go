@@ -0,0 +1,81 @@
+package slave
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"strconv"
+)
+
+/*
+	Prometheus metrics
+
+	The ProcessManager is a prometheus.Collector reporting the Mongods it manages when scraped.
+	The metrics are served by the msp.Listener's metrics listener (-metrics.listen).
+*/
+
+const (
+	mongodStateRunning        = "running"
+	mongodStateRestartPending = "restart_pending"
+	mongodStateCrashLoop      = "crash_loop"
+	mongodStateExited         = "exited"
+)
+
+var mongodStates = []string{mongodStateRunning, mongodStateRestartPending, mongodStateCrashLoop, mongodStateExited}
+
+var (
+	mongodSpawnFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "mamid",
+		Subsystem: "slave",
+		Name:      "mongod_spawn_failures_total",
+		Help:      "Number of Mongods that could not be spawned by trigger (master: requested by the master, restart: supervision).",
+	}, []string{"trigger"})
+	managedMongodsDesc = prometheus.NewDesc("mamid_slave_managed_mongods",
+		"Number of running Mongods managed by the slave.",
+		nil, nil)
+	mongodStateDesc = prometheus.NewDesc("mamid_slave_mongod_state",
+		"State of a Mongod spawned by the slave, 1 for the current state.",
+		[]string{"port", "replica_set", "state"}, nil)
+	mongodCrashCountDesc = prometheus.NewDesc("mamid_slave_mongod_consecutive_crashes",
+		"Number of consecutive crashes of a Mongod spawned by the slave.",
+		[]string{"port", "replica_set"}, nil)
+)
+
+func init() {
+	prometheus.MustRegister(mongodSpawnFailures)
+}
+
+func (p *ProcessManager) Describe(ch chan<- *prometheus.Desc) {
+	ch <- managedMongodsDesc
+	ch <- mongodStateDesc
+	ch <- mongodCrashCountDesc
+}
+
+func (p *ProcessManager) Collect(ch chan<- prometheus.Metric) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	ch <- prometheus.MustNewConstMetric(managedMongodsDesc, prometheus.GaugeValue, float64(len(p.runningProcesses)))
+
+	for port, s := range p.supervised {
+		portLabel := strconv.Itoa(int(port))
+		replicaSetName := s.mongod.ReplicaSetConfig.ReplicaSetName
+
+		_, looping := p.crashLoopStatus(port)
+		state := mongodStateExited
+		if _, running := p.runningProcesses[port]; running {
+			state = mongodStateRunning
+		} else if looping {
+			state = mongodStateCrashLoop
+		} else if s.restartTimer != nil {
+			state = mongodStateRestartPending
+		}
+		for _, candidate := range mongodStates {
+			value := 0.0
+			if candidate == state {
+				value = 1
+			}
+			ch <- prometheus.MustNewConstMetric(mongodStateDesc, prometheus.GaugeValue, value, portLabel, replicaSetName, candidate)
+		}
+
+		ch <- prometheus.MustNewConstMetric(mongodCrashCountDesc, prometheus.GaugeValue, float64(s.CrashCount), portLabel, replicaSetName)
+	}
+}
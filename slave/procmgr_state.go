@@ -0,0 +1,218 @@
+package slave
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/KIT-MAMID/mamid/msp"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+/*
+	Process state persistence
+
+	The ProcessManager stores the PID and arguments of every Mongod it spawns in the process's conf directory.
+	After a slave restart, AdoptProcesses() registers the Mongods that survived the restart
+	instead of spawning them again, i.e. a slave upgrade does not restart the Mongods.
+
+	Adopted processes are not children of the slave and cannot be waited for.
+	Their liveness is polled instead (see adoptProcess()).
+
+	The credentials the Controller uses to connect to the Mongods are persisted alongside.
+	All state files are only readable by the slave user (see KeyfilePermissions).
+*/
+
+type processMetadata struct {
+	PID  int      `json:"pid"`
+	Args []string `json:"args"`
+}
+
+func (p *ProcessManager) processMetadataPath(m msp.Mongod) string {
+	return filepath.Join(p.processConfDir(m), "process.json")
+}
+
+func (p *ProcessManager) processCredentialPath(m msp.Mongod) string {
+	return filepath.Join(p.processConfDir(m), "credential.json")
+}
+
+func (p *ProcessManager) writeProcessMetadata(m msp.Mongod, cmd *exec.Cmd) error {
+	content, err := json.Marshal(processMetadata{PID: cmd.Process.Pid, Args: cmd.Args})
+	if err != nil {
+		return err
+	}
+	return writeFileAtomically(p.processMetadataPath(m), content)
+}
+
+func (p *ProcessManager) removeProcessMetadata(m msp.Mongod) {
+	if err := os.Remove(p.processMetadataPath(m)); err != nil && !os.IsNotExist(err) {
+		log.Errorf("could not remove process metadata of Mongod on port `%d`: %s", m.Port, err)
+	}
+}
+
+// Register the Mongods spawned by a previous instance of the slave that are still running.
+// Processes with a PID that was reused by another process are not adopted.
+func (p *ProcessManager) AdoptProcesses() error {
+
+	replSetNameByPortNumber, err := p.parseProcessDirTree()
+	if err != nil {
+		return fmt.Errorf("could not read process directories: %s", err)
+	}
+
+	for port, replSetName := range replSetNameByPortNumber {
+
+		m := msp.Mongod{Port: port, ReplicaSetConfig: msp.ReplicaSetConfig{ReplicaSetName: replSetName}}
+
+		content, err := ioutil.ReadFile(p.processMetadataPath(m))
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			log.Errorf("could not read process metadata of Mongod on port `%d`: %s", port, err)
+			continue
+		}
+
+		var metadata processMetadata
+		if err := json.Unmarshal(content, &metadata); err != nil {
+			log.Errorf("could not parse process metadata of Mongod on port `%d`: %s", port, err)
+			continue
+		}
+
+		if !processAlive(metadata) {
+			log.Infof("Mongod on port `%d` (pid `%d`) is no longer running", port, metadata.PID)
+			p.removeProcessMetadata(m)
+			continue
+		}
+
+		process, err := os.FindProcess(metadata.PID)
+		if err != nil {
+			log.Errorf("could not find process of Mongod on port `%d`: %s", port, err)
+			continue
+		}
+
+		log.Infof("adopting Mongod on port `%d` (pid `%d`)", port, metadata.PID)
+		p.mutex.Lock()
+		p.adoptProcess(m, &exec.Cmd{
+			Path:    p.command,
+			Args:    metadata.Args,
+			Process: process,
+		}, metadata)
+		p.mutex.Unlock()
+
+	}
+
+	return nil
+
+}
+
+// Whether the process with the PID is running with the arguments of the metadata.
+// The executable is not compared as it might have been replaced by an upgrade or be run by an interpreter.
+func processAlive(metadata processMetadata) bool {
+
+	if err := syscall.Kill(metadata.PID, syscall.Signal(0)); err != nil {
+		return false
+	}
+
+	cmdline, err := ioutil.ReadFile(filepath.Join("/proc", fmt.Sprintf("%d", metadata.PID), "cmdline"))
+	if err != nil {
+		return false
+	}
+	actualArgs := strings.Split(string(bytes.TrimRight(cmdline, "\x00")), "\x00")
+
+	if len(metadata.Args) < 1 || len(actualArgs) < len(metadata.Args) {
+		return false
+	}
+	expectedArgs := metadata.Args[1:]
+	actualArgs = actualArgs[len(actualArgs)-len(expectedArgs):]
+	for i := range expectedArgs {
+		if expectedArgs[i] != actualArgs[i] {
+			return false
+		}
+	}
+	return true
+
+}
+
+// Persist the credential used to connect to the Mongod. The process root directory must exist.
+func (p *ProcessManager) PersistCredential(m msp.Mongod) error {
+	content, err := json.Marshal(m.ReplicaSetConfig.RootCredential)
+	if err != nil {
+		return err
+	}
+	if equal, err := fileContentEqualToBytes(p.processCredentialPath(m), string(content)); err != nil {
+		return err
+	} else if equal {
+		return nil
+	}
+	return writeFileAtomically(p.processCredentialPath(m), content)
+}
+
+// Credentials persisted by PersistCredential for all existing process root directories
+func (p *ProcessManager) PersistedCredentials() (credentials map[msp.PortNumber]msp.MongodCredential, err error) {
+
+	replSetNameByPortNumber, err := p.parseProcessDirTree()
+	if err != nil {
+		return nil, fmt.Errorf("could not read process directories: %s", err)
+	}
+
+	credentials = make(map[msp.PortNumber]msp.MongodCredential)
+	for port, replSetName := range replSetNameByPortNumber {
+
+		m := msp.Mongod{Port: port, ReplicaSetConfig: msp.ReplicaSetConfig{ReplicaSetName: replSetName}}
+
+		content, err := ioutil.ReadFile(p.processCredentialPath(m))
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			log.Errorf("could not read credential of Mongod on port `%d`: %s", port, err)
+			continue
+		}
+
+		var credential msp.MongodCredential
+		if err := json.Unmarshal(content, &credential); err != nil {
+			log.Errorf("could not parse credential of Mongod on port `%d`: %s", port, err)
+			continue
+		}
+		credentials[port] = credential
+
+	}
+
+	return credentials, nil
+
+}
+
+// Write the file with KeyfilePermissions such that readers never observe partially written content
+func writeFileAtomically(path string, content []byte) (err error) {
+
+	tmpFile, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path))
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			os.Remove(tmpFile.Name())
+		}
+	}()
+
+	if err = tmpFile.Chmod(KeyfilePermissions); err != nil {
+		tmpFile.Close()
+		return err
+	}
+	if _, err = tmpFile.Write(content); err != nil {
+		tmpFile.Close()
+		return err
+	}
+	if err = tmpFile.Sync(); err != nil {
+		tmpFile.Close()
+		return err
+	}
+	if err = tmpFile.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmpFile.Name(), path)
+
+}
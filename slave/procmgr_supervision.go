@@ -0,0 +1,235 @@
+package slave
+
+import (
+	"fmt"
+	"github.com/KIT-MAMID/mamid/msp"
+	"time"
+)
+
+/*
+	Process supervision
+
+	When a Mongod exits without the ProcessManager being asked to stop it (KillProcess(), ExpectShutdown()),
+	it is restarted according to the RestartPolicy with the same arguments.
+	Restarts are delayed by an exponential backoff starting at InitialBackoff, doubling with every consecutive crash up to MaxBackoff.
+
+	A Mongod crashing CrashLoopThreshold times in a row, each time within StableUptime after its start, is crash looping:
+	it is no longer restarted and SpawnProcess() refuses to spawn it until CrashLoopCooldown has passed.
+	The Controller reports crash loops as msp.SlaveMongodCrashLoopError.
+*/
+
+type RestartMode uint
+
+const (
+	RestartNever     RestartMode = iota // exited Mongods are respawned by the master's next deployment
+	RestartOnFailure                    // restart Mongods that exited with a non-zero exit code or were killed by a signal
+	RestartAlways                       // restart all Mongods that exited unexpectedly
+)
+
+func ParseRestartMode(s string) (RestartMode, error) {
+	switch s {
+	case "never":
+		return RestartNever, nil
+	case "on-failure":
+		return RestartOnFailure, nil
+	case "always":
+		return RestartAlways, nil
+	default:
+		return RestartNever, fmt.Errorf("unknown restart mode `%s`, must be one of `never`, `on-failure`, `always`", s)
+	}
+}
+
+type RestartPolicy struct {
+	Mode               RestartMode
+	InitialBackoff     time.Duration
+	MaxBackoff         time.Duration
+	CrashLoopThreshold uint          // consecutive crashes after which a Mongod is no longer restarted, 0 disables crash loop detection
+	CrashLoopCooldown  time.Duration // duration after which a crash looping Mongod may be spawned again, 0 waits until it is stopped by the master
+	StableUptime       time.Duration // a Mongod running longer is no longer considered crashing
+}
+
+// Supervision state of a Mongod, recorded across restarts
+type ProcessSupervisionStatus struct {
+	CrashCount   uint // consecutive crashes
+	LastExitCode int  // -1 if killed by a signal or unknown
+	LastExit     time.Time
+	CrashLoop    bool
+}
+
+type supervisedProcess struct {
+	ProcessSupervisionStatus
+	mongod         msp.Mongod // only Port and ReplicaSetName are valid
+	args           []string   // arguments the Mongod was started with
+	started        time.Time
+	expectingExit  bool
+	restartTimer   *time.Timer
+	crashLoopSince time.Time
+}
+
+type processExit struct {
+	port     msp.PortNumber
+	exitCode int
+}
+
+// p.mutex must be held by the caller
+func (p *ProcessManager) supervise(m msp.Mongod, args []string) {
+	s, exists := p.supervised[m.Port]
+	if !exists || s.mongod.ReplicaSetConfig.ReplicaSetName != m.ReplicaSetConfig.ReplicaSetName {
+		s = &supervisedProcess{}
+		p.supervised[m.Port] = s
+	}
+	s.cancelRestart()
+	s.mongod = msp.Mongod{Port: m.Port, ReplicaSetConfig: msp.ReplicaSetConfig{ReplicaSetName: m.ReplicaSetConfig.ReplicaSetName}}
+	s.args = args
+	s.started = time.Now()
+	s.expectingExit = false
+	s.CrashLoop = false
+}
+
+// p.mutex must be held by the caller
+func (p *ProcessManager) handleExit(exit processExit) {
+
+	s, exists := p.supervised[exit.port]
+	if !exists {
+		return
+	}
+	p.removeProcessMetadata(s.mongod)
+
+	s.LastExitCode = exit.exitCode
+	s.LastExit = time.Now()
+
+	if s.expectingExit {
+		log.Debugf("Mongod on port `%d` exited as expected with exit code `%d`", exit.port, exit.exitCode)
+		s.CrashCount = 0
+		return
+	}
+
+	if s.LastExit.Sub(s.started) >= p.RestartPolicy.StableUptime {
+		s.CrashCount = 0
+	}
+	failed := exit.exitCode != 0
+	if failed {
+		s.CrashCount++
+	}
+	log.Errorf("Mongod on port `%d` exited unexpectedly with exit code `%d` (%d consecutive crashes)", exit.port, exit.exitCode, s.CrashCount)
+
+	if p.detectCrashLoop(s) {
+		return
+	}
+
+	switch p.RestartPolicy.Mode {
+	case RestartNever:
+		return
+	case RestartOnFailure:
+		if !failed {
+			return
+		}
+	}
+
+	p.scheduleRestart(s)
+
+}
+
+// p.mutex must be held by the caller
+func (p *ProcessManager) scheduleRestart(s *supervisedProcess) {
+
+	port := s.mongod.Port
+
+	backoff := p.RestartPolicy.InitialBackoff
+	for i := uint(1); i < s.CrashCount && backoff < p.RestartPolicy.MaxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > p.RestartPolicy.MaxBackoff {
+		backoff = p.RestartPolicy.MaxBackoff
+	}
+
+	log.Infof("restarting Mongod on port `%d` in %s", port, backoff)
+	s.restartTimer = time.AfterFunc(backoff, func() {
+		p.mutex.Lock()
+		defer p.mutex.Unlock()
+		if p.supervised[port] != s || s.restartTimer == nil {
+			return // cancelled
+		}
+		s.restartTimer = nil
+		if _, running := p.runningProcesses[port]; running {
+			return
+		}
+		if err := p.startProcess(s.mongod, s.args); err != nil {
+			log.Errorf("could not restart Mongod on port `%d`: %s", port, err)
+			mongodSpawnFailures.WithLabelValues("restart").Inc()
+			s.CrashCount++
+			if !p.detectCrashLoop(s) {
+				p.scheduleRestart(s)
+			}
+		}
+	})
+
+}
+
+// Whether the Mongod crashed CrashLoopThreshold times in a row
+// p.mutex must be held by the caller
+func (p *ProcessManager) detectCrashLoop(s *supervisedProcess) bool {
+	if p.RestartPolicy.CrashLoopThreshold == 0 || s.CrashCount < p.RestartPolicy.CrashLoopThreshold {
+		return false
+	}
+	log.Errorf("Mongod on port `%d` is crash looping, not restarting it", s.mongod.Port)
+	s.CrashLoop = true
+	s.crashLoopSince = time.Now()
+	return true
+}
+
+func (s *supervisedProcess) cancelRestart() {
+	if s.restartTimer != nil {
+		s.restartTimer.Stop()
+		s.restartTimer = nil
+	}
+}
+
+// Do not restart the Mongod when it exits and reset its crash loop state
+// p.mutex must be held by the caller
+func (p *ProcessManager) expectExit(port msp.PortNumber) {
+	if s, exists := p.supervised[port]; exists {
+		s.expectingExit = true
+		s.cancelRestart()
+		s.CrashCount = 0
+		s.CrashLoop = false
+	}
+}
+
+// Announce the shutdown of a Mongod by other means than the ProcessManager, e.g. the `shutdown` command
+func (p *ProcessManager) ExpectShutdown(port msp.PortNumber) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.expectExit(port)
+}
+
+// Whether the Mongod exited and will be restarted by the ProcessManager
+func (p *ProcessManager) RestartPending(port msp.PortNumber) bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	s, exists := p.supervised[port]
+	return exists && s.restartTimer != nil
+}
+
+// Supervision status of the Mongod on port, exists is false if it was never spawned by this ProcessManager
+func (p *ProcessManager) SupervisionStatus(port msp.PortNumber) (status ProcessSupervisionStatus, exists bool) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	status, _ = p.crashLoopStatus(port)
+	_, exists = p.supervised[port]
+	return status, exists
+}
+
+// Ends crash loops after CrashLoopCooldown
+// p.mutex must be held by the caller
+func (p *ProcessManager) crashLoopStatus(port msp.PortNumber) (status ProcessSupervisionStatus, looping bool) {
+	s, exists := p.supervised[port]
+	if !exists {
+		return status, false
+	}
+	if s.CrashLoop && p.RestartPolicy.CrashLoopCooldown > 0 && time.Since(s.crashLoopSince) >= p.RestartPolicy.CrashLoopCooldown {
+		log.Infof("crash loop cooldown of Mongod on port `%d` passed", port)
+		s.CrashLoop = false
+	}
+	return s.ProcessSupervisionStatus, s.CrashLoop
+}